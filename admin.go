@@ -0,0 +1,157 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// AdminAPI exposes the state of the daemon's connectors via HTTP and allows controlling them
+type AdminAPI struct {
+	daemon *Daemon
+	token  string
+}
+
+type connectorStatus struct {
+	Name        string          `json:"name"`
+	Plugin      string          `json:"plugin"`
+	Schedule    string          `json:"schedule"`
+	Paused      bool            `json:"paused"`
+	Running     bool            `json:"running"`
+	NextCheck   *time.Time      `json:"nextCheck,omitempty"`
+	PushedUntil *time.Time      `json:"pushedUntil,omitempty"`
+	LastRun     *RunResult      `json:"lastRun,omitempty"`
+	Offset      json.RawMessage `json:"offset,omitempty"`
+}
+
+func (api *AdminAPI) Register(server *Server) {
+	server.Handle("GET /admin/connectors", api.authenticated(api.listConnectors))
+	server.Handle("GET /admin/connectors/{name}", api.authenticated(api.getConnector))
+	server.Handle("POST /admin/connectors/{name}/check", api.authenticated(api.triggerCheck))
+	server.Handle("POST /admin/connectors/{name}/pause", api.authenticated(api.pauseConnector))
+	server.Handle("POST /admin/connectors/{name}/resume", api.authenticated(api.resumeConnector))
+	server.Handle("GET /admin/offsets", api.authenticated(api.listOffsets))
+}
+
+func (api *AdminAPI) authenticated(handler func(http.ResponseWriter, *http.Request)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !found || subtle.ConstantTimeCompare([]byte(token), []byte(api.token)) != 1 {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid or missing admin token"})
+			return
+		}
+
+		handler(w, r)
+	})
+}
+
+func (api *AdminAPI) listConnectors(w http.ResponseWriter, _ *http.Request) {
+	connectors := api.daemon.runner.Connectors()
+	result := make([]connectorStatus, 0, len(connectors))
+	for _, connector := range connectors {
+		result = append(result, api.status(connector, false))
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (api *AdminAPI) getConnector(w http.ResponseWriter, r *http.Request) {
+	connector, ok := api.connector(w, r)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, api.status(connector, true))
+}
+
+func (api *AdminAPI) triggerCheck(w http.ResponseWriter, r *http.Request) {
+	connector, ok := api.connector(w, r)
+	if !ok {
+		return
+	}
+
+	if !connector.Trigger() {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "a check is already pending"})
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, api.status(connector, false))
+}
+
+func (api *AdminAPI) pauseConnector(w http.ResponseWriter, r *http.Request) {
+	connector, ok := api.connector(w, r)
+	if !ok {
+		return
+	}
+
+	if err := api.daemon.runner.SetPaused(connector, true); err != nil {
+		connector.error.Println(err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	connector.info.Println("Paused via admin API")
+	writeJSON(w, http.StatusOK, api.status(connector, false))
+}
+
+func (api *AdminAPI) resumeConnector(w http.ResponseWriter, r *http.Request) {
+	connector, ok := api.connector(w, r)
+	if !ok {
+		return
+	}
+
+	if err := api.daemon.runner.SetPaused(connector, false); err != nil {
+		connector.error.Println(err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	connector.info.Println("Resumed via admin API")
+	writeJSON(w, http.StatusOK, api.status(connector, false))
+}
+
+func (api *AdminAPI) listOffsets(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, api.daemon.offsets.All())
+}
+
+func (api *AdminAPI) connector(w http.ResponseWriter, r *http.Request) (*ConnectorRuntime, bool) {
+	name := r.PathValue("name")
+	if connector, ok := api.daemon.runner.Connector(name); ok {
+		return connector, true
+	}
+
+	writeJSON(w, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("unknown connector '%s'", name)})
+	return nil, false
+}
+
+func (api *AdminAPI) status(connector *ConnectorRuntime, includeOffset bool) connectorStatus {
+	state := connector.State()
+	status := connectorStatus{
+		Name:     connector.Name,
+		Plugin:   (*connector.Plugin).Name(),
+		Schedule: connector.Schedule.Description,
+		Paused:   state.Paused,
+		Running:  state.Running,
+		LastRun:  state.LastRun,
+	}
+
+	if !state.NextCheck.IsZero() {
+		status.NextCheck = &state.NextCheck
+	}
+	if time.Now().Before(state.PushedUntil) {
+		status.PushedUntil = &state.PushedUntil
+	}
+
+	if includeOffset {
+		status.Offset, _ = api.daemon.offsets.Get(connector.Name)
+	}
+
+	return status
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
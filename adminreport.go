@@ -0,0 +1,77 @@
+package main
+
+import (
+	. "17thshard.com/sanderson-notifications/common"
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	adminReportTimeout = 10 * time.Second
+	// maxAdminErrorLength keeps reports concise, e.g. for errors quoting a whole page
+	maxAdminErrorLength = 1000
+	// adminReportColor is the color of embeds reporting errors
+	adminReportColor = 0xE74C3C
+)
+
+// AdminReporter posts concise reports of failed checks and deliveries to the admin webhook, so they are noticed without
+// anyone watching the logs. A nil reporter discards all reports.
+type AdminReporter struct {
+	sink  Sink
+	error *log.Logger
+}
+
+// NewAdminReporter returns nil if no admin webhook is configured
+func NewAdminReporter(config *Config, errorLog *log.Logger) *AdminReporter {
+	if config.AdminSink == nil {
+		return nil
+	}
+
+	return &AdminReporter{sink: config.AdminSink, error: errorLog}
+}
+
+// CheckFailed reports a failed check of a connector
+func (reporter *AdminReporter) CheckFailed(connector *ConnectorRuntime, err error) {
+	if reporter == nil {
+		return
+	}
+
+	reporter.report(connector, fmt.Sprintf("Check of connector '%s' failed", connector.Name), err)
+}
+
+// DeliveryFailed reports a message of a connector that couldn't be delivered to a sink
+func (reporter *AdminReporter) DeliveryFailed(connector *ConnectorRuntime, sink string, err error) {
+	if reporter == nil {
+		return
+	}
+
+	reporter.report(connector, fmt.Sprintf("Delivery of message of connector '%s' to sink '%s' failed", connector.Name, sink), err)
+}
+
+func (reporter *AdminReporter) report(connector *ConnectorRuntime, title string, err error) {
+	description := []rune(err.Error())
+	if len(description) > maxAdminErrorLength {
+		description = append(description[:maxAdminErrorLength-1], '…')
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), adminReportTimeout)
+	defer cancel()
+
+	now := time.Now()
+	deliveryErr := reporter.sink.Deliver(ctx, Message{
+		Connector: connector.Name,
+		Username:  "Sanderson Notifications",
+		Embed: NewEmbed().
+			WithTitle(title).
+			WithDescription(string(description)).
+			WithColor(adminReportColor).
+			AddField("Plugin", (*connector.Plugin).Name(), true).
+			WithTimestamp(now),
+		Timestamp: now,
+	})
+	if deliveryErr != nil {
+		reporter.error.Printf("Could not report error of connector '%s' to admin webhook: %s", connector.Name, deliveryErr)
+	}
+}
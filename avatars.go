@@ -0,0 +1,72 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+//go:embed avatars/*.png
+var bundledAvatars embed.FS
+
+// AvatarConfig configures where the avatars named by plugins and connectors are hosted
+type AvatarConfig struct {
+	// BaseURL is the public URL the avatars are available under, e.g. the `/avatars` path of the HTTP server
+	BaseURL string `yaml:"baseUrl"`
+	// Serve makes the HTTP server serve the avatars under `/avatars`
+	Serve bool `yaml:"serve"`
+	// Dir contains additional avatars to serve, which take precedence over the bundled ones
+	Dir string `yaml:"dir"`
+}
+
+func (config AvatarConfig) Validate() error {
+	if len(config.Dir) > 0 {
+		if !config.Serve {
+			return fmt.Errorf("a directory of avatars requires serving them")
+		}
+		if info, err := os.Stat(config.Dir); err != nil || !info.IsDir() {
+			return fmt.Errorf("avatar directory '%s' does not exist", config.Dir)
+		}
+	}
+
+	return nil
+}
+
+// AvatarHandler serves the avatars from the configured directory or the ones bundled with the application
+type AvatarHandler struct {
+	dir fs.FS
+}
+
+func NewAvatarHandler(config AvatarConfig) AvatarHandler {
+	handler := AvatarHandler{}
+	if len(config.Dir) > 0 {
+		handler.dir = os.DirFS(config.Dir)
+	}
+
+	return handler
+}
+
+func (handler AvatarHandler) Register(server *Server) {
+	server.Handle("GET /avatars/{name}", handler)
+}
+
+func (handler AvatarHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	name := request.PathValue("name")
+	if !strings.HasSuffix(name, ".png") || !fs.ValidPath(name) || strings.Contains(name, "/") {
+		http.NotFound(writer, request)
+		return
+	}
+
+	if handler.dir != nil {
+		if _, err := fs.Stat(handler.dir, name); err == nil {
+			http.ServeFileFS(writer, request, handler.dir, name)
+			return
+		}
+	}
+
+	http.ServeFileFS(writer, request, bundledAvatars, path.Join("avatars", name))
+}
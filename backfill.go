@@ -0,0 +1,149 @@
+package main
+
+import (
+	. "17thshard.com/sanderson-notifications/common"
+	. "17thshard.com/sanderson-notifications/plugins"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// bufferSink collects messages instead of delivering them
+type bufferSink struct {
+	lock     sync.Mutex
+	messages []Message
+}
+
+func (sink *bufferSink) Name() string {
+	return "buffer"
+}
+
+func (sink *bufferSink) Validate() error {
+	return nil
+}
+
+func (sink *bufferSink) Deliver(_ context.Context, message Message) error {
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+
+	sink.messages = append(sink.messages, message)
+	return nil
+}
+
+// backfillCommand posts past updates of a connector again, without looking at or changing its offset
+func backfillCommand(args []string) {
+	infoLog, errorLog := CreateLoggers("main")
+
+	flags := newFlagSet("backfill")
+	configPath := flags.String("config", "config.yml", "path or URL of YAML, JSON or TOML config file")
+	environment := flags.String("env", "", "environment to select connectors for via 'onlyIn' and 'exceptIn', replacing the config's")
+	connectorName := flags.String("connector", "", "name of the connector whose updates to post")
+	limit := flags.Int("limit", 0, "number of most recent updates to post, 0 for all")
+	since := flags.String("since", "", "only post updates since this duration ago or RFC 3339 timestamp")
+	dryRun := flags.Bool("dry-run", false, "log messages instead of sending them")
+	logLevelFlag(flags)
+	_ = flags.Parse(args)
+
+	if len(*connectorName) == 0 {
+		errorLog.Fatal("The connector to backfill must be specified with -connector")
+	}
+	if *limit <= 0 && len(*since) == 0 {
+		errorLog.Fatal("At least one of -limit and -since must be specified")
+	}
+
+	var sinceTime time.Time
+	if len(*since) > 0 {
+		if duration, err := time.ParseDuration(*since); err == nil {
+			sinceTime = time.Now().Add(-duration)
+		} else if sinceTime, err = time.Parse(time.RFC3339, *since); err != nil {
+			errorLog.Fatalf("Invalid value '%s' for -since, expected a duration or RFC 3339 timestamp", *since)
+		}
+	}
+
+	config := loadConfig(*configPath, *environment, infoLog, errorLog)
+	if *dryRun {
+		infoLog.Println("Performing dry run, messages will only be logged")
+		enableDryRun(config)
+	}
+	defer closeSinks(config, errorLog)
+
+	connector := config.Connector(*connectorName)
+	if connector == nil {
+		errorLog.Fatalf("Unknown connector '%s'", *connectorName)
+	}
+
+	plugin, ok := (*connector.Plugin).(BackfillPlugin)
+	if !ok {
+		errorLog.Fatalf("Plugin '%s' of connector '%s' does not support backfilling", (*connector.Plugin).Name(), connector.Name)
+	}
+
+	offset, err := plugin.BackfillOffset(sinceTime)
+	if err != nil {
+		errorLog.Fatalf("Failed to backfill connector '%s': %s", connector.Name, err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	messages, err := collectBackfill(ctx, connector, offset)
+	if err != nil {
+		errorLog.Fatalf("Failed to collect past updates of connector '%s': %s", connector.Name, err)
+	}
+	if *limit > 0 && len(messages) > *limit {
+		messages = messages[len(messages)-*limit:]
+	}
+
+	var sink Sink = connector.Sink
+	if !connector.Overrides.empty() {
+		sink = &overrideSink{Sink: sink, overrides: connector.Overrides}
+	}
+
+	infoLog.Printf("Posting %d past updates of connector '%s'...", len(messages), connector.Name)
+	for i, message := range messages {
+		if err = sink.Deliver(ctx, message); err != nil {
+			errorLog.Fatalf("Failed to post update %d of %d: %s", i+1, len(messages), err)
+		}
+	}
+}
+
+// collectBackfill checks the connector starting from the given offset, returning the messages it would have sent
+func collectBackfill(parentCtx context.Context, connector *Connector, offset interface{}) ([]Message, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, connector.Timeout)
+	defer cancel()
+
+	transport, err := connector.HTTP.Transport()
+	if err != nil {
+		return nil, fmt.Errorf("invalid HTTP settings: %w", err)
+	}
+
+	connectorInfo, connectorError, connectorLog := connectorLoggers(*connector)
+	buffer := &bufferSink{}
+	pluginContext := PluginContext{
+		Discord: SinkSender{Connector: connector.Name, Sink: buffer, Context: ctx},
+		Info:    connectorInfo,
+		Error:   connectorError,
+		Log:     connectorLog,
+		Context: &ctx,
+		Locale:  connector.Locale,
+		HTTP: &http.Client{
+			Transport: &RetryTransport{
+				Base:   &LoggingTransport{Base: transport, Log: connectorLog},
+				Policy: connector.Retry,
+				Info:   connectorInfo,
+			},
+			CheckRedirect: connector.HTTP.CheckRedirect,
+		},
+		HTTPConfig: connector.HTTP,
+	}
+
+	if _, err := (*connector.Plugin).Check(offset, pluginContext); err != nil {
+		return nil, err
+	}
+
+	return buffer.messages, nil
+}
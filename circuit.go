@@ -0,0 +1,167 @@
+package main
+
+import (
+	"17thshard.com/sanderson-notifications/common"
+	"17thshard.com/sanderson-notifications/state"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	defaultFailureThreshold = 5
+	defaultCoolDown         = time.Hour
+)
+
+// CircuitBreakerConfig configures after how many consecutive failures a connector is skipped and for how long
+type CircuitBreakerConfig struct {
+	Threshold int           `yaml:"threshold"`
+	CoolDown  time.Duration `yaml:"coolDown"`
+	AlertSink string        `yaml:"alertSink"`
+}
+
+// Circuit tracks the consecutive failures of a connector. Once open, the connector is skipped until the cool-down
+// has passed.
+type Circuit struct {
+	Failures  int       `json:"failures"`
+	OpenUntil time.Time `json:"openUntil,omitempty"`
+	Alerted   bool      `json:"alerted,omitempty"`
+}
+
+// CircuitBreaker keeps persistently failing connectors from being checked on every run. The circuits are stored
+// alongside the offsets, so they are kept across runs.
+type CircuitBreaker struct {
+	config   CircuitBreakerConfig
+	offsets  *Offsets
+	alerts   common.Sink
+	lock     sync.Mutex
+	circuits map[string]Circuit
+}
+
+func NewCircuitBreaker(config *Config, offsets *Offsets) (*CircuitBreaker, error) {
+	breaker := &CircuitBreaker{
+		config:   config.CircuitBreaker,
+		offsets:  offsets,
+		circuits: make(map[string]Circuit),
+	}
+
+	breaker.alerts = alertSink(config)
+
+	for connector, raw := range offsets.Entries(state.CircuitsBucket) {
+		var circuit Circuit
+		if err := json.Unmarshal(raw, &circuit); err != nil {
+			return nil, fmt.Errorf("could not parse circuit of connector '%s': %w", connector, err)
+		}
+		breaker.circuits[connector] = circuit
+	}
+
+	return breaker, nil
+}
+
+// Reconfigure applies changed settings of a reloaded config, keeping the state of all circuits
+func (breaker *CircuitBreaker) Reconfigure(config *Config) {
+	breaker.lock.Lock()
+	defer breaker.lock.Unlock()
+
+	breaker.config = config.CircuitBreaker
+	breaker.alerts = alertSink(config)
+}
+
+// alertSink is the sink alerts are sent to, which is the admin webhook unless the circuit breaker has its own sink
+func alertSink(config *Config) common.Sink {
+	if len(config.CircuitBreaker.AlertSink) > 0 {
+		return config.Sinks[config.CircuitBreaker.AlertSink]
+	}
+
+	return config.AdminSink
+}
+
+// OpenUntil returns until when checks of the connector are skipped, or false if the connector may be checked
+func (breaker *CircuitBreaker) OpenUntil(connector string) (time.Time, bool) {
+	breaker.lock.Lock()
+	defer breaker.lock.Unlock()
+
+	circuit := breaker.circuits[connector]
+	return circuit.OpenUntil, time.Now().Before(circuit.OpenUntil)
+}
+
+// Record updates the circuit of a connector with the result of a check, opening it once too many checks in a row
+// failed. The first time a circuit opens, an alert is sent, which happens after the circuit was updated so a slow alert
+// sink doesn't hold up other connectors.
+func (breaker *CircuitBreaker) Record(ctx context.Context, connector *ConnectorRuntime, checkErr error) {
+	alert, alerts, coolDown := breaker.record(connector, checkErr)
+	if alert != nil {
+		sendAlert(ctx, connector, *alert, alerts, coolDown, checkErr)
+	}
+}
+
+// record updates the circuit of the connector, returning it if an alert is due along with where to send it
+func (breaker *CircuitBreaker) record(connector *ConnectorRuntime, checkErr error) (*Circuit, common.Sink, time.Duration) {
+	breaker.lock.Lock()
+	defer breaker.lock.Unlock()
+
+	circuit, known := breaker.circuits[connector.Name]
+	if checkErr == nil {
+		if !known {
+			return nil, nil, 0
+		}
+		if circuit.Alerted {
+			connector.info.Printf("Connector '%s' recovered after %d failed checks", connector.Name, circuit.Failures)
+		}
+		delete(breaker.circuits, connector.Name)
+		breaker.offsets.DeleteEntry(state.CircuitsBucket, connector.Name)
+		return nil, nil, 0
+	}
+
+	var alert *Circuit
+	circuit.Failures++
+	if circuit.Failures >= breaker.config.Threshold {
+		circuit.OpenUntil = time.Now().Add(breaker.config.CoolDown)
+		connector.info.Printf(
+			"Skipping connector '%s' until %s after %d failed checks",
+			connector.Name,
+			circuit.OpenUntil.Format(time.RFC3339),
+			circuit.Failures,
+		)
+
+		if !circuit.Alerted {
+			alerted := circuit
+			alert = &alerted
+			circuit.Alerted = true
+		}
+	}
+
+	breaker.circuits[connector.Name] = circuit
+	if err := breaker.offsets.SetEntry(state.CircuitsBucket, connector.Name, circuit); err != nil {
+		connector.error.Printf("Could not store circuit of connector '%s': %s", connector.Name, err)
+	}
+
+	return alert, breaker.alerts, breaker.config.CoolDown
+}
+
+func sendAlert(ctx context.Context, connector *ConnectorRuntime, circuit Circuit, alerts common.Sink, coolDown time.Duration, checkErr error) {
+	text := fmt.Sprintf(
+		"Connector '%s' failed %d times in a row and is only checked every %s until it recovers. Last error: %s",
+		connector.Name,
+		circuit.Failures,
+		coolDown,
+		checkErr,
+	)
+	connector.error.Println(text)
+
+	if alerts == nil {
+		return
+	}
+
+	err := alerts.Deliver(ctx, common.Message{
+		Connector: connector.Name,
+		Text:      text,
+		Username:  "Sanderson Notifications",
+		Timestamp: time.Now(),
+	})
+	if err != nil {
+		connector.error.Printf("Could not send alert for connector '%s': %s", connector.Name, err)
+	}
+}
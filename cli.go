@@ -0,0 +1,181 @@
+package main
+
+import (
+	. "17thshard.com/sanderson-notifications/common"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"text/tabwriter"
+)
+
+// defaultCommand runs when no command is given, so that invocations with just flags keep working
+const defaultCommand = "run"
+
+// command is a subcommand of the CLI, which either runs on its own or groups further subcommands
+type command struct {
+	name        string
+	summary     string
+	run         func(args []string)
+	subcommands []command
+}
+
+// commands lists all commands in the order they are shown in the help
+func commands() []command {
+	return []command{
+		{name: "run", summary: "Check all connectors once and store their new offsets", run: runCommand},
+		{name: "serve", summary: "Check connectors on their schedules until stopped", run: serveCommand},
+		{name: "validate", summary: "Check a config for errors without running any connectors", run: validateCommand},
+		{name: "doctor", summary: "Check the config, state store, sources and sinks without posting anything", run: doctorCommand},
+		{name: "offsets", summary: "Inspect and change the stored offsets", subcommands: []command{
+			{name: "show", summary: "Show the offsets of connectors as their plugins understand them", run: offsetsShowCommand},
+			{name: "reset", summary: "Remove the offset of a connector, so it starts over", run: func(args []string) {
+				offsetsChangeCommand("reset", args)
+			}},
+			{name: "set", summary: "Replace the offset of a connector", run: func(args []string) {
+				offsetsChangeCommand("set", args)
+			}},
+			{name: "namespaces", summary: "List or remove the namespaces of configs sharing a state store", run: offsetsNamespacesCommand},
+		}},
+		{name: "import-legacy", summary: "Import the state of the former single-purpose scripts as offsets", run: importLegacyCommand},
+		{name: "history", summary: "Show the notifications that were posted", run: historyCommand},
+		{name: "backfill", summary: "Post past updates of a connector", run: backfillCommand},
+		{name: "test", summary: "Post a sample message of a connector", run: testCommand},
+		{name: "preview", summary: "Print the Discord payloads of a sample message of a connector without posting it", run: previewCommand},
+		{name: "config", summary: "Create configs", subcommands: []command{
+			{name: "init", summary: "Create a config interactively", run: configInitCommand},
+		}},
+		{name: "schema", summary: "Print the JSON Schema of the config", run: schemaCommand},
+		{name: "version", summary: "Print the version", run: versionCommand},
+	}
+}
+
+// runCLI runs the command selected by the arguments, falling back to the default command if they start with a flag
+func runCLI(args []string) {
+	if len(args) > 0 && strings.HasPrefix(args[0], "-") && !isHelpFlag(args[0]) {
+		args = append([]string{defaultCommand}, args...)
+	} else if len(args) == 0 {
+		args = []string{defaultCommand}
+	}
+
+	dispatch(nil, commands(), args)
+}
+
+// dispatch runs the command named by the first argument among the available ones, descending into groups of
+// subcommands. 'help' followed by a command prints the help of that command.
+func dispatch(path []string, available []command, args []string) {
+	if len(args) == 0 || isHelpFlag(args[0]) {
+		printCommands(os.Stderr, path, available)
+		if len(args) == 0 {
+			os.Exit(2)
+		}
+		os.Exit(0)
+	}
+
+	if args[0] == "help" {
+		dispatch(path, available, append(args[1:], "-h"))
+		return
+	}
+
+	for _, command := range available {
+		if command.name != args[0] {
+			continue
+		}
+
+		if len(command.subcommands) > 0 {
+			dispatch(append(path, command.name), command.subcommands, args[1:])
+		} else {
+			command.run(args[1:])
+		}
+		return
+	}
+
+	if len(path) == 0 {
+		fmt.Fprintf(os.Stderr, "Unknown command '%s'\n\n", args[0])
+	} else {
+		fmt.Fprintf(os.Stderr, "Unknown subcommand '%s' of '%s'\n\n", args[0], strings.Join(path, " "))
+	}
+	printCommands(os.Stderr, path, available)
+	os.Exit(2)
+}
+
+// printCommands prints the usage of a group of commands
+func printCommands(output io.Writer, path []string, available []command) {
+	prefix := strings.Join(append([]string{"sanderson-notifications"}, path...), " ")
+
+	fmt.Fprintf(output, "Usage: %s <command> [flags]\n\nCommands:\n", prefix)
+	writer := tabwriter.NewWriter(output, 0, 0, 2, ' ', 0)
+	for _, command := range available {
+		summary := command.summary
+		if len(path) == 0 && command.name == defaultCommand {
+			summary += " (default)"
+		}
+		fmt.Fprintf(writer, "  %s\t%s\n", command.name, summary)
+	}
+	_ = writer.Flush()
+
+	fmt.Fprintf(output, "\nRun '%s help <command>' for the flags of a command.\n", prefix)
+}
+
+// findCommand looks up a command by its full name, e.g. 'offsets show'
+func findCommand(name string) (command, bool) {
+	available := commands()
+	var found command
+	for _, part := range strings.Fields(name) {
+		ok := false
+		for _, candidate := range available {
+			if candidate.name == part {
+				found, ok = candidate, true
+				break
+			}
+		}
+		if !ok {
+			return command{}, false
+		}
+		available = found.subcommands
+	}
+
+	return found, true
+}
+
+// newFlagSet creates the flags of a command, whose help shows the command's summary
+func newFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.Usage = func() {
+		output := flags.Output()
+		fmt.Fprintf(output, "Usage: sanderson-notifications %s [flags]\n\n", name)
+		if command, ok := findCommand(name); ok {
+			fmt.Fprintf(output, "%s.\n\n", command.summary)
+		}
+
+		hasFlags := false
+		flags.VisitAll(func(*flag.Flag) {
+			hasFlags = true
+		})
+		if hasFlags {
+			fmt.Fprintln(output, "Flags:")
+			flags.PrintDefaults()
+		}
+	}
+
+	return flags
+}
+
+// logLevelFlag adds the -log-level flag, whose level replaces the one in the config of the command
+func logLevelFlag(flags *flag.FlagSet) {
+	flags.Func("log-level", "minimum level of messages to log, one of 'debug', 'info', 'warn' and 'error', replacing the config's", OverrideLogLevel)
+}
+
+func isHelpFlag(arg string) bool {
+	return arg == "-h" || arg == "-help" || arg == "--help"
+}
+
+// versionCommand prints the version along with the Go version and platform it was built for
+func versionCommand(args []string) {
+	flags := newFlagSet("version")
+	_ = flags.Parse(args)
+
+	fmt.Printf("sanderson-notifications %s (%s, %s/%s)\n", version, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+}
@@ -0,0 +1,97 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+)
+
+// maxAttachments is the maximum number of files Discord accepts on a single message
+const maxAttachments = 10
+
+// Attachment is a file sent along with a message, e.g. a chart or the full text of a long post
+type Attachment struct {
+	Name        string `json:"name"`
+	ContentType string `json:"contentType,omitempty"`
+	Description string `json:"description,omitempty"`
+	Data        []byte `json:"data"`
+}
+
+// AttachmentURL refers to an attachment of the same message by its name, e.g. to show it as image of an embed
+func AttachmentURL(name string) string {
+	return "attachment://" + name
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+// attachmentRef describes an uploaded file in the JSON payload of a multipart request
+type attachmentRef struct {
+	ID          int    `json:"id"`
+	Filename    string `json:"filename"`
+	Description string `json:"description,omitempty"`
+}
+
+// attachmentRefs describes the files of a message in the order they are uploaded
+func attachmentRefs(files []Attachment) []attachmentRef {
+	if len(files) == 0 {
+		return nil
+	}
+
+	refs := make([]attachmentRef, len(files))
+	for i, file := range files {
+		refs[i] = attachmentRef{ID: i, Filename: file.Name, Description: file.Description}
+	}
+
+	return refs
+}
+
+// multipartBody encodes a webhook message with its files, returning the body and its content type
+func multipartBody(body webhookMessage) ([]byte, string, error) {
+	body.Attachments = attachmentRefs(body.files)
+
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, "", fmt.Errorf("could not serialize request: %w", err)
+	}
+
+	var buffer bytes.Buffer
+	writer := multipart.NewWriter(&buffer)
+
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", `form-data; name="payload_json"`)
+	header.Set("Content-Type", "application/json")
+	part, err := writer.CreatePart(header)
+	if err != nil {
+		return nil, "", err
+	}
+	if _, err = part.Write(payload); err != nil {
+		return nil, "", err
+	}
+
+	for i, file := range body.files {
+		contentType := file.ContentType
+		if len(contentType) == 0 {
+			contentType = "application/octet-stream"
+		}
+
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="files[%d]"; filename="%s"`, i, quoteEscaper.Replace(file.Name)))
+		header.Set("Content-Type", contentType)
+		part, err := writer.CreatePart(header)
+		if err != nil {
+			return nil, "", err
+		}
+		if _, err = part.Write(file.Data); err != nil {
+			return nil, "", err
+		}
+	}
+
+	if err = writer.Close(); err != nil {
+		return nil, "", err
+	}
+
+	return buffer.Bytes(), writer.FormDataContentType(), nil
+}
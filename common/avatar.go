@@ -0,0 +1,31 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+const defaultAvatarBaseUrl = "https://raw.githubusercontent.com/Palanaeum/sanderson-notifications/master/avatars"
+
+// avatarBaseUrl is where the bundled avatars are hosted, as Discord only accepts avatars by URL
+var avatarBaseUrl atomic.Value
+
+// SetAvatarBaseURL changes where the bundled avatars are hosted, e.g. by the application's own HTTP server. An empty
+// URL restores the default.
+func SetAvatarBaseURL(baseUrl string) {
+	if len(baseUrl) == 0 {
+		baseUrl = defaultAvatarBaseUrl
+	}
+	avatarBaseUrl.Store(strings.TrimSuffix(baseUrl, "/"))
+}
+
+// AvatarURL resolves the name of one of the bundled avatars to its URL
+func AvatarURL(avatar string) string {
+	baseUrl, ok := avatarBaseUrl.Load().(string)
+	if !ok {
+		baseUrl = defaultAvatarBaseUrl
+	}
+
+	return fmt.Sprintf("%s/%s.png", baseUrl, avatar)
+}
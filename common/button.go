@@ -0,0 +1,48 @@
+package common
+
+// Limits of Discord's link buttons
+const (
+	maxButtonsPerRow   = 5
+	maxButtonRows      = 5
+	maxButtonLabel     = 80
+	componentActionRow = 1
+	componentButton    = 2
+	buttonStyleLink    = 5
+)
+
+// LinkButton is a button opening a URL, e.g. "Watch on YouTube", shown below a message
+type LinkButton struct {
+	Label string `json:"label"`
+	URL   string `json:"url"`
+}
+
+type component struct {
+	Type       int         `json:"type"`
+	Style      int         `json:"style,omitempty"`
+	Label      string      `json:"label,omitempty"`
+	URL        string      `json:"url,omitempty"`
+	Components []component `json:"components,omitempty"`
+}
+
+// buttonComponents lays out link buttons in rows of Discord message components, leaving out those beyond the limits
+func buttonComponents(buttons []LinkButton) []component {
+	var rows []component
+	for i, button := range buttons {
+		if i == maxButtonsPerRow*maxButtonRows {
+			break
+		}
+		if i%maxButtonsPerRow == 0 {
+			rows = append(rows, component{Type: componentActionRow})
+		}
+
+		row := &rows[len(rows)-1]
+		row.Components = append(row.Components, component{
+			Type:  componentButton,
+			Style: buttonStyleLink,
+			Label: truncate(button.Label, maxButtonLabel, ""),
+			URL:   button.URL,
+		})
+	}
+
+	return rows
+}
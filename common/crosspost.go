@@ -0,0 +1,59 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+const apiBaseUrl = "https://discord.com/api/v10"
+
+// DiscordBot authorizes requests webhooks can't make, such as publishing messages in announcement channels
+type DiscordBot struct {
+	Token string
+	// Crosspost publishes every message posted to an announcement channel, so servers following it receive it as well
+	Crosspost bool
+}
+
+// crosspost publishes a message in an announcement channel. Failures are only logged, as the message itself was
+// posted and must not be sent again.
+func (discord *DiscordClient) crosspost(ctx context.Context, posted postedMessage) {
+	if !discord.bot.Crosspost || len(posted.ID) == 0 {
+		return
+	}
+
+	if err := queueOf(discord.webhookUrl).limiter.Wait(ctx); err != nil {
+		discord.error.Printf("Could not publish message '%s': %s", posted.ID, err)
+		return
+	}
+
+	endpoint := fmt.Sprintf(
+		"%s/channels/%s/messages/%s/crosspost",
+		apiBaseUrl,
+		url.PathEscape(posted.ChannelID),
+		url.PathEscape(posted.ID),
+	)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, nil)
+	if err != nil {
+		discord.error.Printf("Could not publish message '%s': %s", posted.ID, err)
+		return
+	}
+	req.Header.Set("Authorization", "Bot "+discord.bot.Token)
+
+	res, err := discordHTTP.Do(req)
+	if err != nil {
+		discord.error.Printf("Could not publish message '%s': %s", posted.ID, err)
+		return
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(res.Body)
+		discord.error.Printf("Could not publish message '%s', is the channel an announcement channel? %s", posted.ID, body)
+		return
+	}
+
+	discord.info.Printf("Published message '%s' to following servers", posted.ID)
+}
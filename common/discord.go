@@ -1,25 +1,62 @@
 package common
 
 import (
+	"17thshard.com/sanderson-notifications/metrics"
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/time/rate"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync"
 	"time"
 )
 
 const webhookBaseUrl = "https://discord.com/api/webhooks"
-const avatarBaseUrl = "https://raw.githubusercontent.com/Palanaeum/sanderson-notifications/master/avatars"
 const maxRetries = 3
 
+// maxThreadNameLength is the maximum length of thread names allowed by Discord
+const maxThreadNameLength = 100
+
+// discordHTTP traces the requests to Discord
+var discordHTTP = &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)}
+
+// webhookQueue serializes and paces the requests to a webhook across all connectors, so they wait their turn instead
+// of all running into its rate limits at once. Webhooks may send 5 requests every 2 seconds and 30 messages per minute
+// to a channel, so bursts are allowed up to the former while staying within the latter.
+type webhookQueue struct {
+	slot    chan struct{}
+	limiter *rate.Limiter
+}
+
+// webhookQueues holds the queues of all webhooks by their URL, so a rate limited webhook doesn't hold up others
+var webhookQueues sync.Map
+
+func queueOf(webhook string) *webhookQueue {
+	queue, _ := webhookQueues.LoadOrStore(webhook, &webhookQueue{
+		slot:    make(chan struct{}, 1),
+		limiter: rate.NewLimiter(rate.Every(2*time.Second), 5),
+	})
+
+	return queue.(*webhookQueue)
+}
+
 type DiscordClient struct {
-	webhookUrl    string
-	mentions      DiscordMentions
-	mentionSuffix string
-	info          *log.Logger
-	error         *log.Logger
+	webhookUrl string
+	webhookID  string
+	mentions   DiscordMentions
+	thread     DiscordThread
+	bot        DiscordBot
+	info       *log.Logger
+	error      *log.Logger
 }
 
 type DiscordMentions struct {
@@ -28,67 +65,388 @@ type DiscordMentions struct {
 	Users []string `json:"users" yaml:"users"`
 }
 
-func CreateDiscordClient(webhook string, mentions DiscordMentions) DiscordClient {
+// DiscordThread selects the thread messages are posted to. Messages either go to an existing thread of the webhook's
+// channel or each create a new thread, which requires the webhook to belong to a forum or media channel.
+type DiscordThread struct {
+	ID string
+	// Name of the threads to create, in which `{connector}`, `{username}` and `{title}` are replaced by the connector,
+	// the username and the title of the message, i.e. the title of its embed or the first line of its text
+	Name string
+	// Tags are the IDs of forum tags applied to created threads
+	Tags []string
+}
+
+// webhookMessage is the payload of a webhook execution. Username, avatar and thread can't be changed when editing a
+// message and are left empty then.
+type webhookMessage struct {
+	Content         string          `json:"content"`
+	Username        string          `json:"username,omitempty"`
+	AvatarURL       string          `json:"avatar_url,omitempty"`
+	AllowedMentions DiscordMentions `json:"allowed_mentions"`
+	Embeds          []*Embed        `json:"embeds,omitempty"`
+	ThreadName      string          `json:"thread_name,omitempty"`
+	AppliedTags     []string        `json:"applied_tags,omitempty"`
+	Attachments     []attachmentRef `json:"attachments,omitempty"`
+	Components      []component     `json:"components,omitempty"`
+	Flags           int             `json:"flags,omitempty"`
+
+	// files are uploaded along with the message, which requires a multipart request
+	files []Attachment
+}
+
+func CreateDiscordClient(webhook string, mentions DiscordMentions, thread DiscordThread, bot DiscordBot) DiscordClient {
 	infoLog, errorLog := CreateLoggers("main")
 
-	mentionSuffix := ""
+	webhookID, _, _ := strings.Cut(webhook, "/")
+
+	return DiscordClient{
+		webhookUrl: fmt.Sprintf("%s/%s", webhookBaseUrl, webhook),
+		webhookID:  webhookID,
+		mentions:   mentions,
+		thread:     thread,
+		bot:        bot,
+		info:       infoLog,
+		error:      errorLog,
+	}
+}
+
+// content appends the mentions of a message to its text, which are those of the client unless the message overrides
+// them. The allowed mentions restrict pings to exactly these roles and users.
+func (discord *DiscordClient) content(message Message) (string, DiscordMentions) {
+	mentions := discord.mentions
+	if message.Mentions != nil {
+		mentions = *message.Mentions
+	}
+
+	suffix := ""
 	for _, role := range mentions.Roles {
-		mentionSuffix += fmt.Sprintf("<@&%s> ", role)
+		suffix += fmt.Sprintf("<@&%s> ", role)
 	}
 	for _, user := range mentions.Users {
-		mentionSuffix += fmt.Sprintf("<@%s> ", user)
+		suffix += fmt.Sprintf("<@%s> ", user)
 	}
-
-	if mentionSuffix != "" {
-		mentionSuffix = fmt.Sprintf("\n-# %s", mentionSuffix)
+	if suffix != "" {
+		suffix = fmt.Sprintf("\n-# %s", suffix)
 	}
 
 	mentions.Parse = make([]string, 0)
 
-	return DiscordClient{
-		webhookUrl:    fmt.Sprintf("%s/%s", webhookBaseUrl, webhook),
-		mentions:      mentions,
-		mentionSuffix: mentionSuffix,
-		info:          infoLog,
-		error:         errorLog,
+	return fmt.Sprintf("%s%s", message.Text, suffix), mentions
+}
+
+func (discord *DiscordClient) Send(text, name, avatar string, embed *Embed) error {
+	return discord.SendWithCustomAvatar(text, name, AvatarURL(avatar), embed)
+}
+
+func (discord *DiscordClient) SendWithCustomAvatar(text, name, avatarURL string, embed *Embed) error {
+	return discord.Deliver(context.Background(), Message{Text: text, Username: name, AvatarURL: avatarURL, Embed: embed})
+}
+
+// postedMessage is the part of the message returned by Discord that is needed to edit it
+type postedMessage struct {
+	ID        string `json:"id"`
+	ChannelID string `json:"channel_id"`
+}
+
+// Deliver posts the message, remembering its ID if it is about an item and the context provides a store. Edits
+// replace the earlier message about the item if there is one. A message split into several parts counts as delivered
+// once its first part is posted.
+func (discord *DiscordClient) Deliver(ctx context.Context, message Message) error {
+	store := SentMessagesOf(ctx)
+	key := ""
+	if store != nil && len(message.Item) > 0 {
+		key = fmt.Sprintf("%s/%s/%s", discord.webhookID, message.Connector, message.Item)
+	}
+
+	if message.Edit && len(key) > 0 {
+		if sent, ok := store.SentMessage(key); ok {
+			err := discord.Edit(ctx, sent, message)
+			if !isUnknownMessage(err) {
+				return err
+			}
+			discord.info.Printf("Message '%s' to edit no longer exists, posting it again", sent.ID)
+		}
+	}
+
+	parts := discord.payloads(message)
+	if len(parts) > 1 {
+		discord.info.Printf("Message exceeds Discord's limits, sending it as %d messages", len(parts))
+	}
+
+	threadID := discord.thread.ID
+
+	return discord.queued(ctx, func() error {
+		for i, body := range parts {
+			query := url.Values{"wait": {"true"}}
+			if len(threadID) > 0 {
+				query.Set("thread_id", threadID)
+			}
+
+			var posted postedMessage
+			if err := discord.trySend(ctx, http.MethodPost, webhookEndpoint(discord.webhookUrl, query, body), body, &posted, 1); err != nil {
+				if i > 0 {
+					// Failing the delivery would post the earlier parts again when it is retried
+					discord.error.Printf("Could not send part %d of %d of message, dropping the remaining parts: %s", i+1, len(parts), err)
+					return nil
+				}
+				return err
+			}
+
+			discord.crosspost(ctx, posted)
+
+			// Continue in the thread created by the first part
+			if len(body.ThreadName) > 0 {
+				threadID = posted.ChannelID
+			}
+
+			if i == 0 && len(key) > 0 && len(posted.ID) > 0 {
+				sent := SentMessage{ID: posted.ID}
+				if len(threadID) > 0 {
+					sent.ThreadID = posted.ChannelID
+				}
+				if err := store.SetSentMessage(key, sent); err != nil {
+					discord.error.Printf("Could not remember sent message '%s': %s", posted.ID, err)
+				}
+			}
+		}
+
+		return nil
+	})
+}
+
+// payloads builds the webhook executions posting a message, which are several if it exceeds Discord's limits. Only
+// the first one creates a thread, the others are posted into it.
+func (discord *DiscordClient) payloads(message Message) []webhookMessage {
+	content, mentions := discord.content(message)
+	content, flags := suppressEmbeds(content, message)
+	parts := splitMessage(content, message.Embed)
+	discord.attach(parts, message)
+
+	threadName := discord.threadName(message)
+	for i := range parts {
+		parts[i].Username = message.Username
+		parts[i].AvatarURL = message.AvatarURL
+		parts[i].AllowedMentions = mentions
+		parts[i].Flags = flags
+		if i == 0 && len(discord.thread.ID) == 0 && len(threadName) > 0 {
+			parts[i].ThreadName, parts[i].AppliedTags = threadName, discord.thread.Tags
+		}
+	}
+
+	return parts
+}
+
+// Preview renders the JSON payloads that delivering the message would post to the webhook, without sending anything
+func (discord *DiscordClient) Preview(message Message) ([]json.RawMessage, error) {
+	parts := discord.payloads(message)
+	payloads := make([]json.RawMessage, len(parts))
+	for i, body := range parts {
+		body.Attachments = attachmentRefs(body.files)
+		serialized, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("could not serialize request: %w", err)
+		}
+		payloads[i] = serialized
+	}
+
+	return payloads, nil
+}
+
+// attach adds the files and buttons of a message to the part with the first embed, which may refer to the files, or
+// the last part if there's no embed
+func (discord *DiscordClient) attach(parts []webhookMessage, message Message) {
+	files := message.Attachments
+	if len(files) > maxAttachments {
+		discord.error.Printf("Discord accepts at most %d files per message, leaving out %d", maxAttachments, len(files)-maxAttachments)
+		files = files[:maxAttachments]
+	}
+
+	target := len(parts) - 1
+	for i, part := range parts {
+		if len(part.Embeds) > 0 {
+			target = i
+			break
+		}
+	}
+	parts[target].files = files
+	parts[target].Components = buttonComponents(message.Buttons)
+}
+
+// webhookEndpoint builds the URL of a webhook request, allowing components if the message has any
+func webhookEndpoint(base string, query url.Values, body webhookMessage) string {
+	if len(body.Components) > 0 {
+		query.Set("with_components", "true")
+	}
+	if len(query) == 0 {
+		return base
+	}
+
+	return base + "?" + query.Encode()
+}
+
+// Edit replaces the content and embed of a message posted earlier. As a single message is replaced, content and
+// embed are truncated to Discord's limits for it, while the attachments of the original message are kept.
+func (discord *DiscordClient) Edit(ctx context.Context, sent SentMessage, message Message) error {
+	content, mentions := discord.content(message)
+	content, flags := suppressEmbeds(content, message)
+	body := webhookMessage{
+		Content:         truncate(content, maxContentLength, ""),
+		AllowedMentions: mentions,
+		Flags:           flags,
+	}
+	if embed := fitEmbed(message.Embed); embed != nil {
+		body.Embeds = []*Embed{embed}
+	}
+	body.Components = buttonComponents(message.Buttons)
+
+	query := url.Values{}
+	if len(sent.ThreadID) > 0 {
+		query.Set("thread_id", sent.ThreadID)
+	}
+	editUrl := webhookEndpoint(fmt.Sprintf("%s/messages/%s", discord.webhookUrl, url.PathEscape(sent.ID)), query, body)
+
+	return discord.queued(ctx, func() error {
+		return discord.trySend(ctx, http.MethodPatch, editUrl, body, nil, 1)
+	})
+}
+
+// Verify checks that Discord knows the webhook, without posting anything
+func (discord *DiscordClient) Verify(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, discord.webhookUrl, nil)
+	if err != nil {
+		return fmt.Errorf("could not create Discord request: %w", err)
+	}
+
+	res, err := discordHTTP.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not send Discord request: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(res.Body)
+		return fmt.Errorf("Discord rejected webhook with status %d: %s", res.StatusCode, body)
 	}
+
+	return nil
+}
+
+// discordError is returned for requests Discord rejected
+type discordError struct {
+	status int
+	body   string
 }
 
-func (discord *DiscordClient) Send(text, name, avatar string, embed interface{}) error {
-	return discord.trySend(text, name, fmt.Sprintf("%s/%s.png", avatarBaseUrl, avatar), embed, 1)
+func (err *discordError) Error() string {
+	return fmt.Sprintf("couldn't send Discord message: %s", err.body)
 }
 
-func (discord *DiscordClient) SendWithCustomAvatar(text, name, avatarURL string, embed interface{}) error {
-	return discord.trySend(text, name, avatarURL, embed, 1)
+func isUnknownMessage(err error) bool {
+	var discordErr *discordError
+	return errors.As(err, &discordErr) && discordErr.status == http.StatusNotFound
 }
 
-func (discord *DiscordClient) trySend(text, name, avatarURL string, embed interface{}, try int) error {
-	body := map[string]interface{}{
-		"username":         name,
-		"avatar_url":       avatarURL,
-		"content":          fmt.Sprintf("%s%s", text, discord.mentionSuffix),
-		"allowed_mentions": discord.mentions,
+// threadName expands the name of the thread to create for a message, which is empty if no thread should be created
+func (discord *DiscordClient) threadName(message Message) string {
+	if len(discord.thread.Name) == 0 {
+		return ""
 	}
 
-	if embed != nil {
-		body["embeds"] = []interface{}{embed}
+	title := message.Text
+	if message.Embed != nil && len(message.Embed.Title) > 0 {
+		title = message.Embed.Title
+	}
+	if firstLine, _, found := strings.Cut(title, "\n"); found {
+		title = firstLine
+	}
+
+	name := strings.NewReplacer(
+		"{connector}", message.Connector,
+		"{username}", message.Username,
+		"{title}", title,
+	).Replace(discord.thread.Name)
+	name = strings.TrimSpace(name)
+	if len(name) == 0 {
+		name = message.Connector
+	}
+	if runes := []rune(name); len(runes) > maxThreadNameLength {
+		name = string(runes[:maxThreadNameLength-1]) + "…"
+	}
+
+	return name
+}
+
+// queued waits for its turn in the queue before sending, which is held while being rate limited so other messages are
+// held back too. Messages split into several parts are sent without others in between.
+func (discord *DiscordClient) queued(ctx context.Context, send func() error) error {
+	queue := queueOf(discord.webhookUrl)
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case queue.slot <- struct{}{}:
+	}
+	defer func() { <-queue.slot }()
+
+	return send()
+}
+
+// encodeBody serializes a webhook message, which is uploaded as multipart form if it has files
+func encodeBody(body webhookMessage) ([]byte, string, error) {
+	if len(body.files) > 0 {
+		serialized, contentType, err := multipartBody(body)
+		if err != nil {
+			return nil, "", fmt.Errorf("could not encode attachments: %w", err)
+		}
+		return serialized, contentType, nil
 	}
 
 	serialized, err := json.Marshal(body)
 	if err != nil {
-		return fmt.Errorf("could not serialize request: %w", err)
+		return nil, "", fmt.Errorf("could not serialize request: %w", err)
+	}
+
+	return serialized, "application/json", nil
+}
+
+// trySend sends a request to the webhook, parsing the response into result unless it is nil
+func (discord *DiscordClient) trySend(ctx context.Context, method, endpoint string, body webhookMessage, result interface{}, try int) error {
+	if err := queueOf(discord.webhookUrl).limiter.Wait(ctx); err != nil {
+		return fmt.Errorf("could not wait for Discord rate limit: %w", err)
+	}
+	if delay, err := waitForRateLimit(ctx, discord.webhookUrl); err != nil {
+		return fmt.Errorf("could not wait for Discord rate limit: %w", err)
+	} else if delay > 0 {
+		trace.SpanFromContext(ctx).AddEvent("waited for rate limit", trace.WithAttributes(
+			attribute.Float64("delay", delay.Seconds()),
+		))
 	}
 
-	res, err := http.Post(discord.webhookUrl, "application/json", bytes.NewReader(serialized))
+	serialized, contentType, err := encodeBody(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, bytes.NewReader(serialized))
+	if err != nil {
+		return fmt.Errorf("could not create Discord request: %w", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	res, err := discordHTTP.Do(req)
 	if err != nil {
 		return fmt.Errorf("could not send Discord request: %w", err)
 	}
+	defer res.Body.Close()
 
 	responseBody, err := ioutil.ReadAll(res.Body)
 	if err != nil {
 		return fmt.Errorf("could not read Discord response: %w", err)
 	}
 
+	if resumeAt := observeRateLimit(discord.webhookUrl, res.Header); !resumeAt.IsZero() {
+		discord.info.Printf("Exhausted Discord rate limit, holding back messages for %s", time.Until(resumeAt).Round(time.Millisecond))
+	}
+
 	if res.StatusCode == http.StatusTooManyRequests {
 		if try == maxRetries {
 			return fmt.Errorf("couldn't send Discord message: Rate limiting still applied after %d retries", maxRetries)
@@ -99,14 +457,31 @@ func (discord *DiscordClient) trySend(text, name, avatarURL string, embed interf
 			return fmt.Errorf("could not parse Discord response: %w", err)
 		}
 
+		metrics.DiscordRateLimits.Inc()
+		trace.SpanFromContext(ctx).AddEvent("rate limited", trace.WithAttributes(
+			attribute.Float64("retry_after", float64(data.Delay)),
+		))
 		discord.info.Printf("Being rate late limited by Discord, waiting for %fs\n", data.Delay)
-		time.Sleep(time.Duration(data.Delay * float32(time.Second)))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(data.Delay*float32(time.Second)) + rateLimitJitter()):
+		}
 
-		return discord.trySend(text, name, avatarURL, embed, try+1)
+		return discord.trySend(ctx, method, endpoint, body, result, try+1)
 	}
 
-	if res.StatusCode != http.StatusNoContent {
-		return fmt.Errorf("couldn't send Discord message: %s", string(responseBody))
+	if res.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if res.StatusCode != http.StatusOK {
+		return &discordError{status: res.StatusCode, body: string(responseBody)}
+	}
+
+	if result != nil {
+		if err := json.Unmarshal(responseBody, result); err != nil {
+			return fmt.Errorf("could not parse Discord response: %w", err)
+		}
 	}
 
 	return nil
@@ -0,0 +1,104 @@
+package common
+
+import "time"
+
+// Embed is a rich embed attached to a message. It serializes to the embed format of Discord's API, from which other
+// sinks render what they support.
+type Embed struct {
+	Title       string       `json:"title,omitempty"`
+	Description string       `json:"description,omitempty"`
+	URL         string       `json:"url,omitempty"`
+	Color       int          `json:"color,omitempty"`
+	Timestamp   *time.Time   `json:"timestamp,omitempty"`
+	Author      *EmbedAuthor `json:"author,omitempty"`
+	Thumbnail   *EmbedImage  `json:"thumbnail,omitempty"`
+	Image       *EmbedImage  `json:"image,omitempty"`
+	Footer      *EmbedFooter `json:"footer,omitempty"`
+	Fields      []EmbedField `json:"fields,omitempty"`
+}
+
+type EmbedAuthor struct {
+	Name    string `json:"name"`
+	URL     string `json:"url,omitempty"`
+	IconURL string `json:"icon_url,omitempty"`
+}
+
+type EmbedImage struct {
+	URL string `json:"url"`
+}
+
+type EmbedFooter struct {
+	Text    string `json:"text"`
+	IconURL string `json:"icon_url,omitempty"`
+}
+
+type EmbedField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline,omitempty"`
+}
+
+// NewEmbed starts building an embed. All builder methods modify the embed and return it for chaining.
+func NewEmbed() *Embed {
+	return &Embed{}
+}
+
+func (embed *Embed) WithTitle(title string) *Embed {
+	embed.Title = title
+	return embed
+}
+
+func (embed *Embed) WithDescription(description string) *Embed {
+	embed.Description = description
+	return embed
+}
+
+func (embed *Embed) WithURL(url string) *Embed {
+	embed.URL = url
+	return embed
+}
+
+// WithColor sets the color of the embed's border as RGB value, e.g. 0xFF0000 for red
+func (embed *Embed) WithColor(color int) *Embed {
+	embed.Color = color
+	return embed
+}
+
+func (embed *Embed) WithTimestamp(timestamp time.Time) *Embed {
+	embed.Timestamp = &timestamp
+	return embed
+}
+
+func (embed *Embed) WithAuthor(name, url, iconURL string) *Embed {
+	embed.Author = &EmbedAuthor{Name: name, URL: url, IconURL: iconURL}
+	return embed
+}
+
+func (embed *Embed) WithThumbnail(url string) *Embed {
+	embed.Thumbnail = &EmbedImage{URL: url}
+	return embed
+}
+
+func (embed *Embed) WithImage(url string) *Embed {
+	embed.Image = &EmbedImage{URL: url}
+	return embed
+}
+
+func (embed *Embed) WithFooter(text, iconURL string) *Embed {
+	embed.Footer = &EmbedFooter{Text: text, IconURL: iconURL}
+	return embed
+}
+
+func (embed *Embed) AddField(name, value string, inline bool) *Embed {
+	embed.Fields = append(embed.Fields, EmbedField{Name: name, Value: value, Inline: inline})
+	return embed
+}
+
+// FooterText returns the text of the footer, which is empty for embeds without footer
+func (embed *Embed) FooterText() string {
+	if embed == nil || embed.Footer == nil {
+		return ""
+	}
+
+	return embed.Footer.Text
+}
@@ -0,0 +1,229 @@
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// FixtureHeadersSuffix is appended to the path of a fixture to name the file holding the status and headers of its
+// response, which are optional
+const FixtureHeadersSuffix = ".headers"
+
+// ErrNoFixture is returned for requests to URLs without a fixture, which is never retried
+var ErrNoFixture = errors.New("no fixture")
+
+// fixtureNameEscaper encodes characters that aren't allowed in file names on all platforms
+var fixtureNameEscaper = strings.NewReplacer(
+	"?", "%3F",
+	":", "%3A",
+	"*", "%2A",
+	"\"", "%22",
+	"<", "%3C",
+	">", "%3E",
+	"|", "%7C",
+	"\\", "%5C",
+)
+
+// FixturePath determines where the response for a URL is stored in a fixtures directory, which is the host followed
+// by the path and query of the URL, e.g. `example.com/feed.xml` for `https://example.com/feed.xml`. Paths ending in a
+// slash are stored as `index`.
+func FixturePath(dir string, target *url.URL) string {
+	path := target.EscapedPath()
+	if len(path) == 0 || strings.HasSuffix(path, "/") {
+		path += "index"
+	}
+
+	segments := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	if len(target.RawQuery) > 0 {
+		segments[len(segments)-1] += "?" + strings.ReplaceAll(target.RawQuery, "/", "%2F")
+	}
+	for i, segment := range segments {
+		segment = fixtureNameEscaper.Replace(segment)
+		// Relative segments must not lead outside of the fixtures directory
+		if segment == "." || segment == ".." {
+			segment = strings.ReplaceAll(segment, ".", "%2E")
+		}
+		segments[i] = segment
+	}
+
+	return filepath.Join(append([]string{dir, fixtureNameEscaper.Replace(target.Host)}, segments...)...)
+}
+
+// FixtureTransport answers requests with responses stored in a directory instead of sending them, which allows
+// simulating runs offline, e.g. to reproduce issues with a saved copy of a website. Each response body is stored in
+// the file named by FixturePath, its status and headers may be given in HTTP format in a file next to it.
+type FixtureTransport struct {
+	Dir string
+}
+
+func (transport *FixtureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme == "file" {
+		return fileTransport{}.RoundTrip(req)
+	}
+
+	path := FixturePath(transport.Dir, req.URL)
+	res, err := serveFile(req, path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w for '%s', expected it at '%s'", ErrNoFixture, req.URL, path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read fixture for '%s': %w", req.URL, err)
+	}
+
+	head, err := os.ReadFile(path + FixtureHeadersSuffix)
+	if errors.Is(err, fs.ErrNotExist) {
+		return res, nil
+	}
+	if err != nil {
+		res.Body.Close()
+		return nil, fmt.Errorf("could not read headers of fixture for '%s': %w", req.URL, err)
+	}
+
+	// The body is read from its own file, so the head only needs to end with an empty line
+	head = append(bytes.TrimRight(head, "\r\n"), "\r\n\r\n"...)
+	recorded, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(head)), req)
+	if err != nil {
+		res.Body.Close()
+		return nil, fmt.Errorf("invalid headers of fixture for '%s': %w", req.URL, err)
+	}
+	recorded.Body.Close()
+
+	res.Status, res.StatusCode, res.Header = recorded.Status, recorded.StatusCode, recorded.Header
+	res.Header.Del("Content-Length")
+	res.Header.Del("Transfer-Encoding")
+
+	return res, nil
+}
+
+// RecordingTransport stores every response in a directory in the layout FixtureTransport reads, so the responses of a
+// run can be replayed later, e.g. to turn a breaking change of a website into a test case
+type RecordingTransport struct {
+	Base http.RoundTripper
+	Dir  string
+}
+
+func (transport *RecordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Responses to conditional requests have no body, which would make for useless fixtures
+	if len(req.Header.Get("If-None-Match")) > 0 || len(req.Header.Get("If-Modified-Since")) > 0 {
+		req = req.Clone(req.Context())
+		req.Header.Del("If-None-Match")
+		req.Header.Del("If-Modified-Since")
+	}
+
+	res, err := transport.Base.RoundTrip(req)
+	if err != nil || req.URL.Scheme == "file" {
+		return res, err
+	}
+
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	res.Body = io.NopCloser(bytes.NewReader(body))
+
+	if err = recordFixture(FixturePath(transport.Dir, req.URL), req, res, body); err != nil {
+		return nil, fmt.Errorf("could not record response of '%s': %w", req.URL, err)
+	}
+
+	return res, nil
+}
+
+// recordFixture writes the body of a response along with its status and headers, leaving out cookies, which might
+// grant access to accounts
+func recordFixture(path string, req *http.Request, res *http.Response, body []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, body, 0644); err != nil {
+		return err
+	}
+
+	header := res.Header.Clone()
+	header.Del("Set-Cookie")
+	header.Del("Content-Length")
+	header.Del("Transfer-Encoding")
+	header.Set("X-Recorded-Url", req.URL.String())
+	header.Set("X-Recorded-At", time.Now().UTC().Format(time.RFC3339))
+
+	var head bytes.Buffer
+	head.WriteString(fmt.Sprintf("%s %s\r\n", res.Proto, res.Status))
+	if err := header.Write(&head); err != nil {
+		return err
+	}
+
+	return os.WriteFile(path+FixtureHeadersSuffix, head.Bytes(), 0644)
+}
+
+// fileTransport answers requests for `file://` URLs with the content of local files, so sources can be read from disk
+type fileTransport struct{}
+
+func (fileTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	path := req.URL.Path
+	// Paths on Windows start with their drive, e.g. file:///C:/feed.xml
+	if runtime.GOOS == "windows" && len(path) > 2 && path[0] == '/' && path[2] == ':' {
+		path = path[1:]
+	}
+
+	res, err := serveFile(req, filepath.FromSlash(path))
+	if errors.Is(err, fs.ErrNotExist) {
+		return &http.Response{
+			Status:     "404 Not Found",
+			StatusCode: http.StatusNotFound,
+			Proto:      "HTTP/1.1",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header:     make(http.Header),
+			Body:       http.NoBody,
+			Request:    req,
+		}, nil
+	}
+
+	return res, err
+}
+
+// serveFile answers a request with the content of a file
+func serveFile(req *http.Request, path string) (*http.Response, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if info.IsDir() {
+		file.Close()
+		return nil, fmt.Errorf("'%s' is a directory", path)
+	}
+
+	res := &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        make(http.Header),
+		Body:          file,
+		ContentLength: info.Size(),
+		Request:       req,
+	}
+	if req.Method == http.MethodHead {
+		file.Close()
+		res.Body = http.NoBody
+	}
+
+	return res, nil
+}
@@ -0,0 +1,70 @@
+package common
+
+import (
+	"context"
+	"net/http"
+)
+
+// CacheValidators identify the version of a response, so later requests for the same URL can be answered with
+// 304 Not Modified if it didn't change
+type CacheValidators struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+}
+
+// ValidatorStore keeps the validators of responses between checks
+type ValidatorStore interface {
+	Validators(url string) (CacheValidators, bool)
+	SetValidators(url string, validators CacheValidators)
+}
+
+type conditionalKey struct{}
+
+// Conditional marks a GET request to be sent with the validators of the previous response for its URL. Callers must
+// expect a response with status 304 Not Modified, which means that nothing changed since.
+func Conditional(req *http.Request) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), conditionalKey{}, true))
+}
+
+// CachingTransport sends conditional requests with the stored validators and stores the validators of new responses
+type CachingTransport struct {
+	Base  http.RoundTripper
+	Store ValidatorStore
+}
+
+func (transport *CachingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := transport.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	conditional, _ := req.Context().Value(conditionalKey{}).(bool)
+	if !conditional || transport.Store == nil || req.Method != http.MethodGet {
+		return base.RoundTrip(req)
+	}
+
+	url := req.URL.String()
+	if validators, ok := transport.Store.Validators(url); ok {
+		req = req.Clone(req.Context())
+		if len(validators.ETag) > 0 {
+			req.Header.Set("If-None-Match", validators.ETag)
+		}
+		if len(validators.LastModified) > 0 {
+			req.Header.Set("If-Modified-Since", validators.LastModified)
+		}
+	}
+
+	res, err := base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode == http.StatusOK {
+		validators := CacheValidators{ETag: res.Header.Get("ETag"), LastModified: res.Header.Get("Last-Modified")}
+		if len(validators.ETag) > 0 || len(validators.LastModified) > 0 {
+			transport.Store.SetValidators(url, validators)
+		}
+	}
+
+	return res, nil
+}
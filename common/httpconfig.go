@@ -0,0 +1,234 @@
+package common
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// HTTPConfig configures the requests plugins make to their sources
+type HTTPConfig struct {
+	UserAgent string `yaml:"userAgent"`
+	// Proxy is the URL of an HTTP, HTTPS or SOCKS5 proxy. Without it, the standard HTTP_PROXY and HTTPS_PROXY
+	// environment variables are used.
+	Proxy string    `yaml:"proxy"`
+	TLS   TLSConfig `yaml:"tls"`
+	// MaxRedirects limits how many redirects are followed, with 0 not following any. Go's default of 10 is used if
+	// unset.
+	MaxRedirects *int `yaml:"maxRedirects"`
+	// MaxResponseSize is the maximum number of bytes read from a response body, which is unlimited if 0
+	MaxResponseSize int64 `yaml:"maxResponseSize"`
+	// Timeout limits each request including reading its response, which is only limited by the timeout of checks if 0
+	Timeout time.Duration `yaml:"timeout"`
+	// Headers are added to all requests, e.g. to authenticate with a source
+	Headers map[string]string `yaml:"headers"`
+	// Fixtures is a directory whose files answer all requests instead of the sources, see FixtureTransport
+	Fixtures string `yaml:"-"`
+	// Record is a directory to store all responses in as fixtures, see RecordingTransport
+	Record string `yaml:"-"`
+}
+
+type TLSConfig struct {
+	CAFile             string `yaml:"caFile"`
+	MinVersion         string `yaml:"minVersion"`
+	InsecureSkipVerify bool   `yaml:"insecureSkipVerify"`
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// WithDefaults fills all settings that aren't set with the given defaults
+func (config HTTPConfig) WithDefaults(defaults HTTPConfig) HTTPConfig {
+	if len(config.UserAgent) == 0 {
+		config.UserAgent = defaults.UserAgent
+	}
+	if len(config.Proxy) == 0 {
+		config.Proxy = defaults.Proxy
+	}
+	if len(config.TLS.CAFile) == 0 {
+		config.TLS.CAFile = defaults.TLS.CAFile
+	}
+	if len(config.TLS.MinVersion) == 0 {
+		config.TLS.MinVersion = defaults.TLS.MinVersion
+	}
+	config.TLS.InsecureSkipVerify = config.TLS.InsecureSkipVerify || defaults.TLS.InsecureSkipVerify
+	if config.MaxRedirects == nil {
+		config.MaxRedirects = defaults.MaxRedirects
+	}
+	if config.MaxResponseSize <= 0 {
+		config.MaxResponseSize = defaults.MaxResponseSize
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaults.Timeout
+	}
+	if len(defaults.Headers) > 0 {
+		headers := make(map[string]string, len(defaults.Headers)+len(config.Headers))
+		for name, value := range defaults.Headers {
+			headers[http.CanonicalHeaderKey(name)] = value
+		}
+		for name, value := range config.Headers {
+			headers[http.CanonicalHeaderKey(name)] = value
+		}
+		config.Headers = headers
+	}
+
+	return config
+}
+
+// Transport creates the base transport for requests with these settings
+func (config HTTPConfig) Transport() (http.RoundTripper, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if len(config.Proxy) > 0 {
+		proxy, err := url.Parse(config.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL '%s': %w", config.Proxy, err)
+		}
+		switch proxy.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return nil, fmt.Errorf("unsupported proxy scheme '%s', expected 'http', 'https' or 'socks5'", proxy.Scheme)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+
+	tlsConfig, err := config.TLS.build()
+	if err != nil {
+		return nil, err
+	}
+	transport.TLSClientConfig = tlsConfig
+	transport.RegisterProtocol("file", fileTransport{})
+
+	var base http.RoundTripper = transport
+	if len(config.Fixtures) > 0 {
+		base = &FixtureTransport{Dir: config.Fixtures}
+	}
+
+	if len(config.UserAgent) > 0 || config.MaxResponseSize > 0 || len(config.Headers) > 0 {
+		base = &requestSettingsTransport{
+			Base:            base,
+			userAgent:       config.UserAgent,
+			headers:         config.Headers,
+			maxResponseSize: config.MaxResponseSize,
+		}
+	}
+	if len(config.Record) > 0 {
+		base = &RecordingTransport{Base: base, Dir: config.Record}
+	}
+
+	return base, nil
+}
+
+// CheckRedirect implements the redirect policy for http.Client
+func (config HTTPConfig) CheckRedirect(req *http.Request, via []*http.Request) error {
+	if config.MaxRedirects == nil {
+		return nil
+	}
+	if len(via) > *config.MaxRedirects {
+		return fmt.Errorf("stopped after %d redirects", *config.MaxRedirects)
+	}
+
+	return nil
+}
+
+func (config TLSConfig) build() (*tls.Config, error) {
+	result := &tls.Config{InsecureSkipVerify: config.InsecureSkipVerify}
+
+	if len(config.MinVersion) > 0 {
+		version, ok := tlsVersions[config.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unknown minimum TLS version '%s', expected one of '1.0', '1.1', '1.2' and '1.3'", config.MinVersion)
+		}
+		result.MinVersion = version
+	}
+
+	if len(config.CAFile) > 0 {
+		pem, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read CA file: %w", err)
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file '%s'", config.CAFile)
+		}
+		result.RootCAs = pool
+	}
+
+	return result, nil
+}
+
+// ErrResponseTooLarge is returned when reading a response body that exceeds the maximum size
+var ErrResponseTooLarge = errors.New("response exceeds maximum size")
+
+// requestSettingsTransport sets the user agent and headers of requests and limits the size of responses
+type requestSettingsTransport struct {
+	Base            http.RoundTripper
+	userAgent       string
+	headers         map[string]string
+	maxResponseSize int64
+}
+
+func (transport *requestSettingsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if len(transport.userAgent) > 0 || len(transport.headers) > 0 {
+		req = req.Clone(req.Context())
+		if len(transport.userAgent) > 0 {
+			req.Header.Set("User-Agent", transport.userAgent)
+		}
+		for name, value := range transport.headers {
+			req.Header.Set(name, value)
+		}
+	}
+
+	res, err := transport.Base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if transport.maxResponseSize > 0 {
+		if res.ContentLength > transport.maxResponseSize {
+			res.Body.Close()
+			return nil, fmt.Errorf("%w of %d bytes: '%s' has %d bytes", ErrResponseTooLarge, transport.maxResponseSize, req.URL, res.ContentLength)
+		}
+		res.Body = &limitedBody{ReadCloser: res.Body, remaining: transport.maxResponseSize, limit: transport.maxResponseSize}
+	}
+
+	return res, nil
+}
+
+// limitedBody fails reads once more than the limit was read, instead of silently truncating the body
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+	limit     int64
+}
+
+func (body *limitedBody) Read(p []byte) (int, error) {
+	if body.remaining < 0 {
+		return 0, fmt.Errorf("%w of %d bytes", ErrResponseTooLarge, body.limit)
+	}
+	if int64(len(p)) > body.remaining+1 {
+		p = p[:body.remaining+1]
+	}
+
+	n, err := body.ReadCloser.Read(p)
+	body.remaining -= int64(n)
+	if body.remaining < 0 {
+		return n + int(body.remaining), fmt.Errorf("%w of %d bytes", ErrResponseTooLarge, body.limit)
+	}
+
+	return n, err
+}
@@ -0,0 +1,84 @@
+package common
+
+import (
+	"embed"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+)
+
+// defaultLanguage is used for any text missing in another language, and if none is configured
+const defaultLanguage = "en"
+
+//go:embed locales/*.yaml
+var localeFiles embed.FS
+
+// Locale provides the built-in texts of plugins, such as default messages, in a language
+type Locale struct {
+	Language string
+	texts    map[string]string
+	fallback map[string]string
+}
+
+// LoadLocale loads the bundled texts for the given language, which is English if empty
+func LoadLocale(language string) (Locale, error) {
+	if len(language) == 0 {
+		language = defaultLanguage
+	}
+
+	texts, err := readLocale(language)
+	if err != nil {
+		return Locale{}, fmt.Errorf("unknown language '%s', must be one of %s", language, strings.Join(Languages(), ", "))
+	}
+
+	fallback, err := readLocale(defaultLanguage)
+	if err != nil {
+		return Locale{}, err
+	}
+
+	return Locale{Language: language, texts: texts, fallback: fallback}, nil
+}
+
+// Languages lists the languages texts are bundled for
+func Languages() []string {
+	files, _ := fs.Glob(localeFiles, "locales/*.yaml")
+
+	languages := make([]string, 0, len(files))
+	for _, file := range files {
+		languages = append(languages, strings.TrimSuffix(path.Base(file), ".yaml"))
+	}
+	slices.Sort(languages)
+
+	return languages
+}
+
+func readLocale(language string) (map[string]string, error) {
+	content, err := localeFiles.ReadFile(fmt.Sprintf("locales/%s.yaml", language))
+	if err != nil {
+		return nil, err
+	}
+
+	var texts map[string]string
+	if err = yaml.Unmarshal(content, &texts); err != nil {
+		return nil, fmt.Errorf("could not parse texts for language '%s': %w", language, err)
+	}
+
+	return texts, nil
+}
+
+// Text returns the text with the given key, falling back to English if it isn't translated. The zero Locale provides
+// the English texts.
+func (locale Locale) Text(key string) string {
+	if text, ok := locale.texts[key]; ok {
+		return text
+	}
+	if locale.fallback == nil {
+		english, _ := readLocale(defaultLanguage)
+		return english[key]
+	}
+
+	return locale.fallback[key]
+}
@@ -0,0 +1,199 @@
+package common
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultLogFileMaxSize    = 10 << 20
+	defaultLogFileMaxBackups = 5
+	logFileTimestampFormat   = "20060102T150405.000Z"
+)
+
+// LogFileConfig configures writing logs to files, e.g. for deployments whose output goes nowhere. Files are rotated
+// once they reach their maximum size, keeping a limited number of previous files next to them.
+type LogFileConfig struct {
+	// Path is the file all messages are written to
+	Path string `yaml:"path"`
+	// ErrorPath is the file warnings and errors are additionally written to
+	ErrorPath  string        `yaml:"errorPath"`
+	MaxSize    int64         `yaml:"maxSize"`
+	MaxBackups *int          `yaml:"maxBackups"`
+	MaxAge     time.Duration `yaml:"maxAge"`
+}
+
+// Validate checks the limits of the log files
+func (config LogFileConfig) Validate() error {
+	if config.MaxSize < 0 {
+		return fmt.Errorf("maximum size of log files must not be negative")
+	}
+	if config.MaxBackups != nil && *config.MaxBackups < 0 {
+		return fmt.Errorf("number of rotated log files to keep must not be negative")
+	}
+	if config.MaxAge < 0 {
+		return fmt.Errorf("maximum age of rotated log files must not be negative")
+	}
+	if len(config.Path) > 0 && filepath.Clean(config.Path) == filepath.Clean(config.ErrorPath) {
+		return fmt.Errorf("error log must be written to a different file than the log")
+	}
+
+	return nil
+}
+
+func (config LogFileConfig) maxSize() int64 {
+	if config.MaxSize == 0 {
+		return defaultLogFileMaxSize
+	}
+
+	return config.MaxSize
+}
+
+func (config LogFileConfig) maxBackups() int {
+	if config.MaxBackups == nil {
+		return defaultLogFileMaxBackups
+	}
+
+	return *config.MaxBackups
+}
+
+// RotatingFile appends to a log file, which is renamed with a timestamp once it would grow beyond its maximum size.
+// Rotated files beyond the maximum number or age are removed.
+type RotatingFile struct {
+	path       string
+	lock       sync.Mutex
+	file       *os.File
+	size       int64
+	maxSize    int64
+	maxBackups int
+	maxAge     time.Duration
+}
+
+// OpenRotatingFile opens the log file at the given path for appending, creating it and its directory if needed
+func OpenRotatingFile(path string, config LogFileConfig) (*RotatingFile, error) {
+	file := &RotatingFile{path: path}
+	file.configure(config)
+	if err := file.open(); err != nil {
+		return nil, err
+	}
+
+	return file, nil
+}
+
+// configure applies the limits of the config, which take effect with the next write
+func (file *RotatingFile) configure(config LogFileConfig) {
+	file.lock.Lock()
+	defer file.lock.Unlock()
+
+	file.maxSize, file.maxBackups, file.maxAge = config.maxSize(), config.maxBackups(), config.MaxAge
+}
+
+func (file *RotatingFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(file.path), 0755); err != nil {
+		return fmt.Errorf("could not create directory of log file '%s': %w", file.path, err)
+	}
+
+	opened, err := os.OpenFile(file.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("could not open log file '%s': %w", file.path, err)
+	}
+	info, err := opened.Stat()
+	if err != nil {
+		opened.Close()
+		return fmt.Errorf("could not open log file '%s': %w", file.path, err)
+	}
+
+	file.file, file.size = opened, info.Size()
+	return nil
+}
+
+func (file *RotatingFile) Write(content []byte) (int, error) {
+	file.lock.Lock()
+	defer file.lock.Unlock()
+
+	if file.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	if file.size > 0 && file.size+int64(len(content)) > file.maxSize {
+		if err := file.rotate(); err != nil {
+			// Keep logging to the current file rather than losing messages
+			fmt.Fprintf(os.Stderr, "Could not rotate log file '%s': %s\n", file.path, err)
+		}
+	}
+
+	written, err := file.file.Write(content)
+	file.size += int64(written)
+	return written, err
+}
+
+// rotate renames the current file and starts a new one, removing the rotated files that are no longer kept
+func (file *RotatingFile) rotate() error {
+	if err := file.file.Close(); err != nil {
+		return err
+	}
+
+	rotatedPath := fmt.Sprintf("%s.%s", file.path, time.Now().UTC().Format(logFileTimestampFormat))
+	renameErr := os.Rename(file.path, rotatedPath)
+	if err := file.open(); err != nil {
+		return err
+	}
+	if renameErr != nil {
+		return renameErr
+	}
+
+	return file.prune()
+}
+
+// prune removes the oldest rotated files beyond the maximum number and those older than the maximum age
+func (file *RotatingFile) prune() error {
+	candidates, err := filepath.Glob(file.path + ".*")
+	if err != nil {
+		return err
+	}
+
+	type rotatedFile struct {
+		path      string
+		rotatedAt time.Time
+	}
+	var rotated []rotatedFile
+	for _, candidate := range candidates {
+		rotatedAt, err := time.Parse(logFileTimestampFormat, strings.TrimPrefix(candidate, file.path+"."))
+		if err == nil {
+			rotated = append(rotated, rotatedFile{candidate, rotatedAt})
+		}
+	}
+	sort.Slice(rotated, func(i, j int) bool {
+		return rotated[i].rotatedAt.After(rotated[j].rotatedAt)
+	})
+
+	for i, candidate := range rotated {
+		if i < file.maxBackups && (file.maxAge == 0 || time.Since(candidate.rotatedAt) <= file.maxAge) {
+			continue
+		}
+		if err := os.Remove(candidate.path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Close closes the current file, after which writes fail
+func (file *RotatingFile) Close() error {
+	file.lock.Lock()
+	defer file.lock.Unlock()
+
+	if file.file == nil {
+		return nil
+	}
+
+	err := file.file.Close()
+	file.file = nil
+	return err
+}
@@ -0,0 +1,411 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// loggerKey is the attribute holding the name of a logger, which the text format shows as prefix
+	loggerKey = "logger"
+	// connectorKey is the attribute holding the connector a logger belongs to, which selects its level
+	connectorKey = "connector"
+)
+
+// LogConfig configures the format and verbosity of logs
+type LogConfig struct {
+	Format string `yaml:"format"`
+	Level  string `yaml:"level"`
+	// Connectors overrides the level of the logs of individual connectors, e.g. to silence a noisy one while debugging
+	// another
+	Connectors map[string]string `yaml:"connectors"`
+	File       LogFileConfig     `yaml:"file"`
+}
+
+// logLevels holds the minimum level of messages to log, in general and for individual connectors
+type logLevels struct {
+	general    slog.Level
+	connectors map[string]slog.Level
+}
+
+// level determines the minimum level of the messages of a connector, which is the general one for other loggers
+func (levels *logLevels) level(connector string) slog.Level {
+	if level, ok := levels.connectors[connector]; ok {
+		return level
+	}
+
+	return levels.general
+}
+
+var (
+	currentLevels atomic.Pointer[logLevels]
+	// levelOverride replaces the level of the config if set, e.g. by a command line flag
+	levelOverride atomic.Pointer[slog.Level]
+	logHandler    atomic.Pointer[slog.Handler]
+	// logFiles holds the currently open log files by path, so they're kept open when logging is reconfigured
+	logFiles     = make(map[string]*RotatingFile)
+	logFilesLock sync.Mutex
+)
+
+func init() {
+	currentLevels.Store(&logLevels{general: slog.LevelInfo})
+
+	// Handlers pass on all messages, as their level is checked by the loggers
+	var handler slog.Handler = newLevelSplitHandler(
+		newPrefixHandler(os.Stdout, slog.LevelDebug),
+		newPrefixHandler(os.Stderr, slog.LevelDebug),
+	)
+	logHandler.Store(&handler)
+}
+
+// Validate checks the format and levels of the config
+func (config LogConfig) Validate() error {
+	if _, err := config.levels(); err != nil {
+		return err
+	}
+	if err := config.File.Validate(); err != nil {
+		return err
+	}
+
+	switch config.Format {
+	case "", "text", "json":
+		return nil
+	default:
+		return fmt.Errorf("unknown log format '%s', expected 'text' or 'json'", config.Format)
+	}
+}
+
+func (config LogConfig) levels() (*logLevels, error) {
+	levels := &logLevels{general: slog.LevelInfo, connectors: make(map[string]slog.Level, len(config.Connectors))}
+	if len(config.Level) > 0 {
+		if err := levels.general.UnmarshalText([]byte(config.Level)); err != nil {
+			return nil, fmt.Errorf("invalid log level '%s'", config.Level)
+		}
+	}
+	if override := levelOverride.Load(); override != nil {
+		levels.general = *override
+	}
+
+	for connector, text := range config.Connectors {
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(text)); err != nil {
+			return nil, fmt.Errorf("invalid log level '%s' for connector '%s'", text, connector)
+		}
+		levels.connectors[connector] = level
+	}
+
+	return levels, nil
+}
+
+// OverrideLogLevel replaces the general level of all configs, including those configured later, e.g. to debug a
+// single run without changing the config
+func OverrideLogLevel(text string) error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(text)); err != nil {
+		return fmt.Errorf("invalid log level '%s', expected 'debug', 'info', 'warn' or 'error'", text)
+	}
+
+	levelOverride.Store(&level)
+
+	levels := *currentLevels.Load()
+	levels.general = level
+	currentLevels.Store(&levels)
+	return nil
+}
+
+// ConfigureLogging switches the format and levels of all loggers, including those that were already created
+func ConfigureLogging(config LogConfig) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	levels, _ := config.levels()
+
+	logFilesLock.Lock()
+	defer logFilesLock.Unlock()
+
+	files := make(map[string]*RotatingFile)
+	for _, path := range []string{config.File.Path, config.File.ErrorPath} {
+		if len(path) == 0 {
+			continue
+		}
+		file, ok := logFiles[path]
+		if ok {
+			file.configure(config.File)
+		} else {
+			var err error
+			if file, err = OpenRotatingFile(path, config.File); err != nil {
+				closeLogFiles(files)
+				return err
+			}
+		}
+		files[path] = file
+	}
+
+	newHandler := func(out io.Writer, level slog.Level) slog.Handler {
+		if config.Format == "json" {
+			return slog.NewJSONHandler(out, &slog.HandlerOptions{Level: level})
+		}
+		return newPrefixHandler(out, level)
+	}
+
+	handlers := multiHandler{newLevelSplitHandler(newHandler(os.Stdout, slog.LevelDebug), newHandler(os.Stderr, slog.LevelDebug))}
+	if file, ok := files[config.File.Path]; ok {
+		handlers = append(handlers, newHandler(file, slog.LevelDebug))
+	}
+	if file, ok := files[config.File.ErrorPath]; ok {
+		handlers = append(handlers, newHandler(file, slog.LevelWarn))
+	}
+	var handler slog.Handler = handlers
+	if len(handlers) == 1 {
+		handler = handlers[0]
+	}
+
+	currentLevels.Store(levels)
+	logHandler.Store(&handler)
+
+	for path, file := range logFiles {
+		if _, ok := files[path]; !ok {
+			_ = file.Close()
+		}
+	}
+	logFiles = files
+	return nil
+}
+
+func closeLogFiles(files map[string]*RotatingFile) {
+	for path, file := range files {
+		if _, ok := logFiles[path]; !ok {
+			_ = file.Close()
+		}
+	}
+}
+
+// NewLogger creates a structured logger with the given name and attributes. Loggers with a `connector` attribute log
+// with the level configured for that connector.
+func NewLogger(name string, attrs ...any) *slog.Logger {
+	return slog.New(dynamicHandler{}).With(loggerKey, name).With(attrs...)
+}
+
+// dynamicHandler passes records on to the currently configured handler, if their level is enabled for the connector
+// the logger belongs to
+type dynamicHandler struct {
+	connector string
+	derive    []func(slog.Handler) slog.Handler
+}
+
+func (handler dynamicHandler) current() slog.Handler {
+	current := *logHandler.Load()
+	for _, derive := range handler.derive {
+		current = derive(current)
+	}
+
+	return current
+}
+
+func (handler dynamicHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= currentLevels.Load().level(handler.connector) && (*logHandler.Load()).Enabled(ctx, level)
+}
+
+func (handler dynamicHandler) Handle(ctx context.Context, record slog.Record) error {
+	if record.Level < currentLevels.Load().level(handler.connector) {
+		return nil
+	}
+
+	return handler.current().Handle(ctx, record)
+}
+
+func (handler dynamicHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	connector := handler.connector
+	for _, attr := range attrs {
+		if attr.Key == connectorKey {
+			connector = attr.Value.String()
+		}
+	}
+
+	return dynamicHandler{connector: connector, derive: append(handler.derive[:len(handler.derive):len(handler.derive)], func(h slog.Handler) slog.Handler {
+		return h.WithAttrs(attrs)
+	})}
+}
+
+func (handler dynamicHandler) WithGroup(name string) slog.Handler {
+	return dynamicHandler{connector: handler.connector, derive: append(handler.derive[:len(handler.derive):len(handler.derive)], func(h slog.Handler) slog.Handler {
+		return h.WithGroup(name)
+	})}
+}
+
+// multiHandler passes records on to all of its handlers, e.g. to write them to the console and a file
+type multiHandler []slog.Handler
+
+func (handlers multiHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, handler := range handlers {
+		if handler.Enabled(ctx, level) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (handlers multiHandler) Handle(ctx context.Context, record slog.Record) error {
+	var errs []error
+	for _, handler := range handlers {
+		if handler.Enabled(ctx, record.Level) {
+			errs = append(errs, handler.Handle(ctx, record.Clone()))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (handlers multiHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	derived := make(multiHandler, len(handlers))
+	for i, handler := range handlers {
+		derived[i] = handler.WithAttrs(attrs)
+	}
+
+	return derived
+}
+
+func (handlers multiHandler) WithGroup(name string) slog.Handler {
+	derived := make(multiHandler, len(handlers))
+	for i, handler := range handlers {
+		derived[i] = handler.WithGroup(name)
+	}
+
+	return derived
+}
+
+// levelSplitHandler writes warnings and errors to a different handler than all other records, so they can go to
+// stderr
+type levelSplitHandler struct {
+	out slog.Handler
+	err slog.Handler
+}
+
+func newLevelSplitHandler(out, err slog.Handler) slog.Handler {
+	return levelSplitHandler{out: out, err: err}
+}
+
+func (handler levelSplitHandler) pick(level slog.Level) slog.Handler {
+	if level >= slog.LevelWarn {
+		return handler.err
+	}
+
+	return handler.out
+}
+
+func (handler levelSplitHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return handler.pick(level).Enabled(ctx, level)
+}
+
+func (handler levelSplitHandler) Handle(ctx context.Context, record slog.Record) error {
+	return handler.pick(record.Level).Handle(ctx, record)
+}
+
+func (handler levelSplitHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return levelSplitHandler{out: handler.out.WithAttrs(attrs), err: handler.err.WithAttrs(attrs)}
+}
+
+func (handler levelSplitHandler) WithGroup(name string) slog.Handler {
+	return levelSplitHandler{out: handler.out.WithGroup(name), err: handler.err.WithGroup(name)}
+}
+
+// prefixHandler keeps the traditional log format, prefixing messages with their level and the name of their logger.
+// Attributes of loggers are implied by their name, so only the attributes of records are appended to the message.
+type prefixHandler struct {
+	out   io.Writer
+	lock  *sync.Mutex
+	level slog.Leveler
+	name  string
+	group string
+}
+
+func newPrefixHandler(out io.Writer, level slog.Leveler) slog.Handler {
+	return &prefixHandler{out: out, lock: &sync.Mutex{}, level: level}
+}
+
+func (handler *prefixHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= handler.level.Level()
+}
+
+func (handler *prefixHandler) Handle(_ context.Context, record slog.Record) error {
+	var line strings.Builder
+	line.WriteString(record.Time.Format("2006/01/02 15:04:05"))
+	line.WriteString(fmt.Sprintf(" [%s] [%s] ", record.Level, handler.name))
+	line.WriteString(strings.TrimSuffix(record.Message, "\n"))
+
+	record.Attrs(func(attr slog.Attr) bool {
+		key := attr.Key
+		if len(handler.group) > 0 {
+			key = handler.group + "." + key
+		}
+		value := attr.Value.String()
+		if strings.ContainsAny(value, " \"=") {
+			value = strconv.Quote(value)
+		}
+		line.WriteString(fmt.Sprintf(" %s=%s", key, value))
+		return true
+	})
+	line.WriteString("\n")
+
+	handler.lock.Lock()
+	defer handler.lock.Unlock()
+
+	_, err := io.WriteString(handler.out, line.String())
+	return err
+}
+
+func (handler *prefixHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	derived := *handler
+	for _, attr := range attrs {
+		if attr.Key == loggerKey {
+			derived.name = attr.Value.String()
+		}
+	}
+
+	return &derived
+}
+
+func (handler *prefixHandler) WithGroup(name string) slog.Handler {
+	derived := *handler
+	if len(derived.group) > 0 {
+		name = derived.group + "." + name
+	}
+	derived.group = name
+
+	return &derived
+}
+
+// LoggingTransport logs every request at debug level with its response status and duration, e.g. to see which URLs a
+// connector requests
+type LoggingTransport struct {
+	Base http.RoundTripper
+	Log  *slog.Logger
+}
+
+func (transport *LoggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !transport.Log.Enabled(req.Context(), slog.LevelDebug) {
+		return transport.Base.RoundTrip(req)
+	}
+
+	started := time.Now()
+	res, err := transport.Base.RoundTrip(req)
+	duration := time.Since(started).Round(time.Millisecond)
+	if err != nil {
+		transport.Log.Debug("Request failed", "method", req.Method, "url", req.URL.String(), "duration", duration, "error", err)
+		return nil, err
+	}
+
+	transport.Log.Debug("Sent request", "method", req.Method, "url", req.URL.String(), "status", res.StatusCode, "duration", duration)
+	return res, nil
+}
@@ -0,0 +1,36 @@
+package common
+
+import (
+	"regexp"
+	"strings"
+)
+
+// flagSuppressEmbeds keeps Discord from showing any embeds for a message, including those sent with it
+const flagSuppressEmbeds = 1 << 2
+
+var bareURLPattern = regexp.MustCompile(`<?https?://[^\s<>()\[\]]+>?`)
+
+// suppressLinkPreviews wraps all links of a text in angle brackets, which keeps Discord from showing previews for them
+func suppressLinkPreviews(text string) string {
+	return bareURLPattern.ReplaceAllStringFunc(text, func(link string) string {
+		if strings.HasPrefix(link, "<") && strings.HasSuffix(link, ">") {
+			return link
+		}
+
+		return "<" + strings.Trim(link, "<>") + ">"
+	})
+}
+
+// suppressEmbeds hides the link previews of a message, returning its content and flags. Messages without embeds of
+// their own are flagged, so Discord doesn't show any embeds, while links are wrapped individually in messages that
+// come with embeds.
+func suppressEmbeds(content string, message Message) (string, int) {
+	if !message.SuppressEmbeds {
+		return content, 0
+	}
+	if message.Embed == nil {
+		return content, flagSuppressEmbeds
+	}
+
+	return suppressLinkPreviews(content), 0
+}
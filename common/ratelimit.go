@@ -0,0 +1,63 @@
+package common
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxRateLimitJitter spreads out requests after a rate limit resets, so a backlog doesn't hit the limit right away
+const maxRateLimitJitter = 250 * time.Millisecond
+
+// discordBuckets remembers until when webhooks that exhausted their rate limit must not be used, as reported by
+// Discord in the headers of every response
+var discordBuckets = struct {
+	sync.Mutex
+	resumeAt map[string]time.Time
+}{resumeAt: make(map[string]time.Time)}
+
+// observeRateLimit records the rate limit reported for a webhook, returning until when it is exhausted
+func observeRateLimit(webhook string, header http.Header) time.Time {
+	if header.Get("X-RateLimit-Remaining") != "0" {
+		return time.Time{}
+	}
+
+	resetAfter, err := strconv.ParseFloat(header.Get("X-RateLimit-Reset-After"), 64)
+	if err != nil {
+		return time.Time{}
+	}
+
+	resumeAt := time.Now().Add(time.Duration(resetAfter*float64(time.Second)) + rateLimitJitter())
+
+	discordBuckets.Lock()
+	defer discordBuckets.Unlock()
+	discordBuckets.resumeAt[webhook] = resumeAt
+
+	return resumeAt
+}
+
+// waitForRateLimit waits until the rate limit of the webhook reset, if it was exhausted
+func waitForRateLimit(ctx context.Context, webhook string) (time.Duration, error) {
+	discordBuckets.Lock()
+	delay := time.Until(discordBuckets.resumeAt[webhook])
+	delete(discordBuckets.resumeAt, webhook)
+	discordBuckets.Unlock()
+
+	if delay <= 0 {
+		return 0, nil
+	}
+
+	select {
+	case <-ctx.Done():
+		return delay, ctx.Err()
+	case <-time.After(delay):
+		return delay, nil
+	}
+}
+
+func rateLimitJitter() time.Duration {
+	return time.Duration(rand.Int63n(int64(maxRateLimitJitter)))
+}
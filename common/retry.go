@@ -0,0 +1,167 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// RetryPolicy describes how often and how quickly failed operations are retried
+type RetryPolicy struct {
+	Attempts   int           `yaml:"attempts"`
+	Backoff    time.Duration `yaml:"backoff"`
+	MaxBackoff time.Duration `yaml:"maxBackoff"`
+}
+
+// HTTPStatusError reports an unexpected status code of an HTTP response
+type HTTPStatusError struct {
+	URL        string
+	StatusCode int
+}
+
+func (err HTTPStatusError) Error() string {
+	return fmt.Sprintf("request to '%s' failed with status %d", err.URL, err.StatusCode)
+}
+
+// retriedError marks errors that mustn't be retried again, as the requests causing them already were
+type retriedError struct {
+	error
+}
+
+func (err retriedError) Unwrap() error {
+	return err.error
+}
+
+// retriesKey holds the flag RetryTransport sets once it gave up retrying a request
+type retriesKey struct{}
+
+// TrackRetries returns a context whose requests report to the returned flag when RetryTransport gave up retrying them
+func TrackRetries(ctx context.Context) (context.Context, *atomic.Bool) {
+	exhausted := &atomic.Bool{}
+	return context.WithValue(ctx, retriesKey{}, exhausted), exhausted
+}
+
+// AlreadyRetried marks the error as not retryable, e.g. since the failed requests were retried by RetryTransport
+func AlreadyRetried(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return retriedError{err}
+}
+
+// IsRetryable classifies errors as transient, i.e. network failures, server errors and rate limiting
+func IsRetryable(err error) bool {
+	var retried retriedError
+	if errors.As(err, &retried) {
+		return false
+	}
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ErrNoFixture) {
+		return false
+	}
+
+	var statusErr HTTPStatusError
+	if errors.As(err, &statusErr) {
+		return retryableStatus(statusErr.StatusCode)
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+func retryableStatus(statusCode int) bool {
+	return statusCode >= 500 || statusCode == http.StatusTooManyRequests
+}
+
+// Delay computes the randomized exponential backoff before the given retry, starting at 1
+func (policy RetryPolicy) Delay(retry int) time.Duration {
+	delay := policy.Backoff << (retry - 1)
+	if delay <= 0 || (policy.MaxBackoff > 0 && delay > policy.MaxBackoff) {
+		delay = policy.MaxBackoff
+	}
+
+	// Jitter keeps connectors that failed at the same time from retrying in lockstep
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// Do runs the operation until it succeeds, fails with an error that isn't retryable or runs out of attempts.
+// onRetry is invoked before waiting for each retry and may be nil.
+func (policy RetryPolicy) Do(ctx context.Context, operation func() error, onRetry func(err error, delay time.Duration)) error {
+	for attempt := 1; ; attempt++ {
+		err := operation()
+		if attempt >= policy.Attempts || !IsRetryable(err) {
+			return err
+		}
+
+		delay := policy.Delay(attempt)
+		if onRetry != nil {
+			onRetry(err, delay)
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
+
+// RetryTransport retries idempotent HTTP requests that fail due to network errors, server errors or rate limiting
+type RetryTransport struct {
+	Base   http.RoundTripper
+	Policy RetryPolicy
+	Info   *log.Logger
+}
+
+func (transport *RetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := transport.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return base.RoundTrip(req)
+	}
+
+	for attempt := 1; ; attempt++ {
+		res, err := base.RoundTrip(req)
+		if err == nil && !retryableStatus(res.StatusCode) {
+			return res, nil
+		}
+		if err == nil {
+			err = HTTPStatusError{URL: req.URL.String(), StatusCode: res.StatusCode}
+		}
+		if attempt >= transport.Policy.Attempts || !IsRetryable(err) {
+			if exhausted, ok := req.Context().Value(retriesKey{}).(*atomic.Bool); ok && IsRetryable(err) {
+				exhausted.Store(true)
+			}
+			if res != nil {
+				return res, nil
+			}
+			return nil, err
+		}
+
+		if res != nil {
+			_, _ = io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+		}
+
+		delay := transport.Policy.Delay(attempt)
+		if transport.Info != nil {
+			transport.Info.Printf("Request to '%s' failed, retrying in %s: %s", req.URL, delay.Round(time.Millisecond), err)
+		}
+
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(delay):
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package common
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestChecksAreNotRetriedAfterRetriedRequests(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	policy := RetryPolicy{Attempts: 3, Backoff: time.Millisecond, MaxBackoff: time.Millisecond}
+	client := &http.Client{Transport: &RetryTransport{Policy: policy}}
+
+	checks := 0
+	err := policy.Do(context.Background(), func() error {
+		checks++
+		ctx, retried := TrackRetries(context.Background())
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		err = HTTPStatusError{URL: server.URL, StatusCode: res.StatusCode}
+		if retried.Load() {
+			return AlreadyRetried(err)
+		}
+		return err
+	}, nil)
+
+	if err == nil {
+		t.Fatal("expected the check to fail")
+	}
+	if checks != 1 || requests != 3 {
+		t.Errorf("expected 1 check with 3 requests, got %d checks with %d requests", checks, requests)
+	}
+}
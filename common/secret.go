@@ -0,0 +1,43 @@
+package common
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ResolveSecret returns a secret that is either given inline, read from an environment variable or read from a file,
+// so configs can be shared without it. At most one of the sources may be given.
+func ResolveSecret(name, value, env, file string) (string, error) {
+	given := 0
+	for _, source := range []string{value, env, file} {
+		if len(source) > 0 {
+			given++
+		}
+	}
+	if given > 1 {
+		return "", fmt.Errorf("%s must only be given once, either inline, via environment variable or via file", name)
+	}
+
+	if len(env) > 0 {
+		secret := strings.TrimSpace(os.Getenv(env))
+		if len(secret) == 0 {
+			return "", fmt.Errorf("environment variable '%s' for %s is not set", env, name)
+		}
+		return secret, nil
+	}
+
+	if len(file) > 0 {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("could not read %s: %w", name, err)
+		}
+		secret := strings.TrimSpace(string(content))
+		if len(secret) == 0 {
+			return "", fmt.Errorf("file '%s' for %s is empty", file, name)
+		}
+		return secret, nil
+	}
+
+	return value, nil
+}
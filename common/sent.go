@@ -0,0 +1,29 @@
+package common
+
+import "context"
+
+// SentMessage identifies a message a sink posted, so it can be edited later
+type SentMessage struct {
+	ID string `json:"id"`
+	// ThreadID is the thread the message was posted in, if any
+	ThreadID string `json:"threadId,omitempty"`
+}
+
+// SentMessageStore remembers which messages sinks posted about the items of connectors
+type SentMessageStore interface {
+	SentMessage(key string) (SentMessage, bool)
+	SetSentMessage(key string, message SentMessage) error
+}
+
+type sentMessagesKey struct{}
+
+// WithSentMessages makes the store available to the sinks delivering messages with the returned context
+func WithSentMessages(ctx context.Context, store SentMessageStore) context.Context {
+	return context.WithValue(ctx, sentMessagesKey{}, store)
+}
+
+// SentMessagesOf returns the store of the context, which is nil if sent messages aren't tracked
+func SentMessagesOf(ctx context.Context) SentMessageStore {
+	store, _ := ctx.Value(sentMessagesKey{}).(SentMessageStore)
+	return store
+}
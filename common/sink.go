@@ -0,0 +1,101 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// Message is a single notification rendered by a plugin, independent of where it is delivered to.
+type Message struct {
+	Connector string `json:"connector"`
+	Text      string `json:"text"`
+	Username  string `json:"username"`
+	AvatarURL string `json:"avatarUrl,omitempty"`
+	Embed     *Embed `json:"embed,omitempty"`
+	// Buttons link to the source of the message, e.g. to watch a video. Sinks without buttons show them as links.
+	Buttons []LinkButton `json:"buttons,omitempty"`
+	// SuppressEmbeds hides the previews sinks show for links in the text
+	SuppressEmbeds bool `json:"suppressEmbeds,omitempty"`
+	// Mentions replace the roles and users sinks mention for this message if set
+	Mentions *DiscordMentions `json:"mentions,omitempty"`
+	// Attachments are files sent along with the message, which sinks not supporting files leave out
+	Attachments []Attachment `json:"attachments,omitempty"`
+	// Type is the kind of update the message reports for plugins with several, e.g. `livestream` or `retweet`
+	Type string `json:"type,omitempty"`
+	// Item identifies what the message is about within its connector, which allows editing it later
+	Item string `json:"item,omitempty"`
+	// Edit replaces the earlier message about the same item. Sinks that can't edit messages deliver it as a new one.
+	Edit      bool      `json:"edit,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Sink delivers rendered messages to a notification target, e.g. a Discord webhook or an MQTT broker.
+type Sink interface {
+	Name() string
+
+	Validate() error
+
+	Deliver(ctx context.Context, message Message) error
+}
+
+// DiagnoseSink is implemented by sinks that can verify their target without delivering anything, e.g. to check a
+// setup before running it
+type DiagnoseSink interface {
+	// Diagnose returns an error if the target can't be reached or rejects the sink's credentials
+	Diagnose(ctx context.Context) error
+}
+
+// PreviewSink is implemented by sinks that can show the requests they would send for a message, e.g. to iterate on
+// message templates without posting anything
+type PreviewSink interface {
+	// Preview returns the JSON payloads delivering the message would send
+	Preview(message Message) ([]json.RawMessage, error)
+}
+
+// DiscordSender is the interface plugins use to publish notifications. The name stems from Discord being the original
+// and default target, other sinks receive the same Discord-style messages.
+type DiscordSender interface {
+	Send(text, name, avatar string, embed *Embed) error
+
+	SendWithCustomAvatar(text, name, avatarURL string, embed *Embed) error
+
+	// SendMessage publishes a message built by the plugin, filling in its connector and timestamp
+	SendMessage(message Message) error
+
+	// EditMessage updates the message previously sent about the item of the given message, or sends it if there is none
+	EditMessage(message Message) error
+}
+
+// SinkSender delivers all messages sent through it to a sink, attributing them to a connector.
+type SinkSender struct {
+	Connector string
+	Sink      Sink
+	Context   context.Context
+}
+
+func (sender SinkSender) Send(text, name, avatar string, embed *Embed) error {
+	return sender.SendWithCustomAvatar(text, name, AvatarURL(avatar), embed)
+}
+
+func (sender SinkSender) SendWithCustomAvatar(text, name, avatarURL string, embed *Embed) error {
+	return sender.SendMessage(Message{
+		Text:      text,
+		Username:  name,
+		AvatarURL: avatarURL,
+		Embed:     embed,
+	})
+}
+
+func (sender SinkSender) SendMessage(message Message) error {
+	message.Connector = sender.Connector
+	message.Timestamp = time.Now()
+
+	return sender.Sink.Deliver(sender.Context, message)
+}
+
+func (sender SinkSender) EditMessage(message Message) error {
+	message.Edit = true
+
+	return sender.SendMessage(message)
+}
@@ -0,0 +1,210 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Limits of Discord messages, see https://discord.com/developers/docs/resources/message#create-message
+const (
+	maxContentLength    = 2000
+	maxEmbedsPerMessage = 10
+	maxEmbedsLength     = 6000
+	maxEmbedDescription = 4096
+	maxEmbedFields      = 25
+	maxEmbedTitle       = 256
+	maxEmbedAuthorName  = 256
+	maxEmbedFieldName   = 256
+	maxEmbedFieldValue  = 1024
+	maxEmbedFooter      = 2048
+)
+
+// splitMessage splits the content and embed of a message into as many webhook messages as needed to stay within
+// Discord's limits. Content comes first, followed by the embeds that continue the original one.
+func splitMessage(content string, embed *Embed) []webhookMessage {
+	var parts []webhookMessage
+	for _, chunk := range splitText(content, maxContentLength) {
+		parts = append(parts, webhookMessage{Content: chunk})
+	}
+	if len(parts) == 0 {
+		parts = append(parts, webhookMessage{})
+	}
+
+	length := 0
+	for _, continued := range continueEmbed(embed) {
+		last := &parts[len(parts)-1]
+		continuedLength := embedLength(continued)
+		if len(last.Embeds) == maxEmbedsPerMessage || (len(last.Embeds) > 0 && length+continuedLength > maxEmbedsLength) {
+			parts = append(parts, webhookMessage{})
+			last, length = &parts[len(parts)-1], 0
+		}
+
+		last.Embeds = append(last.Embeds, continued)
+		length += continuedLength
+	}
+
+	return parts
+}
+
+// splitText splits text into chunks of at most limit characters, preferring to split between lines and then between
+// words. Line breaks and spaces at the split are dropped.
+func splitText(text string, limit int) []string {
+	var chunks []string
+	for utf8.RuneCountInString(text) > limit {
+		head := string([]rune(text)[:limit])
+
+		split := strings.LastIndex(head, "\n")
+		if split <= 0 {
+			split = strings.LastIndex(head, " ")
+		}
+		if split <= 0 {
+			chunks = append(chunks, head)
+			text = text[len(head):]
+			continue
+		}
+
+		chunks = append(chunks, text[:split])
+		text = text[split+1:]
+	}
+	if len(text) > 0 {
+		chunks = append(chunks, text)
+	}
+
+	return chunks
+}
+
+// continueEmbed splits an embed whose description or fields exceed Discord's limits into several embeds. The first
+// one keeps the title, author and thumbnail, the last one the image, footer and timestamp.
+func continueEmbed(embed *Embed) []*Embed {
+	if embed == nil {
+		return nil
+	}
+
+	embed = limitEmbed(embed)
+	if utf8.RuneCountInString(embed.Description) <= maxEmbedDescription &&
+		len(embed.Fields) <= maxEmbedFields &&
+		embedLength(embed) <= maxEmbedsLength {
+		return []*Embed{embed}
+	}
+
+	first := *embed
+	first.Description, first.Fields = "", nil
+	first.Image, first.Footer, first.Timestamp = nil, nil, nil
+
+	embeds := []*Embed{&first}
+	continued := func() *Embed {
+		next := &Embed{Color: embed.Color}
+		embeds = append(embeds, next)
+		return next
+	}
+
+	for i, chunk := range splitText(embed.Description, maxEmbedDescription) {
+		current := embeds[len(embeds)-1]
+		if i > 0 {
+			current = continued()
+		}
+		current.Description = chunk
+	}
+
+	for _, field := range embed.Fields {
+		current := embeds[len(embeds)-1]
+		fieldLength := utf8.RuneCountInString(field.Name) + utf8.RuneCountInString(field.Value)
+		if len(current.Fields) == maxEmbedFields || embedLength(current)+fieldLength > maxEmbedsLength {
+			current = continued()
+		}
+		current.Fields = append(current.Fields, field)
+	}
+
+	last := embeds[len(embeds)-1]
+	if embed.Footer != nil && embedLength(last)+utf8.RuneCountInString(embed.Footer.Text) > maxEmbedsLength {
+		last = continued()
+	}
+	last.Image, last.Footer, last.Timestamp = embed.Image, embed.Footer, embed.Timestamp
+
+	return embeds
+}
+
+// fitEmbed truncates an embed so it fits into a single message on its own, dropping fields beyond the limits
+func fitEmbed(embed *Embed) *Embed {
+	if embed == nil {
+		return nil
+	}
+
+	fitted := limitEmbed(embed)
+	if len(fitted.Fields) > maxEmbedFields {
+		fitted.Fields = fitted.Fields[:maxEmbedFields]
+	}
+	fitted.Description = truncate(fitted.Description, maxEmbedDescription, embed.URL)
+
+	for len(fitted.Fields) > 0 && embedLength(fitted)-utf8.RuneCountInString(fitted.Description) > maxEmbedsLength {
+		fitted.Fields = fitted.Fields[:len(fitted.Fields)-1]
+	}
+	if excess := embedLength(fitted) - maxEmbedsLength; excess > 0 {
+		fitted.Description = truncate(fitted.Description, utf8.RuneCountInString(fitted.Description)-excess, embed.URL)
+	}
+
+	return fitted
+}
+
+// limitEmbed returns a copy of the embed whose texts are truncated to Discord's limits. Truncated field values link
+// to the source of the embed if it has one, so readers can still find the full text.
+func limitEmbed(embed *Embed) *Embed {
+	limited := *embed
+	limited.Title = truncate(embed.Title, maxEmbedTitle, "")
+	if embed.Author != nil {
+		author := *embed.Author
+		author.Name = truncate(author.Name, maxEmbedAuthorName, "")
+		limited.Author = &author
+	}
+	if embed.Footer != nil {
+		footer := *embed.Footer
+		footer.Text = truncate(footer.Text, maxEmbedFooter, "")
+		limited.Footer = &footer
+	}
+
+	limited.Fields = make([]EmbedField, len(embed.Fields))
+	for i, field := range embed.Fields {
+		field.Name = truncate(field.Name, maxEmbedFieldName, "")
+		field.Value = truncate(field.Value, maxEmbedFieldValue, embed.URL)
+		limited.Fields[i] = field
+	}
+
+	return &limited
+}
+
+// truncate shortens text to at most limit characters, ending it with an ellipsis. If a link is given and fits, it
+// is appended so the full text can be read at its source.
+func truncate(text string, limit int, link string) string {
+	if utf8.RuneCountInString(text) <= limit {
+		return text
+	}
+	if limit <= 0 {
+		return ""
+	}
+
+	suffix := "…"
+	if more := fmt.Sprintf("… [more](%s)", link); len(link) > 0 && utf8.RuneCountInString(more) <= limit/2 {
+		suffix = more
+	}
+
+	runes := []rune(text)[:max(limit-utf8.RuneCountInString(suffix), 0)]
+	return strings.TrimRightFunc(string(runes), unicode.IsSpace) + suffix
+}
+
+// embedLength counts the characters of an embed that count towards the total limit of a message's embeds
+func embedLength(embed *Embed) int {
+	length := utf8.RuneCountInString(embed.Title) + utf8.RuneCountInString(embed.Description)
+	if embed.Author != nil {
+		length += utf8.RuneCountInString(embed.Author.Name)
+	}
+	if embed.Footer != nil {
+		length += utf8.RuneCountInString(embed.Footer.Text)
+	}
+	for _, field := range embed.Fields {
+		length += utf8.RuneCountInString(field.Name) + utf8.RuneCountInString(field.Value)
+	}
+
+	return length
+}
@@ -0,0 +1,60 @@
+package common
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		limit    int
+		link     string
+		expected string
+	}{
+		{name: "short text is kept", text: "short", limit: 5, expected: "short"},
+		{name: "long text ends with ellipsis", text: "a long text", limit: 6, expected: "a lon…"},
+		{name: "trailing spaces are trimmed", text: "a long text", limit: 3, expected: "a…"},
+		{name: "link is appended if it fits", text: strings.Repeat("a", 100), limit: 60, link: "https://x.co", expected: strings.Repeat("a", 38) + "… [more](https://x.co)"},
+		{name: "link is left out if it doesn't fit", text: strings.Repeat("a", 100), limit: 20, link: "https://x.co", expected: strings.Repeat("a", 19) + "…"},
+		{name: "limit of the ellipsis", text: "text", limit: 1, expected: "…"},
+		{name: "limit of zero", text: "text", limit: 0, expected: ""},
+		{name: "negative limit", text: "text", limit: -3, expected: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if truncated := truncate(test.text, test.limit, test.link); truncated != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, truncated)
+			}
+		})
+	}
+}
+
+func TestFitEmbedWithoutRoomForDescription(t *testing.T) {
+	embed := NewEmbed().
+		WithTitle(strings.Repeat("t", maxEmbedTitle)).
+		WithDescription("no room left").
+		WithURL("https://example.com")
+	for i := 0; i < 4; i++ {
+		embed.AddField(strings.Repeat("n", maxEmbedFieldName), strings.Repeat("v", maxEmbedFieldValue), false)
+	}
+	// The title and fields take up exactly the total limit of embeds
+	embed.AddField(strings.Repeat("n", 100), strings.Repeat("v", 524), false)
+
+	fitted := fitEmbed(embed)
+	if fitted.Description != "" {
+		t.Errorf("expected description to be dropped, got %q", fitted.Description)
+	}
+	if len(fitted.Fields) != 5 {
+		t.Errorf("expected all 5 fields to be kept, got %d", len(fitted.Fields))
+	}
+	if length := embedLength(fitted); length > maxEmbedsLength {
+		t.Errorf("expected at most %d characters, got %d", maxEmbedsLength, length)
+	}
+	if !utf8.ValidString(fitted.Description) {
+		t.Errorf("description is not valid UTF-8")
+	}
+}
@@ -0,0 +1,75 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// TemplateData holds the fields message templates of plugins can refer to, e.g. `{{.Title}}`
+type TemplateData struct {
+	// Nickname is the configured or fallback name of the source, e.g. an account or channel
+	Nickname string
+	// Type is the kind of update, e.g. `livestream` or `retweet`
+	Type        string
+	Title       string
+	Link        string
+	Author      string
+	PublishedAt time.Time
+	// StartsAt is the scheduled start of livestreams and premieres
+	StartsAt time.Time
+}
+
+var markdownEscaper = strings.NewReplacer(
+	"\\", "\\\\",
+	"*", "\\*",
+	"_", "\\_",
+	"~", "\\~",
+	"`", "\\`",
+	"|", "\\|",
+	">", "\\>",
+	"#", "\\#",
+	"[", "\\[",
+	"]", "\\]",
+)
+
+var templateFuncs = template.FuncMap{
+	// timestamp formats a time as Discord timestamp, shown in each reader's time zone, with an optional style such as
+	// `R` for relative times or `f` for date and time
+	"timestamp": func(t time.Time, style ...string) string {
+		if len(style) == 0 {
+			return fmt.Sprintf("<t:%d>", t.Unix())
+		}
+		return fmt.Sprintf("<t:%d:%s>", t.Unix(), style[0])
+	},
+	// escape keeps Discord from interpreting markdown in the text, e.g. in titles
+	"escape": func(text string) string {
+		return markdownEscaper.Replace(text)
+	},
+}
+
+// ParseTemplate parses a message template, making the helper functions available to it
+func ParseTemplate(name, text string) (*template.Template, error) {
+	parsed, err := template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse template '%s': %w", name, err)
+	}
+
+	return parsed, nil
+}
+
+// RenderTemplate renders a message template with the given data
+func RenderTemplate(name, text string, data TemplateData) (string, error) {
+	parsed, err := ParseTemplate(name, text)
+	if err != nil {
+		return "", err
+	}
+
+	var result strings.Builder
+	if err = parsed.Execute(&result, data); err != nil {
+		return "", fmt.Errorf("could not render template '%s': %w", name, err)
+	}
+
+	return result.String(), nil
+}
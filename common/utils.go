@@ -1,16 +1,14 @@
 package common
 
 import (
-	"fmt"
 	"log"
-	"os"
+	"log/slog"
 )
 
-func CreateLoggers(name string) (info *log.Logger, error *log.Logger) {
-	return log.New(os.Stdout,
-			fmt.Sprintf("[INFO] [%s] ", name),
-			log.Ldate|log.Ltime|log.Lmsgprefix),
-		log.New(os.Stderr,
-			fmt.Sprintf("[ERROR] [%s] ", name),
-			log.Ldate|log.Ltime|log.Lmsgprefix)
-}
\ No newline at end of file
+// CreateLoggers creates a pair of loggers for informational messages and errors, which write through the structured
+// logger with the given name and attributes
+func CreateLoggers(name string, attrs ...any) (info *log.Logger, error *log.Logger) {
+	logger := NewLogger(name, attrs...)
+	return slog.NewLogLogger(logger.Handler(), slog.LevelInfo),
+		slog.NewLogLogger(logger.Handler(), slog.LevelError)
+}
@@ -3,97 +3,864 @@ package main
 import (
 	"17thshard.com/sanderson-notifications/common"
 	. "17thshard.com/sanderson-notifications/plugins"
+	"17thshard.com/sanderson-notifications/sinks"
+	"17thshard.com/sanderson-notifications/state"
+	"errors"
 	"fmt"
 	"github.com/mitchellh/mapstructure"
 	"gopkg.in/yaml.v3"
+	"io/fs"
 	"os"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
 )
 
+const (
+	defaultSink          = "discord"
+	defaultInterval      = 5 * time.Minute
+	defaultFlushInterval = time.Minute
+	defaultTimeout       = 10 * time.Minute
+	defaultRetryAttempts = 3
+	defaultRetryBackoff  = 2 * time.Second
+	defaultMaxBackoff    = 30 * time.Second
+	// defaultOffsetRetention keeps handled entries long enough for any feed that still lists them to be checked again
+	defaultOffsetRetention = 365 * 24 * time.Hour
+)
+
+// defaultUserAgent identifies requests to sources, as some of them block Go's default user agent
+var defaultUserAgent = fmt.Sprintf("sanderson-notifications/%s (+https://github.com/17thshard/sanderson-notifications)", version)
+
+// namespacePattern matches valid namespaces of the state, which mustn't contain the slash separating them from keys
+var namespacePattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
 type ConfigLoader struct {
 	AvailablePlugins map[string]func() Plugin
+	AvailableSinks   map[string]func() common.Sink
+	AvailableStores  map[string]func() state.Store
+	// Environment replaces the environment given in the config if not empty
+	Environment string
 }
 
 type Config struct {
 	DiscordWebhook      string                            `yaml:"discordWebhook"`
+	DiscordWebhookEnv   string                            `yaml:"discordWebhookEnv"`
+	DiscordWebhookFile  string                            `yaml:"discordWebhookFile"`
+	AdminWebhook        string                            `yaml:"adminWebhook"`
+	AdminWebhookEnv     string                            `yaml:"adminWebhookEnv"`
+	AdminWebhookFile    string                            `yaml:"adminWebhookFile"`
+	AdminSink           common.Sink                       `yaml:"-"`
 	DiscordMentions     common.DiscordMentions            `yaml:"discordMentions"`
+	Language            string                            `yaml:"language"`
+	Avatars             AvatarConfig                      `yaml:"avatars"`
+	Timeout             time.Duration                     `yaml:"timeout"`
+	Retry               common.RetryPolicy                `yaml:"retry"`
+	HTTP                common.HTTPConfig                 `yaml:"http"`
+	QuietHours          QuietHoursConfig                  `yaml:"quietHours"`
+	Outbox              bool                              `yaml:"outbox"`
+	Dedupe              DedupeConfig                      `yaml:"dedupe"`
+	History             HistoryConfig                     `yaml:"history"`
+	HealthCheck         HealthCheckConfig                 `yaml:"healthCheck"`
+	Metrics             MetricsConfig                     `yaml:"metrics"`
+	Tracing             TracingConfig                     `yaml:"tracing"`
+	Logging             common.LogConfig                  `yaml:"logging"`
+	Sentry              SentryConfig                      `yaml:"sentry"`
 	Connectors          []Connector                       `yaml:"-"`
+	Sinks               map[string]common.Sink            `yaml:"-"`
+	Store               state.Store                       `yaml:"-"`
+	Namespace           string                            `yaml:"-"`
+	Concurrency         ConcurrencyConfig                 `yaml:"concurrency"`
+	CircuitBreaker      CircuitBreakerConfig              `yaml:"circuitBreaker"`
+	Digest              DigestConfig                      `yaml:"digest"`
+	Daemon              DaemonConfig                      `yaml:"daemon"`
+	Server              ServerConfig                      `yaml:"server"`
+	WebSub              WebSubConfig                      `yaml:"websub"`
 	SharedPluginConfigs map[string]map[string]interface{} `yaml:"shared"`
+	RawSinks            map[string]RawSink                `yaml:"sinks"`
+	RawState            *RawState                         `yaml:"state"`
 	RawConnectors       map[string]RawConnector           `yaml:"connectors"`
+	// Templates hold settings shared by several connectors, which are applied before decoding the connectors
+	Templates map[string]RawConnector `yaml:"templates"`
+	// Defaults hold settings of all connectors, which are applied along with templates. Only their plugin config is
+	// merged after decoding, so shared plugin configs take precedence over it.
+	Defaults RawConnector `yaml:"defaults"`
+	// Environment is the deployment the config is loaded for, e.g. `production`, which selects connectors via their
+	// `onlyIn` and `exceptIn` settings
+	Environment string `yaml:"environment"`
+	// OffsetRetention is how long plugins remember handled entries that were last seen in their feed
+	OffsetRetention time.Duration `yaml:"offsetRetention"`
+	// Disabled are the names of connectors that are turned off
+	Disabled []string `yaml:"-"`
+	// Excluded are the names of connectors that aren't meant for the environment
+	Excluded []string `yaml:"-"`
+	// Includes are the files and directories included by the config file
+	Includes []string `yaml:"-"`
+
+	// locations are where the values of the config are given, to point errors at them
+	locations configLocations
+	// skippedPlugins are the plugins of disabled and excluded connectors, whose offsets are migrated all the same
+	skippedPlugins map[string]string
+}
+
+// DaemonConfig configures the long-running mode of the application
+type DaemonConfig struct {
+	Interval      time.Duration `yaml:"interval"`
+	FlushInterval time.Duration `yaml:"flushInterval"`
+	// WatchConfig reloads the config whenever its file changes, in addition to reloading it on SIGHUP
+	WatchConfig bool `yaml:"watchConfig"`
+	// Tags schedule all connectors with the tag that don't have an interval or schedule of their own
+	Tags map[string]TagSchedule `yaml:"tags"`
+	// Lease lets replicas sharing the state store take turns
+	Lease LeaseConfig `yaml:"lease"`
+}
+
+// TagSchedule determines when the connectors with a tag are checked in daemon mode
+type TagSchedule struct {
+	Interval time.Duration `yaml:"interval"`
+	Schedule string        `yaml:"schedule"`
+	Timezone string        `yaml:"timezone"`
 }
 
 type Connector struct {
-	Name   string
-	Plugin *Plugin
+	Name       string
+	Plugin     *Plugin
+	Sink       common.Sink
+	Targets    []string
+	Tags       []string
+	Schedule   Schedule
+	Timeout    time.Duration
+	Retry      common.RetryPolicy
+	HTTP       common.HTTPConfig
+	QuietHours *QuietHours
+	Overrides  MessageOverrides
+	Locale     common.Locale
+	// OffsetRetention is how long the plugin remembers handled entries no longer in its source
+	OffsetRetention time.Duration
 }
 
 type RawConnector struct {
-	Plugin string
+	// Template names the template whose settings the connector is based on
+	Template string `yaml:"template"`
+	// Enabled turns the connector off if false, keeping its config and offset for when it's turned on again
+	Enabled *bool `yaml:"enabled"`
+	Plugin  string
+	Timeout time.Duration
+	Retry   *common.RetryPolicy
+	HTTP    common.HTTPConfig
+	// QuietHours replaces the global quiet hours, with an empty config disabling them for the connector
+	QuietHours *QuietHoursConfig `yaml:"quietHours"`
+	// Mentions replace those of the connector's sinks, with empty mentions not pinging anyone
+	Mentions *common.DiscordMentions `yaml:"mentions"`
+	// MentionsByType replace the mentions for messages of certain types, e.g. only pinging for livestreams
+	MentionsByType map[string]common.DiscordMentions `yaml:"mentionsByType"`
+	// Username and the avatar replace those set by the plugin. Avatar names one of the bundled avatars, while AvatarURL
+	// may point to any image.
+	Username  string `yaml:"username"`
+	Avatar    string `yaml:"avatar"`
+	AvatarURL string `yaml:"avatarUrl"`
+	// SuppressEmbeds hides the previews Discord shows for links in the connector's messages
+	SuppressEmbeds bool `yaml:"suppressEmbeds"`
+	// Language replaces the global language of the built-in texts of the connector's plugin
+	Language string `yaml:"language"`
+	Interval time.Duration
+	Schedule string
+	Timezone string
+	Sink     string
+	Sinks    []RawConnectorSink
+	Config   map[string]interface{}
+	// SharedLists selects whether lists in the shared config of the plugin are replaced by those of the connector or
+	// whether the connector's items are appended to them
+	SharedLists string `yaml:"sharedLists"`
+	// OnlyIn and ExceptIn restrict the environments the connector runs in
+	OnlyIn   []string `yaml:"onlyIn"`
+	ExceptIn []string `yaml:"exceptIn"`
+	// Tags group connectors, e.g. to run only some of them or to schedule them together
+	Tags []string `yaml:"tags"`
+	// OffsetRetention replaces the global retention of handled entries
+	OffsetRetention time.Duration `yaml:"offsetRetention"`
+}
+
+// runsIn checks whether the connector is meant for the environment, which never matches `onlyIn` if it's empty
+func (connector RawConnector) runsIn(environment string) bool {
+	if len(connector.OnlyIn) > 0 && !slices.Contains(connector.OnlyIn, environment) {
+		return false
+	}
+
+	return !slices.Contains(connector.ExceptIn, environment)
+}
+
+// RawConnectorSink references a sink by name, optionally overriding parts of its config for a single connector.
+// It may either be given as just the name of the sink or as an object with name and overrides.
+type RawConnectorSink struct {
+	Sink   string
 	Config map[string]interface{}
 }
 
+func (sink *RawConnectorSink) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return node.Decode(&sink.Sink)
+	}
+
+	type plain RawConnectorSink
+	return node.Decode((*plain)(sink))
+}
+
+type RawSink struct {
+	Type   string
+	Config map[string]interface{}
+}
+
+// RawState selects where offsets and other state are stored, which is the offsets file by default
+type RawState struct {
+	Type   string
+	Config map[string]interface{}
+	// Namespace keeps the state of the config apart from that of other configs sharing the store
+	Namespace string
+}
+
 func (loader ConfigLoader) Load(path string) (*Config, error) {
-	configContent, err := os.ReadFile(path)
+	environment, err := loader.environmentConfig(os.Environ())
+	if err != nil {
+		return nil, err
+	}
+
+	// Without a config file, the config may be given by environment variables alone
+	configContent, err := readConfigFile(path)
+	if errors.Is(err, fs.ErrNotExist) && environment != nil {
+		configContent, err = nil, nil
+	}
 	if err != nil {
 		return nil, fmt.Errorf("could not read config file: %w", err)
 	}
 
+	document, err := parseConfigDocument(path, configContent)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse config file: %w", err)
+	}
+	locations := make(configLocations)
+	locations.record(documentRoot(document), configName(path), "")
+	includes, err := resolveIncludes(document, path, locations)
+	if err != nil {
+		return nil, err
+	}
+	if environment != nil {
+		locations.record(documentRoot(environment), environmentLocation, "")
+		if root := documentRoot(document); root != nil {
+			overrideNodes(root, documentRoot(environment))
+		} else {
+			document = environment
+		}
+	}
+	if err = applyTemplates(document); err != nil {
+		return nil, err
+	}
+	if err = interpolate(document); err != nil {
+		return nil, fmt.Errorf("could not interpolate config file: %w", err)
+	}
+	if err = loader.checkUnknownKeys(document, locations); err != nil {
+		return nil, fmt.Errorf("config contains unknown keys: %w", err)
+	}
+
 	var config Config
-	if err = yaml.Unmarshal(configContent, &config); err != nil {
+	if err = document.Decode(&config); err != nil {
 		return nil, fmt.Errorf("could not parse config file: %w", err)
 	}
+	if len(loader.Environment) > 0 {
+		config.Environment = loader.Environment
+	}
+	config.Includes = includes
+	config.locations = locations
 
-	if len(config.DiscordWebhook) == 0 {
-		return nil, fmt.Errorf("config is missing Discord webhook ID")
+	if config.Timeout <= 0 {
+		config.Timeout = defaultTimeout
+	}
+	if config.OffsetRetention <= 0 {
+		config.OffsetRetention = defaultOffsetRetention
+	}
+	config.Retry = withDefaultRetry(config.Retry, common.RetryPolicy{
+		Attempts:   defaultRetryAttempts,
+		Backoff:    defaultRetryBackoff,
+		MaxBackoff: defaultMaxBackoff,
+	})
+	if len(config.HTTP.UserAgent) == 0 {
+		config.HTTP.UserAgent = defaultUserAgent
+	}
+	if config.Concurrency.Limit <= 0 {
+		config.Concurrency.Limit = defaultConcurrency
+	}
+	if config.Concurrency.PerHost <= 0 {
+		config.Concurrency.PerHost = defaultHostConcurrency
+	}
+	if err = config.Avatars.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid avatar settings: %w", err)
+	}
+	common.SetAvatarBaseURL(config.Avatars.BaseURL)
+
+	if config.CircuitBreaker.Threshold <= 0 {
+		config.CircuitBreaker.Threshold = defaultFailureThreshold
+	}
+	if config.CircuitBreaker.CoolDown <= 0 {
+		config.CircuitBreaker.CoolDown = defaultCoolDown
+	}
+	if config.Daemon.Interval <= 0 {
+		config.Daemon.Interval = defaultInterval
+	}
+	if config.Daemon.FlushInterval <= 0 {
+		config.Daemon.FlushInterval = defaultFlushInterval
+	}
+	if config.Daemon.Lease.Duration <= 0 {
+		config.Daemon.Lease.Duration = defaultDaemonLeaseDuration
 	}
 
+	if err = loader.loadSinks(&config); err != nil {
+		return nil, err
+	}
+	if config.RawState != nil && len(config.RawState.Type) == 0 {
+		config.RawState.Type = "file"
+	}
+	if config.RawState != nil {
+		if namespace := config.RawState.Namespace; len(namespace) > 0 && !namespacePattern.MatchString(namespace) {
+			return nil, locations.wrap("state.namespace", fmt.Errorf("invalid state namespace '%s', it may only contain letters, digits, '.', '_' and '-'", namespace))
+		}
+		config.Namespace = config.RawState.Namespace
+
+		storeBuilder, ok := loader.AvailableStores[config.RawState.Type]
+		if !ok {
+			return nil, fmt.Errorf("unknown state store type '%s'", config.RawState.Type)
+		}
+
+		config.Store = storeBuilder()
+		if err = decodeConfig(config.RawState.Config, &config.Store); err != nil {
+			return nil, fmt.Errorf("could not parse config for state store of type '%s': %w", config.RawState.Type, err)
+		}
+	}
+	if config.Daemon.Lease.Enabled {
+		if _, ok := config.Store.(state.LeaseStore); !ok {
+			return nil, locations.wrap("daemon.lease", fmt.Errorf("leases require a state store shared by all replicas, such as 'postgres', 'redis', 's3' or 'gcs'"))
+		}
+		if config.Daemon.Lease.Duration < time.Second {
+			return nil, locations.wrap("daemon.lease.duration", fmt.Errorf("lease duration must be at least one second"))
+		}
+	}
+
+	if alertSink := config.CircuitBreaker.AlertSink; len(alertSink) > 0 && config.Sinks[alertSink] == nil {
+		return nil, locations.wrap("circuitBreaker.alertSink", fmt.Errorf("unknown alert sink '%s' for circuit breaker", alertSink))
+	}
+	if digestSink := config.Digest.Sink; len(digestSink) > 0 && config.Sinks[digestSink] == nil {
+		return nil, locations.wrap("digest.sink", fmt.Errorf("unknown sink '%s' for digests", digestSink))
+	}
+	if config.Digest.Enabled && len(config.Digest.Sink) == 0 && config.AdminSink == nil {
+		return nil, locations.wrap("digest", fmt.Errorf("digests require either a sink or the admin webhook"))
+	}
+	if err = config.Logging.Validate(); err != nil {
+		return nil, locations.wrap("logging", err)
+	}
+	for name := range config.Logging.Connectors {
+		if _, ok := config.RawConnectors[name]; !ok {
+			return nil, locations.wrap("logging.connectors."+name, fmt.Errorf("unknown connector '%s' for log level", name))
+		}
+	}
+
+	for key := range config.Defaults.Config {
+		accepted := false
+		for _, builder := range loader.AvailablePlugins {
+			if acceptsOption(builder(), key) {
+				accepted = true
+				break
+			}
+		}
+		if !accepted {
+			return nil, locations.wrap("defaults.config."+key, fmt.Errorf("unknown key '%s' in default config, no plugin accepts it", key))
+		}
+	}
+
+	tagSchedules := make(map[string]Schedule, len(config.Daemon.Tags))
+	for tag, tagSchedule := range config.Daemon.Tags {
+		schedule, err := parseSchedule(tagSchedule.Interval, tagSchedule.Schedule, tagSchedule.Timezone, config.Daemon.Interval)
+		if err != nil {
+			return nil, locations.wrap("daemon.tags."+tag, fmt.Errorf("invalid schedule for tag '%s': %w", tag, err))
+		}
+		tagSchedules[tag] = schedule
+	}
+
+	config.skippedPlugins = make(map[string]string)
 	for name, rawConnector := range config.RawConnectors {
+		if rawConnector.Enabled != nil && !*rawConnector.Enabled {
+			config.Disabled = append(config.Disabled, name)
+			config.skippedPlugins[name] = rawConnector.Plugin
+			continue
+		}
+		if !rawConnector.runsIn(config.Environment) {
+			config.Excluded = append(config.Excluded, name)
+			config.skippedPlugins[name] = rawConnector.Plugin
+			continue
+		}
+
+		path := "connectors." + name
 		pluginBuilder, ok := loader.AvailablePlugins[rawConnector.Plugin]
 		if !ok {
-			return nil, fmt.Errorf("failed to load connector '%s': unknown plugin '%s'", name, rawConnector.Plugin)
+			return nil, locations.wrap(path+".plugin", fmt.Errorf("failed to load connector '%s': unknown plugin '%s'", name, rawConnector.Plugin))
 		}
 
 		plugin := pluginBuilder()
+		lists := listStrategy(rawConnector.SharedLists)
+		if len(lists) == 0 {
+			lists = replaceLists
+		} else if lists != replaceLists && lists != appendLists {
+			return nil, locations.wrap(path+".sharedLists", fmt.Errorf("failed to load connector '%s': shared lists must be merged via '%s' or '%s'", name, replaceLists, appendLists))
+		}
 		sharedConfig := config.SharedPluginConfigs[rawConnector.Plugin]
-		rawConnector.Config = mergeKeys(rawConnector.Config, sharedConfig)
-		if len(rawConnector.Config) > 0 {
-			decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
-				Result:     &plugin,
-				DecodeHook: mapstructure.ComposeDecodeHookFunc(mapstructure.StringToTimeDurationHookFunc()),
-			})
-			if err != nil {
-				return nil, fmt.Errorf("could not parse config for connector '%s' with plugin '%s': %w", name, rawConnector.Plugin, err)
+		if rawConnector.Config, err = mergeKeys(rawConnector.Config, sharedConfig, lists); err != nil {
+			return nil, locations.wrap(path+".config", fmt.Errorf("failed to load connector '%s': could not merge shared config: %w", name, err))
+		}
+		if rawConnector.Config, err = mergeKeys(rawConnector.Config, defaultsFor(config.Defaults.Config, plugin), lists); err != nil {
+			return nil, locations.wrap(path+".config", fmt.Errorf("failed to load connector '%s': could not merge default config: %w", name, err))
+		}
+		if err = decodeConfig(rawConnector.Config, &plugin); err != nil {
+			return nil, locations.wrap(path+".config", fmt.Errorf("could not parse config for connector '%s' with plugin '%s': %w", name, rawConnector.Plugin, err))
+		}
+		if err = plugin.Validate(); err != nil {
+			return nil, locations.wrap(path+".config", fmt.Errorf("invalid configuration for connector '%s' with plugin '%s': %w", name, rawConnector.Plugin, err))
+		}
+
+		sink, targets, err := loader.connectorSink(&config, rawConnector)
+		if err != nil {
+			return nil, locations.wrap(path, fmt.Errorf("failed to load connector '%s': %w", name, err))
+		}
+
+		schedule, err := connectorSchedule(&config, rawConnector, tagSchedules)
+		if err != nil {
+			return nil, locations.wrap(path, fmt.Errorf("invalid schedule for connector '%s': %w", name, err))
+		}
+
+		timeout := rawConnector.Timeout
+		if timeout <= 0 {
+			timeout = config.Timeout
+		}
+		offsetRetention := rawConnector.OffsetRetention
+		if offsetRetention <= 0 {
+			offsetRetention = config.OffsetRetention
+		}
+
+		retry := config.Retry
+		if rawConnector.Retry != nil {
+			retry = withDefaultRetry(*rawConnector.Retry, config.Retry)
+		}
+
+		httpConfig := rawConnector.HTTP.WithDefaults(config.HTTP)
+		if _, err = httpConfig.Transport(); err != nil {
+			return nil, locations.wrap(path+".http", fmt.Errorf("invalid HTTP settings for connector '%s': %w", name, err))
+		}
+
+		quietConfig := config.QuietHours
+		if rawConnector.QuietHours != nil {
+			quietConfig = *rawConnector.QuietHours
+		}
+		quietHours, err := ParseQuietHours(quietConfig)
+		if err != nil {
+			return nil, locations.wrap(path+".quietHours", fmt.Errorf("invalid quiet hours for connector '%s': %w", name, err))
+		}
+
+		language := config.Language
+		if len(rawConnector.Language) > 0 {
+			language = rawConnector.Language
+		}
+		locale, err := common.LoadLocale(language)
+		if err != nil {
+			return nil, locations.wrap(path+".language", fmt.Errorf("invalid language for connector '%s': %w", name, err))
+		}
+
+		overrides := MessageOverrides{
+			Username:       rawConnector.Username,
+			AvatarURL:      rawConnector.AvatarURL,
+			Mentions:       rawConnector.Mentions,
+			MentionsByType: rawConnector.MentionsByType,
+			SuppressEmbeds: rawConnector.SuppressEmbeds,
+		}
+		if len(rawConnector.Avatar) > 0 {
+			if len(rawConnector.AvatarURL) > 0 {
+				return nil, locations.wrap(path+".avatar", fmt.Errorf("connector '%s' must not specify both 'avatar' and 'avatarUrl'", name))
+			}
+			overrides.AvatarURL = common.AvatarURL(rawConnector.Avatar)
+		}
+
+		config.Connectors = append(config.Connectors, Connector{
+			Name:            name,
+			Plugin:          &plugin,
+			Sink:            sink,
+			Targets:         targets,
+			Tags:            rawConnector.Tags,
+			Schedule:        schedule,
+			Timeout:         timeout,
+			OffsetRetention: offsetRetention,
+			Retry:           retry,
+			HTTP:            httpConfig,
+			QuietHours:      quietHours,
+			Overrides:       overrides,
+			Locale:          locale,
+		})
+	}
+
+	return &config, nil
+}
+
+// Connector returns the connector with the given name, or nil if there is none
+func (config *Config) Connector(name string) *Connector {
+	for i := range config.Connectors {
+		if config.Connectors[i].Name == name {
+			return &config.Connectors[i]
+		}
+	}
+
+	return nil
+}
+
+func (loader ConfigLoader) loadSinks(config *Config) error {
+	config.Sinks = make(map[string]common.Sink)
+	if config.RawSinks == nil {
+		config.RawSinks = make(map[string]RawSink)
+	}
+
+	hasWebhook := len(config.DiscordWebhook) > 0 || len(config.DiscordWebhookEnv) > 0 || len(config.DiscordWebhookFile) > 0
+	if _, overridden := config.RawSinks[defaultSink]; !overridden && hasWebhook {
+		config.RawSinks[defaultSink] = RawSink{
+			Type: "discord",
+			Config: m{
+				"webhook":     config.DiscordWebhook,
+				"webhookEnv":  config.DiscordWebhookEnv,
+				"webhookFile": config.DiscordWebhookFile,
+				"mentions": m{
+					"roles": listValue(config.DiscordMentions.Roles),
+					"users": listValue(config.DiscordMentions.Users),
+				},
+			},
+		}
+	}
+
+	for name, rawSink := range config.RawSinks {
+		sink, err := loader.buildSink(name, rawSink)
+		if err != nil {
+			return config.locations.wrap("sinks."+name, err)
+		}
+
+		config.Sinks[name] = sink
+	}
+
+	// The admin webhook only receives error reports and alerts, so it's kept apart from the sinks connectors post to
+	if len(config.AdminWebhook) > 0 || len(config.AdminWebhookEnv) > 0 || len(config.AdminWebhookFile) > 0 {
+		sink, err := loader.buildSink("admin webhook", RawSink{
+			Type: "discord",
+			Config: m{
+				"webhook":     config.AdminWebhook,
+				"webhookEnv":  config.AdminWebhookEnv,
+				"webhookFile": config.AdminWebhookFile,
+			},
+		})
+		if err != nil {
+			return config.locations.wrap("adminWebhook", err)
+		}
+
+		config.AdminSink = sink
+	}
+
+	return nil
+}
+
+func (loader ConfigLoader) buildSink(name string, rawSink RawSink) (common.Sink, error) {
+	sinkBuilder, ok := loader.AvailableSinks[rawSink.Type]
+	if !ok {
+		return nil, fmt.Errorf("failed to load sink '%s': unknown type '%s'", name, rawSink.Type)
+	}
+
+	sink := sinkBuilder()
+	if err := decodeConfig(rawSink.Config, &sink); err != nil {
+		return nil, fmt.Errorf("could not parse config for sink '%s' of type '%s': %w", name, rawSink.Type, err)
+	}
+	if err := sink.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration for sink '%s' of type '%s': %w", name, rawSink.Type, err)
+	}
+
+	return sink, nil
+}
+
+// connectorSink resolves the sinks a connector delivers to, returning their names as well. Sinks with
+// connector-specific overrides are instantiated separately for the connector, while all others are shared. Multiple
+// sinks are combined into a fan-out.
+func (loader ConfigLoader) connectorSink(config *Config, rawConnector RawConnector) (common.Sink, []string, error) {
+	targets := rawConnector.Sinks
+	if len(rawConnector.Sink) > 0 {
+		if len(targets) > 0 {
+			return nil, nil, fmt.Errorf("only one of 'sink' and 'sinks' may be specified")
+		}
+		targets = []RawConnectorSink{{Sink: rawConnector.Sink}}
+	}
+	if len(targets) == 0 {
+		targets = []RawConnectorSink{{Sink: defaultSink}}
+	}
+
+	var named []sinks.NamedSink
+	var names []string
+	for _, target := range targets {
+		rawSink, ok := config.RawSinks[target.Sink]
+		if !ok {
+			if target.Sink == defaultSink {
+				return nil, nil, fmt.Errorf("config is missing Discord webhook ID")
 			}
+			return nil, nil, fmt.Errorf("unknown sink '%s'", target.Sink)
+		}
 
-			if err = decoder.Decode(rawConnector.Config); err != nil {
-				return nil, fmt.Errorf("could not parse config for connector '%s' with plugin '%s': %w", name, rawConnector.Plugin, err)
+		sink := config.Sinks[target.Sink]
+		if len(target.Config) > 0 {
+			var err error
+			if rawSink.Config, err = mergeKeys(target.Config, rawSink.Config, replaceLists); err != nil {
+				return nil, nil, fmt.Errorf("could not override config of sink '%s': %w", target.Sink, err)
+			}
+			if sink, err = loader.buildSink(target.Sink, rawSink); err != nil {
+				return nil, nil, err
 			}
 		}
-		if err = plugin.Validate(); err != nil {
-			return nil, fmt.Errorf("invalid configuration for connector '%s' with plugin '%s': %w", name, rawConnector.Plugin, err)
+
+		named = append(named, sinks.NamedSink{Name: target.Sink, Sink: sink})
+		names = append(names, target.Sink)
+	}
+
+	if len(named) == 1 {
+		return named[0].Sink, names, nil
+	}
+
+	return &sinks.FanOut{Sinks: named}, names, nil
+}
+
+// connectorSchedule determines when a connector is checked in daemon mode, either by a fixed interval or a cron
+// expression. Connectors without either use the schedule of the first of their tags that has one.
+func connectorSchedule(config *Config, rawConnector RawConnector, tagSchedules map[string]Schedule) (Schedule, error) {
+	if rawConnector.Interval <= 0 && len(rawConnector.Schedule) == 0 && len(rawConnector.Timezone) == 0 {
+		for _, tag := range rawConnector.Tags {
+			if schedule, ok := tagSchedules[tag]; ok {
+				return schedule, nil
+			}
 		}
+	}
 
-		config.Connectors = append(config.Connectors, Connector{Name: name, Plugin: &plugin})
+	return parseSchedule(rawConnector.Interval, rawConnector.Schedule, rawConnector.Timezone, config.Daemon.Interval)
+}
+
+// parseSchedule creates the schedule of either an interval or a cron expression, falling back to the given interval
+func parseSchedule(interval time.Duration, expression, timezone string, fallback time.Duration) (Schedule, error) {
+	if len(expression) > 0 {
+		if interval > 0 {
+			return Schedule{}, fmt.Errorf("only one of 'interval' and 'schedule' may be specified")
+		}
+
+		return CronSchedule(expression, timezone)
 	}
 
-	return &config, nil
+	if len(timezone) > 0 {
+		return Schedule{}, fmt.Errorf("'timezone' may only be specified together with 'schedule'")
+	}
+
+	if interval <= 0 {
+		interval = fallback
+	}
+
+	return IntervalSchedule(interval), nil
+}
+
+// withDefaultRetry fills all unset values of a retry policy from the given defaults
+func withDefaultRetry(policy, defaults common.RetryPolicy) common.RetryPolicy {
+	if policy.Attempts <= 0 {
+		policy.Attempts = defaults.Attempts
+	}
+	if policy.Backoff <= 0 {
+		policy.Backoff = defaults.Backoff
+	}
+	if policy.MaxBackoff <= 0 {
+		policy.MaxBackoff = defaults.MaxBackoff
+	}
+
+	return policy
+}
+
+// decodeConfig decodes the config of a plugin, sink or state store, reporting each invalid value along with its key as
+// well as any unknown keys
+func decodeConfig(rawConfig map[string]interface{}, result interface{}) error {
+	if len(rawConfig) == 0 {
+		return nil
+	}
+
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		Result:      result,
+		DecodeHook:  durationHook,
+		ErrorUnused: true,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = decoder.Decode(rawConfig)
+	var decodeErr *mapstructure.Error
+	if errors.As(err, &decodeErr) {
+		messages := make([]string, len(decodeErr.Errors))
+		for i, message := range decodeErr.Errors {
+			// Unknown keys at the top level are reported for an empty name
+			messages[i] = strings.Replace(message, "'' has invalid keys", "unknown keys", 1)
+		}
+		return errors.New(strings.Join(messages, "; "))
+	}
+
+	return err
+}
+
+// defaultsFor picks the keys of the default plugin config that the plugin accepts, as the defaults apply to connectors
+// of all plugins
+func defaultsFor(defaults map[string]interface{}, plugin Plugin) map[string]interface{} {
+	picked := make(map[string]interface{}, len(defaults))
+	for key, value := range defaults {
+		if acceptsOption(plugin, key) {
+			picked[key] = value
+		}
+	}
+
+	return picked
+}
+
+// acceptsOption checks whether the plugin's config has the given key, which is matched regardless of case like the
+// decoder does
+func acceptsOption(plugin Plugin, key string) bool {
+	for name := range schemaOf(reflect.TypeOf(plugin), mapstructureTags).Properties {
+		if strings.EqualFold(name, key) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// durationHook decodes durations from strings such as `90s` or `2h`. Plain numbers must have a unit as well, as they
+// would otherwise be taken as nanoseconds.
+func durationHook(from reflect.Type, to reflect.Type, data interface{}) (interface{}, error) {
+	if to != durationType {
+		return data, nil
+	}
+
+	var value string
+	switch from.Kind() {
+	case reflect.String:
+		value = data.(string)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Float32, reflect.Float64:
+		value = fmt.Sprint(data)
+	default:
+		return data, nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid duration '%s', must be a number with a unit such as '90s', '15m' or '2h'", value)
+	}
+
+	return duration, nil
 }
 
 type m = map[string]interface{}
 
-// Given two maps, recursively merge right into left, NEVER replacing any key that already exists in left
-func mergeKeys(left, right m) m {
+// listStrategy selects how lists given in both configs are merged
+type listStrategy string
+
+const (
+	// replaceLists uses the list of the config taking precedence, ignoring the other
+	replaceLists listStrategy = "replace"
+	// appendLists adds the items of the config taking precedence after those of the other
+	appendLists listStrategy = "append"
+)
+
+// mergeKeys recursively merges right into left, never replacing any value that already exists in left. Nested maps
+// are merged and lists according to the strategy. Values of different shapes, e.g. a list and a map, are rejected.
+// Values taken from right are copied, so right may be merged into several configs.
+func mergeKeys(left, right m, lists listStrategy) (m, error) {
+	return mergeMaps(left, right, lists, "")
+}
+
+func mergeMaps(left, right m, lists listStrategy, path string) (m, error) {
 	if left == nil {
-		return right
+		left = make(m, len(right))
 	}
 
 	for key, rightVal := range right {
-		if leftVal, present := left[key]; present {
-			//then we don't want to replace it - recurse
-			left[key] = mergeKeys(leftVal.(m), rightVal.(m))
-		} else {
-			// key not in left so we can just shove it in
-			left[key] = rightVal
+		keyPath := key
+		if len(path) > 0 {
+			keyPath = fmt.Sprintf("%s.%s", path, key)
 		}
+
+		leftVal, present := left[key]
+		if !present {
+			left[key] = copyValue(rightVal)
+			continue
+		}
+		if leftVal == nil || rightVal == nil {
+			continue
+		}
+		if leftShape, rightShape := valueShape(leftVal), valueShape(rightVal); leftShape != rightShape {
+			return nil, fmt.Errorf("'%s' must be %s, not %s", keyPath, rightShape, leftShape)
+		}
+
+		switch leftVal := leftVal.(type) {
+		case m:
+			merged, err := mergeMaps(leftVal, rightVal.(m), lists, keyPath)
+			if err != nil {
+				return nil, err
+			}
+			left[key] = merged
+		case []interface{}:
+			if lists == appendLists {
+				left[key] = append(copyValue(rightVal).([]interface{}), leftVal...)
+			}
+		}
+	}
+
+	return left, nil
+}
+
+// valueShape describes whether a config value is a map, a list or any other value
+func valueShape(value interface{}) string {
+	switch value.(type) {
+	case m:
+		return "a map"
+	case []interface{}:
+		return "a list"
+	default:
+		return "a value"
+	}
+}
+
+// copyValue copies maps and lists deeply
+func copyValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case m:
+		copied := make(m, len(value))
+		for key, item := range value {
+			copied[key] = copyValue(item)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(value))
+		for i, item := range value {
+			copied[i] = copyValue(item)
+		}
+		return copied
+	default:
+		return value
 	}
-	return left
+}
+
+// listValue converts a list of strings to the form lists decoded from configs have, so they can be merged with them
+func listValue(values []string) []interface{} {
+	list := make([]interface{}, len(values))
+	for i, value := range values {
+		list[i] = value
+	}
+
+	return list
 }
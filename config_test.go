@@ -0,0 +1,182 @@
+package main
+
+import (
+	. "17thshard.com/sanderson-notifications/common"
+	. "17thshard.com/sanderson-notifications/plugins"
+	"17thshard.com/sanderson-notifications/sinks"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMergeKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		left     m
+		right    m
+		lists    listStrategy
+		expected m
+		err      string
+	}{
+		{
+			name:     "left scalar overrides right",
+			left:     m{"message": "connector"},
+			right:    m{"message": "shared"},
+			lists:    replaceLists,
+			expected: m{"message": "connector"},
+		},
+		{
+			name:     "missing keys are taken from right",
+			left:     m{"message": "connector"},
+			right:    m{"nickname": "shared"},
+			lists:    replaceLists,
+			expected: m{"message": "connector", "nickname": "shared"},
+		},
+		{
+			name:     "nested maps are merged",
+			left:     m{"http": m{"timeout": "5s"}},
+			right:    m{"http": m{"timeout": "10s", "proxy": "http://proxy"}},
+			lists:    replaceLists,
+			expected: m{"http": m{"timeout": "5s", "proxy": "http://proxy"}},
+		},
+		{
+			name:     "lists are replaced",
+			left:     m{"excludedTags": []interface{}{"a"}},
+			right:    m{"excludedTags": []interface{}{"b", "c"}},
+			lists:    replaceLists,
+			expected: m{"excludedTags": []interface{}{"a"}},
+		},
+		{
+			name:     "lists are appended after those of right",
+			left:     m{"excludedTags": []interface{}{"a"}},
+			right:    m{"excludedTags": []interface{}{"b", "c"}},
+			lists:    appendLists,
+			expected: m{"excludedTags": []interface{}{"b", "c", "a"}},
+		},
+		{
+			name:     "nil left takes all of right",
+			left:     nil,
+			right:    m{"message": "shared", "http": m{"timeout": "10s"}},
+			lists:    replaceLists,
+			expected: m{"message": "shared", "http": m{"timeout": "10s"}},
+		},
+		{
+			name:     "nil right keeps left",
+			left:     m{"message": "connector"},
+			right:    nil,
+			lists:    replaceLists,
+			expected: m{"message": "connector"},
+		},
+		{
+			name:     "nil values are kept on either side",
+			left:     m{"message": nil, "nickname": "connector"},
+			right:    m{"message": "shared", "nickname": nil},
+			lists:    replaceLists,
+			expected: m{"message": nil, "nickname": "connector"},
+		},
+		{
+			name:  "values of different shapes are rejected with their path",
+			left:  m{"http": m{"headers": []interface{}{"a"}}},
+			right: m{"http": m{"headers": m{"Accept": "text/html"}}},
+			lists: replaceLists,
+			err:   "'http.headers' must be a map, not a list",
+		},
+		{
+			name:  "scalars and maps are rejected",
+			left:  m{"http": "fast"},
+			right: m{"http": m{"timeout": "10s"}},
+			lists: appendLists,
+			err:   "'http' must be a map, not a value",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			merged, err := mergeKeys(test.left, test.right, test.lists)
+			if len(test.err) > 0 {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error containing %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(merged, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, merged)
+			}
+		})
+	}
+}
+
+func TestMergeKeysCopiesRight(t *testing.T) {
+	right := m{"http": m{"timeout": "10s"}, "excludedTags": []interface{}{"a"}}
+
+	merged, err := mergeKeys(nil, right, appendLists)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	merged["http"].(m)["timeout"] = "5s"
+	merged["excludedTags"].([]interface{})[0] = "b"
+
+	if right["http"].(m)["timeout"] != "10s" || right["excludedTags"].([]interface{})[0] != "a" {
+		t.Errorf("merging modified right: %v", right)
+	}
+}
+
+func TestOverrideDefaultSinkMentions(t *testing.T) {
+	tests := []struct {
+		name     string
+		global   string
+		expected []string
+	}{
+		{name: "without global mentions", expected: []string{"2"}},
+		{name: "replacing global mentions", global: "discordMentions: {roles: ['1']}", expected: []string{"2"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yml")
+			content := fmt.Sprintf(`discordWebhook: '1/abc'
+%s
+connectors:
+  progress:
+    plugin: progress
+    sinks:
+      - sink: discord
+        config: {mentions: {roles: ['2']}}
+    config: {url: 'https://example.com', message: Progress}
+`, test.global)
+			if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			loader := ConfigLoader{
+				AvailablePlugins: map[string]func() Plugin{
+					"progress": func() Plugin {
+						return &ProgressPlugin{}
+					},
+				},
+				AvailableSinks: map[string]func() Sink{
+					"discord": func() Sink {
+						return &sinks.DiscordSink{}
+					},
+				},
+			}
+			config, err := loader.Load(path)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			sink, ok := config.Connectors[0].Sink.(*sinks.DiscordSink)
+			if !ok {
+				t.Fatalf("expected Discord sink, got %T", config.Connectors[0].Sink)
+			}
+			if !reflect.DeepEqual(sink.Mentions.Roles, test.expected) {
+				t.Errorf("expected roles %v, got %v", test.expected, sink.Mentions.Roles)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// configExtensions are the file extensions of the supported config formats
+var configExtensions = []string{".yml", ".yaml", ".json", ".toml"}
+
+// parseConfigDocument parses a config file in the format indicated by its extension, which is YAML by default. All
+// formats are converted to a YAML document, so includes and placeholders work the same for each of them. Files
+// encrypted with age or SOPS are decrypted first.
+func parseConfigDocument(path string, content []byte) (*yaml.Node, error) {
+	content, err := decryptAge(content)
+	if err != nil {
+		return nil, err
+	}
+
+	document, err := parseDocument(configExtension(path), content)
+	if err != nil {
+		return nil, err
+	}
+	if err = decryptSops(document); err != nil {
+		return nil, err
+	}
+
+	return document, nil
+}
+
+func parseDocument(extension string, content []byte) (*yaml.Node, error) {
+	var document yaml.Node
+
+	switch extension {
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(content))
+		decoder.UseNumber()
+
+		var value interface{}
+		if err := decoder.Decode(&value); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+		if err := document.Encode(value); err != nil {
+			return nil, err
+		}
+	case ".toml":
+		var value map[string]interface{}
+		if err := toml.Unmarshal(content, &value); err != nil {
+			return nil, fmt.Errorf("invalid TOML: %w", err)
+		}
+		if err := document.Encode(value); err != nil {
+			return nil, err
+		}
+	default:
+		if err := yaml.Unmarshal(content, &document); err != nil {
+			return nil, err
+		}
+		return &document, nil
+	}
+
+	// Encoding yields the mapping itself instead of a document containing it
+	return &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{&document}}, nil
+}
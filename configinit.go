@@ -0,0 +1,216 @@
+package main
+
+import (
+	. "17thshard.com/sanderson-notifications/common"
+	. "17thshard.com/sanderson-notifications/plugins"
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// discordWebhookPrefix precedes the webhook ID in the URLs Discord provides
+const discordWebhookPrefix = "https://discord.com/api/webhooks/"
+
+// initConfig is the config written by `config init`, with just the settings asked for
+type initConfig struct {
+	DiscordWebhook string                   `yaml:"discordWebhook"`
+	Connectors     map[string]initConnector `yaml:"connectors"`
+}
+
+type initConnector struct {
+	Plugin string                 `yaml:"plugin"`
+	Config map[string]interface{} `yaml:"config,omitempty"`
+}
+
+// configInitCommand creates a config interactively, asking for the webhook and the connectors to set up, along with an
+// empty offsets file
+func configInitCommand(args []string) {
+	infoLog, errorLog := CreateLoggers("main")
+
+	flags := newFlagSet("config init")
+	configPath := flags.String("config", "config.yml", "path of the YAML config file to create")
+	offsetsPath := flags.String("offsets", "offsets.json", "path of the offsets file to create")
+	force := flags.Bool("force", false, "replace an existing config file")
+	_ = flags.Parse(args)
+
+	if _, err := os.Stat(*configPath); err == nil && !*force {
+		errorLog.Fatalf("Config file '%s' already exists, use -force to replace it", *configPath)
+	}
+
+	loader := newConfigLoader()
+	prompt := &prompter{input: bufio.NewScanner(os.Stdin), output: os.Stdout}
+	config, err := prompt.config(loader)
+	if errors.Is(err, io.EOF) {
+		errorLog.Fatal("Input ended before the config was complete, no files were written")
+	} else if err != nil {
+		errorLog.Fatalf("Failed to create config: %s", err)
+	}
+
+	var content bytes.Buffer
+	encoder := yaml.NewEncoder(&content)
+	encoder.SetIndent(2)
+	if err = encoder.Encode(config); err != nil {
+		errorLog.Fatalf("Failed to create config: %s", err)
+	}
+	if err = os.WriteFile(*configPath, content.Bytes(), 0600); err != nil {
+		errorLog.Fatalf("Failed to write config: %s", err)
+	}
+	infoLog.Printf("Wrote config with %d connectors to '%s'", len(config.Connectors), *configPath)
+
+	if _, err = os.Stat(*offsetsPath); errors.Is(err, os.ErrNotExist) {
+		if err = os.WriteFile(*offsetsPath, []byte("{}\n"), 0644); err != nil {
+			errorLog.Fatalf("Failed to write offsets file: %s", err)
+		}
+		infoLog.Printf("Wrote empty offsets file to '%s'", *offsetsPath)
+	}
+
+	if _, err = loader.Load(*configPath); err != nil {
+		errorLog.Fatalf("Written config is invalid: %s", err)
+	}
+	infoLog.Printf("Config is valid, try it via 'test -config %s -connector <name>'", *configPath)
+}
+
+// prompter asks for settings on the terminal
+type prompter struct {
+	input  *bufio.Scanner
+	output io.Writer
+}
+
+// ask prints the question and returns the trimmed answer, or the default if the answer is empty
+func (prompt *prompter) ask(question, fallback string) (string, error) {
+	if len(fallback) > 0 {
+		fmt.Fprintf(prompt.output, "%s [%s]: ", question, fallback)
+	} else {
+		fmt.Fprintf(prompt.output, "%s: ", question)
+	}
+
+	if !prompt.input.Scan() {
+		if err := prompt.input.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+
+	answer := strings.TrimSpace(prompt.input.Text())
+	if len(answer) == 0 {
+		return fallback, nil
+	}
+
+	return answer, nil
+}
+
+// config asks for the webhook and the connectors until no further connector is wanted
+func (prompt *prompter) config(loader ConfigLoader) (*initConfig, error) {
+	config := &initConfig{Connectors: make(map[string]initConnector)}
+
+	for len(config.DiscordWebhook) == 0 {
+		webhook, err := prompt.ask("Discord webhook URL or ID", "")
+		if err != nil {
+			return nil, err
+		}
+		webhook = strings.TrimPrefix(webhook, discordWebhookPrefix)
+		if !strings.Contains(webhook, "/") {
+			fmt.Fprintln(prompt.output, "The webhook must consist of channel ID and token, e.g. '123/abc'")
+			continue
+		}
+		config.DiscordWebhook = webhook
+	}
+
+	plugins := make([]string, 0, len(loader.AvailablePlugins))
+	for name := range loader.AvailablePlugins {
+		plugins = append(plugins, name)
+	}
+	sort.Strings(plugins)
+
+	for {
+		fallback := ""
+		if len(config.Connectors) == 0 {
+			fallback = "progress"
+		}
+		plugin, err := prompt.ask(fmt.Sprintf("Plugin of the next connector (%s), empty to finish", strings.Join(plugins, ", ")), fallback)
+		if err != nil {
+			return nil, err
+		}
+		if len(plugin) == 0 {
+			break
+		}
+		if _, ok := loader.AvailablePlugins[plugin]; !ok {
+			fmt.Fprintf(prompt.output, "Unknown plugin '%s'\n", plugin)
+			continue
+		}
+
+		name, connector, err := prompt.connector(loader, plugin, config.Connectors)
+		if err != nil {
+			return nil, err
+		}
+		config.Connectors[name] = *connector
+	}
+
+	if len(config.Connectors) == 0 {
+		return nil, fmt.Errorf("config must contain at least one connector")
+	}
+
+	return config, nil
+}
+
+// connector asks for the name and the options of a connector, until they pass the plugin's validation
+func (prompt *prompter) connector(loader ConfigLoader, pluginName string, existing map[string]initConnector) (string, *initConnector, error) {
+	fallback := pluginName
+	for i := 2; existing[fallback].Plugin != ""; i++ {
+		fallback = fmt.Sprintf("%s-%d", pluginName, i)
+	}
+
+	var name string
+	for len(name) == 0 {
+		var err error
+		if name, err = prompt.ask("Name of the connector", fallback); err != nil {
+			return "", nil, err
+		}
+		if _, ok := existing[name]; ok {
+			fmt.Fprintf(prompt.output, "Connector '%s' already exists\n", name)
+			name = ""
+		}
+	}
+
+	var fields []SetupField
+	if setup, ok := loader.AvailablePlugins[pluginName]().(SetupPlugin); ok {
+		fields = setup.SetupFields()
+	}
+
+	connector := &initConnector{Plugin: pluginName, Config: make(map[string]interface{})}
+	for {
+		for _, field := range fields {
+			fallback, _ := connector.Config[field.Key].(string)
+			if len(fallback) == 0 {
+				fallback = field.Default
+			}
+
+			value, err := prompt.ask(field.Prompt, fallback)
+			if err != nil {
+				return "", nil, err
+			}
+			if len(value) > 0 {
+				connector.Config[field.Key] = value
+			}
+		}
+
+		plugin := loader.AvailablePlugins[pluginName]()
+		err := decodeConfig(connector.Config, &plugin)
+		if err == nil {
+			err = plugin.Validate()
+		}
+		if err == nil {
+			return name, connector, nil
+		}
+		if len(fields) == 0 {
+			return "", nil, fmt.Errorf("plugin '%s' can't be set up interactively: %w", pluginName, err)
+		}
+		fmt.Fprintf(prompt.output, "Invalid settings: %s\n", err)
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	. "17thshard.com/sanderson-notifications/common"
+	"17thshard.com/sanderson-notifications/state"
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// configWatchInterval is how often the config file is checked for changes if it's watched
+const configWatchInterval = 10 * time.Second
+
+// Daemon periodically checks all connectors on their own intervals, flushing changed offsets regularly
+type Daemon struct {
+	runner     *Runner
+	offsets    *Offsets
+	config     *Config
+	configPath string
+	// environment replaces the one of the config when reloading it
+	environment   string
+	flushInterval time.Duration
+	// lease is held while checking connectors if several replicas share the state, nil otherwise
+	lease *Lease
+	// webSub subscribes connectors to WebSub hubs if the HTTP server is enabled, nil otherwise
+	webSub *WebSubManager
+	info   *log.Logger
+	error  *log.Logger
+
+	lock      sync.Mutex
+	wg        sync.WaitGroup
+	scheduled map[string]*scheduledConnector
+}
+
+// scheduledConnector controls the goroutine scheduling the checks of a connector
+type scheduledConnector struct {
+	connector *ConnectorRuntime
+	// stop ends the scheduling once a running check finished, after which done is closed
+	stop chan struct{}
+	done chan struct{}
+}
+
+func serveCommand(args []string) {
+	infoLog, errorLog := CreateLoggers("main")
+
+	flags := newFlagSet("serve")
+	configPath := flags.String("config", "config.yml", "path or URL of YAML, JSON or TOML config file")
+	environment := flags.String("env", "", "environment to select connectors for via 'onlyIn' and 'exceptIn', replacing the config's")
+	offsetsPath := flags.String("offsets", "offsets.json", "path of offset storage file")
+	lockTimeout := flags.Duration("wait", 0, "how long to wait for another running instance to finish")
+	logLevelFlag(flags)
+	_ = flags.Parse(args)
+
+	config := loadConfig(*configPath, *environment, infoLog, errorLog)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	options := state.OpenOptions{LockTimeout: *lockTimeout, Shared: config.Daemon.Lease.Enabled}
+	store := openStore(config, *offsetsPath, options, errorLog)
+
+	// Replicas only load the state once they hold the lease, as it's changed by the active replica until then
+	var lease *Lease
+	if config.Daemon.Lease.Enabled {
+		lease = NewLease(config, store.(state.LeaseStore), infoLog, errorLog)
+		if !lease.Acquire(ctx) {
+			return
+		}
+		defer lease.Release()
+		ctx = lease.Keep(ctx)
+	}
+
+	offsets := loadOffsets(config, store, false, infoLog, errorLog)
+	offsets.Fence(lease.Lost)
+
+	reporter := NewErrorReporter(config, errorLog)
+	defer reporter.Flush()
+
+	// The daemon has no runs to summarize, so digests are posted daily unless configured otherwise
+	if config.Digest.Enabled && config.Digest.Interval <= 0 {
+		config.Digest.Interval = defaultDaemonDigestInterval
+	}
+
+	runner, err := NewRunner(config, offsets, reporter)
+	if err != nil {
+		errorLog.Fatalf("Failed to set up connectors: %s", err)
+	}
+
+	shutdownTracing := setupTracing(config.Tracing, errorLog)
+	defer shutdownTracing()
+
+	daemon := Daemon{
+		runner:        runner,
+		offsets:       offsets,
+		config:        config,
+		configPath:    *configPath,
+		environment:   *environment,
+		flushInterval: config.Daemon.FlushInterval,
+		lease:         lease,
+		info:          infoLog,
+		error:         errorLog,
+	}
+
+	if len(config.Server.Listen) > 0 {
+		server := NewServer(config.Server, infoLog, errorLog)
+		if len(config.Server.AdminToken) > 0 {
+			api := AdminAPI{daemon: &daemon, token: config.Server.AdminToken}
+			api.Register(server)
+		}
+		if config.Metrics.Enabled {
+			registerMetrics(server)
+		}
+		if config.Avatars.Serve {
+			NewAvatarHandler(config.Avatars).Register(server)
+		}
+		// WebSub may also be enabled by reloading the config, so its endpoints are always served
+		daemon.webSub = NewWebSubManager(config.WebSub, daemon.runner, infoLog, errorLog)
+		daemon.webSub.Register(server)
+		daemon.webSub.Run(ctx)
+		go server.Run(ctx)
+	} else if len(config.WebSub.CallbackURL) > 0 {
+		errorLog.Println("WebSub requires the HTTP server to be enabled, falling back to polling")
+	}
+
+	daemon.Run(ctx)
+
+	closeSinks(daemon.config, errorLog)
+
+	if lease != nil && lease.Lost() {
+		errorLog.Println("Stopped after losing the lease, exiting")
+		os.Exit(ExitFailure)
+	}
+}
+
+// Run schedules all connectors until the context is cancelled. The config is reloaded on SIGHUP and, if enabled,
+// whenever the config file changes.
+func (daemon *Daemon) Run(ctx context.Context) {
+	connectors := daemon.runner.Connectors()
+	daemon.info.Printf("Starting daemon with %d connectors", len(connectors))
+
+	daemon.scheduled = make(map[string]*scheduledConnector)
+	for _, connector := range connectors {
+		daemon.start(ctx, connector, nil)
+	}
+
+	ticker := time.NewTicker(daemon.flushInterval)
+	defer ticker.Stop()
+
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	defer signal.Stop(reload)
+
+	var watch <-chan time.Time
+	modified := daemon.configModified()
+	if daemon.config.Daemon.WatchConfig {
+		watchTicker := time.NewTicker(configWatchInterval)
+		defer watchTicker.Stop()
+		watch = watchTicker.C
+	}
+
+	for running := true; running; {
+		select {
+		case <-ticker.C:
+			daemon.runner.PostDigest(ctx)
+			daemon.flush()
+		case <-reload:
+			daemon.info.Println("Received SIGHUP, reloading config...")
+			modified = daemon.configModified()
+			daemon.reload(ctx)
+		case <-watch:
+			if current := daemon.configModified(); !current.Equal(modified) {
+				daemon.info.Println("Config file changed, reloading config...")
+				modified = current
+				daemon.reload(ctx)
+			}
+		case <-ctx.Done():
+			running = false
+		}
+	}
+
+	daemon.info.Println("Shutting down, waiting for running checks to be cancelled...")
+	daemon.wg.Wait()
+	daemon.flush()
+	daemon.info.Println("Daemon stopped")
+}
+
+// reload replaces all connectors with those of the config file. Connectors that are still configured continue on
+// their schedule, without interrupting running checks. Invalid configs are rejected, keeping the current connectors.
+func (daemon *Daemon) reload(ctx context.Context) {
+	loader := newConfigLoader()
+	loader.Environment = daemon.environment
+	config, err := loader.Load(daemon.configPath)
+	if err != nil {
+		daemon.error.Printf("Failed to reload config, keeping current connectors: %s", err)
+		return
+	}
+	if len(config.Connectors) == 0 {
+		daemon.error.Println("Reloaded config did not contain any connectors, keeping current connectors")
+		closeSinks(config, daemon.error)
+		return
+	}
+	if err = ConfigureLogging(config.Logging); err != nil {
+		daemon.error.Printf("Failed to configure logging: %s", err)
+	}
+
+	connectors, err := daemon.runner.Reload(config)
+	if err != nil {
+		daemon.error.Printf("Failed to reload connectors, keeping current ones: %s", err)
+		closeSinks(config, daemon.error)
+		return
+	}
+
+	daemon.lock.Lock()
+	previous := daemon.scheduled
+	daemon.scheduled = make(map[string]*scheduledConnector)
+	for _, connector := range connectors {
+		if _, ok := previous[connector.Name]; !ok {
+			daemon.info.Printf("Added connector '%s'", connector.Name)
+		}
+	}
+	for name, scheduled := range previous {
+		close(scheduled.stop)
+		if !slices.ContainsFunc(connectors, func(connector *ConnectorRuntime) bool { return connector.Name == name }) {
+			daemon.info.Printf("Removed connector '%s'", name)
+		}
+	}
+	daemon.lock.Unlock()
+
+	for _, connector := range connectors {
+		daemon.start(ctx, connector, previous[connector.Name])
+	}
+	if daemon.webSub != nil {
+		daemon.webSub.Reload(config.WebSub)
+	}
+
+	// Sinks of the previous config may only be closed once no check uses them anymore
+	previousConfig := daemon.config
+	daemon.config = config
+	go func() {
+		for _, scheduled := range previous {
+			<-scheduled.done
+		}
+		closeSinks(previousConfig, daemon.error)
+	}()
+
+	daemon.info.Printf("Reloaded config with %d connectors", len(connectors))
+}
+
+// configModified returns when the config file was last modified, or the zero time if that's unknown
+func (daemon *Daemon) configModified() time.Time {
+	var modified time.Time
+	for _, path := range append([]string{daemon.configPath}, daemon.config.Includes...) {
+		if info, err := os.Stat(path); err == nil && info.ModTime().After(modified) {
+			modified = info.ModTime()
+		}
+	}
+
+	return modified
+}
+
+// start schedules the checks of a connector. If it replaces a previous instance of the connector, it waits for that
+// to stop and continues its schedule.
+func (daemon *Daemon) start(ctx context.Context, connector *ConnectorRuntime, previous *scheduledConnector) {
+	scheduled := &scheduledConnector{connector: connector, stop: make(chan struct{}), done: make(chan struct{})}
+
+	daemon.lock.Lock()
+	daemon.scheduled[connector.Name] = scheduled
+	daemon.lock.Unlock()
+
+	daemon.wg.Add(1)
+	go func() {
+		defer daemon.wg.Done()
+		defer close(scheduled.done)
+
+		if previous != nil {
+			<-previous.done
+		}
+		daemon.schedule(ctx, scheduled, previous != nil)
+	}()
+}
+
+func (daemon *Daemon) schedule(ctx context.Context, scheduled *scheduledConnector, resumed bool) {
+	connector := scheduled.connector
+	connector.info.Printf("Checking for updates %s", connector.Schedule.Description)
+
+	if connector.Schedule.Immediate && !resumed {
+		_ = daemon.runner.Check(ctx, connector)
+	}
+
+	for first := true; ; first = false {
+		next := connector.Schedule.Next(time.Now())
+		// Keep the check a replaced instance of the connector scheduled, unless the new schedule is due earlier
+		if previous := connector.State().NextCheck; first && resumed && previous.After(time.Now()) && previous.Before(next) {
+			next = previous
+		}
+		connector.updateState(func(state *ConnectorState) {
+			state.NextCheck = next
+		})
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-scheduled.stop:
+			return
+		case <-connector.trigger:
+			connector.info.Println("Running requested check")
+			_ = daemon.runner.Check(ctx, connector)
+		case <-time.After(time.Until(next)):
+			// Connectors receiving pushed updates are only polled rarely, in case the hub misses any
+			if state := connector.State(); time.Now().Before(state.PushedUntil) &&
+				state.LastRun != nil && time.Since(state.LastRun.Started) < webSubPollInterval {
+				continue
+			}
+			_ = daemon.runner.Check(ctx, connector)
+		}
+	}
+}
+
+func (daemon *Daemon) flush() {
+	// Changes of a replica that lost the lease would overwrite those of its successor
+	if daemon.lease.Lost() {
+		daemon.info.Println("Not storing offsets, as the lease was lost")
+		return
+	}
+	if err := daemon.offsets.Flush(); err != nil {
+		daemon.error.Printf("Failed to store offsets: %s", err)
+	}
+}
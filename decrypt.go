@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// ageKeyEnv and ageKeyFileEnv give the age keys to decrypt configs with, named like SOPS does
+	ageKeyEnv     = "SOPS_AGE_KEY"
+	ageKeyFileEnv = "SOPS_AGE_KEY_FILE"
+	// ageExtension may be appended to the extension of config files encrypted as a whole, e.g. `config.yml.age`
+	ageExtension = ".age"
+	// sopsKey holds the metadata of files encrypted by SOPS
+	sopsKey = "sops"
+)
+
+// sopsValuePattern matches values encrypted by SOPS
+var sopsValuePattern = regexp.MustCompile(`^ENC\[AES256_GCM,data:(.*),iv:(.+),tag:(.+),type:(.+)]$`)
+
+// sopsMetadata is the part of SOPS' metadata needed to decrypt files encrypted for age keys
+type sopsMetadata struct {
+	MAC              string `yaml:"mac"`
+	LastModified     string `yaml:"lastmodified"`
+	MACOnlyEncrypted bool   `yaml:"mac_only_encrypted"`
+
+	Age       []sopsAgeKey `yaml:"age"`
+	KeyGroups []struct {
+		Age []sopsAgeKey `yaml:"age"`
+	} `yaml:"key_groups"`
+}
+
+type sopsAgeKey struct {
+	Recipient string `yaml:"recipient"`
+	Enc       string `yaml:"enc"`
+}
+
+// configExtension returns the extension selecting the format of a config file, ignoring that of encrypted files as
+// well as the query of remote configs
+func configExtension(path string) string {
+	if remote, err := parseRemoteConfig(path); err == nil && remote != nil {
+		path = remote.location.Path
+	}
+
+	return strings.ToLower(filepath.Ext(strings.TrimSuffix(path, ageExtension)))
+}
+
+// decryptAge decrypts config files encrypted with age as a whole, in binary or armored form, and returns any other
+// content as it is
+func decryptAge(content []byte) ([]byte, error) {
+	var encrypted io.Reader
+	switch {
+	case bytes.HasPrefix(content, []byte("age-encryption.org/")):
+		encrypted = bytes.NewReader(content)
+	case bytes.HasPrefix(bytes.TrimSpace(content), []byte(armor.Header)):
+		encrypted = armor.NewReader(bytes.NewReader(bytes.TrimSpace(content)))
+	default:
+		return content, nil
+	}
+
+	identities, err := ageIdentities()
+	if err != nil {
+		return nil, err
+	}
+	decrypted, err := age.Decrypt(encrypted, identities...)
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt file: %w", err)
+	}
+
+	return io.ReadAll(decrypted)
+}
+
+// decryptSops decrypts the values of documents encrypted by SOPS for age keys in place, removing its metadata. Like SOPS,
+// it refuses documents whose message authentication code doesn't match their values, e.g. as some were removed.
+func decryptSops(document *yaml.Node) error {
+	root := documentRoot(document)
+	if root == nil {
+		return nil
+	}
+	node, index := mappingValue(root, sopsKey)
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	var metadata sopsMetadata
+	if err := node.Decode(&metadata); err != nil {
+		return fmt.Errorf("invalid SOPS metadata: %w", err)
+	}
+	keys := metadata.Age
+	if len(metadata.KeyGroups) > 1 {
+		return errors.New("SOPS files split across several key groups are not supported")
+	} else if len(metadata.KeyGroups) == 1 {
+		keys = append(keys, metadata.KeyGroups[0].Age...)
+	}
+	if len(keys) == 0 {
+		return errors.New("SOPS file must be encrypted for an age key")
+	}
+
+	dataKey, err := sopsDataKey(keys)
+	if err != nil {
+		return err
+	}
+
+	root.Content = slices.Delete(root.Content, index, index+2)
+	decrypter := sopsDecrypter{dataKey: dataKey, mac: sha512.New(), macOnlyEncrypted: metadata.MACOnlyEncrypted}
+	if err = decrypter.decrypt(root, nil); err != nil {
+		return err
+	}
+
+	return decrypter.verify(metadata)
+}
+
+// sopsDecrypter decrypts the values of a SOPS file, calculating its message authentication code along the way
+type sopsDecrypter struct {
+	dataKey []byte
+	mac     hash.Hash
+	// macOnlyEncrypted leaves values that aren't encrypted out of the message authentication code
+	macOnlyEncrypted bool
+}
+
+// sopsDataKey decrypts the key the values of a SOPS file are encrypted with via any of the available age keys
+func sopsDataKey(keys []sopsAgeKey) ([]byte, error) {
+	identities, err := ageIdentities()
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	for _, key := range keys {
+		decrypted, err := age.Decrypt(armor.NewReader(strings.NewReader(strings.TrimSpace(key.Enc))), identities...)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("recipient %s: %w", key.Recipient, err))
+			continue
+		}
+
+		return io.ReadAll(decrypted)
+	}
+
+	return nil, fmt.Errorf("could not decrypt SOPS data key: %w", errors.Join(errs...))
+}
+
+// decrypt decrypts all values within the node. SOPS authenticates each value with the path of keys leading to it,
+// ignoring list indices.
+func (decrypter *sopsDecrypter) decrypt(node *yaml.Node, path []string) error {
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			if err := decrypter.decrypt(node.Content[i+1], append(slices.Clip(path), key)); err != nil {
+				return err
+			}
+		}
+	case yaml.SequenceNode:
+		for _, item := range node.Content {
+			if err := decrypter.decrypt(item, path); err != nil {
+				return err
+			}
+		}
+	case yaml.ScalarNode:
+		groups := sopsValuePattern.FindStringSubmatch(node.Value)
+		if groups == nil {
+			if !decrypter.macOnlyEncrypted {
+				var value interface{}
+				if err := node.Decode(&value); err != nil {
+					return err
+				}
+				decrypter.mac.Write(sopsMACBytes(value))
+			}
+			return nil
+		}
+
+		value, err := decryptSopsValue(groups[1], groups[2], groups[3], decrypter.dataKey, strings.Join(path, ":")+":")
+		if err != nil {
+			return fmt.Errorf("could not decrypt '%s': %w", strings.Join(path, "."), err)
+		}
+		typed, err := sopsTypedValue(value, groups[4])
+		if err != nil {
+			return fmt.Errorf("could not decrypt '%s': %w", strings.Join(path, "."), err)
+		}
+		decrypter.mac.Write(sopsMACBytes(typed))
+
+		node.Value, node.Style = value, 0
+		switch groups[4] {
+		case "int":
+			node.Tag = "!!int"
+		case "float":
+			node.Tag = "!!float"
+		case "bool":
+			node.Tag, node.Value = "!!bool", strings.ToLower(value)
+		default:
+			// Quoting keeps decrypted strings from being typed by their content when interpolating
+			node.Tag, node.Style = "!!str", yaml.DoubleQuotedStyle
+		}
+	}
+
+	return nil
+}
+
+// verify checks the message authentication code of the decrypted values against the one SOPS stored, which is
+// encrypted with the time the file was last modified as additional data
+func (decrypter *sopsDecrypter) verify(metadata sopsMetadata) error {
+	groups := sopsValuePattern.FindStringSubmatch(metadata.MAC)
+	if groups == nil {
+		return errors.New("SOPS file has no message authentication code")
+	}
+	lastModified, err := time.Parse(time.RFC3339, metadata.LastModified)
+	if err != nil {
+		return fmt.Errorf("invalid SOPS modification time: %w", err)
+	}
+
+	expected, err := decryptSopsValue(groups[1], groups[2], groups[3], decrypter.dataKey, lastModified.Format(time.RFC3339))
+	if err != nil {
+		return fmt.Errorf("could not decrypt SOPS message authentication code: %w", err)
+	}
+	actual := fmt.Sprintf("%X", decrypter.mac.Sum(nil))
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(actual)) != 1 {
+		return errors.New("SOPS message authentication code does not match, the file may have been tampered with")
+	}
+
+	return nil
+}
+
+// sopsTypedValue converts a decrypted value to the type SOPS recorded for it
+func sopsTypedValue(value, valueType string) (interface{}, error) {
+	switch valueType {
+	case "int":
+		return strconv.Atoi(value)
+	case "float":
+		return strconv.ParseFloat(value, 64)
+	case "bool":
+		return strconv.ParseBool(value)
+	default:
+		return value, nil
+	}
+}
+
+// sopsMACBytes represents a value the way SOPS does when calculating its message authentication code
+func sopsMACBytes(value interface{}) []byte {
+	switch value := value.(type) {
+	case int:
+		return []byte(strconv.Itoa(value))
+	case float64:
+		return []byte(strconv.FormatFloat(value, 'f', -1, 64))
+	case bool:
+		if value {
+			return []byte("True")
+		}
+		return []byte("False")
+	case nil:
+		return nil
+	default:
+		return []byte(fmt.Sprint(value))
+	}
+}
+
+func decryptSopsValue(data, iv, tag string, dataKey []byte, additionalData string) (string, error) {
+	var decoded [3][]byte
+	for i, part := range []string{data, iv, tag} {
+		var err error
+		if decoded[i], err = base64.StdEncoding.DecodeString(part); err != nil {
+			return "", fmt.Errorf("invalid encrypted value: %w", err)
+		}
+	}
+
+	block, err := aes.NewCipher(dataKey)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(decoded[1]))
+	if err != nil {
+		return "", err
+	}
+
+	plaintext, err := gcm.Open(nil, decoded[1], append(decoded[0], decoded[2]...), []byte(additionalData))
+	if err != nil {
+		return "", err
+	}
+
+	return string(plaintext), nil
+}
+
+// ageIdentities loads the age keys from the environment variable or key file, which is the one SOPS uses by default
+// if neither is set
+func ageIdentities() ([]age.Identity, error) {
+	if key := os.Getenv(ageKeyEnv); len(key) > 0 {
+		identities, err := age.ParseIdentities(strings.NewReader(key))
+		if err != nil {
+			return nil, fmt.Errorf("invalid age key in '%s': %w", ageKeyEnv, err)
+		}
+		return identities, nil
+	}
+
+	path := os.Getenv(ageKeyFileEnv)
+	if len(path) == 0 {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return nil, fmt.Errorf("no age key given, set '%s' or '%s'", ageKeyEnv, ageKeyFileEnv)
+		}
+		path = filepath.Join(dir, "sops", "age", "keys.txt")
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read age key file, set '%s' or '%s': %w", ageKeyEnv, ageKeyFileEnv, err)
+	}
+	defer file.Close()
+
+	identities, err := age.ParseIdentities(file)
+	if err != nil {
+		return nil, fmt.Errorf("invalid age key file '%s': %w", path, err)
+	}
+
+	return identities, nil
+}
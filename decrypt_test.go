@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDecryptSopsVerifiesMAC(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(ageKeyEnv, identity.String())
+
+	dataKey := make([]byte, 32)
+	if _, err = rand.Read(dataKey); err != nil {
+		t.Fatal(err)
+	}
+	var armored bytes.Buffer
+	armorWriter := armor.NewWriter(&armored)
+	ageWriter, err := age.Encrypt(armorWriter, identity.Recipient())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = ageWriter.Write(dataKey)
+	_ = ageWriter.Close()
+	_ = armorWriter.Close()
+
+	const lastModified = "2026-01-02T03:04:05Z"
+	mac := sha512.New()
+	mac.Write([]byte("secret"))
+	mac.Write([]byte("42"))
+	mac.Write([]byte("True"))
+	mac.Write([]byte("hello"))
+
+	token := encryptSopsValue(t, dataKey, "secret", "str", "token:")
+	count := encryptSopsValue(t, dataKey, "42", "int", "count:")
+	enabled := encryptSopsValue(t, dataKey, "True", "bool", "nested:enabled:")
+	metadata := fmt.Sprintf(`sops:
+  age:
+    - recipient: %s
+      enc: |
+%s
+  lastmodified: "%s"
+  mac: %s
+`,
+		identity.Recipient(),
+		indentText(armored.String(), "        "),
+		lastModified,
+		encryptSopsValue(t, dataKey, fmt.Sprintf("%X", mac.Sum(nil)), "str", lastModified),
+	)
+
+	tests := []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{
+			name:    "intact",
+			content: fmt.Sprintf("token: %s\ncount: %s\nnested:\n  enabled: %s\nplain: hello\n", token, count, enabled),
+		},
+		{
+			name:    "removed value",
+			content: fmt.Sprintf("token: %s\nnested:\n  enabled: %s\nplain: hello\n", token, enabled),
+			err:     "message authentication code does not match",
+		},
+		{
+			name:    "modified plain value",
+			content: fmt.Sprintf("token: %s\ncount: %s\nnested:\n  enabled: %s\nplain: bye\n", token, count, enabled),
+			err:     "message authentication code does not match",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			document, err := parseDocument(".yml", []byte(test.content+metadata))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = decryptSops(document)
+			if len(test.err) > 0 {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error containing '%s', got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var decrypted struct {
+				Token  string `yaml:"token"`
+				Count  int    `yaml:"count"`
+				Nested struct {
+					Enabled bool `yaml:"enabled"`
+				} `yaml:"nested"`
+			}
+			if err = document.Decode(&decrypted); err != nil {
+				t.Fatal(err)
+			}
+			if decrypted.Token != "secret" || decrypted.Count != 42 || !decrypted.Nested.Enabled {
+				t.Errorf("unexpected decrypted values: %+v", decrypted)
+			}
+		})
+	}
+}
+
+// encryptSopsValue encrypts a value the way SOPS does
+func encryptSopsValue(t *testing.T, dataKey []byte, value, valueType, additionalData string) string {
+	block, err := aes.NewCipher(dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iv := make([]byte, 32)
+	if _, err = rand.Read(iv); err != nil {
+		t.Fatal(err)
+	}
+
+	sealed := gcm.Seal(nil, iv, []byte(value), []byte(additionalData))
+	data, tag := sealed[:len(sealed)-gcm.Overhead()], sealed[len(sealed)-gcm.Overhead():]
+
+	return fmt.Sprintf(
+		"ENC[AES256_GCM,data:%s,iv:%s,tag:%s,type:%s]",
+		base64.StdEncoding.EncodeToString(data),
+		base64.StdEncoding.EncodeToString(iv),
+		base64.StdEncoding.EncodeToString(tag),
+		valueType,
+	)
+}
+
+func indentText(text, prefix string) string {
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	for i, line := range lines {
+		lines[i] = prefix + line
+	}
+
+	return strings.Join(lines, "\n")
+}
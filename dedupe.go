@@ -0,0 +1,162 @@
+package main
+
+import (
+	. "17thshard.com/sanderson-notifications/common"
+	"17thshard.com/sanderson-notifications/state"
+	"context"
+	"encoding/json"
+	"log"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultDedupeTTL = 7 * 24 * time.Hour
+
+var urlPattern = regexp.MustCompile(`https?://[^\s<>()\[\]]+`)
+
+// DedupeConfig configures the deduplication of notifications across connectors
+type DedupeConfig struct {
+	Enabled bool          `yaml:"enabled"`
+	TTL     time.Duration `yaml:"ttl"`
+}
+
+type dedupeEntry struct {
+	Connector string    `json:"connector"`
+	Seen      time.Time `json:"seen"`
+}
+
+// Deduplicator remembers which connector first posted about a link, so that other connectors reporting the same
+// link don't post it again
+type Deduplicator struct {
+	offsets *Offsets
+	ttl     time.Duration
+	lock    sync.Mutex
+}
+
+func NewDeduplicator(config DedupeConfig, offsets *Offsets) *Deduplicator {
+	if config.TTL <= 0 {
+		config.TTL = defaultDedupeTTL
+	}
+
+	dedupe := &Deduplicator{offsets: offsets, ttl: config.TTL}
+	for key := range offsets.Entries(state.DedupeBucket) {
+		if _, ok := dedupe.entry(key); !ok {
+			offsets.DeleteEntry(state.DedupeBucket, key)
+		}
+	}
+
+	return dedupe
+}
+
+func (dedupe *Deduplicator) entry(key string) (dedupeEntry, bool) {
+	var entry dedupeEntry
+	raw, ok := dedupe.offsets.Entry(state.DedupeBucket, key)
+	if !ok || json.Unmarshal(raw, &entry) != nil || time.Since(entry.Seen) > dedupe.ttl {
+		return entry, false
+	}
+
+	return entry, true
+}
+
+// Claim records the keys for the connector, unless another connector already claimed any of them. In that case,
+// the name of that connector is returned.
+func (dedupe *Deduplicator) Claim(connector string, keys []string) (string, bool) {
+	dedupe.lock.Lock()
+	defer dedupe.lock.Unlock()
+
+	for _, key := range keys {
+		if entry, ok := dedupe.entry(key); ok && entry.Connector != connector {
+			return entry.Connector, false
+		}
+	}
+
+	now := time.Now()
+	for _, key := range keys {
+		_ = dedupe.offsets.SetEntry(state.DedupeBucket, key, dedupeEntry{Connector: connector, Seen: now})
+	}
+
+	return connector, true
+}
+
+// Release removes the claims of a connector, e.g. because the message couldn't be delivered after all
+func (dedupe *Deduplicator) Release(connector string, keys []string) {
+	dedupe.lock.Lock()
+	defer dedupe.lock.Unlock()
+
+	for _, key := range keys {
+		if entry, ok := dedupe.entry(key); ok && entry.Connector == connector {
+			dedupe.offsets.DeleteEntry(state.DedupeBucket, key)
+		}
+	}
+}
+
+// dedupeKeys extracts the canonicalized links of a message from its text and the URL of its embed
+func dedupeKeys(message Message) []string {
+	candidates := urlPattern.FindAllString(message.Text, -1)
+	if message.Embed != nil && len(message.Embed.URL) > 0 {
+		candidates = append(candidates, message.Embed.URL)
+	}
+
+	var keys []string
+	for _, candidate := range candidates {
+		if key, ok := canonicalURL(candidate); ok {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+// canonicalURL normalizes links so that different spellings of the same link are considered equal
+func canonicalURL(raw string) (string, bool) {
+	parsed, err := url.Parse(strings.TrimRight(raw, ".,!?'\""))
+	if err != nil || len(parsed.Host) == 0 {
+		return "", false
+	}
+
+	query := parsed.Query()
+	for param := range query {
+		if strings.HasPrefix(param, "utm_") {
+			query.Del(param)
+		}
+	}
+
+	host := strings.TrimPrefix(strings.ToLower(parsed.Hostname()), "www.")
+	path := strings.TrimSuffix(parsed.EscapedPath(), "/")
+	key := host + path
+	if encoded := query.Encode(); len(encoded) > 0 {
+		key += "?" + encoded
+	}
+
+	return key, true
+}
+
+// DedupeSink skips messages about links that another connector already posted about
+type DedupeSink struct {
+	Sink
+	connector string
+	dedupe    *Deduplicator
+	info      *log.Logger
+}
+
+func (sink *DedupeSink) Deliver(ctx context.Context, message Message) error {
+	keys := dedupeKeys(message)
+	if len(keys) == 0 {
+		return sink.Sink.Deliver(ctx, message)
+	}
+
+	if owner, claimed := sink.dedupe.Claim(sink.connector, keys); !claimed {
+		sink.info.Printf("Skipping message, as connector '%s' already posted about the same link", owner)
+		return nil
+	}
+
+	if err := sink.Sink.Deliver(ctx, message); err != nil {
+		sink.dedupe.Release(sink.connector, keys)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	. "17thshard.com/sanderson-notifications/common"
+	"17thshard.com/sanderson-notifications/state"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultDaemonDigestInterval = 24 * time.Hour
+	// digestKey is the entry of the digest bucket holding the activity collected since the last digest
+	digestKey = "pending"
+	// maxDigestConnectors limits how many connectors are listed in a digest
+	maxDigestConnectors = 15
+	// maxDigestErrorLength keeps the errors listed in a digest short
+	maxDigestErrorLength = 150
+	digestColor          = 0x2ECC71
+)
+
+// DigestConfig configures summaries of the checks of all connectors, which are posted to the admin webhook or a sink
+type DigestConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Interval is how often a digest is posted, summarizing all checks since the previous one. Without an interval, a
+	// digest is posted after every run in which anything happened, and once a day in daemon mode.
+	Interval time.Duration `yaml:"interval"`
+	// Sink is the name of the sink digests are posted to instead of the admin webhook
+	Sink string `yaml:"sink"`
+}
+
+// digestStats sums up the checks of a connector
+type digestStats struct {
+	Checks       int           `json:"checks"`
+	FailedChecks int           `json:"failedChecks"`
+	Found        int64         `json:"found"`
+	Posted       int64         `json:"posted"`
+	Failed       int64         `json:"failed"`
+	Duration     time.Duration `json:"duration"`
+	Longest      time.Duration `json:"longest"`
+	LastError    string        `json:"lastError,omitempty"`
+}
+
+// eventful checks whether the connector posted anything or failed
+func (stats digestStats) eventful() bool {
+	return stats.Posted > 0 || stats.Failed > 0 || stats.FailedChecks > 0
+}
+
+// digestState is the activity collected since the last digest, which is stored so it's kept across runs
+type digestState struct {
+	Since      time.Time              `json:"since"`
+	Connectors map[string]digestStats `json:"connectors"`
+}
+
+// Digest collects the results of all checks and regularly posts a summary of them, giving maintainers an overview of
+// the health of the application. A nil digest discards all results.
+type Digest struct {
+	interval time.Duration
+	sink     Sink
+	offsets  *Offsets
+	error    *log.Logger
+	lock     sync.Mutex
+	state    digestState
+}
+
+// NewDigest continues collecting results from the stored state, returning nil if digests are disabled
+func NewDigest(config *Config, offsets *Offsets) *Digest {
+	if !config.Digest.Enabled {
+		return nil
+	}
+
+	_, errorLog := CreateLoggers("digest")
+	digest := &Digest{
+		interval: config.Digest.Interval,
+		sink:     config.AdminSink,
+		offsets:  offsets,
+		error:    errorLog,
+	}
+	if len(config.Digest.Sink) > 0 {
+		digest.sink = config.Sinks[config.Digest.Sink]
+	}
+
+	if raw, ok := offsets.Entry(state.DigestBucket, digestKey); ok {
+		if err := json.Unmarshal(raw, &digest.state); err != nil {
+			errorLog.Printf("Could not parse stored digest, starting over: %s", err)
+			digest.state = digestState{}
+		}
+	}
+	if digest.state.Since.IsZero() || digest.state.Connectors == nil {
+		digest.reset(time.Now())
+	}
+
+	return digest
+}
+
+// Record adds the result of a check of a connector to the next digest
+func (digest *Digest) Record(connector string, result RunResult) {
+	if digest == nil {
+		return
+	}
+
+	digest.lock.Lock()
+	defer digest.lock.Unlock()
+
+	stats := digest.state.Connectors[connector]
+	stats.Checks++
+	if len(result.Error) > 0 {
+		stats.FailedChecks++
+		stats.LastError = result.Error
+	}
+	stats.Found += result.Found
+	stats.Posted += result.Posted
+	stats.Failed += result.Failed
+
+	duration := result.Finished.Sub(result.Started)
+	stats.Duration += duration
+	stats.Longest = max(stats.Longest, duration)
+	digest.state.Connectors[connector] = stats
+
+	digest.store()
+}
+
+// PostIfDue posts the digest once its interval passed. Without an interval, it is posted if anything happened since
+// the last call, i.e. during the last run.
+func (digest *Digest) PostIfDue(ctx context.Context) {
+	if digest == nil {
+		return
+	}
+
+	digest.lock.Lock()
+	defer digest.lock.Unlock()
+
+	now := time.Now()
+	if digest.interval > 0 && now.Sub(digest.state.Since) < digest.interval {
+		return
+	}
+	if digest.interval <= 0 && !digest.eventful() {
+		digest.reset(now)
+		digest.store()
+		return
+	}
+
+	if err := digest.sink.Deliver(ctx, digest.message(now)); err != nil {
+		// Keep collecting, so the next attempt covers the results of this one as well
+		digest.error.Printf("Could not post digest: %s", err)
+		return
+	}
+
+	digest.reset(now)
+	digest.store()
+}
+
+func (digest *Digest) eventful() bool {
+	for _, stats := range digest.state.Connectors {
+		if stats.eventful() {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (digest *Digest) reset(now time.Time) {
+	digest.state = digestState{Since: now, Connectors: make(map[string]digestStats)}
+}
+
+func (digest *Digest) store() {
+	if err := digest.offsets.SetEntry(state.DigestBucket, digestKey, digest.state); err != nil {
+		digest.error.Printf("Could not store digest: %s", err)
+	}
+}
+
+// message summarizes the collected results, listing the connectors that posted anything or failed
+func (digest *Digest) message(now time.Time) Message {
+	var total digestStats
+	var slowest string
+	names := make([]string, 0, len(digest.state.Connectors))
+	for name, stats := range digest.state.Connectors {
+		total.Checks += stats.Checks
+		total.FailedChecks += stats.FailedChecks
+		total.Posted += stats.Posted
+		total.Failed += stats.Failed
+		total.Duration += stats.Duration
+		if stats.Longest > total.Longest {
+			total.Longest, slowest = stats.Longest, name
+		}
+		if stats.eventful() {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	title := "Summary of the last run"
+	if digest.interval > 0 {
+		title = "Summary of all checks"
+	}
+
+	lines := []string{
+		fmt.Sprintf("Since <t:%d:f>", digest.state.Since.Unix()),
+		fmt.Sprintf(
+			"**%d** checks of **%d** connectors, **%d** failed",
+			total.Checks,
+			len(digest.state.Connectors),
+			total.FailedChecks,
+		),
+		fmt.Sprintf("**%d** messages posted, **%d** deliveries failed", total.Posted, total.Failed),
+	}
+	if total.Checks > 0 {
+		lines = append(lines, fmt.Sprintf(
+			"Checks took %s on average, the longest was `%s` with %s",
+			(total.Duration/time.Duration(total.Checks)).Round(time.Millisecond),
+			slowest,
+			total.Longest.Round(time.Millisecond),
+		))
+	}
+
+	if len(names) > 0 {
+		lines = append(lines, "")
+	}
+	for i, name := range names {
+		if i == maxDigestConnectors {
+			lines = append(lines, fmt.Sprintf("… and %d more", len(names)-i))
+			break
+		}
+		lines = append(lines, describeDigestStats(name, digest.state.Connectors[name]))
+	}
+
+	color := digestColor
+	if total.FailedChecks > 0 || total.Failed > 0 {
+		color = adminReportColor
+	}
+
+	return Message{
+		Connector: "digest",
+		Username:  "Sanderson Notifications",
+		Embed: NewEmbed().
+			WithTitle(title).
+			WithDescription(strings.Join(lines, "\n")).
+			WithColor(color).
+			WithTimestamp(now),
+		Timestamp: now,
+	}
+}
+
+// describeDigestStats summarizes the activity of a single connector in one line
+func describeDigestStats(name string, stats digestStats) string {
+	parts := []string{fmt.Sprintf("%d posted", stats.Posted)}
+	if stats.Failed > 0 {
+		parts = append(parts, fmt.Sprintf("%d deliveries failed", stats.Failed))
+	}
+	if stats.FailedChecks > 0 {
+		lastError := []rune(stats.LastError)
+		if len(lastError) > maxDigestErrorLength {
+			lastError = append(lastError[:maxDigestErrorLength-1], '…')
+		}
+		parts = append(parts, fmt.Sprintf("%d of %d checks failed, last with: %s", stats.FailedChecks, stats.Checks, string(lastError)))
+	}
+
+	return fmt.Sprintf("`%s`: %s", name, strings.Join(parts, ", "))
+}
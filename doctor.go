@@ -0,0 +1,236 @@
+package main
+
+import (
+	. "17thshard.com/sanderson-notifications/common"
+	. "17thshard.com/sanderson-notifications/plugins"
+	"17thshard.com/sanderson-notifications/sinks"
+	"17thshard.com/sanderson-notifications/state"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+const (
+	doctorPass = "PASS"
+	doctorFail = "FAIL"
+	doctorSkip = "SKIP"
+)
+
+// doctor checks a setup step by step, printing the result of every check
+type doctor struct {
+	output io.Writer
+	failed bool
+	// sinks holds the results of sinks already checked, which are shared by several connectors
+	sinks map[Sink]error
+}
+
+// doctorCommand verifies the whole setup without sending anything: the config, the state store and, for every
+// connector, its offset, its source and credentials as well as the sinks it delivers to
+func doctorCommand(args []string) {
+	flags := newFlagSet("doctor")
+	configPath := flags.String("config", "config.yml", "path or URL of YAML, JSON or TOML config file")
+	environment := flags.String("env", "", "environment to select connectors for via 'onlyIn' and 'exceptIn', replacing the config's")
+	offsetsPath := flags.String("offsets", "offsets.json", "path of offset storage file")
+	var connectorNames stringList
+	flags.Var(&connectorNames, "connector", "only check the connector with this name, may be repeated")
+	_ = flags.Parse(args)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	doc := &doctor{output: os.Stdout, sinks: make(map[Sink]error)}
+
+	fmt.Fprintln(doc.output, "config")
+	config := doc.checkConfig(*configPath, *environment)
+	if config == nil {
+		os.Exit(ExitConfigError)
+	}
+	defer closeSinks(config, log.New(io.Discard, "", 0))
+
+	if len(connectorNames) > 0 {
+		if err := filterConnectors(config, connectorNames, nil, nil); err != nil {
+			doc.result(doctorFail, "filter", err.Error())
+			os.Exit(ExitConfigError)
+		}
+	}
+
+	fmt.Fprintln(doc.output, "offsets")
+	offsets := doc.checkOffsets(config, *offsetsPath)
+	if offsets != nil {
+		defer offsets.Close()
+	}
+
+	if diagnose, ok := config.AdminSink.(DiagnoseSink); ok {
+		fmt.Fprintln(doc.output, "admin webhook")
+		adminCtx, cancel := context.WithTimeout(ctx, adminReportTimeout)
+		doc.check("webhook", diagnose.Diagnose(adminCtx), "")
+		cancel()
+	}
+
+	sort.Slice(config.Connectors, func(i, j int) bool { return config.Connectors[i].Name < config.Connectors[j].Name })
+	for _, connector := range config.Connectors {
+		if ctx.Err() != nil {
+			break
+		}
+		fmt.Fprintf(doc.output, "connector '%s' (%s)\n", connector.Name, (*connector.Plugin).Name())
+		doc.checkConnector(ctx, connector, offsets)
+	}
+
+	if doc.failed || ctx.Err() != nil {
+		os.Exit(ExitFailure)
+	}
+}
+
+// result prints the outcome of a single check
+func (doc *doctor) result(status, check, detail string) {
+	if status == doctorFail {
+		doc.failed = true
+	}
+
+	line := fmt.Sprintf("  %s  %-16s %s", status, check, detail)
+	fmt.Fprintln(doc.output, strings.TrimRight(line, " "))
+}
+
+// check prints whether a check passed, given its error
+func (doc *doctor) check(check string, err error, detail string) {
+	if err != nil {
+		doc.result(doctorFail, check, err.Error())
+		return
+	}
+
+	doc.result(doctorPass, check, detail)
+}
+
+// checkConfig checks the config against the schema and loads it, returning nil if either fails
+func (doc *doctor) checkConfig(path, environment string) *Config {
+	loader := newConfigLoader()
+	loader.Environment = environment
+
+	schemaErrs, err := loader.CheckSchema(path)
+	if err == nil && len(schemaErrs) > 0 {
+		err = fmt.Errorf("found %d problems", len(schemaErrs))
+	}
+	doc.check("schema", err, "")
+	for _, schemaErr := range schemaErrs {
+		fmt.Fprintf(doc.output, "        %s\n", schemaErr)
+	}
+	if err != nil {
+		return nil
+	}
+
+	config, err := loader.Load(path)
+	if err == nil && len(config.Connectors) == 0 {
+		err = fmt.Errorf("config does not contain any enabled connectors")
+	}
+	if err != nil {
+		doc.check("load", err, "")
+		return nil
+	}
+
+	detail := fmt.Sprintf("%d connectors", len(config.Connectors))
+	if skipped := len(config.Disabled) + len(config.Excluded); skipped > 0 {
+		detail = fmt.Sprintf("%s, %d skipped", detail, skipped)
+	}
+	doc.check("load", nil, detail)
+
+	return config
+}
+
+// checkOffsets opens the state store read-only and checks that its offsets can be migrated, returning nil if not
+func (doc *doctor) checkOffsets(config *Config, offsetsPath string) *Offsets {
+	store := offsetStore(config, offsetsPath)
+	if err := store.Open(state.OpenOptions{ReadOnly: true}); err != nil {
+		doc.check("open", fmt.Errorf("could not open %s state store: %w", store.Name(), err), "")
+		return nil
+	}
+	doc.check("open", nil, fmt.Sprintf("%s state store", store.Name()))
+
+	offsets, err := LoadOffsets(store, config.Namespace, true)
+	if err == nil {
+		err = MigrateOffsets(offsets, config, log.New(io.Discard, "", 0))
+	}
+	if err != nil {
+		_ = store.Close()
+		doc.check("load", err, "")
+		return nil
+	}
+	doc.check("load", nil, fmt.Sprintf("%d offsets", len(offsets.All())))
+
+	return offsets
+}
+
+// checkConnector checks the offset of the connector, reads its source and checks the sinks it delivers to
+func (doc *doctor) checkConnector(ctx context.Context, connector Connector, offsets *Offsets) {
+	plugin := *connector.Plugin
+
+	if offsets == nil {
+		doc.result(doctorSkip, "offset", "state store is unavailable")
+	} else if raw, ok := offsets.Get(connector.Name); !ok {
+		doc.result(doctorPass, "offset", "none stored yet")
+	} else if _, err := decodeOffset(plugin, raw); err != nil {
+		doc.check("offset", fmt.Errorf("could not parse offset: %w", err), "")
+	} else {
+		doc.check("offset", nil, "")
+	}
+
+	if diagnose, ok := plugin.(DiagnosePlugin); ok {
+		doc.check("source", doc.diagnoseSource(ctx, connector, diagnose), "")
+	} else {
+		doc.result(doctorSkip, "source", fmt.Sprintf("plugin '%s' can't be checked", plugin.Name()))
+	}
+
+	targets := []sinks.NamedSink{{Name: connector.Targets[0], Sink: connector.Sink}}
+	if fanOut, ok := connector.Sink.(*sinks.FanOut); ok {
+		targets = fanOut.Sinks
+	}
+	for _, target := range targets {
+		check := fmt.Sprintf("sink '%s'", target.Name)
+
+		diagnose, ok := target.Sink.(DiagnoseSink)
+		if !ok {
+			doc.result(doctorSkip, check, fmt.Sprintf("%s sinks can't be checked", target.Sink.Name()))
+			continue
+		}
+
+		err, checked := doc.sinks[target.Sink]
+		if !checked {
+			sinkCtx, cancel := context.WithTimeout(ctx, connector.Timeout)
+			err = diagnose.Diagnose(sinkCtx)
+			cancel()
+			doc.sinks[target.Sink] = err
+		}
+		doc.check(check, err, "")
+	}
+}
+
+func (doc *doctor) diagnoseSource(ctx context.Context, connector Connector, plugin DiagnosePlugin) error {
+	ctx, cancel := context.WithTimeout(ctx, connector.Timeout)
+	defer cancel()
+
+	transport, err := connector.HTTP.Transport()
+	if err != nil {
+		return err
+	}
+
+	info, errorLog, structured := connectorLoggers(connector)
+	return plugin.Diagnose(PluginContext{
+		Info:    info,
+		Error:   errorLog,
+		Log:     structured,
+		Context: &ctx,
+		HTTP: &http.Client{
+			Transport:     transport,
+			CheckRedirect: connector.HTTP.CheckRedirect,
+			Timeout:       connector.HTTP.Timeout,
+		},
+		HTTPConfig: connector.HTTP,
+		Locale:     connector.Locale,
+	})
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	. "17thshard.com/sanderson-notifications/common"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// DryRunSink logs messages instead of delivering them, so changes to the config can be tried out safely
+type DryRunSink struct {
+	targets []string
+	info    *log.Logger
+}
+
+func (sink *DryRunSink) Name() string {
+	return "dry-run"
+}
+
+func (sink *DryRunSink) Validate() error {
+	return nil
+}
+
+func (sink *DryRunSink) Deliver(_ context.Context, message Message) error {
+	sink.info.Printf(
+		"Would send message of connector '%s' to %s as '%s':",
+		message.Connector,
+		strings.Join(sink.targets, ", "),
+		message.Username,
+	)
+	if len(message.Text) > 0 {
+		sink.info.Printf("  Text: %s", message.Text)
+	}
+	if message.Embed != nil {
+		embed, err := json.Marshal(message.Embed)
+		if err != nil {
+			return fmt.Errorf("could not serialize embed: %w", err)
+		}
+		sink.info.Printf("  Embed: %s", embed)
+	}
+
+	return nil
+}
+
+// enableDryRun replaces all sinks of the config, so that messages are logged instead of delivered
+func enableDryRun(config *Config) {
+	info, _ := CreateLoggers("dry-run")
+
+	for name := range config.Sinks {
+		config.Sinks[name] = &DryRunSink{targets: []string{name}, info: info}
+	}
+	for i := range config.Connectors {
+		config.Connectors[i].Sink = &DryRunSink{targets: config.Connectors[i].Targets, info: info}
+	}
+	if config.AdminSink != nil {
+		config.AdminSink = &DryRunSink{targets: []string{"admin webhook"}, info: info}
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"slices"
+	"sort"
+	"strings"
+)
+
+const (
+	// envPrefix marks the environment variables holding config values, e.g. `SN_CONNECTORS__BRANDON__PLUGIN`
+	envPrefix = "SN_"
+	// envSeparator separates the keys of nested values in the names of environment variables
+	envSeparator = "__"
+)
+
+// environmentConfig builds a config document from the environment variables with the config prefix, or returns nil if
+// there are none. The name of each variable is the path of keys to its value, which are matched against the schema
+// ignoring case and underscores, so `DISCORD_WEBHOOK` sets `discordWebhook`. Names of connectors, sinks and other
+// entries chosen freely are lowercased, with underscores turned into dashes. Lists are given comma-separated.
+func (loader ConfigLoader) environmentConfig(environ []string) (*yaml.Node, error) {
+	sort.Strings(environ)
+
+	var root *yaml.Node
+	schema := loader.GenerateSchema()
+	for _, variable := range environ {
+		name, value, _ := strings.Cut(variable, "=")
+		path, ok := strings.CutPrefix(name, envPrefix)
+		if !ok || len(path) == 0 {
+			continue
+		}
+
+		if root == nil {
+			root = &yaml.Node{Kind: yaml.MappingNode}
+		}
+		if err := setEnvironmentValue(root, []*Schema{schema}, strings.Split(path, envSeparator), value); err != nil {
+			return nil, fmt.Errorf("invalid environment variable '%s': %w", name, err)
+		}
+	}
+
+	if root == nil {
+		return nil, nil
+	}
+
+	return &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{root}}, nil
+}
+
+// setEnvironmentValue sets the value at the path of keys in the mapping, creating the mappings along the way
+func setEnvironmentValue(mapping *yaml.Node, schemas []*Schema, path []string, value string) error {
+	key, schemas, err := environmentKey(schemas, path[0])
+	if err != nil {
+		return err
+	}
+
+	existing, _ := mappingValue(mapping, key)
+	if len(path) == 1 {
+		if existing != nil {
+			return fmt.Errorf("'%s' is already set by another variable", key)
+		}
+		mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, environmentValue(schemas, value))
+		return nil
+	}
+
+	if existing == nil {
+		existing = &yaml.Node{Kind: yaml.MappingNode}
+		mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, existing)
+	} else if existing.Kind != yaml.MappingNode {
+		return fmt.Errorf("'%s' is already set by another variable", key)
+	}
+
+	return setEnvironmentValue(existing, schemas, path[1:], value)
+}
+
+// environmentKey finds the key a part of a variable name refers to, along with the schemas of its value. There may be
+// several of them, e.g. for the configs of connectors, which depend on their plugin.
+func environmentKey(schemas []*Schema, part string) (string, []*Schema, error) {
+	normalized := strings.ToLower(strings.ReplaceAll(part, "_", ""))
+
+	var key string
+	var matches, additional []*Schema
+	freeForm := false
+	for _, schema := range objectSchemas(schemas) {
+		for name, property := range schema.Properties {
+			if strings.ToLower(name) == normalized {
+				key = name
+				matches = append(matches, property)
+			}
+		}
+		if schema.AdditionalProperties != nil {
+			additional = append(additional, schema.AdditionalProperties)
+		}
+		if len(schema.Type) == 0 && len(schema.Properties) == 0 && len(schema.AnyOf) == 0 && !schema.closed {
+			freeForm = true
+		}
+	}
+
+	switch {
+	case len(matches) > 0:
+		return key, matches, nil
+	case len(additional) > 0 || freeForm:
+		return strings.ToLower(strings.ReplaceAll(part, "_", "-")), additional, nil
+	default:
+		return "", nil, fmt.Errorf("unknown key '%s'", part)
+	}
+}
+
+// objectSchemas returns the given schemas along with all their alternatives and variants
+func objectSchemas(schemas []*Schema) []*Schema {
+	var result []*Schema
+	for _, schema := range schemas {
+		result = append(result, schema)
+		result = append(result, objectSchemas(schema.AnyOf)...)
+		for _, variant := range schema.AllOf {
+			if variant.Then != nil {
+				result = append(result, objectSchemas([]*Schema{variant.Then})...)
+			}
+		}
+	}
+
+	return result
+}
+
+// environmentValue converts the value of a variable to a node, splitting lists and keeping strings like IDs from being
+// read as numbers
+func environmentValue(schemas []*Schema, value string) *yaml.Node {
+	var isList, isString, isOther bool
+	for _, schema := range objectSchemas(schemas) {
+		for _, t := range schema.Type {
+			switch t {
+			case "array":
+				isList = true
+			case "string":
+				isString = true
+			default:
+				isOther = true
+			}
+		}
+	}
+
+	if isList {
+		var items []*Schema
+		for _, schema := range objectSchemas(schemas) {
+			if schema.Items != nil {
+				items = append(items, schema.Items)
+			}
+		}
+
+		list := &yaml.Node{Kind: yaml.SequenceNode}
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); len(item) > 0 {
+				list.Content = append(list.Content, environmentValue(items, item))
+			}
+		}
+		return list
+	}
+
+	node := &yaml.Node{Kind: yaml.ScalarNode, Value: value}
+	if isString && !isOther {
+		node.Style = yaml.DoubleQuotedStyle
+	}
+
+	return node
+}
+
+// overrideNodes sets the keys of the source mapping in the target mapping, replacing any values but mappings, which
+// are merged
+func overrideNodes(target, source *yaml.Node) {
+	for i := 0; i+1 < len(source.Content); i += 2 {
+		key, value := source.Content[i], source.Content[i+1]
+
+		existing, index := mappingValue(target, key.Value)
+		switch {
+		case existing == nil:
+			target.Content = append(target.Content, key, value)
+		case existing.Kind == yaml.MappingNode && value.Kind == yaml.MappingNode:
+			overrideNodes(existing, value)
+		default:
+			target.Content = slices.Replace(target.Content, index+1, index+2, value)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// useFixtures answers the requests of all connectors with the responses stored in the directory instead of sending
+// them to their sources
+func useFixtures(config *Config, dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("could not access fixtures: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("fixtures at '%s' are not a directory", dir)
+	}
+
+	for i := range config.Connectors {
+		config.Connectors[i].HTTP.Fixtures = dir
+	}
+
+	return nil
+}
+
+// recordFixtures stores the responses to all requests of connectors in the directory, so the run can be replayed with
+// useFixtures later
+func recordFixtures(config *Config, dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("could not create directory for fixtures: %w", err)
+	}
+
+	for i := range config.Connectors {
+		config.Connectors[i].HTTP.Record = dir
+	}
+
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const defaultHealthCheckTimeout = 10 * time.Second
+
+// HealthCheckConfig configures a dead man's switch such as healthchecks.io, which alerts when runs stop happening or
+// fail
+type HealthCheckConfig struct {
+	URL     string        `yaml:"url"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// HealthCheck pings the configured URL when a run starts and finishes. Pings that fail are only logged, as they must
+// not keep notifications from being sent.
+type HealthCheck struct {
+	config HealthCheckConfig
+	client *http.Client
+	error  *log.Logger
+}
+
+func NewHealthCheck(config HealthCheckConfig, errorLog *log.Logger) *HealthCheck {
+	if config.Timeout <= 0 {
+		config.Timeout = defaultHealthCheckTimeout
+	}
+
+	return &HealthCheck{config: config, client: &http.Client{Timeout: config.Timeout}, error: errorLog}
+}
+
+// Start signals that a run has started, so the duration of runs can be tracked
+func (check *HealthCheck) Start(ctx context.Context) {
+	check.ping(ctx, "/start", nil)
+}
+
+// Finish signals the end of a run, reporting it as failed unless it exited successfully. The report is sent along,
+// so it can be inspected in the health check's logs.
+func (check *HealthCheck) Finish(ctx context.Context, report *RunReport) {
+	suffix := ""
+	if report.ExitCode != ExitSuccess {
+		suffix = "/fail"
+	}
+
+	body, err := json.Marshal(report)
+	if err != nil {
+		check.error.Printf("Could not serialize run report for health check: %s", err)
+	}
+
+	check.ping(ctx, suffix, body)
+}
+
+func (check *HealthCheck) ping(ctx context.Context, suffix string, body []byte) {
+	if len(check.config.URL) == 0 {
+		return
+	}
+
+	url := strings.TrimSuffix(check.config.URL, "/") + suffix
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		check.error.Printf("Could not ping health check: %s", err)
+		return
+	}
+
+	res, err := check.client.Do(req)
+	if err != nil {
+		check.error.Printf("Could not ping health check: %s", err)
+		return
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, res.Body)
+
+	if res.StatusCode >= 300 {
+		check.error.Printf("Could not ping health check: unexpected status %d", res.StatusCode)
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	. "17thshard.com/sanderson-notifications/common"
+	"17thshard.com/sanderson-notifications/state"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultHistoryRetention = 90 * 24 * time.Hour
+
+// HistoryConfig configures the audit log of all notifications that were sent
+type HistoryConfig struct {
+	Enabled   bool          `yaml:"enabled"`
+	Retention time.Duration `yaml:"retention"`
+}
+
+const (
+	HistoryDelivered = "delivered"
+	HistoryFailed    = "failed"
+)
+
+// HistoryEntry records a single attempt of delivering a notification
+type HistoryEntry struct {
+	Connector string    `json:"connector"`
+	Timestamp time.Time `json:"timestamp"`
+	Targets   []string  `json:"targets"`
+	Message   Message   `json:"message"`
+	Result    string    `json:"result"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// History keeps the notifications sent by all connectors in the state store, removing them once they're older than
+// the configured retention
+type History struct {
+	offsets   *Offsets
+	retention time.Duration
+	lock      sync.Mutex
+}
+
+func NewHistory(config HistoryConfig, offsets *Offsets) *History {
+	if config.Retention <= 0 {
+		config.Retention = defaultHistoryRetention
+	}
+
+	history := &History{offsets: offsets, retention: config.Retention}
+	for key, raw := range offsets.Entries(state.HistoryBucket) {
+		var entry HistoryEntry
+		if json.Unmarshal(raw, &entry) != nil || time.Since(entry.Timestamp) > history.retention {
+			offsets.DeleteEntry(state.HistoryBucket, key)
+		}
+	}
+
+	return history
+}
+
+// Record stores an entry under a key that sorts chronologically
+func (history *History) Record(entry HistoryEntry) error {
+	history.lock.Lock()
+	defer history.lock.Unlock()
+
+	timestamp := entry.Timestamp.UTC()
+	key := historyKey(timestamp, entry.Connector)
+	for {
+		if _, exists := history.offsets.Entry(state.HistoryBucket, key); !exists {
+			break
+		}
+		timestamp = timestamp.Add(time.Nanosecond)
+		key = historyKey(timestamp, entry.Connector)
+	}
+
+	return history.offsets.SetEntry(state.HistoryBucket, key, entry)
+}
+
+func historyKey(timestamp time.Time, connector string) string {
+	return fmt.Sprintf("%s/%s", timestamp.Format("2006-01-02T15:04:05.000000000Z"), connector)
+}
+
+// HistoryQuery filters the entries returned by QueryHistory
+type HistoryQuery struct {
+	Connector string
+	Since     time.Time
+	Search    string
+	Limit     int
+}
+
+// QueryHistory returns the most recent entries matching the query, oldest first
+func QueryHistory(offsets *Offsets, query HistoryQuery) ([]HistoryEntry, error) {
+	raw := offsets.Entries(state.HistoryBucket)
+	keys := make([]string, 0, len(raw))
+	for key := range raw {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	search := strings.ToLower(query.Search)
+	var entries []HistoryEntry
+	for i := len(keys) - 1; i >= 0; i-- {
+		if query.Limit > 0 && len(entries) >= query.Limit {
+			break
+		}
+
+		var entry HistoryEntry
+		if err := json.Unmarshal(raw[keys[i]], &entry); err != nil {
+			return nil, fmt.Errorf("could not parse history entry '%s': %w", keys[i], err)
+		}
+
+		if entry.Timestamp.Before(query.Since) {
+			break
+		}
+		if len(query.Connector) > 0 && entry.Connector != query.Connector {
+			continue
+		}
+		if len(search) > 0 && !entry.matches(search) {
+			continue
+		}
+
+		entries = append(entries, entry)
+	}
+
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+
+	return entries, nil
+}
+
+func (entry HistoryEntry) matches(search string) bool {
+	if strings.Contains(strings.ToLower(entry.Message.Text), search) {
+		return true
+	}
+
+	embed, err := json.Marshal(entry.Message.Embed)
+	return err == nil && strings.Contains(strings.ToLower(string(embed)), search)
+}
+
+// HistorySink records every message delivered to the sinks of a connector along with the result of the delivery
+type HistorySink struct {
+	Sink
+	connector string
+	targets   []string
+	history   *History
+	error     *log.Logger
+}
+
+func (sink *HistorySink) Deliver(ctx context.Context, message Message) error {
+	err := sink.Sink.Deliver(ctx, message)
+
+	entry := HistoryEntry{
+		Connector: sink.connector,
+		Timestamp: time.Now(),
+		Targets:   sink.targets,
+		Message:   message,
+		Result:    HistoryDelivered,
+	}
+	if err != nil {
+		entry.Result = HistoryFailed
+		entry.Error = err.Error()
+	}
+
+	if recordErr := sink.history.Record(entry); recordErr != nil {
+		sink.error.Printf("Could not record message in history: %s", recordErr)
+	}
+
+	return err
+}
+
+func historyCommand(args []string) {
+	_, errorLog := CreateLoggers("main")
+	// Keep the output limited to the history itself, so it can be processed further
+	infoLog := log.New(io.Discard, "", 0)
+
+	flags := newFlagSet("history")
+	configPath := flags.String("config", "config.yml", "path or URL of YAML, JSON or TOML config file")
+	offsetsPath := flags.String("offsets", "offsets.json", "path of offset storage file")
+	connector := flags.String("connector", "", "only show notifications of this connector")
+	since := flags.String("since", "", "only show notifications since this duration ago or RFC 3339 timestamp")
+	search := flags.String("search", "", "only show notifications containing this text")
+	limit := flags.Int("limit", 20, "maximum number of notifications to show, 0 for all")
+	asJSON := flags.Bool("json", false, "print notifications as JSON")
+	_ = flags.Parse(args)
+
+	query := HistoryQuery{Connector: *connector, Search: *search, Limit: *limit}
+	if len(*since) > 0 {
+		if duration, err := time.ParseDuration(*since); err == nil {
+			query.Since = time.Now().Add(-duration)
+		} else if query.Since, err = time.Parse(time.RFC3339, *since); err != nil {
+			errorLog.Fatalf("Invalid value '%s' for -since, expected a duration or RFC 3339 timestamp", *since)
+		}
+	}
+
+	config := loadConfig(*configPath, "", infoLog, errorLog)
+
+	offsets := openOffsets(config, *offsetsPath, state.OpenOptions{ReadOnly: true}, infoLog, errorLog)
+	defer offsets.Close()
+
+	entries, err := QueryHistory(offsets, query)
+	if err != nil {
+		errorLog.Fatalf("Failed to query history: %s", err)
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if entries == nil {
+			entries = []HistoryEntry{}
+		}
+		if err = encoder.Encode(entries); err != nil {
+			errorLog.Fatalf("Failed to print history: %s", err)
+		}
+		return
+	}
+
+	for _, entry := range entries {
+		fmt.Printf(
+			"%s  %-20s  %-9s  %s\n",
+			entry.Timestamp.Local().Format(time.RFC3339),
+			entry.Connector,
+			entry.Result,
+			strings.Join(entry.Targets, ", "),
+		)
+		if len(entry.Message.Text) > 0 {
+			fmt.Printf("    %s\n", strings.ReplaceAll(entry.Message.Text, "\n", "\n    "))
+		}
+		if entry.Message.Embed != nil && len(entry.Message.Embed.Title) > 0 {
+			fmt.Printf("    [%s]\n", entry.Message.Embed.Title)
+		}
+		if len(entry.Error) > 0 {
+			fmt.Printf("    Error: %s\n", entry.Error)
+		}
+	}
+}
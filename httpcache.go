@@ -0,0 +1,81 @@
+package main
+
+import (
+	. "17thshard.com/sanderson-notifications/common"
+	"17thshard.com/sanderson-notifications/state"
+	"encoding/json"
+	"sync"
+)
+
+// HTTPCache keeps the validators of a connector's conditional requests in the state store. Validators of a check are
+// only stored once it succeeded, so a failed check can't cause the updates it saw to be skipped as unmodified.
+type HTTPCache struct {
+	offsets   *Offsets
+	connector string
+	lock      sync.Mutex
+	enabled   bool
+	pending   map[string]CacheValidators
+}
+
+func NewHTTPCache(offsets *Offsets, connector string) *HTTPCache {
+	return &HTTPCache{offsets: offsets, connector: connector}
+}
+
+// Begin prepares a check. Without an offset, nothing may be skipped, so no validators are sent along.
+func (cache *HTTPCache) Begin(hasOffset bool) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	cache.enabled = hasOffset
+	cache.pending = make(map[string]CacheValidators)
+}
+
+// Commit stores the validators received during a successful check
+func (cache *HTTPCache) Commit() error {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	for url, validators := range cache.pending {
+		if err := cache.offsets.SetEntry(state.HTTPCacheBucket, cache.key(url), validators); err != nil {
+			return err
+		}
+	}
+	cache.pending = nil
+
+	return nil
+}
+
+func (cache *HTTPCache) Validators(url string) (CacheValidators, bool) {
+	cache.lock.Lock()
+	enabled := cache.enabled
+	cache.lock.Unlock()
+
+	if !enabled {
+		return CacheValidators{}, false
+	}
+
+	raw, ok := cache.offsets.Entry(state.HTTPCacheBucket, cache.key(url))
+	if !ok {
+		return CacheValidators{}, false
+	}
+
+	var validators CacheValidators
+	if err := json.Unmarshal(raw, &validators); err != nil {
+		return CacheValidators{}, false
+	}
+
+	return validators, true
+}
+
+func (cache *HTTPCache) SetValidators(url string, validators CacheValidators) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	if cache.pending != nil {
+		cache.pending[url] = validators
+	}
+}
+
+func (cache *HTTPCache) key(url string) string {
+	return cache.connector + "/" + url
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	. "17thshard.com/sanderson-notifications/common"
+	. "17thshard.com/sanderson-notifications/plugins"
+	"17thshard.com/sanderson-notifications/state"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const (
+	// legacyTweetFile holds the ID of the last Tweet the former Twitter script posted
+	legacyTweetFile = "last_tweet"
+	// legacyProgressFile holds the progress bars the former progress script last saw, in the format of the offsets
+	legacyProgressFile = "last_progress.json"
+	// legacyYouTubeDir holds an empty file per YouTube feed entry the former YouTube script posted, named by its ID
+	legacyYouTubeDir = "youtube_feed_entries"
+	// youTubeEntryPrefix precedes the video IDs in the IDs of YouTube feed entries
+	youTubeEntryPrefix = "yt:video:"
+)
+
+// legacyImport is the offset of a connector converted from the state of a former single-purpose script
+type legacyImport struct {
+	artifact  string
+	connector string
+	offset    interface{}
+}
+
+// importLegacyCommand converts the state kept by the former single-purpose scripts into the offsets of the configured
+// connectors, so switching to this application doesn't post everything again
+func importLegacyCommand(args []string) {
+	infoLog, errorLog := CreateLoggers("main")
+
+	flags := newFlagSet("import-legacy")
+	configPath := flags.String("config", "config.yml", "path or URL of YAML, JSON or TOML config file")
+	offsetsPath := flags.String("offsets", "offsets.json", "path of offset storage file")
+	lockTimeout := flags.Duration("wait", 0, "how long to wait for another running instance to finish")
+	dir := flags.String("dir", ".", "directory the former scripts kept their state in")
+	connectors := map[string]*string{
+		"twitter":  flags.String("twitter", "", "name of the connector to import 'last_tweet' into, if there are several 'twitter' connectors"),
+		"progress": flags.String("progress", "", "name of the connector to import 'last_progress.json' into, if there are several 'progress' connectors"),
+		"youtube":  flags.String("youtube", "", "name of the connector to import 'youtube_feed_entries' into, if there are several 'youtube' connectors"),
+	}
+	force := flags.Bool("force", false, "replace offsets the connectors already have")
+	_ = flags.Parse(args)
+
+	config := loadConfig(*configPath, "", infoLog, errorLog)
+	plugins := connectorPlugins(config)
+
+	imports, err := readLegacyState(*dir)
+	if err != nil {
+		errorLog.Printf("Failed to read legacy state: %s", err)
+		os.Exit(ExitFailure)
+	}
+	if len(imports) == 0 {
+		errorLog.Printf("No legacy state found in '%s'", *dir)
+		os.Exit(ExitConfigError)
+	}
+
+	for i, imported := range imports {
+		if imports[i].connector, err = legacyConnector(plugins, imported.connector, *connectors[imported.connector]); err != nil {
+			errorLog.Printf("Cannot import '%s': %s", imported.artifact, err)
+			os.Exit(ExitConfigError)
+		}
+	}
+
+	offsets := openOffsets(config, *offsetsPath, state.OpenOptions{LockTimeout: *lockTimeout}, infoLog, errorLog)
+	defer offsets.Close()
+
+	for _, imported := range imports {
+		if _, stored := offsets.Get(imported.connector); stored && !*force {
+			errorLog.Printf("Connector '%s' already has an offset, use -force to replace it with '%s'", imported.connector, imported.artifact)
+			os.Exit(ExitConfigError)
+		}
+	}
+
+	if err = offsets.Backup(); err != nil {
+		errorLog.Fatalf("Failed to back up offsets: %s", err)
+	}
+	for _, imported := range imports {
+		if err = offsets.Set(imported.connector, imported.offset); err != nil {
+			errorLog.Fatalf("Failed to set offset: %s", err)
+		}
+	}
+	if err = offsets.Flush(); err != nil {
+		errorLog.Fatalf("Failed to store offsets: %s", err)
+	}
+
+	for _, imported := range imports {
+		infoLog.Printf("Imported '%s' as offset of connector '%s'", imported.artifact, imported.connector)
+	}
+}
+
+// readLegacyState converts all artifacts of the former scripts in the directory to offsets. Each import names the
+// plugin whose connector it's meant for until it's assigned to a connector.
+func readLegacyState(dir string) ([]legacyImport, error) {
+	var imports []legacyImport
+
+	content, err := os.ReadFile(filepath.Join(dir, legacyTweetFile))
+	if err == nil {
+		id := strings.TrimSpace(string(content))
+		if len(id) == 0 || strings.Trim(id, "0123456789") != "" {
+			return nil, fmt.Errorf("'%s' does not contain a Tweet ID", legacyTweetFile)
+		}
+		imports = append(imports, legacyImport{artifact: legacyTweetFile, connector: "twitter", offset: id})
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	content, err = os.ReadFile(filepath.Join(dir, legacyProgressFile))
+	if err == nil {
+		progress, err := decodeOffset(&ProgressPlugin{}, content)
+		if err != nil {
+			return nil, fmt.Errorf("'%s' does not contain progress bars: %w", legacyProgressFile, err)
+		}
+		imports = append(imports, legacyImport{artifact: legacyProgressFile, connector: "progress", offset: progress})
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	files, err := os.ReadDir(filepath.Join(dir, legacyYouTubeDir))
+	if err == nil {
+		// The script didn't record when it last saw entries, so they count as seen when it posted them
+		handled := make(HandledEntries)
+		for _, file := range files {
+			info, err := file.Info()
+			if err != nil {
+				return nil, err
+			}
+			if !info.Mode().IsRegular() {
+				continue
+			}
+
+			id := file.Name()
+			if !strings.HasPrefix(id, youTubeEntryPrefix) {
+				id = youTubeEntryPrefix + id
+			}
+			handled.Seen(id, info.ModTime())
+		}
+		imports = append(imports, legacyImport{artifact: legacyYouTubeDir, connector: "youtube", offset: YouTubeOffset{Handled: handled}})
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	return imports, nil
+}
+
+// legacyConnector selects the connector of the plugin to import into, which is either the given one or the only one
+// using the plugin
+func legacyConnector(plugins map[string]string, plugin, name string) (string, error) {
+	if len(name) > 0 {
+		if configured, ok := plugins[name]; !ok {
+			return "", fmt.Errorf("unknown connector '%s'", name)
+		} else if configured != plugin {
+			return "", fmt.Errorf("connector '%s' uses plugin '%s' instead of '%s'", name, configured, plugin)
+		}
+		return name, nil
+	}
+
+	var candidates []string
+	for connector, configured := range plugins {
+		if configured == plugin {
+			candidates = append(candidates, connector)
+		}
+	}
+	slices.Sort(candidates)
+
+	switch len(candidates) {
+	case 0:
+		return "", fmt.Errorf("no '%s' connector is configured", plugin)
+	case 1:
+		return candidates[0], nil
+	default:
+		return "", fmt.Errorf("several '%s' connectors are configured, select one via -%s: %s", plugin, plugin, strings.Join(candidates, ", "))
+	}
+}
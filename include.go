@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// includeKey lists further config files, or directories of them, to merge into the main config
+const includeKey = "include"
+
+// resolveIncludes merges the files included by the config at the given path into its document. Mappings such as
+// `connectors` are merged, while any other value must only be given once across all files. It returns the included
+// files and directories, whose changes affect the config, and records the locations of their values.
+func resolveIncludes(document *yaml.Node, path string, locations configLocations) ([]string, error) {
+	root := documentRoot(document)
+	if root == nil {
+		return nil, nil
+	}
+
+	includes, err := takeIncludes(root)
+	if err != nil {
+		return nil, err
+	}
+	if remote, _ := parseRemoteConfig(path); remote != nil && len(includes) > 0 {
+		return nil, fmt.Errorf("remote configs must not include further files")
+	}
+
+	files, dirs, err := includedFiles(includes, filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("could not read included file: %w", err)
+		}
+
+		included, err := parseConfigDocument(file, content)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse included file '%s': %w", file, err)
+		}
+
+		includedRoot := documentRoot(included)
+		if includedRoot == nil {
+			continue
+		}
+		if nested, _ := mappingValue(includedRoot, includeKey); nested != nil {
+			return nil, fmt.Errorf("included file '%s' must not include further files", file)
+		}
+		if err = mergeNodes(root, includedRoot, ""); err != nil {
+			return nil, fmt.Errorf("could not merge included file '%s': %w", file, err)
+		}
+		locations.record(includedRoot, file, "")
+	}
+
+	return append(files, dirs...), nil
+}
+
+// documentRoot returns the top-level mapping of a YAML document, or nil if the document is empty
+func documentRoot(document *yaml.Node) *yaml.Node {
+	if document.Kind != yaml.DocumentNode || len(document.Content) == 0 || document.Content[0].Kind != yaml.MappingNode {
+		return nil
+	}
+
+	return document.Content[0]
+}
+
+// mappingValue returns the value of the given key in a mapping node along with its index
+func mappingValue(mapping *yaml.Node, key string) (*yaml.Node, int) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1], i
+		}
+	}
+
+	return nil, -1
+}
+
+// takeIncludes removes the list of includes from the mapping, which may be given as a single path as well
+func takeIncludes(root *yaml.Node) ([]string, error) {
+	value, index := mappingValue(root, includeKey)
+	if value == nil {
+		return nil, nil
+	}
+	root.Content = slices.Delete(root.Content, index, index+2)
+
+	var includes []string
+	if value.Kind == yaml.ScalarNode {
+		includes = []string{value.Value}
+	} else if err := value.Decode(&includes); err != nil {
+		return nil, fmt.Errorf("'%s' must be a path or list of paths: %w", includeKey, err)
+	}
+
+	return includes, nil
+}
+
+// includedFiles resolves the included paths relative to the directory of the main config. Directories include all
+// config files directly within them, in order of their names, and patterns all files matching them.
+func includedFiles(includes []string, dir string) (files []string, dirs []string, err error) {
+	for _, include := range includes {
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(dir, include)
+		}
+
+		matches, err := filepath.Glob(include)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid include pattern '%s': %w", include, err)
+		}
+		if len(matches) == 0 && !strings.ContainsAny(include, "*?[") {
+			return nil, nil, fmt.Errorf("included file '%s' does not exist", include)
+		}
+
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil {
+				return nil, nil, fmt.Errorf("could not read included file: %w", err)
+			}
+			if !info.IsDir() {
+				files = append(files, match)
+				continue
+			}
+
+			dirs = append(dirs, match)
+			entries, err := os.ReadDir(match)
+			if err != nil {
+				return nil, nil, fmt.Errorf("could not read included directory: %w", err)
+			}
+			for _, entry := range entries {
+				if !entry.IsDir() && slices.Contains(configExtensions, configExtension(entry.Name())) {
+					files = append(files, filepath.Join(match, entry.Name()))
+				}
+			}
+		}
+	}
+
+	return files, dirs, nil
+}
+
+// mergeNodes merges the keys of the source mapping into the target mapping, merging nested mappings as well
+func mergeNodes(target, source *yaml.Node, path string) error {
+	for i := 0; i+1 < len(source.Content); i += 2 {
+		key, value := source.Content[i], source.Content[i+1]
+		keyPath := key.Value
+		if len(path) > 0 {
+			keyPath = fmt.Sprintf("%s.%s", path, key.Value)
+		}
+
+		existing, _ := mappingValue(target, key.Value)
+		if existing == nil {
+			target.Content = append(target.Content, key, value)
+			continue
+		}
+		if existing.Kind != yaml.MappingNode || value.Kind != yaml.MappingNode {
+			return fmt.Errorf("'%s' is already defined", keyPath)
+		}
+		if err := mergeNodes(existing, value, keyPath); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
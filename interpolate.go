@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// placeholderPattern matches `${NAME}`, `${file:PATH}` and `${...:-default}` placeholders as well as escaped `$${...}`
+// ones
+var placeholderPattern = regexp.MustCompile(`\$?\$\{(file:[^}]+?|[^}:]*)(:-[^}]*)?}`)
+
+// interpolate replaces the placeholders in all values of the YAML document with the environment variables or contents
+// of files they name, reporting all variables that aren't set and files that can't be read if there is no default
+func interpolate(node *yaml.Node) error {
+	var errs []error
+	interpolateNode(node, &errs)
+
+	return errors.Join(errs...)
+}
+
+func interpolateNode(node *yaml.Node, errs *[]error) {
+	if node.Kind == yaml.ScalarNode && strings.Contains(node.Value, "${") {
+		node.Value = placeholderPattern.ReplaceAllStringFunc(node.Value, func(placeholder string) string {
+			if strings.HasPrefix(placeholder, "$$") {
+				return placeholder[1:]
+			}
+
+			groups := placeholderPattern.FindStringSubmatch(placeholder)
+			name, fallback := strings.TrimSpace(groups[1]), groups[2]
+			value, err := lookupPlaceholder(name)
+			if err == nil && (len(value) > 0 || len(fallback) == 0) {
+				return value
+			}
+			if len(fallback) > 0 {
+				return strings.TrimPrefix(fallback, ":-")
+			}
+
+			*errs = append(*errs, fmt.Errorf("line %d: %w", node.Line, err))
+			return placeholder
+		})
+
+		// Plain values like `${PORT}` are typed by their expanded content, e.g. as numbers
+		if node.Style == 0 {
+			node.Tag = ""
+		}
+	}
+
+	for _, child := range node.Content {
+		interpolateNode(child, errs)
+	}
+}
+
+// lookupPlaceholder resolves the value of a placeholder, trimming files so they may end with a line break
+func lookupPlaceholder(name string) (string, error) {
+	if path, isFile := strings.CutPrefix(name, "file:"); isFile {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("could not read file '%s': %w", path, err)
+		}
+		return strings.TrimSpace(string(content)), nil
+	}
+
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("environment variable '%s' is not set", name)
+	}
+
+	return value, nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"17thshard.com/sanderson-notifications/state"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultDaemonLeaseDuration = 30 * time.Second
+	// leaseName identifies the lease of the daemon within a namespace
+	leaseName = "lease"
+)
+
+// LeaseConfig lets several replicas of the daemon share a state store for redundancy, of which only the one holding
+// the lease checks connectors and posts. Others wait until the lease is released or expires.
+type LeaseConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Duration is how long the lease is valid without being extended, i.e. how long it takes at most for another
+	// replica to take over
+	Duration time.Duration `yaml:"duration"`
+}
+
+// Lease is held by the replica that currently checks connectors
+type Lease struct {
+	store    state.LeaseStore
+	name     string
+	holder   string
+	duration time.Duration
+	lost     atomic.Bool
+	info     *log.Logger
+	error    *log.Logger
+}
+
+// NewLease prepares the lease of the config's namespace, identifying this replica by its host and process
+func NewLease(config *Config, store state.LeaseStore, infoLog, errorLog *log.Logger) *Lease {
+	name := leaseName
+	if len(config.Namespace) > 0 {
+		name = fmt.Sprintf("%s%s/%s", state.NamespacePrefix, config.Namespace, leaseName)
+	}
+
+	hostname, _ := os.Hostname()
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+
+	return &Lease{
+		store:    store,
+		name:     name,
+		holder:   fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), hex.EncodeToString(suffix)),
+		duration: config.Daemon.Lease.Duration,
+		info:     infoLog,
+		error:    errorLog,
+	}
+}
+
+// Acquire waits until this replica holds the lease, returning false if the context is cancelled first
+func (lease *Lease) Acquire(ctx context.Context) bool {
+	lease.info.Printf("Waiting for lease as '%s'...", lease.holder)
+
+	ticker := time.NewTicker(lease.duration / 3)
+	defer ticker.Stop()
+
+	for {
+		acquired, err := lease.store.AcquireLease(lease.name, lease.holder, lease.duration)
+		if err != nil {
+			lease.error.Printf("Failed to acquire lease: %s", err)
+		} else if acquired {
+			lease.info.Println("Acquired lease, starting to check connectors")
+			lease.store.FenceSaves(lease.name, lease.holder)
+			return true
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// Keep extends the lease regularly until the context is cancelled. The returned context is cancelled as well once the
+// lease is lost, e.g. because it couldn't be extended in time and another replica took over.
+func (lease *Lease) Keep(ctx context.Context) context.Context {
+	ctx, cancel := context.WithCancel(ctx)
+
+	go func() {
+		defer cancel()
+
+		ticker := time.NewTicker(lease.duration / 3)
+		defer ticker.Stop()
+
+		extended := time.Now()
+		for {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+
+			acquired, err := lease.store.AcquireLease(lease.name, lease.holder, lease.duration)
+			switch {
+			case err == nil && acquired:
+				extended = time.Now()
+				continue
+			case err == nil:
+				lease.error.Println("Lease was taken over by another replica")
+			case time.Since(extended) < lease.duration:
+				lease.error.Printf("Failed to extend lease, retrying: %s", err)
+				continue
+			default:
+				lease.error.Printf("Failed to extend lease before it expired: %s", err)
+			}
+
+			lease.lost.Store(true)
+			return
+		}
+	}()
+
+	return ctx
+}
+
+// Lost checks whether the lease was lost while it was kept, which is never the case without a lease
+func (lease *Lease) Lost() bool {
+	return lease != nil && lease.lost.Load()
+}
+
+// Release frees the lease, so that a waiting replica takes over right away
+func (lease *Lease) Release() {
+	if err := lease.store.ReleaseLease(lease.name, lease.holder); err != nil {
+		lease.error.Printf("Failed to release lease: %s", err)
+	}
+}
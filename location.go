@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"strings"
+)
+
+// environmentLocation is the file reported for values given by environment variables
+const environmentLocation = "environment"
+
+// configLocation is where a value of the config is given
+type configLocation struct {
+	File   string
+	Line   int
+	Column int
+}
+
+func (location configLocation) String() string {
+	// JSON and TOML files are converted without keeping track of positions
+	if location.Line == 0 {
+		return location.File
+	}
+
+	return fmt.Sprintf("%s:%d:%d", location.File, location.Line, location.Column)
+}
+
+// configLocations maps the paths of config values, e.g. `connectors.brandon.config.url`, to where they're given, so
+// problems found after decoding the config can point to the offending line
+type configLocations map[string]configLocation
+
+// record adds the locations of all keys within the mapping. Values that were given before are replaced, while mappings
+// merged with earlier ones keep their first location.
+func (locations configLocations) record(node *yaml.Node, file, path string) {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		keyPath := key.Value
+		if len(path) > 0 {
+			keyPath = fmt.Sprintf("%s.%s", path, key.Value)
+		}
+
+		if _, exists := locations[keyPath]; !exists || value.Kind != yaml.MappingNode {
+			locations[keyPath] = configLocation{File: file, Line: key.Line, Column: key.Column}
+		}
+		locations.record(value, file, keyPath)
+	}
+}
+
+// find returns the location of the value at the path, or that of the closest value containing it, e.g. the connector
+// for options it inherits from a template
+func (locations configLocations) find(path string) (configLocation, bool) {
+	for len(path) > 0 {
+		if location, ok := locations[path]; ok {
+			return location, true
+		}
+
+		index := strings.LastIndex(path, ".")
+		if index < 0 {
+			break
+		}
+		path = path[:index]
+	}
+
+	return configLocation{}, false
+}
+
+// wrap prefixes the error with the location of the value at the path, if it's known
+func (locations configLocations) wrap(path string, err error) error {
+	location, ok := locations.find(path)
+	if !ok {
+		return err
+	}
+
+	return fmt.Errorf("%s: %w", location, err)
+}
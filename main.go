@@ -3,27 +3,41 @@ package main
 import (
 	. "17thshard.com/sanderson-notifications/common"
 	. "17thshard.com/sanderson-notifications/plugins"
+	"17thshard.com/sanderson-notifications/sinks"
+	"17thshard.com/sanderson-notifications/state"
 	"context"
 	"encoding/json"
-	"flag"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"os"
-	"reflect"
-	"sync"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
 )
 
-func main() {
-	infoLog, errorLog := CreateLoggers("main")
+// version is set by GoReleaser when building releases
+var version = "dev"
 
-	configPath := flag.String("config", "config.yml", "path of YAML config file")
-	offsetsPath := flag.String("offsets", "offsets.json", "path of offset storage file")
-	flag.Parse()
+// started is when the application started, which heartbeats report its uptime from
+var started = time.Now()
 
-	configLoader := ConfigLoader{
+func main() {
+	runCLI(os.Args[1:])
+}
+
+func newConfigLoader() ConfigLoader {
+	return ConfigLoader{
 		AvailablePlugins: map[string]func() Plugin{
 			"atom": func() Plugin {
 				return &AtomPlugin{}
 			},
+			"heartbeat": func() Plugin {
+				return &HeartbeatPlugin{Version: version, Started: started}
+			},
 			"progress": func() Plugin {
 				return &ProgressPlugin{}
 			},
@@ -34,106 +48,352 @@ func main() {
 				return &YouTubePlugin{}
 			},
 		},
+		AvailableSinks: map[string]func() Sink{
+			"apprise": func() Sink {
+				return &sinks.AppriseSink{}
+			},
+			"archive": func() Sink {
+				return &sinks.ArchiveSink{}
+			},
+			"discord": func() Sink {
+				return &sinks.DiscordSink{}
+			},
+			"gotify": func() Sink {
+				return &sinks.GotifySink{}
+			},
+			"guilded": func() Sink {
+				return &sinks.GuildedSink{}
+			},
+			"mqtt": func() Sink {
+				return &sinks.MQTTSink{}
+			},
+			"revolt": func() Sink {
+				return &sinks.RevoltSink{}
+			},
+			"rocketchat": func() Sink {
+				return &sinks.RocketChatSink{}
+			},
+			"teams": func() Sink {
+				return &sinks.TeamsSink{}
+			},
+		},
+		AvailableStores: map[string]func() state.Store{
+			"file": func() state.Store {
+				return &state.FileStore{}
+			},
+			"gcs": func() state.Store {
+				return &state.GCSStore{}
+			},
+			"postgres": func() state.Store {
+				return &state.PostgresStore{}
+			},
+			"redis": func() state.Store {
+				return &state.RedisStore{}
+			},
+			"s3": func() state.Store {
+				return &state.S3Store{}
+			},
+			"sqlite": func() state.Store {
+				return &state.SQLiteStore{}
+			},
+		},
 	}
-	config, err := configLoader.Load(*configPath)
+}
+
+// loadConfig loads the config at the given path, exiting if it is invalid or doesn't contain any connectors
+func loadConfig(path string, environment string, infoLog, errorLog *log.Logger) *Config {
+	configLoader := newConfigLoader()
+	configLoader.Environment = environment
+	config, err := configLoader.Load(path)
 	if err != nil {
-		errorLog.Fatalf("Failed to load config: %s", err)
+		errorLog.Printf("Failed to load config: %s", err)
+		os.Exit(ExitConfigError)
+	}
+	if err = ConfigureLogging(config.Logging); err != nil {
+		errorLog.Printf("Failed to configure logging: %s", err)
+		os.Exit(ExitConfigError)
 	}
 
+	if len(config.Disabled) > 0 {
+		slices.Sort(config.Disabled)
+		infoLog.Printf("Skipping disabled connectors: %s", strings.Join(config.Disabled, ", "))
+	}
+	if len(config.Excluded) > 0 {
+		slices.Sort(config.Excluded)
+		infoLog.Printf("Skipping connectors not meant for environment '%s': %s", config.Environment, strings.Join(config.Excluded, ", "))
+	}
+	if len(config.Connectors) == 0 && len(config.Disabled)+len(config.Excluded) > 0 {
+		errorLog.Println("All connectors in the config are disabled or not meant for the environment")
+		os.Exit(ExitConfigError)
+	}
 	if len(config.Connectors) == 0 {
 		errorLog.Println("Config did not contain any connectors. Consider configuring one of the following plugins:")
 		for plugin := range configLoader.AvailablePlugins {
 			errorLog.Printf(" - %s", plugin)
 		}
-		os.Exit(1)
+		os.Exit(ExitConfigError)
 	}
 	infoLog.Printf("Loaded configuration with %d connectors", len(config.Connectors))
 
-	offsetContent, err := os.ReadFile(*offsetsPath)
-	if os.IsNotExist(err) {
-		offsetContent = []byte("{}")
+	return config
+}
+
+// openOffsets opens the state store configured in the config, falling back to the offsets file at the given path.
+// Offsets opened read-only are still migrated, but only in memory.
+func openOffsets(config *Config, offsetsPath string, options state.OpenOptions, infoLog, errorLog *log.Logger) *Offsets {
+	return loadOffsets(config, openStore(config, offsetsPath, options, errorLog), options.ReadOnly, infoLog, errorLog)
+}
+
+// openStore opens the state store configured in the config, falling back to the offsets file at the given path
+func openStore(config *Config, offsetsPath string, options state.OpenOptions, errorLog *log.Logger) state.Store {
+	store := offsetStore(config, offsetsPath)
+	if err := store.Open(options); errors.Is(err, state.ErrLocked) {
+		errorLog.Fatal("Another instance is still running, exiting")
 	} else if err != nil {
+		errorLog.Fatalf("Failed to open %s state store: %s", store.Name(), err)
+	}
+
+	return store
+}
+
+// loadOffsets reads the state of the config's namespace from the opened store and migrates it
+func loadOffsets(config *Config, store state.Store, readOnly bool, infoLog, errorLog *log.Logger) *Offsets {
+	offsets, err := LoadOffsets(store, config.Namespace, readOnly)
+	if err != nil {
 		errorLog.Fatalf("Failed to load offsets: %s", err)
 	}
 
-	var rawOffsets map[string]json.RawMessage
-	if err = json.Unmarshal(offsetContent, &rawOffsets); err != nil {
-		errorLog.Fatalf("Failed to parse offsets: %s", err)
+	if err = MigrateOffsets(offsets, config, infoLog); err != nil {
+		errorLog.Fatalf("Failed to migrate offsets: %s", err)
+	}
+
+	return offsets
+}
+
+// offsetStore returns the state store configured in the config, falling back to the offsets file at the given path
+func offsetStore(config *Config, offsetsPath string) state.Store {
+	store := config.Store
+	if store == nil {
+		store = &state.FileStore{}
+	}
+	if fileStore, ok := store.(*state.FileStore); ok && len(fileStore.Path) == 0 {
+		fileStore.Path = offsetsPath
+	}
+
+	return store
+}
+
+func runCommand(args []string) {
+	infoLog, errorLog := CreateLoggers("main")
+
+	flags := newFlagSet("run")
+	configPath := flags.String("config", "config.yml", "path or URL of YAML, JSON or TOML config file")
+	environment := flags.String("env", "", "environment to select connectors for via 'onlyIn' and 'exceptIn', replacing the config's")
+	offsetsPath := flags.String("offsets", "offsets.json", "path of offset storage file")
+	lockTimeout := flags.Duration("wait", 0, "how long to wait for another running instance to finish")
+	dryRun := flags.Bool("dry-run", false, "log messages instead of sending them and don't store new offsets")
+	reportPath := flags.String("report", "", "path to write a JSON report of the run to, '-' for stdout")
+	fixturesDir := flags.String("fixtures", "", "directory of stored responses to answer the requests of connectors with instead of their sources")
+	recordDir := flags.String("record", "", "directory to store the responses to all requests of connectors in as fixtures")
+	logLevelFlag(flags)
+	var connectorNames, pluginNames, tags stringList
+	flags.Var(&connectorNames, "connector", "only run the connector with this name, may be repeated")
+	flags.Var(&pluginNames, "plugin", "only run connectors using this plugin, may be repeated")
+	flags.Var(&tags, "tags", "only run connectors with any of these comma-separated tags, may be repeated")
+	_ = flags.Parse(args)
+
+	config := loadConfig(*configPath, *environment, infoLog, errorLog)
+	if len(connectorNames) > 0 || len(pluginNames) > 0 || len(tags) > 0 {
+		if err := filterConnectors(config, connectorNames, pluginNames, tags.split()); err != nil {
+			errorLog.Printf("Failed to select connectors: %s", err)
+			os.Exit(ExitConfigError)
+		}
+		infoLog.Printf("Running %d of the configured connectors", len(config.Connectors))
+	}
+	if len(*fixturesDir) > 0 && len(*recordDir) > 0 {
+		errorLog.Println("Fixtures can't be recorded while answering requests with fixtures")
+		os.Exit(ExitConfigError)
+	}
+	if len(*fixturesDir) > 0 {
+		if err := useFixtures(config, *fixturesDir); err != nil {
+			errorLog.Printf("Failed to use fixtures: %s", err)
+			os.Exit(ExitConfigError)
+		}
+		infoLog.Printf("Answering requests of connectors with fixtures from '%s'", *fixturesDir)
+	}
+	if len(*recordDir) > 0 {
+		if err := recordFixtures(config, *recordDir); err != nil {
+			errorLog.Printf("Failed to record fixtures: %s", err)
+			os.Exit(ExitConfigError)
+		}
+		infoLog.Printf("Recording responses to requests of connectors as fixtures in '%s'", *recordDir)
+	}
+	if *dryRun {
+		infoLog.Println("Performing dry run, messages will only be logged")
+		enableDryRun(config)
+	}
+
+	offsets := openOffsets(
+		config,
+		*offsetsPath,
+		state.OpenOptions{LockTimeout: *lockTimeout, ReadOnly: *dryRun},
+		infoLog,
+		errorLog,
+	)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var reporter *ErrorReporter
+	if !*dryRun {
+		reporter = NewErrorReporter(config, errorLog)
+	}
+
+	runner, err := NewRunner(config, offsets, reporter)
+	if err != nil {
+		errorLog.Fatalf("Failed to set up connectors: %s", err)
+	}
+
+	shutdownTracing := setupTracing(config.Tracing, errorLog)
+
+	// Dry runs must not affect the monitoring of regular runs
+	healthCheck := NewHealthCheck(config.HealthCheck, errorLog)
+	if *dryRun {
+		healthCheck = NewHealthCheck(HealthCheckConfig{}, errorLog)
+	}
+	healthCheck.Start(ctx)
+
+	var previousOffsets map[string]json.RawMessage
+	if *dryRun {
+		previousOffsets = offsets.All()
 	}
 
 	infoLog.Println("Checking for updates...")
+	report := runner.RunAll(ctx)
+	runner.PostDigest(ctx)
 
-	client := CreateDiscordClient(config.DiscordWebhook, config.DiscordMentions)
-	ctx := context.Background()
+	if *dryRun {
+		logOffsetChanges(config.Connectors, previousOffsets, offsets.All(), infoLog)
+	}
 
-	var wg sync.WaitGroup
-	wg.Add(len(config.Connectors))
-	erroredChannel := make(chan interface{})
-	var workingOffsets sync.Map
+	closeSinks(config, errorLog)
 
-	// Store old offsets, so they're not lost in case of failure or between config changes
-	for connector, offset := range rawOffsets {
-		workingOffsets.Store(connector, offset)
+	if !*dryRun {
+		infoLog.Println("Storing new offsets...")
+	}
+	if err = offsets.Save(); err != nil {
+		errorLog.Printf("Failed to store new offsets: %s", err)
+		report.ExitCode = ExitFailure
+	}
+	if err = offsets.Close(); err != nil {
+		errorLog.Printf("Failed to close state store: %s", err)
 	}
 
-	for _, connector := range config.Connectors {
-		connector := connector
-		connectorInfo, connectorError := CreateLoggers(fmt.Sprintf("connector=%s", connector.Name))
-		pluginContext := PluginContext{Discord: &client, Info: connectorInfo, Error: connectorError, Context: &ctx}
-		go func() {
-			defer wg.Done()
-			var offset interface{}
-			rawOffset, ok := rawOffsets[connector.Name]
-			if ok {
-				offsetPrototype := (*connector.Plugin).OffsetPrototype()
-				offsetRef := reflect.New(reflect.TypeOf(offsetPrototype))
-				offsetRef.Elem().Set(reflect.ValueOf(offsetPrototype))
-				if err = json.Unmarshal(rawOffset, offsetRef.Interface()); err != nil {
-					pluginContext.Error.Printf("Could not parse offsets for connector '%s': %s", connector.Name, err)
-					erroredChannel <- nil
-					return
-				}
-				offset = offsetRef.Elem().Interface()
-			}
+	if ctx.Err() != nil {
+		errorLog.Println("Run was interrupted, offsets of all finished checks were stored")
+		report.ExitCode = ExitFailure
+	} else if report.ExitCode == ExitDeliveryFailure {
+		errorLog.Println("Some messages could not be delivered")
+	} else if report.ExitCode == ExitCheckFailure {
+		errorLog.Println("Errors occurred while trying to check for updates")
+	}
 
-			newOffset, err := (*connector.Plugin).Check(offset, pluginContext)
-			if err != nil {
-				pluginContext.Error.Printf("Check for connector '%s' failed: %s", connector.Name, err)
-				erroredChannel <- nil
-			}
-			workingOffsets.Store(connector.Name, newOffset)
-		}()
+	if len(config.Metrics.Textfile) > 0 && !*dryRun {
+		if err = writeMetrics(config.Metrics.Textfile); err != nil {
+			errorLog.Printf("Failed to write metrics: %s", err)
+		}
+	}
+
+	if len(*reportPath) > 0 {
+		if err = report.Write(*reportPath); err != nil {
+			errorLog.Printf("Failed to write run report: %s", err)
+		}
 	}
 
-	errored := false
+	healthCheck.Finish(context.Background(), report)
+	shutdownTracing()
+	reporter.Flush()
 
-	go func() {
-		for {
-			select {
-			case <-erroredChannel:
-				errored = true
+	os.Exit(report.ExitCode)
+}
+
+// stringList is a flag that may be specified multiple times
+type stringList []string
+
+func (list *stringList) String() string {
+	return strings.Join(*list, ",")
+}
+
+func (list *stringList) Set(value string) error {
+	*list = append(*list, value)
+	return nil
+}
+
+// split returns the comma-separated items of all values
+func (list *stringList) split() []string {
+	var items []string
+	for _, value := range *list {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); len(item) > 0 {
+				items = append(items, item)
 			}
 		}
-	}()
+	}
 
-	wg.Wait()
+	return items
+}
 
-	infoLog.Println("Storing new offsets...")
-	newOffsets := make(map[string]interface{})
-	workingOffsets.Range(func(k interface{}, v interface{}) bool {
-		newOffsets[k.(string)] = v
-		return true
-	})
-	serializedOffsets, err := json.Marshal(newOffsets)
-	if err != nil {
-		errorLog.Fatalf("Failed to serialize new offsets: %s", err)
+// filterConnectors restricts the connectors of the config to those with one of the given names, plugins or tags
+func filterConnectors(config *Config, names, plugins, tags []string) error {
+	for _, name := range names {
+		if !slices.ContainsFunc(config.Connectors, func(connector Connector) bool { return connector.Name == name }) {
+			return fmt.Errorf("unknown connector '%s'", name)
+		}
+	}
+
+	var filtered []Connector
+	for _, connector := range config.Connectors {
+		if slices.Contains(names, connector.Name) || slices.Contains(plugins, (*connector.Plugin).Name()) ||
+			slices.ContainsFunc(connector.Tags, func(tag string) bool { return slices.Contains(tags, tag) }) {
+			filtered = append(filtered, connector)
+		}
+	}
+	if len(filtered) == 0 {
+		return fmt.Errorf("no connectors match the given filters")
 	}
 
-	if err = os.WriteFile(*offsetsPath, serializedOffsets, 0644); err != nil {
-		errorLog.Fatalf("Failed to write new offsets: %s", err)
+	config.Connectors = filtered
+	return nil
+}
+
+// closeSinks closes all shared and connector-specific sinks that hold resources such as connections
+func closeSinks(config *Config, errorLog *log.Logger) {
+	closed := make(map[Sink]bool)
+	var closeSink func(name string, sink Sink)
+	closeSink = func(name string, sink Sink) {
+		if closed[sink] {
+			return
+		}
+		closed[sink] = true
+
+		if fanOut, ok := sink.(*sinks.FanOut); ok {
+			for _, target := range fanOut.Sinks {
+				closeSink(target.Name, target.Sink)
+			}
+			return
+		}
+
+		if closer, ok := sink.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				errorLog.Printf("Failed to close sink '%s': %s", name, err)
+			}
+		}
 	}
 
-	if errored {
-		errorLog.Fatal("Errors occurred while trying to check for updates")
+	for name, sink := range config.Sinks {
+		closeSink(name, sink)
+	}
+	for _, connector := range config.Connectors {
+		closeSink(connector.Name, connector.Sink)
 	}
 }
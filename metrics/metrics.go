@@ -0,0 +1,78 @@
+// Package metrics holds the Prometheus metrics of the application
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"net/http"
+)
+
+const namespace = "sanderson_notifications"
+
+// Registry holds all metrics of the application, without any metrics of the Go runtime, so that it can also be
+// written to a file after a single run
+var Registry = prometheus.NewRegistry()
+
+var (
+	CheckDuration = promauto.With(Registry).NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "check_duration_seconds",
+		Help:      "Duration of connector checks",
+		Buckets:   []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120},
+	}, []string{"connector"})
+	CheckFailures = promauto.With(Registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "check_failures_total",
+		Help:      "Number of connector checks that failed",
+	}, []string{"connector"})
+	LastCheck = promauto.With(Registry).NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "last_check_timestamp_seconds",
+		Help:      "Time at which the last check of a connector finished",
+	}, []string{"connector"})
+	ItemsFound = promauto.With(Registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "items_found_total",
+		Help:      "Number of updates connectors found and tried to post",
+	}, []string{"connector"})
+	NotificationsSent = promauto.With(Registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "notifications_sent_total",
+		Help:      "Number of notifications delivered to their sinks",
+	}, []string{"connector"})
+	NotificationFailures = promauto.With(Registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "notification_failures_total",
+		Help:      "Number of notifications that could not be delivered to some of their sinks",
+	}, []string{"connector"})
+	HTTPErrors = promauto.With(Registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_errors_total",
+		Help:      "Number of requests to sources that failed or returned an error status",
+	}, []string{"connector"})
+	DiscordRateLimits = promauto.With(Registry).NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "discord_rate_limits_total",
+		Help:      "Number of times Discord rate limited a message",
+	})
+)
+
+// Transport counts the requests of a connector that fail or return an error status
+type Transport struct {
+	Base      http.RoundTripper
+	Connector string
+}
+
+func (transport *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := transport.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	res, err := base.RoundTrip(req)
+	if err != nil || res.StatusCode >= http.StatusBadRequest {
+		HTTPErrors.WithLabelValues(transport.Connector).Inc()
+	}
+
+	return res, err
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	. "17thshard.com/sanderson-notifications/plugins"
+	"17thshard.com/sanderson-notifications/state"
+	"encoding/json"
+	"fmt"
+	"log"
+)
+
+const versionKey = "version"
+
+// MigrateOffsets brings the offsets of all configured connectors up to date with the current offset formats of their
+// plugins. The state is backed up before migrating, if the store supports it.
+func MigrateOffsets(offsets *Offsets, config *Config, info *log.Logger) error {
+	version := 0
+	if raw, ok := offsets.Entry(state.MetaBucket, versionKey); ok {
+		if err := json.Unmarshal(raw, &version); err != nil {
+			return fmt.Errorf("could not parse offsets version: %w", err)
+		}
+	}
+
+	latest := len(OffsetMigrations)
+	if version > latest {
+		return fmt.Errorf("offsets have version %d, but only versions up to %d are supported", version, latest)
+	}
+	if version == latest {
+		return nil
+	}
+
+	if err := offsets.Backup(); err != nil {
+		return fmt.Errorf("could not back up offsets before migrating: %w", err)
+	}
+
+	for ; version < latest; version++ {
+		migration := OffsetMigrations[version]
+		info.Printf("Migrating offsets to version %d: %s", version+1, migration.Description)
+
+		if migration.Migrate == nil {
+			continue
+		}
+
+		for name, plugin := range connectorPlugins(config) {
+			if plugin != migration.Plugin {
+				continue
+			}
+
+			offset, ok := offsets.Get(name)
+			if !ok {
+				continue
+			}
+
+			migrated, err := migration.Migrate(offset)
+			if err != nil {
+				return fmt.Errorf("could not migrate offset of connector '%s' to version %d: %w", name, version+1, err)
+			}
+			if err = offsets.Set(name, migrated); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := offsets.SetEntry(state.MetaBucket, versionKey, latest); err != nil {
+		return err
+	}
+
+	return offsets.Save()
+}
+
+// connectorPlugins returns the plugins of all connectors in the config, including disabled ones and those not meant
+// for the environment, as their offsets must be readable once they run again
+func connectorPlugins(config *Config) map[string]string {
+	plugins := make(map[string]string, len(config.Connectors)+len(config.skippedPlugins))
+	for name, plugin := range config.skippedPlugins {
+		plugins[name] = plugin
+	}
+	for _, connector := range config.Connectors {
+		plugins[connector.Name] = (*connector.Plugin).Name()
+	}
+
+	return plugins
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	. "17thshard.com/sanderson-notifications/plugins"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+)
+
+// logOffsetChanges logs how the offsets of the connectors changed compared to the given previous offsets, e.g. to show
+// what a dry run would have stored
+func logOffsetChanges(connectors []Connector, previous, current map[string]json.RawMessage, info *log.Logger) {
+	for _, connector := range connectors {
+		before, after := previous[connector.Name], current[connector.Name]
+		if bytes.Equal(before, after) {
+			info.Printf("Offset of connector '%s' would stay unchanged", connector.Name)
+			continue
+		}
+
+		changes, err := diffOffsets(*connector.Plugin, before, after)
+		if err != nil {
+			info.Printf("Offset of connector '%s' would change from %s to %s", connector.Name, before, after)
+			continue
+		}
+		if len(changes) == 0 {
+			info.Printf("Offset of connector '%s' would stay unchanged", connector.Name)
+			continue
+		}
+
+		info.Printf("Offset of connector '%s' would change:", connector.Name)
+		for _, change := range changes {
+			info.Printf("  %s", change)
+		}
+	}
+}
+
+// diffOffsets lists the changes between two offsets, using the plugin's diff if it provides one
+func diffOffsets(plugin Plugin, before, after json.RawMessage) ([]string, error) {
+	if diff, ok := plugin.(DiffPlugin); ok && after != nil {
+		old := plugin.OffsetPrototype()
+		if before != nil {
+			var err error
+			if old, err = decodeOffset(plugin, before); err != nil {
+				return nil, err
+			}
+		}
+		updated, err := decodeOffset(plugin, after)
+		if err != nil {
+			return nil, err
+		}
+
+		return diff.DiffOffsets(old, updated), nil
+	}
+
+	var old, updated interface{}
+	if before != nil {
+		if err := json.Unmarshal(before, &old); err != nil {
+			return nil, err
+		}
+	}
+	if after != nil {
+		if err := json.Unmarshal(after, &updated); err != nil {
+			return nil, err
+		}
+	}
+
+	return diffJSON("", old, updated), nil
+}
+
+// diffJSON compares two decoded JSON values, listing added values with '+', removed ones with '-' and changed ones with
+// '~' along with their path
+func diffJSON(path string, before, after interface{}) []string {
+	if reflect.DeepEqual(before, after) {
+		return nil
+	}
+
+	switch {
+	case before == nil:
+		return []string{fmt.Sprintf("+ %s%s", pathOrRoot(path), formatJSON(after))}
+	case after == nil:
+		return []string{fmt.Sprintf("- %s%s", pathOrRoot(path), formatJSON(before))}
+	}
+
+	oldObject, oldIsObject := before.(map[string]interface{})
+	newObject, newIsObject := after.(map[string]interface{})
+	if oldIsObject && newIsObject {
+		keys := make([]string, 0, len(oldObject)+len(newObject))
+		for key := range oldObject {
+			keys = append(keys, key)
+		}
+		for key := range newObject {
+			if _, ok := oldObject[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+
+		var changes []string
+		for _, key := range keys {
+			changes = append(changes, diffJSON(fmt.Sprintf("%s[%q]", path, key), oldObject[key], newObject[key])...)
+		}
+		return changes
+	}
+
+	oldArray, oldIsArray := before.([]interface{})
+	newArray, newIsArray := after.([]interface{})
+	if oldIsArray && newIsArray {
+		var changes []string
+		for i := 0; i < max(len(oldArray), len(newArray)); i++ {
+			var old, updated interface{}
+			if i < len(oldArray) {
+				old = oldArray[i]
+			}
+			if i < len(newArray) {
+				updated = newArray[i]
+			}
+			changes = append(changes, diffJSON(fmt.Sprintf("%s[%d]", path, i), old, updated)...)
+		}
+		return changes
+	}
+
+	return []string{fmt.Sprintf("~ %s%s -> %s", pathOrRoot(path), formatJSON(before), formatJSON(after))}
+}
+
+func pathOrRoot(path string) string {
+	if len(path) == 0 {
+		return ""
+	}
+
+	return path + ": "
+}
+
+func formatJSON(value interface{}) string {
+	serialized, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+
+	return string(serialized)
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"17thshard.com/sanderson-notifications/state"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// Offsets holds the serialized offsets of all connectors, including those no longer configured, so they're not lost
+// in case of failure or between config changes. Besides offsets, it keeps other state of the connectors in separate
+// buckets, all of which are persisted in a state store. Configs sharing a store keep their state apart in namespaces, so
+// their connectors may have the same names.
+type Offsets struct {
+	store     state.Store
+	namespace string
+	readOnly  bool
+	lock      sync.Mutex
+	buckets   map[string]map[string]json.RawMessage
+	changes   state.Changes
+	// lost reports whether the instance may no longer change the state, e.g. because another replica took over
+	lost func() bool
+}
+
+// LoadOffsets reads all state from an opened store, of which only the given namespace is used. Changes to offsets
+// loaded from a read-only store are only kept in memory and never written back.
+func LoadOffsets(store state.Store, namespace string, readOnly bool) (*Offsets, error) {
+	offsets := &Offsets{
+		store:     store,
+		namespace: namespace,
+		readOnly:  readOnly,
+		buckets:   make(map[string]map[string]json.RawMessage),
+		changes:   make(state.Changes),
+	}
+
+	for _, bucket := range state.Buckets {
+		entries, err := store.Load(bucket)
+		if err != nil {
+			return nil, fmt.Errorf("could not read %s: %w", bucket, err)
+		}
+		offsets.buckets[bucket] = entries
+	}
+
+	return offsets, nil
+}
+
+func (offsets *Offsets) Get(connector string) (json.RawMessage, bool) {
+	return offsets.Entry(state.OffsetsBucket, connector)
+}
+
+// All returns a copy of all offsets, including those of connectors that are no longer configured
+func (offsets *Offsets) All() map[string]json.RawMessage {
+	return offsets.Entries(state.OffsetsBucket)
+}
+
+func (offsets *Offsets) Set(connector string, offset interface{}) error {
+	if err := offsets.SetEntry(state.OffsetsBucket, connector, offset); err != nil {
+		return fmt.Errorf("could not serialize offset for connector '%s': %w", connector, err)
+	}
+
+	return nil
+}
+
+func (offsets *Offsets) Entry(bucket, key string) (json.RawMessage, bool) {
+	offsets.lock.Lock()
+	defer offsets.lock.Unlock()
+
+	value, ok := offsets.buckets[bucket][offsets.key(key)]
+	return value, ok
+}
+
+// Entries returns a copy of all entries of a bucket
+func (offsets *Offsets) Entries(bucket string) map[string]json.RawMessage {
+	offsets.lock.Lock()
+	defer offsets.lock.Unlock()
+
+	result := make(map[string]json.RawMessage, len(offsets.buckets[bucket]))
+	for key, value := range offsets.buckets[bucket] {
+		if key, ok := offsets.unqualified(key); ok {
+			result[key] = value
+		}
+	}
+
+	return result
+}
+
+func (offsets *Offsets) SetEntry(bucket, key string, value interface{}) error {
+	serialized, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	offsets.lock.Lock()
+	defer offsets.lock.Unlock()
+
+	key = offsets.key(key)
+	offsets.bucket(bucket)[key] = serialized
+	offsets.change(bucket, key, serialized)
+
+	return nil
+}
+
+func (offsets *Offsets) DeleteEntry(bucket, key string) {
+	offsets.lock.Lock()
+	defer offsets.lock.Unlock()
+
+	key = offsets.key(key)
+	delete(offsets.bucket(bucket), key)
+	offsets.change(bucket, key, nil)
+}
+
+// key qualifies a key with the namespace of the offsets
+func (offsets *Offsets) key(key string) string {
+	if len(offsets.namespace) == 0 {
+		return key
+	}
+
+	return state.NamespacePrefix + offsets.namespace + "/" + key
+}
+
+// unqualified returns the key without namespace, if it belongs to the namespace of the offsets
+func (offsets *Offsets) unqualified(key string) (string, bool) {
+	namespace, unqualified := state.SplitNamespace(key)
+	return unqualified, namespace == offsets.namespace
+}
+
+// Namespaces counts the entries of all namespaces in the store, including those of other configs. Entries outside
+// any namespace are counted for the empty namespace.
+func (offsets *Offsets) Namespaces() map[string]int {
+	offsets.lock.Lock()
+	defer offsets.lock.Unlock()
+
+	namespaces := make(map[string]int)
+	for _, entries := range offsets.buckets {
+		for key := range entries {
+			namespace, _ := state.SplitNamespace(key)
+			namespaces[namespace]++
+		}
+	}
+
+	return namespaces
+}
+
+// DeleteNamespace removes all entries of the namespace from the store, e.g. once the config using it is gone
+func (offsets *Offsets) DeleteNamespace(namespace string) {
+	offsets.lock.Lock()
+	defer offsets.lock.Unlock()
+
+	for bucket, entries := range offsets.buckets {
+		for key := range entries {
+			if keyNamespace, _ := state.SplitNamespace(key); keyNamespace == namespace {
+				delete(entries, key)
+				offsets.change(bucket, key, nil)
+			}
+		}
+	}
+}
+
+func (offsets *Offsets) bucket(bucket string) map[string]json.RawMessage {
+	entries, ok := offsets.buckets[bucket]
+	if !ok {
+		entries = make(map[string]json.RawMessage)
+		offsets.buckets[bucket] = entries
+	}
+
+	return entries
+}
+
+func (offsets *Offsets) change(bucket, key string, value json.RawMessage) {
+	if offsets.changes[bucket] == nil {
+		offsets.changes[bucket] = make(map[string]json.RawMessage)
+	}
+	offsets.changes[bucket][key] = value
+}
+
+// Save writes all changes to the store, regardless of whether there are any
+func (offsets *Offsets) Save() error {
+	offsets.lock.Lock()
+	defer offsets.lock.Unlock()
+
+	return offsets.save()
+}
+
+// Flush writes all changes to the store if anything changed since it was last written
+func (offsets *Offsets) Flush() error {
+	offsets.lock.Lock()
+	defer offsets.lock.Unlock()
+
+	if len(offsets.changes) == 0 {
+		return nil
+	}
+
+	return offsets.save()
+}
+
+func (offsets *Offsets) save() error {
+	if offsets.lost != nil && offsets.lost() {
+		return state.ErrLeaseLost
+	}
+	if offsets.readOnly {
+		offsets.changes = make(state.Changes)
+		return nil
+	}
+
+	if err := offsets.store.Save(offsets.changes); err != nil {
+		return err
+	}
+	offsets.changes = make(state.Changes)
+
+	return nil
+}
+
+// Fence keeps all changes from being saved once lost reports true, e.g. after losing the lease of a shared store
+func (offsets *Offsets) Fence(lost func() bool) {
+	offsets.lock.Lock()
+	defer offsets.lock.Unlock()
+
+	offsets.lost = lost
+}
+
+// Backup backs up the state before it's changed, if the store supports it and isn't read-only
+func (offsets *Offsets) Backup() error {
+	store, ok := offsets.store.(state.BackupStore)
+	if !ok || offsets.readOnly {
+		return nil
+	}
+
+	return store.Backup()
+}
+
+func (offsets *Offsets) Close() error {
+	return offsets.store.Close()
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	. "17thshard.com/sanderson-notifications/common"
+	. "17thshard.com/sanderson-notifications/plugins"
+	"17thshard.com/sanderson-notifications/state"
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// offsetsShowCommand prints the offsets of all or the given connectors, described by their plugins where possible
+func offsetsShowCommand(args []string) {
+	_, errorLog := CreateLoggers("main")
+	// Keep the output limited to the offsets themselves, so it can be processed further
+	infoLog := log.New(io.Discard, "", 0)
+
+	flags := newFlagSet("offsets show")
+	configPath := flags.String("config", "config.yml", "path or URL of YAML, JSON or TOML config file")
+	environment := flags.String("env", "", "environment to select connectors for via 'onlyIn' and 'exceptIn', replacing the config's")
+	offsetsPath := flags.String("offsets", "offsets.json", "path of offset storage file")
+	asJSON := flags.Bool("json", false, "print the raw offsets as JSON")
+	var connectorNames stringList
+	flags.Var(&connectorNames, "connector", "only show the offset of the connector with this name, may be repeated")
+	_ = flags.Parse(args)
+
+	config := loadConfig(*configPath, *environment, infoLog, errorLog)
+
+	offsets := openOffsets(config, *offsetsPath, state.OpenOptions{ReadOnly: true}, infoLog, errorLog)
+	defer offsets.Close()
+
+	plugins := connectorPlugins(config)
+	stored := offsets.All()
+
+	var names []string
+	if len(connectorNames) > 0 {
+		for _, name := range connectorNames {
+			_, configured := plugins[name]
+			if _, ok := stored[name]; !ok && !configured {
+				errorLog.Printf("Unknown connector '%s'", name)
+				os.Exit(ExitConfigError)
+			}
+			names = append(names, name)
+		}
+	} else {
+		for name := range plugins {
+			names = append(names, name)
+		}
+		for name := range stored {
+			if _, ok := plugins[name]; !ok {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+	}
+
+	if *asJSON {
+		selected := make(map[string]json.RawMessage, len(names))
+		for _, name := range names {
+			if raw, ok := stored[name]; ok {
+				selected[name] = raw
+			}
+		}
+
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(selected); err != nil {
+			errorLog.Fatalf("Failed to print offsets: %s", err)
+		}
+		return
+	}
+
+	loader := newConfigLoader()
+	for _, name := range names {
+		pluginName, configured := plugins[name]
+		switch {
+		case !configured:
+			fmt.Printf("%s (not configured)\n", name)
+		case slices.Contains(config.Disabled, name):
+			fmt.Printf("%s (%s, disabled)\n", name, pluginName)
+		case slices.Contains(config.Excluded, name):
+			fmt.Printf("%s (%s, excluded)\n", name, pluginName)
+		default:
+			fmt.Printf("%s (%s)\n", name, pluginName)
+		}
+
+		// Configured plugins are preferred, as their settings may matter for describing offsets
+		var plugin Plugin
+		if connector := config.Connector(name); connector != nil {
+			plugin = *connector.Plugin
+		} else if builder, ok := loader.AvailablePlugins[pluginName]; ok {
+			plugin = builder()
+		}
+		for _, line := range describeOffset(plugin, stored[name]) {
+			fmt.Printf("    %s\n", line)
+		}
+
+		if raw, ok := offsets.Entry(state.RunsBucket, name); ok {
+			var lastRun RunResult
+			if err := json.Unmarshal(raw, &lastRun); err == nil {
+				fmt.Printf("    %s\n", describeRun(lastRun))
+			}
+		}
+		if raw, ok := offsets.Entry(state.PausedBucket, name); ok {
+			var paused pausedConnector
+			if err := json.Unmarshal(raw, &paused); err == nil {
+				fmt.Printf("    Paused since %s\n", paused.Since.Local().Format(time.RFC3339))
+			}
+		}
+	}
+}
+
+// describeOffset presents the offset through the plugin if it supports it, falling back to the raw JSON
+func describeOffset(plugin Plugin, raw json.RawMessage) []string {
+	if raw == nil {
+		return []string{"No offset stored yet"}
+	}
+
+	describe, ok := plugin.(DescribePlugin)
+	if !ok {
+		return []string{string(raw)}
+	}
+
+	offset, err := decodeOffset(plugin, raw)
+	if err != nil {
+		return []string{fmt.Sprintf("Invalid offset: %s", err), string(raw)}
+	}
+
+	return describe.DescribeOffset(offset)
+}
+
+func describeRun(run RunResult) string {
+	description := fmt.Sprintf(
+		"Last run %s: found %d, posted %d, failed %d",
+		run.Finished.Local().Format(time.RFC3339),
+		run.Found,
+		run.Posted,
+		run.Failed,
+	)
+	if len(run.Error) > 0 {
+		description = fmt.Sprintf("%s, error: %s", description, run.Error)
+	}
+
+	return description
+}
+
+// offsetsChangeCommand removes the offset of a connector or replaces it with the given value, after backing up the
+// state and asking for confirmation
+func offsetsChangeCommand(subcommand string, args []string) {
+	infoLog, errorLog := CreateLoggers("main")
+
+	flags := newFlagSet("offsets " + subcommand)
+	configPath := flags.String("config", "config.yml", "path or URL of YAML, JSON or TOML config file")
+	offsetsPath := flags.String("offsets", "offsets.json", "path of offset storage file")
+	lockTimeout := flags.Duration("wait", 0, "how long to wait for another running instance to finish")
+	connector := flags.String("connector", "", "name of the connector whose offset to change")
+	yes := flags.Bool("yes", false, "change the offset without asking for confirmation")
+	var value *string
+	if subcommand == "set" {
+		value = flags.String("value", "", "new offset as JSON, plain text is taken as a JSON string")
+	}
+	_ = flags.Parse(args)
+
+	if len(*connector) == 0 {
+		errorLog.Printf("The connector whose offset to %s must be given via -connector", subcommand)
+		os.Exit(ExitConfigError)
+	}
+	if value != nil && len(*value) == 0 {
+		errorLog.Print("The new offset must be given via -value")
+		os.Exit(ExitConfigError)
+	}
+
+	config := loadConfig(*configPath, "", infoLog, errorLog)
+
+	offsets := openOffsets(config, *offsetsPath, state.OpenOptions{LockTimeout: *lockTimeout}, infoLog, errorLog)
+	defer offsets.Close()
+
+	previous, stored := offsets.Get(*connector)
+	pluginName, configured := connectorPlugins(config)[*connector]
+	if !stored && !configured {
+		errorLog.Printf("Unknown connector '%s'", *connector)
+		os.Exit(ExitConfigError)
+	}
+
+	var offset json.RawMessage
+	if value != nil {
+		var plugin Plugin
+		if builder, ok := newConfigLoader().AvailablePlugins[pluginName]; ok {
+			plugin = builder()
+		}
+
+		var err error
+		if offset, err = parseOffset(plugin, *value); err != nil {
+			errorLog.Printf("Invalid offset for connector '%s': %s", *connector, err)
+			os.Exit(ExitConfigError)
+		}
+	} else if !stored {
+		infoLog.Printf("Connector '%s' has no offset stored, nothing to reset", *connector)
+		return
+	}
+
+	if stored {
+		infoLog.Printf("Current offset of connector '%s': %s", *connector, previous)
+	}
+	if offset != nil {
+		infoLog.Printf("New offset of connector '%s': %s", *connector, offset)
+	}
+
+	if !*yes {
+		prompt := &prompter{input: bufio.NewScanner(os.Stdin), output: os.Stdout}
+		answer, err := prompt.ask(fmt.Sprintf("%s offset of connector '%s'? (yes/no)", strings.ToUpper(subcommand[:1])+subcommand[1:], *connector), "no")
+		if err != nil || !strings.HasPrefix(strings.ToLower(answer), "y") {
+			infoLog.Println("Offset left unchanged")
+			return
+		}
+	}
+
+	if err := offsets.Backup(); err != nil {
+		errorLog.Fatalf("Failed to back up offsets: %s", err)
+	}
+
+	if offset != nil {
+		if err := offsets.Set(*connector, offset); err != nil {
+			errorLog.Fatalf("Failed to set offset: %s", err)
+		}
+	} else {
+		offsets.DeleteEntry(state.OffsetsBucket, *connector)
+	}
+	if err := offsets.Flush(); err != nil {
+		errorLog.Fatalf("Failed to store offsets: %s", err)
+	}
+
+	if offset != nil {
+		infoLog.Printf("Set offset of connector '%s'", *connector)
+	} else {
+		infoLog.Printf("Reset offset of connector '%s', its next check starts from scratch", *connector)
+	}
+}
+
+// parseOffset reads a JSON offset and checks that the plugin understands it. Values that aren't valid offsets are
+// taken as JSON strings, so e.g. Tweet IDs don't need to be quoted.
+func parseOffset(plugin Plugin, value string) (json.RawMessage, error) {
+	offset := json.RawMessage(value)
+	if plugin == nil {
+		if !json.Valid(offset) {
+			return json.Marshal(value)
+		}
+		return offset, nil
+	}
+
+	_, err := decodeOffset(plugin, offset)
+	if err == nil {
+		return offset, nil
+	}
+
+	quoted, _ := json.Marshal(value)
+	if _, quotedErr := decodeOffset(plugin, quoted); quotedErr == nil {
+		return quoted, nil
+	}
+
+	return nil, err
+}
+
+// offsetsNamespacesCommand lists the namespaces of all configs sharing the state store, or removes one of them along
+// with all its state
+func offsetsNamespacesCommand(args []string) {
+	infoLog, errorLog := CreateLoggers("main")
+
+	flags := newFlagSet("offsets namespaces")
+	configPath := flags.String("config", "config.yml", "path or URL of YAML, JSON or TOML config file")
+	offsetsPath := flags.String("offsets", "offsets.json", "path of offset storage file")
+	lockTimeout := flags.Duration("wait", 0, "how long to wait for another running instance to finish")
+	deleted := flags.String("delete", "", "remove all state of this namespace")
+	yes := flags.Bool("yes", false, "remove the namespace without asking for confirmation")
+	_ = flags.Parse(args)
+
+	if len(*deleted) == 0 {
+		// Keep the output limited to the namespaces themselves, so it can be processed further
+		infoLog = log.New(io.Discard, "", 0)
+	}
+
+	config := loadConfig(*configPath, "", infoLog, errorLog)
+
+	options := state.OpenOptions{LockTimeout: *lockTimeout, ReadOnly: len(*deleted) == 0}
+	offsets := openOffsets(config, *offsetsPath, options, infoLog, errorLog)
+	defer offsets.Close()
+
+	namespaces := offsets.Namespaces()
+	if len(*deleted) == 0 {
+		names := make([]string, 0, len(namespaces))
+		for namespace := range namespaces {
+			names = append(names, namespace)
+		}
+		sort.Strings(names)
+
+		for _, namespace := range names {
+			label := namespace
+			if len(label) == 0 {
+				label = "(none)"
+			}
+			if namespace == config.Namespace {
+				label += " (current)"
+			}
+			fmt.Printf("%-30s %d entries\n", label, namespaces[namespace])
+		}
+		return
+	}
+
+	count, ok := namespaces[*deleted]
+	if !ok {
+		errorLog.Printf("Unknown namespace '%s'", *deleted)
+		os.Exit(ExitConfigError)
+	}
+	if *deleted == config.Namespace {
+		infoLog.Printf("Namespace '%s' is used by config '%s'", *deleted, *configPath)
+	}
+
+	if !*yes {
+		prompt := &prompter{input: bufio.NewScanner(os.Stdin), output: os.Stdout}
+		answer, err := prompt.ask(fmt.Sprintf("Remove %d entries of namespace '%s'? (yes/no)", count, *deleted), "no")
+		if err != nil || !strings.HasPrefix(strings.ToLower(answer), "y") {
+			infoLog.Println("Namespace left unchanged")
+			return
+		}
+	}
+
+	if err := offsets.Backup(); err != nil {
+		errorLog.Fatalf("Failed to back up offsets: %s", err)
+	}
+	offsets.DeleteNamespace(*deleted)
+	if err := offsets.Flush(); err != nil {
+		errorLog.Fatalf("Failed to store offsets: %s", err)
+	}
+
+	infoLog.Printf("Removed %d entries of namespace '%s'", count, *deleted)
+}
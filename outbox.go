@@ -0,0 +1,118 @@
+package main
+
+import (
+	. "17thshard.com/sanderson-notifications/common"
+	"17thshard.com/sanderson-notifications/state"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+)
+
+// Outbox keeps messages that could not be delivered in the state store, so they can be redelivered later in the order
+// they were sent
+type Outbox struct {
+	offsets *Offsets
+	lock    sync.Mutex
+}
+
+func (outbox *Outbox) pending(connector string) ([]Message, error) {
+	raw, ok := outbox.offsets.Entry(state.OutboxBucket, connector)
+	if !ok {
+		return nil, nil
+	}
+
+	var messages []Message
+	if err := json.Unmarshal(raw, &messages); err != nil {
+		return nil, fmt.Errorf("could not parse outbox of connector '%s': %w", connector, err)
+	}
+
+	return messages, nil
+}
+
+func (outbox *Outbox) store(connector string, messages []Message) error {
+	if len(messages) == 0 {
+		outbox.offsets.DeleteEntry(state.OutboxBucket, connector)
+		return nil
+	}
+
+	return outbox.offsets.SetEntry(state.OutboxBucket, connector, messages)
+}
+
+// Enqueue appends a message to the outbox of a connector
+func (outbox *Outbox) Enqueue(connector string, message Message) error {
+	outbox.lock.Lock()
+	defer outbox.lock.Unlock()
+
+	messages, err := outbox.pending(connector)
+	if err != nil {
+		return err
+	}
+
+	return outbox.store(connector, append(messages, message))
+}
+
+// HasPending returns whether there are messages of the connector waiting for redelivery
+func (outbox *Outbox) HasPending(connector string) bool {
+	_, ok := outbox.offsets.Entry(state.OutboxBucket, connector)
+	return ok
+}
+
+// Redeliver sends the pending messages of a connector in order, stopping at the first one that fails again
+func (outbox *Outbox) Redeliver(ctx context.Context, connector string, sink Sink, info *log.Logger) error {
+	outbox.lock.Lock()
+	defer outbox.lock.Unlock()
+
+	messages, err := outbox.pending(connector)
+	if err != nil || len(messages) == 0 {
+		return err
+	}
+
+	info.Printf("Redelivering %d messages from outbox", len(messages))
+
+	delivered := 0
+	for _, message := range messages {
+		if err = sink.Deliver(ctx, message); err != nil {
+			break
+		}
+		delivered++
+	}
+
+	if storeErr := outbox.store(connector, messages[delivered:]); storeErr != nil {
+		return storeErr
+	}
+	if err != nil {
+		return fmt.Errorf("could not redeliver %d messages from outbox: %w", len(messages)-delivered, err)
+	}
+
+	return nil
+}
+
+// OutboxSink queues messages in the outbox instead of failing if they can't be delivered. As long as there are
+// queued messages for the connector, new messages are queued behind them to preserve their order.
+type OutboxSink struct {
+	Sink
+	connector string
+	outbox    *Outbox
+	error     *log.Logger
+	queued    *atomic.Bool
+}
+
+func (sink *OutboxSink) Deliver(ctx context.Context, message Message) error {
+	if !sink.outbox.HasPending(sink.connector) {
+		err := sink.Sink.Deliver(ctx, message)
+		if err == nil {
+			return nil
+		}
+		sink.error.Printf("Could not deliver message, queueing it for redelivery: %s", err)
+	}
+
+	if err := sink.outbox.Enqueue(sink.connector, message); err != nil {
+		return fmt.Errorf("could not queue message in outbox: %w", err)
+	}
+	sink.queued.Store(true)
+
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	. "17thshard.com/sanderson-notifications/common"
+	"context"
+)
+
+// MessageOverrides replace parts of the messages of a connector, regardless of what its plugin sets
+type MessageOverrides struct {
+	Username  string
+	AvatarURL string
+	Mentions  *DiscordMentions
+	// MentionsByType replace the mentions of messages of certain types, taking precedence over Mentions
+	MentionsByType map[string]DiscordMentions
+	// SuppressEmbeds hides the previews Discord shows for links in messages
+	SuppressEmbeds bool
+}
+
+func (overrides MessageOverrides) empty() bool {
+	return len(overrides.Username) == 0 && len(overrides.AvatarURL) == 0 && overrides.Mentions == nil &&
+		len(overrides.MentionsByType) == 0 && !overrides.SuppressEmbeds
+}
+
+// overrideSink applies the overrides configured for a connector to its messages. As they're applied right before
+// delivery, messages from the outbox or held during quiet hours use the current config, too.
+type overrideSink struct {
+	Sink
+	overrides MessageOverrides
+}
+
+func (sink *overrideSink) Deliver(ctx context.Context, message Message) error {
+	return sink.Sink.Deliver(ctx, sink.overrides.apply(message))
+}
+
+// apply replaces the parts of the message that are overridden
+func (overrides MessageOverrides) apply(message Message) Message {
+	if len(overrides.Username) > 0 {
+		message.Username = overrides.Username
+	}
+	if len(overrides.AvatarURL) > 0 {
+		message.AvatarURL = overrides.AvatarURL
+	}
+	if mentions, ok := overrides.MentionsByType[message.Type]; ok && message.Mentions == nil {
+		message.Mentions = &mentions
+	}
+	if message.Mentions == nil {
+		message.Mentions = overrides.Mentions
+	}
+	message.SuppressEmbeds = message.SuppressEmbeds || overrides.SuppressEmbeds
+
+	return message
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	. "17thshard.com/sanderson-notifications/common"
+	"17thshard.com/sanderson-notifications/state"
+	"context"
+	"fmt"
+	"time"
+)
+
+// pausedConnector is stored for paused connectors, so they stay paused across restarts
+type pausedConnector struct {
+	Since time.Time `json:"since"`
+}
+
+// SetPaused pauses or resumes a connector. Paused connectors are still checked and keep their offsets up to date, but
+// their notifications are suppressed, so nothing they found while paused is posted after they are resumed.
+func (runner *Runner) SetPaused(connector *ConnectorRuntime, paused bool) error {
+	connector.updateState(func(state *ConnectorState) {
+		state.Paused = paused
+	})
+
+	if paused {
+		if err := runner.offsets.SetEntry(state.PausedBucket, connector.Name, pausedConnector{Since: time.Now()}); err != nil {
+			return err
+		}
+	} else {
+		runner.offsets.DeleteEntry(state.PausedBucket, connector.Name)
+	}
+
+	if err := runner.offsets.Flush(); err != nil {
+		return fmt.Errorf("could not store whether connector '%s' is paused: %w", connector.Name, err)
+	}
+
+	return nil
+}
+
+// pausedSink drops all messages of a connector while it is paused
+type pausedSink struct {
+	Sink
+	connector *ConnectorRuntime
+}
+
+func (sink *pausedSink) Deliver(ctx context.Context, message Message) error {
+	if sink.connector.State().Paused {
+		sink.connector.info.Println("Suppressing message, as connector is paused")
+		return nil
+	}
+
+	return sink.Sink.Deliver(ctx, message)
+}
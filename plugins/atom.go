@@ -1,12 +1,15 @@
 package plugins
 
 import (
+	"17thshard.com/sanderson-notifications/common"
+	goContext "context"
 	"fmt"
 	"github.com/PuerkitoBio/goquery"
 	"github.com/mmcdole/gofeed/atom"
 	"net/http"
 	"slices"
 	"sort"
+	"strings"
 	"time"
 )
 
@@ -17,6 +20,10 @@ type AtomPlugin struct {
 	Message      string
 	ExcludedTags []string       `mapstructure:"excludedTags"`
 	MaxAge       *time.Duration `mapstructure:"maxAge"`
+	// RichEmbed posts an embed with the title, summary and image of a post instead of relying on the link preview
+	RichEmbed bool `mapstructure:"richEmbed"`
+	// Button is the label of a button linking to the post, e.g. "Read the post"
+	Button string
 
 	client *http.Client
 }
@@ -25,16 +32,45 @@ func (plugin *AtomPlugin) Name() string {
 	return "atom"
 }
 
+func (plugin *AtomPlugin) SetupFields() []SetupField {
+	return []SetupField{{Key: "feedUrl", Prompt: "URL of the Atom feed"}}
+}
+
 func (plugin *AtomPlugin) Validate() error {
 	if len(plugin.FeedURL) == 0 {
 		return fmt.Errorf("feed URL for Atom integration must not be empty")
 	}
 
+	if _, err := common.ParseTemplate("message", plugin.Message); err != nil {
+		return err
+	}
+
 	return nil
 }
 
+func (plugin *AtomPlugin) SourceHost() string {
+	return hostOf(plugin.FeedURL)
+}
+
 func (plugin *AtomPlugin) OffsetPrototype() interface{} {
-	return map[string]bool{}
+	return HandledEntries{}
+}
+
+func (plugin *AtomPlugin) DescribeOffset(offset interface{}) []string {
+	return offset.(HandledEntries).Describe()
+}
+
+func (plugin *AtomPlugin) DiffOffsets(old, updated interface{}) []string {
+	return old.(HandledEntries).Diff(updated.(HandledEntries))
+}
+
+func (plugin *AtomPlugin) BackfillOffset(since time.Time) (interface{}, error) {
+	if !since.IsZero() {
+		maxAge := time.Since(since)
+		plugin.MaxAge = &maxAge
+	}
+
+	return HandledEntries{}, nil
 }
 
 type AtomPost struct {
@@ -42,8 +78,83 @@ type AtomPost struct {
 	ID        string
 	Title     string
 	Link      string
+	Author    string
+	Summary   string
+	Image     string
 }
 
+// message presents the post as it is posted, using the configured message
+func (plugin *AtomPlugin) message(post AtomPost, feedTitle string) (common.Message, error) {
+	text, err := common.RenderTemplate("message", plugin.Message, post.templateData(plugin.Nickname))
+	if err != nil {
+		return common.Message{}, err
+	}
+
+	message := common.Message{
+		Text:      fmt.Sprintf("%s\n%s", text, post.Link),
+		Username:  plugin.Nickname,
+		AvatarURL: plugin.AvatarURL,
+		Item:      post.ID,
+		Type:      "post",
+	}
+	if plugin.RichEmbed {
+		message.Text = text
+		message.Embed = post.embed(feedTitle)
+	}
+	if len(plugin.Button) > 0 {
+		message.Buttons = []common.LinkButton{{Label: plugin.Button, URL: post.Link}}
+	}
+
+	return message, nil
+}
+
+// SampleMessage presents a made-up post like a real one
+func (plugin *AtomPlugin) SampleMessage(locale common.Locale, item SampleItem) (common.Message, error) {
+	if len(item.Type) > 0 && item.Type != "post" {
+		return common.Message{}, fmt.Errorf("unknown post type '%s', must be 'post'", item.Type)
+	}
+	if len(plugin.Nickname) == 0 {
+		plugin.Nickname = "Sample Blog"
+	}
+	if len(plugin.Message) == 0 {
+		plugin.Message = locale.Text("atom.post")
+	}
+
+	item = item.orDefault(SampleItem{
+		Title:       "A sample blog post",
+		Link:        plugin.FeedURL,
+		Author:      plugin.Nickname,
+		Text:        "This is what notifications about new blog posts will look like.",
+		PublishedAt: time.Now(),
+	})
+	return plugin.message(AtomPost{
+		Timestamp: &item.PublishedAt,
+		Title:     item.Title,
+		Link:      item.Link,
+		Author:    item.Author,
+		Summary:   item.Text,
+	}, plugin.Nickname)
+}
+
+// templateData exposes the post to message templates
+func (post AtomPost) templateData(nickname string) common.TemplateData {
+	data := common.TemplateData{
+		Nickname: nickname,
+		Type:     "post",
+		Title:    post.Title,
+		Link:     post.Link,
+		Author:   post.Author,
+	}
+	if post.Timestamp != nil {
+		data.PublishedAt = *post.Timestamp
+	}
+
+	return data
+}
+
+// maxSummaryLength limits how much of a post's summary is shown in its embed
+const maxSummaryLength = 300
+
 type ByTimestamp []AtomPost
 
 func (posts ByTimestamp) Len() int {
@@ -66,21 +177,57 @@ func (posts ByTimestamp) Swap(i, j int) {
 	posts[i], posts[j] = posts[j], posts[i]
 }
 
+// Diagnose checks that the feed can be read and parsed
+func (plugin *AtomPlugin) Diagnose(context PluginContext) error {
+	req, err := http.NewRequestWithContext(*context.Context, http.MethodGet, plugin.FeedURL, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := context.HTTP.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not read Atom feed at '%s': %w", plugin.FeedURL, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return common.HTTPStatusError{URL: plugin.FeedURL, StatusCode: res.StatusCode}
+	}
+
+	if _, err = (&atom.Parser{}).Parse(res.Body); err != nil {
+		return fmt.Errorf("invalid Atom feed at '%s': %w", plugin.FeedURL, err)
+	}
+
+	return nil
+}
+
 func (plugin *AtomPlugin) Check(offset interface{}, context PluginContext) (interface{}, error) {
 	context.Info.Printf("Checking Atom feed at %s for updates...", plugin.FeedURL)
 
 	plugin.client = &http.Client{
+		Transport: context.HTTP.Transport,
+		Timeout:   context.HTTP.Timeout,
 		CheckRedirect: func(req *http.Request, via []*http.Request) error {
 			return http.ErrUseLastResponse
 		},
 	}
 
-	res, err := plugin.client.Get(plugin.FeedURL)
+	req, err := http.NewRequestWithContext(*context.Context, http.MethodGet, plugin.FeedURL, nil)
+	if err != nil {
+		return offset, err
+	}
+
+	res, err := plugin.client.Do(common.Conditional(req))
 	if err != nil {
 		return offset, fmt.Errorf("could not read Atom feed at '%s': %w", plugin.FeedURL, err)
 	}
 	defer res.Body.Close()
 
+	if res.StatusCode == http.StatusNotModified {
+		context.Info.Printf("Atom feed at '%s' was not modified.", plugin.FeedURL)
+		return offset, nil
+	}
+
 	if res.StatusCode == 404 {
 		logLevel := context.Info
 		if offset == nil {
@@ -96,48 +243,59 @@ func (plugin *AtomPlugin) Check(offset interface{}, context PluginContext) (inte
 		return offset, err
 	}
 
+	context.Log.Debug("Parsed feed", "entries", len(atomFeed.Entries), "feed", plugin.FeedURL)
 	if len(atomFeed.Entries) == 0 {
 		context.Info.Printf("No entries in Atoom feed at '%s'.", plugin.FeedURL)
 		return offset, nil
 	}
 
-	handledEntries := make(map[string]bool)
+	now := time.Now()
+	handledEntries := make(HandledEntries)
 	if offset != nil {
-		handledEntries = offset.(map[string]bool)
+		handledEntries = offset.(HandledEntries)
 	}
 
 	var sortedEntries []AtomPost
 
 	for _, entry := range atomFeed.Entries {
-		if handled, present := handledEntries[entry.ID]; present && handled {
+		if handledEntries.Handled(entry.ID) {
+			handledEntries.Seen(entry.ID, now)
+			context.Log.Debug("Skipping post as it was already handled", "item", entry.ID, "feed", plugin.FeedURL)
 			continue
 		}
 
 		link := entry.Links[0].Href
-		hasExcludedTag, err := plugin.HasExcludedTag(link)
+		hasExcludedTag, err := plugin.HasExcludedTag(*context.Context, context.HTTP, link)
 		if err != nil {
 			return offset, fmt.Errorf("could not fully handle Atom feed at '%s': %w", plugin.FeedURL, err)
 		}
 
 		if hasExcludedTag {
-			handledEntries[entry.ID] = true
-			context.Info.Printf("Skipping post '%s' from feed at '%s' as it has an excluded tag", entry.Title, plugin.FeedURL)
+			handledEntries.Seen(entry.ID, now)
+			context.Log.Info("Skipping post as it has an excluded tag", "item", entry.ID, "title", entry.Title, "feed", plugin.FeedURL)
 			continue
 		}
 
-		sortedEntries = append([]AtomPost{{
+		post := AtomPost{
 			Timestamp: entry.PublishedParsed,
 			ID:        entry.ID,
 			Title:     entry.Title,
 			Link:      link,
-		}}, sortedEntries...)
+			Summary:   plainText(entry.Summary, maxSummaryLength),
+			Image:     entryImage(entry),
+		}
+		if len(entry.Authors) > 0 {
+			post.Author = entry.Authors[0].Name
+		}
+		sortedEntries = append([]AtomPost{post}, sortedEntries...)
 	}
 
 	sort.Sort(ByTimestamp(sortedEntries))
+	handledEntries.Prune(now, context.OffsetRetention)
 
 	if len(sortedEntries) == 0 {
 		context.Info.Printf("No posts to report from Atom feed at '%s'.", plugin.FeedURL)
-		return offset, nil
+		return handledEntries, nil
 	}
 
 	context.Info.Printf("Reporting posts from Atom feed at '%s'...", plugin.FeedURL)
@@ -152,7 +310,7 @@ func (plugin *AtomPlugin) Check(offset interface{}, context PluginContext) (inte
 	}
 
 	if len(plugin.Message) == 0 {
-		plugin.Message = "A new blog post was published"
+		plugin.Message = context.Locale.Text("atom.post")
 		context.Info.Printf(
 			"No message was provided for Atom feed at '%s', using default",
 			plugin.FeedURL,
@@ -160,36 +318,133 @@ func (plugin *AtomPlugin) Check(offset interface{}, context PluginContext) (inte
 	}
 
 	for _, entry := range sortedEntries {
+		if err = (*context.Context).Err(); err != nil {
+			return handledEntries, err
+		}
+
 		if entry.Timestamp != nil && plugin.MaxAge != nil && time.Now().Sub(*entry.Timestamp) > *plugin.MaxAge {
-			handledEntries[entry.ID] = true
-			context.Info.Printf("Skipping post '%s' from feed at '%s' as it is too old", entry.Title, plugin.FeedURL)
+			handledEntries.Seen(entry.ID, now)
+			context.Log.Info("Skipping post as it is too old", "item", entry.ID, "title", entry.Title, "feed", plugin.FeedURL)
 			continue
 		}
 
-		if err = context.Discord.SendWithCustomAvatar(
-			fmt.Sprintf("%s\n%s", plugin.Message, entry.Link),
-			plugin.Nickname,
-			plugin.AvatarURL,
-			nil,
-		); err != nil {
+		message, err := plugin.message(entry, atomFeed.Title)
+		if err != nil {
 			return handledEntries, err
 		}
 
-		handledEntries[entry.ID] = true
+		if err = context.Discord.SendMessage(message); err != nil {
+			return handledEntries, err
+		}
+
+		handledEntries.Seen(entry.ID, now)
 
-		context.Info.Printf("Reported post '%s' from feed at '%s'", entry.Title, plugin.FeedURL)
+		context.Log.Info("Reported post", "item", entry.ID, "title", entry.Title, "feed", plugin.FeedURL)
 	}
 
 	return handledEntries, nil
 }
 
-func (plugin *AtomPlugin) HasExcludedTag(link string) (bool, error) {
+// embed presents the post with its title, summary and image, attributing it to its author or the feed
+func (post AtomPost) embed(feedTitle string) *common.Embed {
+	embed := common.NewEmbed().
+		WithTitle(post.Title).
+		WithURL(post.Link).
+		WithDescription(post.Summary)
+
+	author := post.Author
+	if len(author) == 0 {
+		author = feedTitle
+	}
+	if len(author) > 0 {
+		embed.WithAuthor(author, "", "")
+	}
+	if post.Timestamp != nil {
+		embed.WithTimestamp(*post.Timestamp)
+	}
+	if len(post.Image) > 0 {
+		embed.WithImage(post.Image)
+	}
+
+	return embed
+}
+
+// entryImage finds an image of an entry, given either as enclosure or through Media RSS
+func entryImage(entry *atom.Entry) string {
+	for _, link := range entry.Links {
+		if link.Rel == "enclosure" && strings.HasPrefix(link.Type, "image/") {
+			return link.Href
+		}
+	}
+
+	for _, name := range []string{"thumbnail", "content"} {
+		for _, media := range entry.Extensions["media"][name] {
+			if url := media.Attrs["url"]; len(url) > 0 && (name == "thumbnail" || media.Attrs["medium"] == "image") {
+				return url
+			}
+		}
+	}
+
+	return ""
+}
+
+// plainText strips the HTML of a summary, shortening it to the given number of characters
+func plainText(html string, maxLength int) string {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+	if err != nil {
+		return ""
+	}
+
+	text := []rune(strings.Join(strings.Fields(doc.Text()), " "))
+	if len(text) > maxLength {
+		return strings.TrimSpace(string(text[:maxLength-1])) + "…"
+	}
+
+	return string(text)
+}
+
+func (plugin *AtomPlugin) WebSubTopic(context PluginContext) (string, string, error) {
+	req, err := http.NewRequestWithContext(*context.Context, http.MethodGet, plugin.FeedURL, nil)
+	if err != nil {
+		return "", "", err
+	}
+
+	res, err := context.HTTP.Do(req)
+	if err != nil {
+		return "", "", fmt.Errorf("could not read Atom feed at '%s': %w", plugin.FeedURL, err)
+	}
+	defer res.Body.Close()
+
+	fp := atom.Parser{}
+	atomFeed, err := fp.Parse(res.Body)
+	if err != nil {
+		return "", "", err
+	}
+
+	topic, hub := plugin.FeedURL, ""
+	for _, link := range atomFeed.Links {
+		switch link.Rel {
+		case "hub":
+			hub = link.Href
+		case "self":
+			topic = link.Href
+		}
+	}
+
+	return topic, hub, nil
+}
+
+func (plugin *AtomPlugin) HasExcludedTag(ctx goContext.Context, client *http.Client, link string) (bool, error) {
 	if len(plugin.ExcludedTags) == 0 {
 		return false, nil
 	}
 
-	res, err := http.Get(link)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return false, err
+	}
 
+	res, err := client.Do(req)
 	if err != nil {
 		return false, fmt.Errorf("could not read entry '%s': %w", link, err)
 	}
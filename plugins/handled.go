@@ -0,0 +1,86 @@
+package plugins
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// HandledEntries is the offset of plugins that remember which entries of a feed they already handled, mapping their
+// IDs to when they were last seen in the feed. Entries that haven't been seen for longer than the offset retention are
+// pruned, so offsets don't grow forever while entries that are still in the feed are never reported again.
+type HandledEntries map[string]time.Time
+
+// Handled checks whether the entry was handled before
+func (entries HandledEntries) Handled(id string) bool {
+	_, ok := entries[id]
+	return ok
+}
+
+// Seen marks the entry as handled and present in the feed at the given time
+func (entries HandledEntries) Seen(id string, at time.Time) {
+	entries[id] = at.UTC().Truncate(time.Second)
+}
+
+// Prune removes all entries that weren't seen within the retention before the given time. A retention of zero keeps
+// all entries.
+func (entries HandledEntries) Prune(now time.Time, retention time.Duration) {
+	if retention <= 0 {
+		return
+	}
+
+	cutoff := now.Add(-retention)
+	for id, seen := range entries {
+		if seen.Before(cutoff) {
+			delete(entries, id)
+		}
+	}
+}
+
+// Describe summarizes how many entries were handled and when they were seen
+func (entries HandledEntries) Describe() []string {
+	if len(entries) == 0 {
+		return []string{"No handled entries"}
+	}
+
+	var oldest, latest time.Time
+	for _, seen := range entries {
+		if oldest.IsZero() || seen.Before(oldest) {
+			oldest = seen
+		}
+		if seen.After(latest) {
+			latest = seen
+		}
+	}
+
+	return []string{
+		fmt.Sprintf("%d handled entries", len(entries)),
+		fmt.Sprintf("Last seen %s, least recently seen %s", latest.Local().Format(time.RFC3339), oldest.Local().Format(time.RFC3339)),
+	}
+}
+
+// Diff lists the entries that were newly handled or pruned, summarizing those that were merely seen again
+func (entries HandledEntries) Diff(updated HandledEntries) []string {
+	var changes []string
+	seenAgain := 0
+	for id, seen := range updated {
+		previous, ok := entries[id]
+		if !ok {
+			changes = append(changes, fmt.Sprintf("+ %s", id))
+		} else if !seen.Equal(previous) {
+			seenAgain++
+		}
+	}
+	for id := range entries {
+		if _, ok := updated[id]; !ok {
+			changes = append(changes, fmt.Sprintf("- %s", id))
+		}
+	}
+	sort.Strings(changes)
+
+	if seenAgain > 0 {
+		changes = append(changes, fmt.Sprintf("~ %d handled entries seen again", seenAgain))
+	}
+
+	return changes
+}
@@ -0,0 +1,178 @@
+package plugins
+
+import (
+	"17thshard.com/sanderson-notifications/common"
+	"fmt"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const (
+	defaultHeartbeatInterval = 7 * 24 * time.Hour
+	// heartbeatTolerance lets heartbeats be posted slightly early, so a connector checked on a schedule matching the
+	// interval doesn't skip every other heartbeat because the previous one was posted a few seconds after its check began
+	heartbeatTolerance = 5 * time.Minute
+	heartbeatColor     = 0x2ECC71
+)
+
+// HeartbeatPlugin regularly posts a message that the application is still running, so a deployment that stopped
+// checking altogether doesn't go unnoticed
+type HeartbeatPlugin struct {
+	Interval time.Duration `mapstructure:"interval"`
+	Message  string        `mapstructure:"message"`
+	// Version and Started describe the running application and are provided when creating the plugin
+	Version string    `mapstructure:"-"`
+	Started time.Time `mapstructure:"-"`
+}
+
+// HeartbeatOffset remembers when the last heartbeat was posted
+type HeartbeatOffset struct {
+	LastSent time.Time
+}
+
+func (plugin *HeartbeatPlugin) Name() string {
+	return "heartbeat"
+}
+
+func (plugin *HeartbeatPlugin) SetupFields() []SetupField {
+	return []SetupField{
+		{Key: "interval", Prompt: "Time between two heartbeats", Default: "168h"},
+	}
+}
+
+func (plugin *HeartbeatPlugin) Validate() error {
+	if plugin.Interval < 0 {
+		return fmt.Errorf("interval between heartbeats must not be negative")
+	}
+	if len(plugin.Message) > 0 {
+		if _, err := common.ParseTemplate("message", plugin.Message); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// interval is the configured time between two heartbeats or the default one
+func (plugin *HeartbeatPlugin) interval() time.Duration {
+	if plugin.Interval <= 0 {
+		return defaultHeartbeatInterval
+	}
+
+	return plugin.Interval
+}
+
+func (plugin *HeartbeatPlugin) OffsetPrototype() interface{} {
+	return HeartbeatOffset{}
+}
+
+// BackfillOffset posts a heartbeat right away, as there are no past ones to report
+func (plugin *HeartbeatPlugin) BackfillOffset(_ time.Time) (interface{}, error) {
+	return HeartbeatOffset{}, nil
+}
+
+// DescribeOffset shows when the last heartbeat was posted and when the next one is due
+func (plugin *HeartbeatPlugin) DescribeOffset(offset interface{}) []string {
+	heartbeat, _ := offset.(HeartbeatOffset)
+	if heartbeat.LastSent.IsZero() {
+		return []string{"No heartbeat posted yet"}
+	}
+
+	return []string{
+		fmt.Sprintf("Last heartbeat posted %s", heartbeat.LastSent.Local().Format(time.RFC3339)),
+		fmt.Sprintf("Next heartbeat due %s", heartbeat.LastSent.Add(plugin.interval()).Local().Format(time.RFC3339)),
+	}
+}
+
+func (plugin *HeartbeatPlugin) Check(offset interface{}, context PluginContext) (interface{}, error) {
+	previous, _ := offset.(HeartbeatOffset)
+
+	now := time.Now()
+	if due := previous.LastSent.Add(plugin.interval()); now.Before(due.Add(-heartbeatTolerance)) {
+		context.Info.Printf("Next heartbeat is due %s", due.Local().Format(time.RFC3339))
+		return previous, nil
+	}
+
+	context.Info.Println("Posting heartbeat...")
+	message, err := plugin.message(context.Locale, now, previous.LastSent)
+	if err != nil {
+		return previous, err
+	}
+	if err = context.Discord.SendMessage(message); err != nil {
+		return previous, err
+	}
+
+	return HeartbeatOffset{LastSent: now.UTC().Truncate(time.Second)}, nil
+}
+
+// SampleMessage presents a heartbeat like a real one, following one posted an interval ago
+func (plugin *HeartbeatPlugin) SampleMessage(locale common.Locale, item SampleItem) (common.Message, error) {
+	if len(item.Type) > 0 && item.Type != "heartbeat" {
+		return common.Message{}, fmt.Errorf("unknown update type '%s', must be 'heartbeat'", item.Type)
+	}
+
+	now := time.Now()
+	return plugin.message(locale, now, now.Add(-plugin.interval()))
+}
+
+// message presents the version and uptime of the application in an embed, preceded by the configured message
+func (plugin *HeartbeatPlugin) message(locale common.Locale, now, lastSent time.Time) (common.Message, error) {
+	template := plugin.Message
+	if len(template) == 0 {
+		template = locale.Text("heartbeat.message")
+	}
+	text, err := common.RenderTemplate("message", template, common.TemplateData{
+		Type:        "heartbeat",
+		PublishedAt: now,
+	})
+	if err != nil {
+		return common.Message{}, err
+	}
+
+	version := plugin.Version
+	if len(version) == 0 {
+		version = "unknown"
+	}
+	embed := common.NewEmbed().
+		WithColor(heartbeatColor).
+		WithTimestamp(now).
+		AddField(locale.Text("heartbeat.version"), fmt.Sprintf("`%s` (%s)", version, runtime.Version()), true)
+	if !plugin.Started.IsZero() {
+		embed.AddField(locale.Text("heartbeat.uptime"), formatUptime(now.Sub(plugin.Started)), true)
+	}
+	if !lastSent.IsZero() {
+		embed.AddField(locale.Text("heartbeat.previous"), fmt.Sprintf("<t:%d:R>", lastSent.Unix()), true)
+	}
+
+	return common.Message{
+		Text:     text,
+		Username: locale.Text("heartbeat.username"),
+		Embed:    embed,
+		Type:     "heartbeat",
+	}, nil
+}
+
+// formatUptime shortens a duration to days, hours and minutes, e.g. `3d 4h 12m`, or seconds if it's shorter than a
+// minute, as is usual when running from a task scheduler
+func formatUptime(uptime time.Duration) string {
+	if uptime < time.Minute {
+		return uptime.Round(time.Second).String()
+	}
+
+	uptime = uptime.Truncate(time.Minute)
+	days := uptime / (24 * time.Hour)
+	hours := uptime % (24 * time.Hour) / time.Hour
+	minutes := uptime % time.Hour / time.Minute
+
+	var parts []string
+	if days > 0 {
+		parts = append(parts, fmt.Sprintf("%dd", days))
+	}
+	if days > 0 || hours > 0 {
+		parts = append(parts, fmt.Sprintf("%dh", hours))
+	}
+	parts = append(parts, fmt.Sprintf("%dm", minutes))
+
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,70 @@
+package plugins
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// OffsetMigration converts the offsets of all connectors using a plugin from its previous format to the next one.
+// Migrations without a plugin don't change any offsets.
+type OffsetMigration struct {
+	Plugin      string
+	Description string
+	Migrate     func(offset json.RawMessage) (json.RawMessage, error)
+}
+
+// OffsetMigrations lists all changes to offset formats in the order they were made. Offsets have the version n after
+// the first n migrations were applied to them, so migrations must only ever be appended.
+var OffsetMigrations = []OffsetMigration{
+	{Description: "Start tracking the version of offsets"},
+	{Plugin: "youtube", Description: "Remember announced YouTube livestreams and premieres to update them once they're over", Migrate: migrateYouTubeOffset},
+	{Plugin: "atom", Description: "Remember when handled Atom entries were last seen", Migrate: migrateHandledEntries},
+	{Plugin: "youtube", Description: "Remember when handled YouTube entries were last seen", Migrate: migrateYouTubeHandledEntries},
+}
+
+// migrateYouTubeOffset moves the handled entries into an object, next to which announced livestreams and premieres are
+// remembered. Events announced before the migration aren't updated.
+func migrateYouTubeOffset(offset json.RawMessage) (json.RawMessage, error) {
+	var entries map[string]bool
+	if err := json.Unmarshal(offset, &entries); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(map[string]interface{}{"handled": entries})
+}
+
+// migrateHandledEntries converts flags of handled entries to the time they were last seen, which is taken as the time
+// of the migration. Entries marked as unhandled are dropped, so they're still reported again.
+func migrateHandledEntries(offset json.RawMessage) (json.RawMessage, error) {
+	var flags map[string]bool
+	if err := json.Unmarshal(offset, &flags); err != nil {
+		return nil, err
+	}
+
+	entries := make(HandledEntries, len(flags))
+	now := time.Now()
+	for id, handled := range flags {
+		if handled {
+			entries.Seen(id, now)
+		}
+	}
+
+	return json.Marshal(entries)
+}
+
+// migrateYouTubeHandledEntries converts the handled entries of YouTube offsets like migrateHandledEntries, keeping the
+// announced livestreams and premieres
+func migrateYouTubeHandledEntries(offset json.RawMessage) (json.RawMessage, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(offset, &fields); err != nil {
+		return nil, err
+	}
+
+	handled, err := migrateHandledEntries(fields["handled"])
+	if err != nil {
+		return nil, err
+	}
+	fields["handled"] = handled
+
+	return json.Marshal(fields)
+}
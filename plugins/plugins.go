@@ -4,6 +4,10 @@ import (
 	"17thshard.com/sanderson-notifications/common"
 	"context"
 	"log"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
 )
 
 type Plugin interface {
@@ -17,8 +21,129 @@ type Plugin interface {
 }
 
 type PluginContext struct {
-	Discord *common.DiscordClient
+	Discord common.DiscordSender
 	Info    *log.Logger
 	Error   *log.Logger
+	// Log is the structured logger of the connector, which should be used to log details such as the IDs of items
+	Log     *slog.Logger
 	Context *context.Context
+	// HTTP is the client plugins should use for their requests, it retries requests failing due to transient errors.
+	// Requests marked with common.Conditional are answered with 304 Not Modified if nothing changed since the last
+	// successful check.
+	HTTP *http.Client
+	// HTTPConfig holds the settings HTTP was built from, for plugins whose libraries bring their own client
+	HTTPConfig common.HTTPConfig
+	// Locale provides the built-in texts of plugins in the configured language
+	Locale common.Locale
+	// OffsetRetention is how long plugins should remember handled entries that are no longer in their source
+	OffsetRetention time.Duration
+}
+
+// SourcePlugin is implemented by plugins that know the host they retrieve updates from, which allows limiting how many
+// connectors access the same host at once
+type SourcePlugin interface {
+	SourceHost() string
+}
+
+// WebSubPlugin is implemented by plugins whose sources can push updates through a WebSub hub instead of being polled
+type WebSubPlugin interface {
+	// WebSubTopic determines the topic to subscribe to and the hub managing it. An empty hub means that the source
+	// currently doesn't support WebSub.
+	WebSubTopic(context PluginContext) (topic string, hub string, err error)
+}
+
+// BackfillPlugin is implemented by plugins that can report past updates again, regardless of what was already posted
+type BackfillPlugin interface {
+	// BackfillOffset returns an offset from which the next check reports all updates published since the given time,
+	// or as many past updates as the source provides if the time is zero
+	BackfillOffset(since time.Time) (interface{}, error)
+}
+
+// SamplePlugin is implemented by plugins that can present a made-up update like a real one, e.g. to test how messages
+// look and whom they mention
+type SamplePlugin interface {
+	// SampleMessage returns a message presenting the item, filling in made-up values for the fields left empty
+	SampleMessage(locale common.Locale, item SampleItem) (common.Message, error)
+}
+
+// SampleItem is a made-up update presented by sample messages. Plugins ignore the fields that don't apply to them.
+type SampleItem struct {
+	// Type is the kind of update, e.g. `livestream` or `retweet`, or the most common one if empty
+	Type   string `yaml:"type"`
+	Title  string `yaml:"title"`
+	Link   string `yaml:"link"`
+	Author string `yaml:"author"`
+	// Text is the content of the update, e.g. the summary of a blog post or the text of a tweet
+	Text        string    `yaml:"text"`
+	PublishedAt time.Time `yaml:"publishedAt"`
+}
+
+// orDefault fills in the fields of the item that were left empty from the fallback
+func (item SampleItem) orDefault(fallback SampleItem) SampleItem {
+	if len(item.Type) == 0 {
+		item.Type = fallback.Type
+	}
+	if len(item.Title) == 0 {
+		item.Title = fallback.Title
+	}
+	if len(item.Link) == 0 {
+		item.Link = fallback.Link
+	}
+	if len(item.Author) == 0 {
+		item.Author = fallback.Author
+	}
+	if len(item.Text) == 0 {
+		item.Text = fallback.Text
+	}
+	if item.PublishedAt.IsZero() {
+		item.PublishedAt = fallback.PublishedAt
+	}
+
+	return item
+}
+
+// DiagnosePlugin is implemented by plugins that can verify their source and credentials without reporting any updates,
+// e.g. to check a setup before running it
+type DiagnosePlugin interface {
+	// Diagnose returns an error if the source can't be read as expected or the credentials are rejected
+	Diagnose(context PluginContext) error
+}
+
+// DescribePlugin is implemented by plugins that can present their offsets to humans, e.g. to inspect the state of a
+// connector without reading raw JSON
+type DescribePlugin interface {
+	// DescribeOffset summarizes the offset in a few lines
+	DescribeOffset(offset interface{}) []string
+}
+
+// DiffPlugin is implemented by plugins that can summarize how their offset changed, e.g. to show what a dry run would
+// have stored
+type DiffPlugin interface {
+	// DiffOffsets lists the changes from the old to the new offset, one per line
+	DiffOffsets(old, updated interface{}) []string
+}
+
+// SetupPlugin is implemented by plugins that know which options must be given to set up a connector, e.g. to ask for
+// them when creating a config interactively
+type SetupPlugin interface {
+	SetupFields() []SetupField
+}
+
+// SetupField is an option needed to set up a connector
+type SetupField struct {
+	// Key is the name of the option in the plugin config
+	Key string
+	// Prompt describes the option to the user
+	Prompt string
+	// Default is suggested if not empty
+	Default string
+}
+
+func hostOf(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+
+	return parsed.Hostname()
 }
@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type ProgressPlugin struct {
@@ -19,6 +20,13 @@ func (plugin ProgressPlugin) Name() string {
 	return "progress"
 }
 
+func (plugin ProgressPlugin) SetupFields() []SetupField {
+	return []SetupField{
+		{Key: "url", Prompt: "URL of the page with progress bars", Default: "https://brandonsanderson.com"},
+		{Key: "message", Prompt: "Message posted with the progress bars", Default: "The progress bars were updated!"},
+	}
+}
+
 func (plugin ProgressPlugin) Validate() error {
 	if len(plugin.Url) == 0 {
 		return fmt.Errorf("URL for progress updates must not be empty")
@@ -28,13 +36,77 @@ func (plugin ProgressPlugin) Validate() error {
 		return fmt.Errorf("message for progress updates must not be empty")
 	}
 
+	if _, err := common.ParseTemplate("message", plugin.Message); err != nil {
+		return err
+	}
+
 	return nil
 }
 
+func (plugin ProgressPlugin) SourceHost() string {
+	return hostOf(plugin.Url)
+}
+
 func (plugin ProgressPlugin) OffsetPrototype() interface{} {
 	return []Progress{}
 }
 
+// BackfillOffset reports the current state of all progress bars, as the site doesn't provide any past updates
+func (plugin ProgressPlugin) BackfillOffset(_ time.Time) (interface{}, error) {
+	return nil, nil
+}
+
+// DescribeOffset lists the progress bars with their values as last seen
+func (plugin ProgressPlugin) DescribeOffset(offset interface{}) []string {
+	progress, _ := offset.([]Progress)
+	if len(progress) == 0 {
+		return []string{"No progress bars"}
+	}
+
+	lines := make([]string, 0, len(progress))
+	for _, bar := range progress {
+		line := fmt.Sprintf("%s: %d%%", bar.Title, bar.Value)
+		if len(bar.Link) > 0 {
+			line = fmt.Sprintf("%s (%s)", line, bar.Link)
+		}
+		lines = append(lines, line)
+	}
+
+	return lines
+}
+
+// DiffOffsets lists the progress bars that were added, removed or changed their value, identified by their titles
+func (plugin ProgressPlugin) DiffOffsets(old, updated interface{}) []string {
+	oldProgress, _ := old.([]Progress)
+	newProgress, _ := updated.([]Progress)
+
+	previous := make(map[string]Progress, len(oldProgress))
+	for _, bar := range oldProgress {
+		previous[bar.Title] = bar
+	}
+
+	var changes []string
+	for _, bar := range newProgress {
+		oldBar, ok := previous[bar.Title]
+		delete(previous, bar.Title)
+		switch {
+		case !ok:
+			changes = append(changes, fmt.Sprintf("+ %s: %d%%", bar.Title, bar.Value))
+		case oldBar.Value != bar.Value:
+			changes = append(changes, fmt.Sprintf("~ %s: %d%% -> %d%%", bar.Title, oldBar.Value, bar.Value))
+		case oldBar.Link != bar.Link:
+			changes = append(changes, fmt.Sprintf("~ %s: link %s -> %s", bar.Title, oldBar.Link, bar.Link))
+		}
+	}
+	for _, bar := range oldProgress {
+		if _, ok := previous[bar.Title]; ok {
+			changes = append(changes, fmt.Sprintf("- %s: %d%%", bar.Title, bar.Value))
+		}
+	}
+
+	return changes
+}
+
 type Progress struct {
 	Title string
 	Link  string
@@ -57,12 +129,26 @@ const (
 func (plugin ProgressPlugin) Check(offset interface{}, context PluginContext) (interface{}, error) {
 	context.Info.Println("Checking for progress updates...")
 
-	res, err := http.Get(plugin.Url)
+	req, err := http.NewRequestWithContext(*context.Context, http.MethodGet, plugin.Url, nil)
+	if err != nil {
+		return offset, err
+	}
+
+	res, err := context.HTTP.Do(common.Conditional(req))
 	if err != nil {
 		return offset, fmt.Errorf("could not read progress site '%s': %w", plugin.Url, err)
 	}
 	defer res.Body.Close()
 
+	if res.StatusCode == http.StatusNotModified {
+		context.Info.Println("Progress site was not modified.")
+		return offset, nil
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return offset, common.HTTPStatusError{URL: plugin.Url, StatusCode: res.StatusCode}
+	}
+
 	doc, err := goquery.NewDocumentFromReader(res.Body)
 	if err != nil {
 		return offset, err
@@ -79,6 +165,14 @@ func (plugin ProgressPlugin) Check(offset interface{}, context PluginContext) (i
 	}
 
 	differences := diff(oldProgress, currentProgress)
+	context.Log.Debug("Parsed progress bars", "bars", len(currentProgress), "url", plugin.Url)
+	for _, bar := range differences {
+		if bar.New {
+			context.Log.Debug("Found new progress bar", "title", bar.Title, "value", bar.Value)
+		} else if bar.OldValue != bar.Value {
+			context.Log.Debug("Progress bar changed", "title", bar.Title, "from", bar.OldValue, "to", bar.Value)
+		}
+	}
 
 	if differences == nil {
 		context.Info.Println("No progress changes to report.")
@@ -87,13 +181,46 @@ func (plugin ProgressPlugin) Check(offset interface{}, context PluginContext) (i
 
 	context.Info.Println("Reporting changed progress bars...")
 
-	if err = plugin.reportProgress(context.Discord, differences); err != nil {
+	message, err := plugin.message(context.Locale, differences)
+	if err != nil {
+		return oldProgress, err
+	}
+	if err = context.Discord.SendMessage(message); err != nil {
 		return oldProgress, err
 	}
 
 	return currentProgress, nil
 }
 
+// Diagnose checks that the site can be read and that its progress bars are found
+func (plugin ProgressPlugin) Diagnose(context PluginContext) error {
+	req, err := http.NewRequestWithContext(*context.Context, http.MethodGet, plugin.Url, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := context.HTTP.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not read progress site '%s': %w", plugin.Url, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return common.HTTPStatusError{URL: plugin.Url, StatusCode: res.StatusCode}
+	}
+
+	doc, err := goquery.NewDocumentFromReader(res.Body)
+	if err != nil {
+		return err
+	}
+
+	if doc.Find("[class^=progress-item-template]").Length() == 0 {
+		return fmt.Errorf("progress site '%s' does not contain any progress bars", plugin.Url)
+	}
+
+	return nil
+}
+
 func readProgress(doc *goquery.Document) ([]Progress, error) {
 	bars := doc.Find("[class^=progress-item-template]")
 	result := make([]Progress, bars.Length())
@@ -153,7 +280,21 @@ func diff(old, new []Progress) []ProgressDiff {
 	return result
 }
 
-func (plugin ProgressPlugin) reportProgress(client *common.DiscordClient, progressBars []ProgressDiff) error {
+// SampleMessage presents made-up changes of progress bars like real ones, the first of which is titled like the item
+func (plugin ProgressPlugin) SampleMessage(locale common.Locale, item SampleItem) (common.Message, error) {
+	if len(item.Type) > 0 && item.Type != "progress" {
+		return common.Message{}, fmt.Errorf("unknown update type '%s', must be 'progress'", item.Type)
+	}
+
+	item = item.orDefault(SampleItem{Title: "Sample book", Link: plugin.Url})
+	return plugin.message(locale, []ProgressDiff{
+		{Title: item.Title, Link: item.Link, OldValue: 40, Value: 65},
+		{Title: "Sample novella", Value: 10, New: true},
+	})
+}
+
+// message presents the changed progress bars in an embed, preceded by the configured message
+func (plugin ProgressPlugin) message(locale common.Locale, progressBars []ProgressDiff) (common.Message, error) {
 	var embedBuilder strings.Builder
 
 	for i, progress := range progressBars {
@@ -166,9 +307,9 @@ func (plugin ProgressPlugin) reportProgress(client *common.DiscordClient, progre
 			title = fmt.Sprintf("[%s](%s)", progress.Title, progress.Link)
 		}
 		if progress.New {
-			title = fmt.Sprintf("[New] %s", title)
+			title = fmt.Sprintf("%s %s", locale.Text("progress.new"), title)
 		} else if progress.Value != progress.OldValue {
-			title = fmt.Sprintf("[Changed] %s (%d%% → %d%%)", title, progress.OldValue, progress.Value)
+			title = fmt.Sprintf("%s %s (%d%% → %d%%)", locale.Text("progress.changed"), title, progress.OldValue, progress.Value)
 		}
 		embedBuilder.WriteString(fmt.Sprintf("**%s**\n", title))
 
@@ -180,17 +321,24 @@ func (plugin ProgressPlugin) reportProgress(client *common.DiscordClient, progre
 		embedBuilder.WriteRune('`')
 	}
 
-	embed := map[string]interface{}{
-		"description": embedBuilder.String(),
-		"footer": map[string]interface{}{
-			"text": fmt.Sprintf("See %s for more", plugin.Url),
-		},
+	embed := common.NewEmbed().
+		WithDescription(embedBuilder.String()).
+		WithFooter(fmt.Sprintf(locale.Text("progress.footer"), plugin.Url), "")
+
+	text, err := common.RenderTemplate("message", plugin.Message, common.TemplateData{
+		Type:        "progress",
+		Link:        plugin.Url,
+		PublishedAt: time.Now(),
+	})
+	if err != nil {
+		return common.Message{}, err
 	}
 
-	return client.Send(
-		plugin.Message,
-		"Progress Updates",
-		"dragonsteel",
-		embed,
-	)
+	return common.Message{
+		Text:      text,
+		Username:  locale.Text("progress.username"),
+		AvatarURL: common.AvatarURL("dragonsteel"),
+		Embed:     embed,
+		Type:      "progress",
+	}, nil
 }
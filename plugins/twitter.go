@@ -1,6 +1,7 @@
 package plugins
 
 import (
+	"17thshard.com/sanderson-notifications/common"
 	goContext "context"
 	"encoding/json"
 	"fmt"
@@ -8,6 +9,8 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type TwitterPlugin struct {
@@ -18,9 +21,11 @@ type TwitterPlugin struct {
 	ExcludedRetweetAccounts []string `mapstructure:"excludeRetweetsOf"`
 	EmbedURL                string   `mapstructure:"embedUrl"`
 
-	LoginUser       string `mapstructure:"loginUser"`
-	LoginPassword   string `mapstructure:"loginPassword"`
-	LoginCookiePath string `mapstructure:"cookiePath"`
+	LoginUser     string `mapstructure:"loginUser"`
+	LoginPassword string `mapstructure:"loginPassword"`
+	// LoginPasswordFile names a file to read the password from instead, e.g. a Docker or Kubernetes secret
+	LoginPasswordFile string `mapstructure:"loginPasswordFile"`
+	LoginCookiePath   string `mapstructure:"cookiePath"`
 
 	retweetExclusions map[string]bool
 	scraper           *twitterscraper.Scraper
@@ -30,23 +35,78 @@ func (plugin *TwitterPlugin) Name() string {
 	return "twitter"
 }
 
+func (plugin *TwitterPlugin) SetupFields() []SetupField {
+	return []SetupField{{Key: "account", Prompt: "Name of the Twitter account, without @"}}
+}
+
 func (plugin *TwitterPlugin) Validate() error {
 	if len(plugin.Account) == 0 {
 		return fmt.Errorf("account name for Twitter must not be empty")
 	}
 
+	password, err := common.ResolveSecret("login password for Twitter", plugin.LoginPassword, "", plugin.LoginPasswordFile)
+	if err != nil {
+		return err
+	}
+	plugin.LoginPassword = password
+
 	plugin.retweetExclusions = make(map[string]bool)
 	for _, account := range plugin.ExcludedRetweetAccounts {
 		plugin.retweetExclusions[account] = true
 	}
 
+	if _, err := common.ParseTemplate("tweetMessage", plugin.TweetMessage); err != nil {
+		return err
+	}
+	if _, err := common.ParseTemplate("retweetMessage", plugin.RetweetMessage); err != nil {
+		return err
+	}
+
 	return nil
 }
 
+func (plugin *TwitterPlugin) SourceHost() string {
+	return "x.com"
+}
+
 func (plugin *TwitterPlugin) OffsetPrototype() interface{} {
 	return ""
 }
 
+// twitterEpoch is the time from which Tweet IDs count milliseconds
+const twitterEpoch = 1288834974657
+
+func (plugin *TwitterPlugin) BackfillOffset(since time.Time) (interface{}, error) {
+	if since.IsZero() {
+		return "0", nil
+	}
+
+	// Tweet IDs are snowflakes starting with a timestamp, so all tweets posted since the given time have greater IDs
+	// than the one derived from it
+	millis := since.UnixMilli() - twitterEpoch
+	if millis <= 0 {
+		return "0", nil
+	}
+
+	return strconv.FormatUint(uint64(millis)<<22-1, 10), nil
+}
+
+// DescribeOffset shows the ID of the last Tweet along with when it was posted, as derived from the ID
+func (plugin *TwitterPlugin) DescribeOffset(offset interface{}) []string {
+	lastTweet := offset.(string)
+	if len(lastTweet) == 0 {
+		return []string{"No last Tweet"}
+	}
+
+	id, err := strconv.ParseUint(lastTweet, 10, 64)
+	if err != nil || id == 0 {
+		return []string{fmt.Sprintf("Last Tweet: %s", lastTweet)}
+	}
+
+	posted := time.UnixMilli(int64(id>>22) + twitterEpoch)
+	return []string{fmt.Sprintf("Last Tweet: %s (posted %s)", lastTweet, posted.Local().Format(time.RFC3339))}
+}
+
 type Tweet struct {
 	Id              uint64
 	User            TweetUser
@@ -76,7 +136,10 @@ func (plugin *TwitterPlugin) Check(offset interface{}, context PluginContext) (i
 		return nil, fmt.Errorf("latest Tweet ID '%s' is not valid snowflake: %w", lastTweet, err)
 	}
 
-	plugin.scraper = twitterscraper.New().WithReplies(true)
+	plugin.scraper, err = newScraper(*context.Context, context.HTTPConfig)
+	if err != nil {
+		return lastTweet, err
+	}
 
 	err = plugin.login(context)
 	if err != nil {
@@ -87,10 +150,11 @@ func (plugin *TwitterPlugin) Check(offset interface{}, context PluginContext) (i
 		return lastTweet, fmt.Errorf("was not logged into Twitter, maybe try other credentials")
 	}
 
-	tweets, err := plugin.retrieveTweetsSince(sortableLastTweet)
+	tweets, err := plugin.retrieveTweetsSince(*context.Context, sortableLastTweet)
 	if err != nil {
 		return lastTweet, err
 	}
+	context.Log.Debug("Retrieved tweets newer than offset", "tweets", len(tweets), "account", plugin.Account, "offset", lastTweet)
 
 	err = plugin.saveLoginState()
 	if err != nil {
@@ -104,9 +168,9 @@ func (plugin *TwitterPlugin) Check(offset interface{}, context PluginContext) (i
 
 	context.Info.Printf("Reporting %d tweets...\n", len(tweets))
 
-	if len(plugin.Nickname) == 0 && (len(plugin.TweetMessage) == 0 || len(plugin.RetweetMessage) == 0) {
-		profile, err := plugin.scraper.GetProfile(plugin.Account)
-
+	if len(plugin.Nickname) == 0 && (len(plugin.TweetMessage) == 0 || len(plugin.RetweetMessage) == 0 ||
+		strings.Contains(plugin.TweetMessage+plugin.RetweetMessage, ".Nickname")) {
+		profile, err := plugin.profile(*context.Context)
 		if err != nil {
 			return lastTweet, err
 		}
@@ -120,14 +184,18 @@ func (plugin *TwitterPlugin) Check(offset interface{}, context PluginContext) (i
 	}
 
 	for i := len(tweets) - 1; i >= 0; i-- {
+		if err = (*context.Context).Err(); err != nil {
+			return lastTweet, err
+		}
+
 		tweet := tweets[i]
 		if tweet.RetweetedStatus != nil {
 			if exclude, present := plugin.retweetExclusions[tweet.RetweetedStatus.Username]; present && exclude {
-				context.Info.Printf(
-					"Ignoring retweet %s from '%s', as the original tweet is from '%s'",
-					tweet.ID,
-					tweet.Username,
-					tweet.RetweetedStatus.Username,
+				context.Log.Info(
+					"Ignoring retweet, as the original tweet is from an excluded account",
+					"item", tweet.ID,
+					"account", tweet.Username,
+					"original", tweet.RetweetedStatus.Username,
 				)
 				lastTweet = tweet.ID
 				continue
@@ -135,57 +203,150 @@ func (plugin *TwitterPlugin) Check(offset interface{}, context PluginContext) (i
 		}
 
 		if tweet.IsReply && (tweet.InReplyToStatus == nil || tweet.InReplyToStatus.Username != plugin.Account) {
-			context.Info.Printf(
-				"Ignoring reply tweet %s from '%s', as it is not in response to themself",
-				tweet.ID,
-				tweet.Username,
-			)
+			context.Log.Info("Ignoring reply tweet, as it is not in response to themself", "item", tweet.ID, "account", tweet.Username)
 			lastTweet = tweet.ID
 			continue
 		}
 
-		messageTweet := tweet
-		message := fmt.Sprintf("%s tweeted", plugin.Nickname)
-		if len(plugin.TweetMessage) > 0 {
-			message = plugin.TweetMessage
-		}
-		if tweet.RetweetedStatus != nil {
-			messageTweet = *tweet.RetweetedStatus
-			message = fmt.Sprintf("%s retweeted", plugin.Nickname)
-			if len(plugin.RetweetMessage) > 0 {
-				message = plugin.RetweetMessage
-			}
+		message, err := plugin.message(tweet, context.Locale)
+		if err != nil {
+			return lastTweet, err
 		}
 
-		baseUrl := "https://fxtwitter.com"
-		if len(plugin.EmbedURL) != 0 {
-			baseUrl = plugin.EmbedURL
+		if err = context.Discord.SendMessage(message); err != nil {
+			return lastTweet, err
 		}
 
-		text := fmt.Sprintf("%s\n%s/%s/status/%s", message, baseUrl, messageTweet.Username, messageTweet.ID)
-		if tweet.RetweetedStatus != nil {
-			text = fmt.Sprintf(
-				"%s (<%s/%s/status/%s>)",
-				text,
-				baseUrl,
-				tweet.Username,
-				tweet.ID,
-			)
+		lastTweet = tweet.ID
+		context.Log.Info("Reported tweet", "item", tweet.ID, "account", tweet.Username)
+	}
+
+	return lastTweet, nil
+}
+
+// message presents the tweet with a link to it, preceded by the configured message for tweets or retweets
+func (plugin *TwitterPlugin) message(tweet twitterscraper.Tweet, locale common.Locale) (common.Message, error) {
+	messageTweet := tweet
+	messageType, template := "tweet", locale.Text("twitter.tweet")
+	if len(plugin.TweetMessage) > 0 {
+		template = plugin.TweetMessage
+	}
+	if tweet.RetweetedStatus != nil {
+		messageTweet = *tweet.RetweetedStatus
+		messageType, template = "retweet", locale.Text("twitter.retweet")
+		if len(plugin.RetweetMessage) > 0 {
+			template = plugin.RetweetMessage
 		}
+	}
 
-		if err = context.Discord.Send(
+	baseUrl := "https://fxtwitter.com"
+	if len(plugin.EmbedURL) != 0 {
+		baseUrl = plugin.EmbedURL
+	}
+	link := fmt.Sprintf("%s/%s/status/%s", baseUrl, messageTweet.Username, messageTweet.ID)
+
+	author := messageTweet.Name
+	if len(author) == 0 {
+		author = messageTweet.Username
+	}
+	message, err := common.RenderTemplate(messageType+"Message", template, common.TemplateData{
+		Nickname:    plugin.Nickname,
+		Type:        messageType,
+		Title:       messageTweet.Text,
+		Link:        link,
+		Author:      author,
+		PublishedAt: messageTweet.TimeParsed,
+	})
+	if err != nil {
+		return common.Message{}, err
+	}
+
+	text := fmt.Sprintf("%s\n%s", message, link)
+	if tweet.RetweetedStatus != nil {
+		text = fmt.Sprintf(
+			"%s (<%s/%s/status/%s>)",
 			text,
-			"Twitter",
-			"twitter",
-			nil,
-		); err != nil {
-			return lastTweet, err
-		}
+			baseUrl,
+			tweet.Username,
+			tweet.ID,
+		)
+	}
 
-		lastTweet = tweet.ID
+	return common.Message{
+		Text:      text,
+		Username:  "Twitter",
+		AvatarURL: common.AvatarURL("twitter"),
+		Item:      tweet.ID,
+		Type:      messageType,
+	}, nil
+}
+
+// SampleMessage presents a made-up tweet or retweet like a real one
+func (plugin *TwitterPlugin) SampleMessage(locale common.Locale, item SampleItem) (common.Message, error) {
+	if len(plugin.Nickname) == 0 {
+		plugin.Nickname = plugin.Account
 	}
 
-	return lastTweet, nil
+	item = item.orDefault(SampleItem{Text: "A sample tweet", PublishedAt: time.Now()})
+	tweet := twitterscraper.Tweet{ID: "20", Username: plugin.Account, Text: item.Text, TimeParsed: item.PublishedAt}
+	switch item.Type {
+	case "", "tweet":
+		tweet.Name = item.Author
+	case "retweet":
+		item = item.orDefault(SampleItem{Author: "jack"})
+		original := twitterscraper.Tweet{ID: "20", Username: item.Author, Text: item.Text, TimeParsed: item.PublishedAt}
+		tweet.RetweetedStatus = &original
+	default:
+		return common.Message{}, fmt.Errorf("unknown tweet type '%s', must be 'tweet' or 'retweet'", item.Type)
+	}
+
+	return plugin.message(tweet, locale)
+}
+
+// Diagnose checks that logging in works and that the account exists
+func (plugin *TwitterPlugin) Diagnose(context PluginContext) error {
+	scraper, err := newScraper(*context.Context, context.HTTPConfig)
+	if err != nil {
+		return err
+	}
+	plugin.scraper = scraper
+
+	if err := plugin.login(context); err != nil {
+		return fmt.Errorf("could not log into Twitter: %w", err)
+	}
+	if !plugin.scraper.IsLoggedIn() {
+		return fmt.Errorf("was not logged into Twitter, maybe try other credentials")
+	}
+
+	if _, err := plugin.profile(*context.Context); err != nil {
+		return fmt.Errorf("could not find Twitter account '%s': %w", plugin.Account, err)
+	}
+
+	return plugin.saveLoginState()
+}
+
+// newScraper creates a scraper using the proxy, timeout and user agent configured for the connector's requests, as it
+// can't use the connector's client. Its requests are limited to the deadline of the context, as not all of them take
+// one.
+func newScraper(ctx goContext.Context, config common.HTTPConfig) (*twitterscraper.Scraper, error) {
+	scraper := twitterscraper.New().WithReplies(true)
+	timeout := config.Timeout
+	if deadline, ok := ctx.Deadline(); ok && (timeout <= 0 || time.Until(deadline) < timeout) {
+		timeout = time.Until(deadline)
+	}
+	if timeout > 0 {
+		scraper.WithClientTimeout(timeout)
+	}
+	if len(config.Proxy) > 0 {
+		if err := scraper.SetProxy(config.Proxy); err != nil {
+			return nil, fmt.Errorf("could not use proxy '%s' for Twitter: %w", config.Proxy, err)
+		}
+	}
+	if len(config.UserAgent) > 0 {
+		scraper.SetUserAgent(config.UserAgent)
+	}
+
+	return scraper, nil
 }
 
 func (plugin *TwitterPlugin) login(context PluginContext) error {
@@ -211,18 +372,47 @@ func (plugin *TwitterPlugin) login(context PluginContext) error {
 		return nil
 	}
 
-	var err error
-	if len(plugin.LoginUser) > 0 {
-		err = plugin.scraper.Login(plugin.LoginUser, plugin.LoginPassword)
-	} else {
-		_, err = plugin.scraper.LoginOpenAccount()
-	}
+	scraper := plugin.scraper
+	return interruptible(*context.Context, func() error {
+		if len(plugin.LoginUser) > 0 {
+			return scraper.Login(plugin.LoginUser, plugin.LoginPassword)
+		}
 
-	if err != nil {
+		_, err := scraper.LoginOpenAccount()
+		return err
+	})
+}
+
+// profile retrieves the profile of the account, giving up once the context is done
+func (plugin *TwitterPlugin) profile(ctx goContext.Context) (twitterscraper.Profile, error) {
+	profiles := make(chan twitterscraper.Profile, 1)
+	scraper := plugin.scraper
+	err := interruptible(ctx, func() error {
+		profile, err := scraper.GetProfile(plugin.Account)
+		profiles <- profile
 		return err
+	})
+	if err != nil {
+		return twitterscraper.Profile{}, err
 	}
 
-	return nil
+	return <-profiles, nil
+}
+
+// interruptible runs a call of the scraper that takes no context, returning once the context is done without waiting
+// for the call, which ends at the latest when the scraper's client times out
+func interruptible(ctx goContext.Context, call func() error) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- call()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 func (plugin *TwitterPlugin) saveLoginState() error {
@@ -249,10 +439,10 @@ func (plugin *TwitterPlugin) saveLoginState() error {
 	return nil
 }
 
-func (plugin *TwitterPlugin) retrieveTweetsSince(lastTweet uint64) ([]twitterscraper.Tweet, error) {
+func (plugin *TwitterPlugin) retrieveTweetsSince(ctx goContext.Context, lastTweet uint64) ([]twitterscraper.Tweet, error) {
 	var result []twitterscraper.Tweet
 
-	for tweet := range plugin.scraper.GetTweets(goContext.Background(), plugin.Account, 3200) {
+	for tweet := range plugin.scraper.GetTweets(ctx, plugin.Account, 3200) {
 		if tweet.Error != nil {
 			return nil, fmt.Errorf("could not read tweets: %w", tweet.Error)
 		}
@@ -1,104 +1,241 @@
 package plugins
 
 import (
+	"17thshard.com/sanderson-notifications/common"
+	goContext "context"
 	"fmt"
 	"github.com/mmcdole/gofeed/atom"
 	"google.golang.org/api/option"
 	"google.golang.org/api/youtube/v3"
 	"net/http"
 	"net/url"
+	"sort"
+	"strings"
 	"time"
 )
 
 type YouTubePlugin struct {
-	ChannelId         string `mapstructure:"channelId"`
-	Nickname          string
-	Messages          map[string]string
-	Token             string
+	ChannelId string `mapstructure:"channelId"`
+	Nickname  string
+	Messages  map[string]string
+	Token     string
+	// TokenFile names a file to read the token from instead, e.g. a Docker or Kubernetes secret
+	TokenFile         string   `mapstructure:"tokenFile"`
 	ExcludedPostTypes []string `mapstructure:"excludedPostTypes"`
+	// RichEmbed posts an embed with the title and thumbnail of a video instead of relying on the link preview
+	RichEmbed bool `mapstructure:"richEmbed"`
+	// Button is the label of a button linking to the video, e.g. "Watch on YouTube"
+	Button string
 
 	excludedTypes map[string]bool
 	client        *http.Client
+	// since excludes older posts while backfilling
+	since time.Time
 }
 
 func (plugin *YouTubePlugin) Name() string {
 	return "youtube"
 }
 
+func (plugin *YouTubePlugin) SetupFields() []SetupField {
+	return []SetupField{
+		{Key: "channelId", Prompt: "ID of the YouTube channel, e.g. UC3g-w83Cb5pEAu5UmRrge-A"},
+		{Key: "token", Prompt: "YouTube API token"},
+	}
+}
+
 func (plugin *YouTubePlugin) Validate() error {
 	if len(plugin.ChannelId) == 0 {
 		return fmt.Errorf("channel ID for YouTube must not be empty")
 	}
 
-	if len(plugin.Token) == 0 {
+	token, err := common.ResolveSecret("API token for YouTube", plugin.Token, "", plugin.TokenFile)
+	if err != nil {
+		return err
+	}
+	if len(token) == 0 {
 		return fmt.Errorf("API token for YouTube must not be empty")
 	}
+	plugin.Token = token
 
 	plugin.excludedTypes = make(map[string]bool)
 	for _, postType := range plugin.ExcludedPostTypes {
 		plugin.excludedTypes[postType] = true
 	}
 
+	for postType, message := range plugin.Messages {
+		if _, err := common.ParseTemplate(postType, message); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+func (plugin *YouTubePlugin) SourceHost() string {
+	return "youtube.com"
+}
+
 func (plugin *YouTubePlugin) OffsetPrototype() interface{} {
-	return map[string]bool{}
+	return YouTubeOffset{}
+}
+
+func (plugin *YouTubePlugin) DescribeOffset(offset interface{}) []string {
+	youTubeOffset := offset.(YouTubeOffset)
+	description := youTubeOffset.Handled.Describe()
+	if len(youTubeOffset.Upcoming) > 0 {
+		description = append(description, fmt.Sprintf("%d announced livestreams and premieres to update once they're over", len(youTubeOffset.Upcoming)))
+	}
+
+	return description
+}
+
+func (plugin *YouTubePlugin) DiffOffsets(old, updated interface{}) []string {
+	oldOffset, updatedOffset := old.(YouTubeOffset), updated.(YouTubeOffset)
+	changes := oldOffset.Handled.Diff(updatedOffset.Handled)
+
+	var events []string
+	for id := range updatedOffset.Upcoming {
+		if _, ok := oldOffset.Upcoming[id]; !ok {
+			events = append(events, fmt.Sprintf("+ upcoming %s", id))
+		}
+	}
+	for id := range oldOffset.Upcoming {
+		if _, ok := updatedOffset.Upcoming[id]; !ok {
+			events = append(events, fmt.Sprintf("- upcoming %s", id))
+		}
+	}
+	sort.Strings(events)
+
+	return append(changes, events...)
+}
+
+func (plugin *YouTubePlugin) BackfillOffset(since time.Time) (interface{}, error) {
+	plugin.since = since
+
+	return YouTubeOffset{}, nil
+}
+
+// YouTubeOffset holds the handled entries of the feed along with the livestreams and premieres that were announced
+// before they started, whose announcements are updated once they're over
+type YouTubeOffset struct {
+	Handled  HandledEntries           `json:"handled"`
+	Upcoming map[string]UpcomingEvent `json:"upcoming,omitempty"`
+}
+
+// UpcomingEvent is an announced livestream or premiere with everything needed to present it as a video later on
+type UpcomingEvent struct {
+	Title       string     `json:"title"`
+	Link        string     `json:"link"`
+	VideoID     string     `json:"videoId"`
+	PublishedAt *time.Time `json:"publishedAt,omitempty"`
+	Channel     string     `json:"channel"`
+	StartsAt    time.Time  `json:"startsAt"`
+}
+
+func (event UpcomingEvent) post(id string) YouTubePost {
+	return YouTubePost{ID: id, Title: event.Title, Link: event.Link, VideoID: event.VideoID, Timestamp: event.PublishedAt}
 }
 
 type YouTubePost struct {
-	ID      string
-	Title   string
-	Link    string
-	VideoID string
+	ID        string
+	Title     string
+	Link      string
+	VideoID   string
+	Timestamp *time.Time
 }
 
+// youTubeRed is the color of embeds for YouTube posts
+const youTubeRed = 0xFF0000
+
 func (plugin *YouTubePlugin) Check(offset interface{}, context PluginContext) (interface{}, error) {
 	context.Info.Println("Checking for YouTube updates...")
 
+	state := YouTubeOffset{}
+	if offset != nil {
+		state = offset.(YouTubeOffset)
+	}
+	if state.Handled == nil {
+		state.Handled = make(HandledEntries)
+	}
+	if state.Upcoming == nil {
+		state.Upcoming = make(map[string]UpcomingEvent)
+	}
+
+	youtubeService, err := youtube.NewService(*context.Context, option.WithAPIKey(plugin.Token))
+	if err != nil {
+		return offset, fmt.Errorf("could not create YouTube client: %w", err)
+	}
+
 	plugin.client = &http.Client{
+		Transport: context.HTTP.Transport,
+		Timeout:   context.HTTP.Timeout,
 		CheckRedirect: func(req *http.Request, via []*http.Request) error {
 			return http.ErrUseLastResponse
 		},
 	}
 
-	res, err := plugin.client.Get(fmt.Sprintf("https://www.youtube.com/feeds/videos.xml?channel_id=%s", url.QueryEscape(plugin.ChannelId)))
+	// Events are checked regardless of the feed, which doesn't change once they're over
+	if err = plugin.endEvents(context, state, youtubeService); err != nil {
+		return state, err
+	}
+
+	req, err := http.NewRequestWithContext(
+		*context.Context,
+		http.MethodGet,
+		fmt.Sprintf("https://www.youtube.com/feeds/videos.xml?channel_id=%s", url.QueryEscape(plugin.ChannelId)),
+		nil,
+	)
+	if err != nil {
+		return state, err
+	}
+
+	res, err := plugin.client.Do(common.Conditional(req))
 	if err != nil {
-		return offset, fmt.Errorf("could not read YouTube feed for channel '%s': %w", plugin.ChannelId, err)
+		return state, fmt.Errorf("could not read YouTube feed for channel '%s': %w", plugin.ChannelId, err)
 	}
 	defer res.Body.Close()
 
+	if res.StatusCode == http.StatusNotModified {
+		context.Info.Printf("YouTube feed for channel '%s' was not modified.", plugin.ChannelId)
+		return state, nil
+	}
+
 	if res.StatusCode == 404 {
 		logLevel := context.Info
 		if offset == nil {
 			logLevel = context.Error
 		}
 		logLevel.Printf("Could not find feed for channel ID '%s'. YouTube API might be down.", plugin.ChannelId)
-		return offset, nil
+		return state, nil
 	}
 
 	fp := atom.Parser{}
 	atomFeed, err := fp.Parse(res.Body)
 	if err != nil {
-		return offset, err
+		return state, err
 	}
 
+	context.Log.Debug("Parsed YouTube feed", "entries", len(atomFeed.Entries), "channel", plugin.ChannelId)
 	if len(atomFeed.Entries) == 0 {
 		context.Info.Println("No entries in YouTube feed.")
-		return offset, nil
+		return state, nil
 	}
 
-	youtubeService, err := youtube.NewService(*context.Context, option.WithAPIKey(plugin.Token))
-
-	handledEntries := make(map[string]bool)
-	if offset != nil {
-		handledEntries = offset.(map[string]bool)
-	}
+	now := time.Now()
+	handledEntries := state.Handled
 
 	var sortedEntries []YouTubePost
 
 	for _, entry := range atomFeed.Entries {
-		if handled, present := handledEntries[entry.ID]; present && handled {
+		if handledEntries.Handled(entry.ID) {
+			handledEntries.Seen(entry.ID, now)
+			context.Log.Debug("Skipping YouTube post as it was already handled", "item", entry.ID)
+			continue
+		}
+
+		if entry.PublishedParsed != nil && entry.PublishedParsed.Before(plugin.since) {
+			context.Log.Debug("Skipping YouTube post as it was published before the backfill", "item", entry.ID, "title", entry.Title)
 			continue
 		}
 
@@ -108,100 +245,294 @@ func (plugin *YouTubePlugin) Check(offset interface{}, context PluginContext) (i
 		}
 
 		sortedEntries = append([]YouTubePost{{
-			ID:      entry.ID,
-			Title:   entry.Title,
-			Link:    entry.Links[0].Href,
-			VideoID: videoId,
+			ID:        entry.ID,
+			Title:     entry.Title,
+			Link:      entry.Links[0].Href,
+			VideoID:   videoId,
+			Timestamp: entry.PublishedParsed,
 		}}, sortedEntries...)
 	}
 
+	handledEntries.Prune(now, context.OffsetRetention)
+
 	if len(sortedEntries) == 0 {
 		context.Info.Println("No YouTube posts to report.")
-		return offset, nil
+		return state, nil
 	}
 
 	context.Info.Println("Reporting YouTube posts...")
 
-	if len(plugin.Nickname) == 0 && len(plugin.Messages) == 0 {
+	if len(plugin.Nickname) == 0 {
 		plugin.Nickname = atomFeed.Title
 		context.Info.Printf(
-			"No nickname or specific messages were provided for channel '%s', using feed title '%s' as fallback nickname",
+			"No nickname was provided for channel '%s', using feed title '%s' as fallback nickname",
 			plugin.ChannelId,
 			plugin.Nickname,
 		)
 	}
 
 	for _, entry := range sortedEntries {
-		info, err := plugin.buildPostInfo(entry, youtubeService)
+		if err = (*context.Context).Err(); err != nil {
+			return state, err
+		}
+
+		info, err := plugin.buildPostInfo(*context.Context, entry, youtubeService)
 		if err != nil {
-			return handledEntries, err
+			return state, err
 		}
 
 		if exclude, present := plugin.excludedTypes[info.Type]; present && exclude {
-			context.Info.Printf("Ignoring YouTube %s '%s'", info.Type, entry.Title)
-			handledEntries[entry.ID] = true
+			context.Log.Info("Ignoring YouTube post of excluded type", "item", entry.ID, "type", info.Type, "title", entry.Title)
+			handledEntries.Seen(entry.ID, now)
 
 			continue
 		}
 
-		template := info.DefaultTemplate
-		if configTemplate, exists := plugin.Messages[info.Type]; exists {
-			template = configTemplate
+		post, err := plugin.message(entry, *info, atomFeed.Title, context.Locale)
+		if err != nil {
+			return state, err
+		}
+
+		if err = context.Discord.SendMessage(post); err != nil {
+			return state, err
+		}
+
+		handledEntries.Seen(entry.ID, now)
+		if !info.StartsAt.IsZero() {
+			state.Upcoming[entry.ID] = UpcomingEvent{
+				Title:       entry.Title,
+				Link:        entry.Link,
+				VideoID:     entry.VideoID,
+				PublishedAt: entry.Timestamp,
+				Channel:     atomFeed.Title,
+				StartsAt:    info.StartsAt,
+			}
+		}
+
+		context.Log.Info("Reported YouTube post", "item", entry.ID, "type", info.Type, "title", entry.Title)
+	}
+
+	return state, nil
+}
+
+// endEvents edits the announcements of livestreams and premieres that are over, so they present the video instead.
+// Events whose entries are no longer handled or whose videos were removed are forgotten.
+func (plugin *YouTubePlugin) endEvents(context PluginContext, state YouTubeOffset, youtubeService *youtube.Service) error {
+	now := time.Now()
+	for id, event := range state.Upcoming {
+		if !state.Handled.Handled(id) {
+			delete(state.Upcoming, id)
+			continue
+		}
+		if event.StartsAt.After(now) {
+			continue
 		}
 
-		message := template
-		if info.FormatMessage != nil {
-			message = info.FormatMessage(template)
+		videoList, err := youtubeService.Videos.List([]string{"liveStreamingDetails"}).Id(event.VideoID).Context(*context.Context).Do()
+		if err != nil {
+			return fmt.Errorf("could not check whether '%s' is over: %w", event.Title, err)
+		}
+		if len(videoList.Items) == 0 {
+			context.Log.Info("Forgetting livestream or premiere, as its video was removed", "item", id, "title", event.Title)
+			delete(state.Upcoming, id)
+			continue
+		}
+		if details := videoList.Items[0].LiveStreamingDetails; details != nil && len(details.ActualEndTime) == 0 {
+			context.Log.Debug("Livestream or premiere is not over yet", "item", id, "title", event.Title)
+			continue
 		}
 
-		if err = context.Discord.Send(
-			fmt.Sprintf("%s\n%s", message, entry.Link),
-			"YouTube",
-			"youtube",
-			nil,
-		); err != nil {
-			return handledEntries, err
+		if exclude, present := plugin.excludedTypes["video"]; !present || !exclude {
+			if len(plugin.Nickname) == 0 {
+				plugin.Nickname = event.Channel
+			}
+			post, err := plugin.message(event.post(id), postInfo{Type: "video"}, event.Channel, context.Locale)
+			if err != nil {
+				return err
+			}
+			if err = context.Discord.EditMessage(post); err != nil {
+				return err
+			}
+			context.Log.Info("Updated announcement of livestream or premiere that is over", "item", id, "title", event.Title)
 		}
 
-		handledEntries[entry.ID] = true
+		delete(state.Upcoming, id)
+	}
+
+	return nil
+}
+
+// Diagnose checks that the channel's feed can be read and that the API accepts the token, which costs a single unit of
+// the token's quota
+func (plugin *YouTubePlugin) Diagnose(context PluginContext) error {
+	feedURL := fmt.Sprintf("https://www.youtube.com/feeds/videos.xml?channel_id=%s", url.QueryEscape(plugin.ChannelId))
+	req, err := http.NewRequestWithContext(*context.Context, http.MethodGet, feedURL, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := context.HTTP.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not read YouTube feed for channel '%s': %w", plugin.ChannelId, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return common.HTTPStatusError{URL: feedURL, StatusCode: res.StatusCode}
+	}
+	if _, err = (&atom.Parser{}).Parse(res.Body); err != nil {
+		return fmt.Errorf("invalid YouTube feed for channel '%s': %w", plugin.ChannelId, err)
+	}
+
+	youtubeService, err := youtube.NewService(*context.Context, option.WithAPIKey(plugin.Token))
+	if err != nil {
+		return fmt.Errorf("could not create YouTube client: %w", err)
+	}
+	channels, err := youtubeService.Channels.List([]string{"id"}).Id(plugin.ChannelId).Context(*context.Context).Do()
+	if err != nil {
+		return fmt.Errorf("YouTube API rejected the token: %w", err)
+	}
+	if len(channels.Items) == 0 {
+		return fmt.Errorf("YouTube channel '%s' does not exist", plugin.ChannelId)
+	}
+
+	return nil
+}
 
-		context.Info.Printf("Reported YouTube post '%s'", entry.Title)
+// message presents the post with a link to it, preceded by the configured message for its type
+func (plugin *YouTubePlugin) message(entry YouTubePost, info postInfo, channel string, locale common.Locale) (common.Message, error) {
+	template := locale.Text("youtube." + info.Type)
+	if configTemplate, exists := plugin.Messages[info.Type]; exists {
+		template = configTemplate
+	}
+
+	data := common.TemplateData{
+		Nickname: plugin.Nickname,
+		Type:     info.Type,
+		Title:    entry.Title,
+		Link:     entry.Link,
+		Author:   channel,
+		StartsAt: info.StartsAt,
+	}
+	if entry.Timestamp != nil {
+		data.PublishedAt = *entry.Timestamp
+	}
+
+	message, err := common.RenderTemplate(info.Type, template, data)
+	if err != nil {
+		return common.Message{}, err
+	}
+	// Older configs use %s as placeholder for the start of livestreams and premieres
+	if !info.StartsAt.IsZero() {
+		message = strings.Replace(message, "%s", fmt.Sprintf("<t:%d:R>", info.StartsAt.Unix()), 1)
 	}
 
-	return handledEntries, nil
+	post := common.Message{
+		Text:      fmt.Sprintf("%s\n%s", message, entry.Link),
+		Username:  "YouTube",
+		AvatarURL: common.AvatarURL("youtube"),
+		Item:      entry.ID,
+		Type:      info.Type,
+	}
+	if plugin.RichEmbed {
+		post.Text, post.Embed = message, entry.embed(channel)
+	}
+	if len(plugin.Button) > 0 {
+		post.Buttons = []common.LinkButton{{Label: plugin.Button, URL: entry.Link}}
+	}
+
+	return post, nil
+}
+
+// SampleMessage presents a made-up post of the given type like a real one
+func (plugin *YouTubePlugin) SampleMessage(locale common.Locale, item SampleItem) (common.Message, error) {
+	if len(plugin.Nickname) == 0 {
+		plugin.Nickname = "Sample Channel"
+	}
+
+	info := postInfo{Type: item.Type}
+	switch item.Type {
+	case "":
+		info.Type = "video"
+	case "video", "short":
+	case "livestream", "premiere":
+		info.StartsAt = time.Now().Add(time.Hour)
+	default:
+		return common.Message{}, fmt.Errorf(
+			"unknown post type '%s', must be one of 'video', 'short', 'livestream' and 'premiere'",
+			item.Type,
+		)
+	}
+
+	item = item.orDefault(SampleItem{
+		Title:       "A sample video",
+		Link:        fmt.Sprintf("https://www.youtube.com/channel/%s", url.PathEscape(plugin.ChannelId)),
+		Author:      plugin.Nickname,
+		PublishedAt: time.Now(),
+	})
+	return plugin.message(YouTubePost{
+		Title:     item.Title,
+		Link:      item.Link,
+		Timestamp: &item.PublishedAt,
+	}, info, item.Author, locale)
+}
+
+// embed presents the post with its title and thumbnail, attributing it to the channel
+func (post YouTubePost) embed(channel string) *common.Embed {
+	embed := common.NewEmbed().
+		WithTitle(post.Title).
+		WithURL(post.Link).
+		WithColor(youTubeRed)
+
+	if len(channel) > 0 {
+		embed.WithAuthor(channel, "", "")
+	}
+	if post.Timestamp != nil {
+		embed.WithTimestamp(*post.Timestamp)
+	}
+	if len(post.VideoID) > 0 {
+		embed.WithImage(fmt.Sprintf("https://i.ytimg.com/vi/%s/hqdefault.jpg", url.PathEscape(post.VideoID)))
+	}
+
+	return embed
+}
+
+func (plugin *YouTubePlugin) WebSubTopic(_ PluginContext) (string, string, error) {
+	return fmt.Sprintf("https://www.youtube.com/xml/feeds/videos.xml?channel_id=%s", url.QueryEscape(plugin.ChannelId)),
+		"https://pubsubhubbub.appspot.com/subscribe",
+		nil
 }
 
 type postInfo struct {
-	Type            string
-	DefaultTemplate string
-	FormatMessage   func(string) string
+	Type string
+	// StartsAt is the scheduled start of livestreams and premieres
+	StartsAt time.Time
 }
 
-func (plugin *YouTubePlugin) buildPostInfo(entry YouTubePost, youtubeService *youtube.Service) (*postInfo, error) {
+func (plugin *YouTubePlugin) buildPostInfo(ctx goContext.Context, entry YouTubePost, youtubeService *youtube.Service) (*postInfo, error) {
 	if entry.VideoID == "" {
 		return nil, nil
 	}
 
-	info, err := plugin.buildLiveEventInfo(entry, youtubeService)
+	info, err := plugin.buildLiveEventInfo(ctx, entry, youtubeService)
 	if info != nil || err != nil {
 		return info, err
 	}
 
-	info, err = plugin.buildShortInfo(entry)
+	info, err = plugin.buildShortInfo(ctx, entry)
 	if info != nil || err != nil {
 		return info, err
 	}
 
 	info = &postInfo{
-		Type:            "video",
-		DefaultTemplate: fmt.Sprintf("%s posted something on YouTube", plugin.Nickname),
+		Type: "video",
 	}
 
 	return info, nil
 }
 
-func (plugin *YouTubePlugin) buildLiveEventInfo(entry YouTubePost, youtubeService *youtube.Service) (*postInfo, error) {
-	videoList, err := youtubeService.Videos.List([]string{"liveStreamingDetails", "status"}).Id(entry.VideoID).Do()
+func (plugin *YouTubePlugin) buildLiveEventInfo(ctx goContext.Context, entry YouTubePost, youtubeService *youtube.Service) (*postInfo, error) {
+	videoList, err := youtubeService.Videos.List([]string{"liveStreamingDetails", "status"}).Id(entry.VideoID).Context(ctx).Do()
 
 	if err != nil {
 		return nil, err
@@ -227,24 +558,24 @@ func (plugin *YouTubePlugin) buildLiveEventInfo(entry YouTubePost, youtubeServic
 	}
 
 	info := postInfo{
-		Type:            "livestream",
-		DefaultTemplate: fmt.Sprintf("%s is going live on YouTube %%s!", plugin.Nickname),
-		FormatMessage: func(template string) string {
-			return fmt.Sprintf(template, fmt.Sprintf("<t:%d:R>", parsedStart.Unix()))
-		},
+		Type:     "livestream",
+		StartsAt: parsedStart,
 	}
 
 	if video.Status.UploadStatus == "processed" {
 		info.Type = "premiere"
-		info.DefaultTemplate = fmt.Sprintf("%s will premiere a video on YouTube %%s!", plugin.Nickname)
 	}
 
 	return &info, nil
 }
 
-func (plugin *YouTubePlugin) buildShortInfo(entry YouTubePost) (*postInfo, error) {
-	response, err := plugin.client.Head(fmt.Sprintf("https://www.youtube.com/shorts/%s", entry.VideoID))
+func (plugin *YouTubePlugin) buildShortInfo(ctx goContext.Context, entry YouTubePost) (*postInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, fmt.Sprintf("https://www.youtube.com/shorts/%s", entry.VideoID), nil)
+	if err != nil {
+		return nil, err
+	}
 
+	response, err := plugin.client.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -254,8 +585,7 @@ func (plugin *YouTubePlugin) buildShortInfo(entry YouTubePost) (*postInfo, error
 	}
 
 	info := postInfo{
-		Type:            "short",
-		DefaultTemplate: fmt.Sprintf("%s posted a short on YouTube!", plugin.Nickname),
+		Type: "short",
 	}
 
 	return &info, nil
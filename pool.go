@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+const (
+	defaultConcurrency     = 4
+	defaultHostConcurrency = 1
+)
+
+// ConcurrencyConfig limits how many connectors are checked at the same time, in total and per source host
+type ConcurrencyConfig struct {
+	Limit   int `yaml:"limit"`
+	PerHost int `yaml:"perHost"`
+}
+
+// WorkerPool hands out slots for running checks. Connectors retrieving updates from the same host additionally share
+// a separate set of slots, so that a single source isn't hit by many connectors at once.
+type WorkerPool struct {
+	slots   chan struct{}
+	perHost int
+
+	lock  sync.Mutex
+	hosts map[string]chan struct{}
+}
+
+func NewWorkerPool(config ConcurrencyConfig) *WorkerPool {
+	return &WorkerPool{
+		slots:   make(chan struct{}, config.Limit),
+		perHost: config.PerHost,
+		hosts:   make(map[string]chan struct{}),
+	}
+}
+
+// Acquire blocks until a slot for the given host is available, returning a function that releases the slot again.
+// An empty host is only subject to the global limit.
+func (pool *WorkerPool) Acquire(ctx context.Context, host string) (func(), error) {
+	var hostSlots chan struct{}
+	if len(host) > 0 {
+		hostSlots = pool.hostSlots(host)
+
+		// Wait for the host first, so that connectors of busy hosts don't block the global slots of others
+		select {
+		case hostSlots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	select {
+	case pool.slots <- struct{}{}:
+	case <-ctx.Done():
+		if hostSlots != nil {
+			<-hostSlots
+		}
+		return nil, ctx.Err()
+	}
+
+	return func() {
+		<-pool.slots
+		if hostSlots != nil {
+			<-hostSlots
+		}
+	}, nil
+}
+
+func (pool *WorkerPool) hostSlots(host string) chan struct{} {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	slots, ok := pool.hosts[host]
+	if !ok {
+		slots = make(chan struct{}, pool.perHost)
+		pool.hosts[host] = slots
+	}
+
+	return slots
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	. "17thshard.com/sanderson-notifications/common"
+	. "17thshard.com/sanderson-notifications/plugins"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+// previewCommand prints the Discord payloads of a made-up message of a connector, so message templates and embeds can
+// be iterated on without checking the source or posting anything
+func previewCommand(args []string) {
+	_, errorLog := CreateLoggers("main")
+	// Keep the output limited to the payloads, so it can be processed further
+	infoLog := log.New(io.Discard, "", 0)
+
+	flags := newFlagSet("preview")
+	configPath := flags.String("config", "config.yml", "path or URL of YAML, JSON or TOML config file")
+	environment := flags.String("env", "", "environment to select connectors for via 'onlyIn' and 'exceptIn', replacing the config's")
+	connectorName := flags.String("connector", "", "name of the connector to preview a message of")
+	messageType := flags.String("type", "", "type of update to preview, e.g. 'livestream' or 'retweet'")
+	itemPath := flags.String("item", "", "path of a YAML, JSON or TOML file describing the update to preview")
+	sinkName := flags.String("sink", "", "name of the Discord sink whose settings to apply, the connector's first one by default")
+	_ = flags.Parse(args)
+
+	if len(*connectorName) == 0 {
+		errorLog.Fatal("The connector to preview must be specified with -connector")
+	}
+
+	config := loadConfig(*configPath, *environment, infoLog, errorLog)
+	defer closeSinks(config, errorLog)
+
+	connector := config.Connector(*connectorName)
+	if connector == nil {
+		errorLog.Fatalf("Unknown connector '%s'", *connectorName)
+	}
+
+	plugin, ok := (*connector.Plugin).(SamplePlugin)
+	if !ok {
+		errorLog.Fatalf("Plugin '%s' of connector '%s' does not support sample messages", (*connector.Plugin).Name(), connector.Name)
+	}
+
+	var item SampleItem
+	if len(*itemPath) > 0 {
+		var err error
+		if item, err = loadSampleItem(*itemPath); err != nil {
+			errorLog.Fatalf("Failed to load item to preview: %s", err)
+		}
+	}
+	if len(*messageType) > 0 {
+		item.Type = *messageType
+	}
+
+	message, err := plugin.SampleMessage(connector.Locale, item)
+	if err != nil {
+		errorLog.Fatalf("Failed to create message for connector '%s': %s", connector.Name, err)
+	}
+	message.Connector, message.Timestamp = connector.Name, time.Now()
+	message = connector.Overrides.apply(message)
+
+	sink, err := previewSink(config, connector, *sinkName)
+	if err != nil {
+		errorLog.Fatalf("Failed to select sink rendering the preview: %s", err)
+	}
+
+	payloads, err := sink.Preview(message)
+	if err != nil {
+		errorLog.Fatalf("Failed to render message of connector '%s': %s", connector.Name, err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	if err = encoder.Encode(payloads); err != nil {
+		errorLog.Fatalf("Failed to print payloads: %s", err)
+	}
+}
+
+// loadSampleItem reads the description of an update to preview from a file in any of the config formats
+func loadSampleItem(path string) (SampleItem, error) {
+	var item SampleItem
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return item, fmt.Errorf("could not read '%s': %w", path, err)
+	}
+
+	document, err := parseDocument(configExtension(path), content)
+	if err != nil {
+		return item, fmt.Errorf("could not parse '%s': %w", path, err)
+	}
+	if err = document.Decode(&item); err != nil {
+		return item, fmt.Errorf("invalid item in '%s': %w", path, err)
+	}
+
+	return item, nil
+}
+
+// previewSink selects the sink rendering the preview, which is the given one, the first of the connector's sinks that
+// supports previews or a Discord webhook without any settings if there is none
+func previewSink(config *Config, connector *Connector, name string) (PreviewSink, error) {
+	if len(name) > 0 {
+		sink, ok := config.Sinks[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown sink '%s'", name)
+		}
+		preview, ok := sink.(PreviewSink)
+		if !ok {
+			return nil, fmt.Errorf("sink '%s' of type '%s' does not support previews", name, sink.Name())
+		}
+		return preview, nil
+	}
+
+	for _, target := range connector.Targets {
+		if preview, ok := config.Sinks[target].(PreviewSink); ok {
+			return preview, nil
+		}
+	}
+
+	client := CreateDiscordClient("", DiscordMentions{}, DiscordThread{}, DiscordBot{})
+	return &client, nil
+}
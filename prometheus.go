@@ -0,0 +1,41 @@
+package main
+
+import (
+	"17thshard.com/sanderson-notifications/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// MetricsConfig configures how Prometheus metrics are exposed. The daemon serves them on its HTTP server, while single
+// runs can write them to a file for the textfile collector of the node exporter.
+type MetricsConfig struct {
+	Enabled  bool   `yaml:"enabled"`
+	Textfile string `yaml:"textfile"`
+}
+
+// recordMetrics updates the metrics of a connector with the result of a check
+func recordMetrics(connector string, result RunResult) {
+	metrics.CheckDuration.WithLabelValues(connector).Observe(result.Finished.Sub(result.Started).Seconds())
+	metrics.LastCheck.WithLabelValues(connector).Set(float64(result.Finished.Unix()))
+	metrics.ItemsFound.WithLabelValues(connector).Add(float64(result.Found))
+	metrics.NotificationsSent.WithLabelValues(connector).Add(float64(result.Posted))
+	metrics.NotificationFailures.WithLabelValues(connector).Add(float64(result.Failed))
+	if len(result.Error) > 0 {
+		metrics.CheckFailures.WithLabelValues(connector).Inc()
+	}
+}
+
+// registerMetrics serves the metrics on the server, along with those of the Go runtime
+func registerMetrics(server *Server) {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+
+	gatherers := prometheus.Gatherers{metrics.Registry, registry}
+	server.Handle("GET /metrics", promhttp.HandlerFor(gatherers, promhttp.HandlerOpts{}))
+}
+
+// writeMetrics writes the metrics of a single run to a file in the format of the textfile collector
+func writeMetrics(path string) error {
+	return prometheus.WriteToTextfile(path, metrics.Registry)
+}
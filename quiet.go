@@ -0,0 +1,224 @@
+package main
+
+import (
+	. "17thshard.com/sanderson-notifications/common"
+	"17thshard.com/sanderson-notifications/state"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// QuietHoursConfig configures daily times during which notifications are held back, to be delivered once they're over
+type QuietHoursConfig struct {
+	Timezone string        `yaml:"timezone"`
+	Windows  []QuietWindow `yaml:"windows"`
+}
+
+// QuietWindow is a daily range of times, which ends on the next day if it doesn't end after it starts
+type QuietWindow struct {
+	From string `yaml:"from"`
+	To   string `yaml:"to"`
+	// Days restricts the window to the weekdays it starts on
+	Days []string `yaml:"days"`
+}
+
+// QuietHours decides whether notifications are currently held. A nil value never holds any.
+type QuietHours struct {
+	location *time.Location
+	windows  []quietWindow
+}
+
+type quietWindow struct {
+	from time.Duration
+	to   time.Duration
+	days map[time.Weekday]bool
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// ParseQuietHours validates the configured windows, returning nil if there are none
+func ParseQuietHours(config QuietHoursConfig) (*QuietHours, error) {
+	if len(config.Windows) == 0 {
+		return nil, nil
+	}
+
+	location := time.Local
+	if len(config.Timezone) > 0 {
+		var err error
+		if location, err = time.LoadLocation(config.Timezone); err != nil {
+			return nil, fmt.Errorf("invalid timezone '%s': %w", config.Timezone, err)
+		}
+	}
+
+	quiet := &QuietHours{location: location}
+	for _, window := range config.Windows {
+		from, err := parseTimeOfDay(window.From)
+		if err != nil {
+			return nil, err
+		}
+		to, err := parseTimeOfDay(window.To)
+		if err != nil {
+			return nil, err
+		}
+
+		var days map[time.Weekday]bool
+		for _, day := range window.Days {
+			weekday, ok := weekdays[strings.ToLower(day[:min(len(day), 3)])]
+			if !ok {
+				return nil, fmt.Errorf("unknown weekday '%s'", day)
+			}
+			if days == nil {
+				days = make(map[time.Weekday]bool)
+			}
+			days[weekday] = true
+		}
+
+		quiet.windows = append(quiet.windows, quietWindow{from: from, to: to, days: days})
+	}
+
+	return quiet, nil
+}
+
+func parseTimeOfDay(value string) (time.Duration, error) {
+	parsed, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time '%s', expected format HH:MM", value)
+	}
+
+	return time.Duration(parsed.Hour())*time.Hour + time.Duration(parsed.Minute())*time.Minute, nil
+}
+
+// Active returns whether notifications are held at the given time
+func (quiet *QuietHours) Active(now time.Time) bool {
+	if quiet == nil {
+		return false
+	}
+
+	local := now.In(quiet.location)
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, quiet.location)
+	sinceMidnight := local.Sub(midnight)
+	today, yesterday := local.Weekday(), midnight.AddDate(0, 0, -1).Weekday()
+
+	for _, window := range quiet.windows {
+		if window.from < window.to {
+			if window.appliesOn(today) && sinceMidnight >= window.from && sinceMidnight < window.to {
+				return true
+			}
+			continue
+		}
+
+		if window.appliesOn(today) && sinceMidnight >= window.from {
+			return true
+		}
+		if window.appliesOn(yesterday) && sinceMidnight < window.to {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (window quietWindow) appliesOn(day time.Weekday) bool {
+	return window.days == nil || window.days[day]
+}
+
+// HoldSink keeps messages in the state store during quiet hours. As long as messages are held for the connector, new
+// messages are held behind them to preserve their order.
+type HoldSink struct {
+	Sink
+	connector string
+	quiet     *QuietHours
+	offsets   *Offsets
+	info      *log.Logger
+	lock      sync.Mutex
+}
+
+func (sink *HoldSink) held() ([]Message, error) {
+	raw, ok := sink.offsets.Entry(state.HeldBucket, sink.connector)
+	if !ok {
+		return nil, nil
+	}
+
+	var messages []Message
+	if err := json.Unmarshal(raw, &messages); err != nil {
+		return nil, fmt.Errorf("could not parse held messages of connector '%s': %w", sink.connector, err)
+	}
+
+	return messages, nil
+}
+
+func (sink *HoldSink) store(messages []Message) error {
+	if len(messages) == 0 {
+		sink.offsets.DeleteEntry(state.HeldBucket, sink.connector)
+		return nil
+	}
+
+	return sink.offsets.SetEntry(state.HeldBucket, sink.connector, messages)
+}
+
+func (sink *HoldSink) Deliver(ctx context.Context, message Message) error {
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+
+	messages, err := sink.held()
+	if err != nil {
+		return err
+	}
+
+	if len(messages) == 0 && !sink.quiet.Active(time.Now()) {
+		return sink.Sink.Deliver(ctx, message)
+	}
+
+	if err = sink.store(append(messages, message)); err != nil {
+		return fmt.Errorf("could not hold message during quiet hours: %w", err)
+	}
+	sink.info.Println("Holding message until quiet hours are over")
+
+	return nil
+}
+
+// Release delivers all held messages in order once quiet hours are over, stopping at the first one that fails
+func (sink *HoldSink) Release(ctx context.Context) error {
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+
+	if sink.quiet.Active(time.Now()) {
+		return nil
+	}
+
+	messages, err := sink.held()
+	if err != nil || len(messages) == 0 {
+		return err
+	}
+
+	sink.info.Printf("Quiet hours are over, delivering %d held messages", len(messages))
+
+	delivered := 0
+	for _, message := range messages {
+		if err = sink.Sink.Deliver(ctx, message); err != nil {
+			break
+		}
+		delivered++
+	}
+
+	if storeErr := sink.store(messages[delivered:]); storeErr != nil {
+		return storeErr
+	}
+	if err != nil {
+		return fmt.Errorf("could not deliver %d held messages: %w", len(messages)-delivered, err)
+	}
+
+	return nil
+}
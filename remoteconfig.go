@@ -0,0 +1,243 @@
+package main
+
+import (
+	"17thshard.com/sanderson-notifications/common"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// remoteConfigTimeout limits how long fetching a remote config may take
+const remoteConfigTimeout = 30 * time.Second
+
+// remoteConfigSchemes are the URL schemes of config locations that aren't local files
+var remoteConfigSchemes = []string{"http", "https", "s3", "etcd", "etcds"}
+
+// remoteConfig is a config fetched via HTTP(S), from an S3 bucket or from an etcd key. The fragment of its URL may
+// contain a `sha256` checksum the config must match and a `cache` file the last valid config is kept in, which is
+// used whenever the config can't be fetched.
+type remoteConfig struct {
+	location *url.URL
+	checksum string
+	cache    string
+}
+
+// parseRemoteConfig returns the remote config the path points to, or nil if it's a local file
+func parseRemoteConfig(path string) (*remoteConfig, error) {
+	scheme, _, found := strings.Cut(path, "://")
+	if !found || !slices.Contains(remoteConfigSchemes, strings.ToLower(scheme)) {
+		return nil, nil
+	}
+
+	location, err := url.Parse(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config URL: %w", err)
+	}
+	options, err := url.ParseQuery(location.Fragment)
+	if err != nil {
+		return nil, fmt.Errorf("invalid options of config URL: %w", err)
+	}
+	location.Fragment = ""
+
+	return &remoteConfig{location: location, checksum: strings.ToLower(options.Get("sha256")), cache: options.Get("cache")}, nil
+}
+
+// readConfigFile reads the config at the path, which may be a local file or the URL of a remote config
+func readConfigFile(path string) ([]byte, error) {
+	remote, err := parseRemoteConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	if remote == nil {
+		return os.ReadFile(path)
+	}
+
+	return remote.read()
+}
+
+// configName returns the path of the config for messages, hiding the credentials of remote configs
+func configName(path string) string {
+	if remote, err := parseRemoteConfig(path); err == nil && remote != nil {
+		return remote.location.Redacted()
+	}
+
+	return path
+}
+
+func (config *remoteConfig) read() ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), remoteConfigTimeout)
+	defer cancel()
+
+	content, err := config.fetch(ctx)
+	if err == nil {
+		err = config.verify(content)
+	}
+	if err != nil {
+		if len(config.cache) == 0 {
+			return nil, err
+		}
+
+		cached, cacheErr := os.ReadFile(config.cache)
+		if cacheErr != nil {
+			return nil, fmt.Errorf("%w, and cached config is unavailable: %w", err, cacheErr)
+		}
+
+		_, errorLog := common.CreateLoggers("config")
+		errorLog.Printf("Using cached config from '%s': %s", config.cache, err)
+		return cached, nil
+	}
+
+	if len(config.cache) > 0 {
+		if err = writeCachedConfig(config.cache, content); err != nil {
+			return nil, fmt.Errorf("could not cache config: %w", err)
+		}
+	}
+
+	return content, nil
+}
+
+func (config *remoteConfig) fetch(ctx context.Context) ([]byte, error) {
+	switch config.location.Scheme {
+	case "s3":
+		return config.fetchS3(ctx)
+	case "etcd", "etcds":
+		return config.fetchEtcd(ctx)
+	default:
+		return config.fetchHTTP(ctx, http.MethodGet, config.location.String(), nil)
+	}
+}
+
+func (config *remoteConfig) verify(content []byte) error {
+	if len(config.checksum) == 0 {
+		return nil
+	}
+
+	sum := sha256.Sum256(content)
+	if actual := hex.EncodeToString(sum[:]); actual != config.checksum {
+		return fmt.Errorf("checksum of config from %s is %s instead of %s", config.location.Redacted(), actual, config.checksum)
+	}
+
+	return nil
+}
+
+func (config *remoteConfig) fetchHTTP(ctx context.Context, method, location string, body []byte) ([]byte, error) {
+	request, err := http.NewRequestWithContext(ctx, method, location, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("User-Agent", defaultUserAgent)
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch config from %s: %w", config.location.Redacted(), err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("could not fetch config from %s: status %d", config.location.Redacted(), response.StatusCode)
+	}
+
+	return io.ReadAll(response.Body)
+}
+
+// fetchS3 reads the object `s3://bucket/key`. Like the S3 state store, credentials are taken from the environment and
+// the endpoint, region and `insecure` may be given as query parameters.
+func (config *remoteConfig) fetchS3(ctx context.Context) ([]byte, error) {
+	query := config.location.Query()
+	endpoint := query.Get("endpoint")
+	if len(endpoint) == 0 {
+		endpoint = "s3.amazonaws.com"
+	}
+
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds: credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.FileAWSCredentials{},
+			&credentials.IAM{},
+		}),
+		Region: query.Get("region"),
+		Secure: query.Get("insecure") != "true",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not create S3 client: %w", err)
+	}
+
+	bucket, key := config.location.Host, strings.TrimPrefix(config.location.Path, "/")
+	object, err := client.GetObject(ctx, bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not read s3://%s/%s: %w", bucket, key, err)
+	}
+	defer object.Close()
+
+	content, err := io.ReadAll(object)
+	if err != nil {
+		return nil, fmt.Errorf("could not read s3://%s/%s: %w", bucket, key, err)
+	}
+
+	return content, nil
+}
+
+// fetchEtcd reads the key `etcd://host:port/key` via the JSON gateway of etcd's v3 API, using TLS for `etcds`
+func (config *remoteConfig) fetchEtcd(ctx context.Context) ([]byte, error) {
+	scheme := "http"
+	if config.location.Scheme == "etcds" {
+		scheme = "https"
+	}
+	endpoint := url.URL{Scheme: scheme, Host: config.location.Host, Path: "/v3/kv/range"}
+
+	request, err := json.Marshal(map[string]string{"key": base64.StdEncoding.EncodeToString([]byte(config.location.Path))})
+	if err != nil {
+		return nil, err
+	}
+	response, err := config.fetchHTTP(ctx, http.MethodPost, endpoint.String(), request)
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		KVs []struct {
+			Value string `json:"value"`
+		} `json:"kvs"`
+	}
+	if err = json.Unmarshal(response, &result); err != nil {
+		return nil, fmt.Errorf("invalid response from etcd: %w", err)
+	}
+	if len(result.KVs) == 0 {
+		return nil, fmt.Errorf("etcd key '%s' does not exist", config.location.Path)
+	}
+
+	return base64.StdEncoding.DecodeString(result.KVs[0].Value)
+}
+
+// writeCachedConfig replaces the cached config atomically, so a crash never leaves a partial config behind
+func writeCachedConfig(path string, content []byte) error {
+	temp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	if _, err = temp.Write(content); err != nil {
+		_ = temp.Close()
+		return err
+	}
+	if err = temp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(temp.Name(), path)
+}
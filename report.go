@@ -0,0 +1,120 @@
+package main
+
+import (
+	. "17thshard.com/sanderson-notifications/common"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// Exit codes of the run command, so that wrapper scripts and monitoring can tell apart different kinds of failures
+const (
+	ExitSuccess = 0
+	// ExitFailure is used for failures not covered by other codes, e.g. if the state can't be stored
+	ExitFailure         = 1
+	ExitConfigError     = 2
+	ExitCheckFailure    = 3
+	ExitDeliveryFailure = 4
+)
+
+// RunReport summarizes a single run of all connectors
+type RunReport struct {
+	Started    time.Time         `json:"started"`
+	Finished   time.Time         `json:"finished"`
+	ExitCode   int               `json:"exitCode"`
+	Connectors []ConnectorReport `json:"connectors"`
+}
+
+// ConnectorReport summarizes the check of a connector during a run
+type ConnectorReport struct {
+	Name   string `json:"name"`
+	Plugin string `json:"plugin"`
+	// Skipped is set if the connector wasn't checked, e.g. due to previous failures
+	Skipped         bool    `json:"skipped,omitempty"`
+	DurationSeconds float64 `json:"durationSeconds"`
+	Found           int64   `json:"found"`
+	Posted          int64   `json:"posted"`
+	Failed          int64   `json:"failed"`
+	Error           string  `json:"error,omitempty"`
+}
+
+// newConnectorReport summarizes the result of a check, which is considered skipped if it started before the run
+func newConnectorReport(connector *ConnectorRuntime, runStarted time.Time) ConnectorReport {
+	report := ConnectorReport{Name: connector.Name, Plugin: (*connector.Plugin).Name()}
+
+	result := connector.State().LastRun
+	if result == nil || result.Started.Before(runStarted) {
+		report.Skipped = true
+		return report
+	}
+
+	report.DurationSeconds = result.Finished.Sub(result.Started).Seconds()
+	report.Found = result.Found
+	report.Posted = result.Posted
+	report.Failed = result.Failed
+	report.Error = result.Error
+
+	return report
+}
+
+// exitCode determines the exit code for the connector reports, with failed deliveries taking precedence over other
+// failures
+func exitCode(reports []ConnectorReport) int {
+	code := ExitSuccess
+	for _, report := range reports {
+		if report.Failed > 0 {
+			return ExitDeliveryFailure
+		}
+		if len(report.Error) > 0 {
+			code = ExitCheckFailure
+		}
+	}
+
+	return code
+}
+
+// Write writes the report as JSON to the given path, or to stdout if the path is '-'
+func (report *RunReport) Write(path string) error {
+	serialized, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not serialize run report: %w", err)
+	}
+	serialized = append(serialized, '\n')
+
+	if path == "-" {
+		_, err = os.Stdout.Write(serialized)
+	} else {
+		err = os.WriteFile(path, serialized, 0644)
+	}
+	if err != nil {
+		return fmt.Errorf("could not write run report: %w", err)
+	}
+
+	return nil
+}
+
+// countingSink counts the messages passing through it. Counters that aren't needed may be nil.
+type countingSink struct {
+	Sink
+	messages  *atomic.Int64
+	delivered *atomic.Int64
+	failed    *atomic.Int64
+}
+
+func (sink *countingSink) Deliver(ctx context.Context, message Message) error {
+	if sink.messages != nil {
+		sink.messages.Add(1)
+	}
+
+	err := sink.Sink.Deliver(ctx, message)
+	if err == nil && sink.delivered != nil {
+		sink.delivered.Add(1)
+	} else if err != nil && sink.failed != nil {
+		sink.failed.Add(1)
+	}
+
+	return err
+}
@@ -0,0 +1,553 @@
+package main
+
+import (
+	. "17thshard.com/sanderson-notifications/common"
+	"17thshard.com/sanderson-notifications/metrics"
+	. "17thshard.com/sanderson-notifications/plugins"
+	"17thshard.com/sanderson-notifications/sinks"
+	"17thshard.com/sanderson-notifications/state"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"log"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Runner executes the checks of connectors and keeps track of their offsets
+type Runner struct {
+	offsets    *Offsets
+	sent       *SentMessages
+	pool       *WorkerPool
+	breaker    *CircuitBreaker
+	digest     *Digest
+	outbox     *Outbox
+	dedupe     *Deduplicator
+	history    *History
+	reporter   *ErrorReporter
+	lock       sync.RWMutex
+	connectors []*ConnectorRuntime
+}
+
+// ConnectorRuntime holds everything a connector needs to run its checks
+type ConnectorRuntime struct {
+	Connector
+
+	info  *log.Logger
+	error *log.Logger
+	log   *slog.Logger
+	http  *http.Client
+	cache *HTTPCache
+	// sink delivers the messages of the connector through all delivery stages to its configured sink
+	sink Sink
+	// delivery delivers messages straight to the configured sink, only recording them in the history
+	delivery Sink
+	// hold keeps messages back during quiet hours
+	hold           *HoldSink
+	partialFailure atomic.Bool
+	queued         atomic.Bool
+	found          atomic.Int64
+	posted         atomic.Int64
+	failed         atomic.Int64
+	trigger        chan struct{}
+
+	stateLock sync.Mutex
+	state     ConnectorState
+}
+
+// ConnectorState describes what a connector is currently doing and how its last check went
+type ConnectorState struct {
+	Paused    bool
+	Running   bool
+	NextCheck time.Time
+	LastRun   *RunResult
+	// PushedUntil is the time until which updates are pushed to the connector, so it doesn't need to poll
+	PushedUntil time.Time
+}
+
+type RunResult struct {
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	// Found is the number of messages the connector sent, of which Posted were delivered and Failed weren't
+	Found  int64  `json:"found"`
+	Posted int64  `json:"posted"`
+	Failed int64  `json:"failed"`
+	Error  string `json:"error,omitempty"`
+}
+
+func (connector *ConnectorRuntime) State() ConnectorState {
+	connector.stateLock.Lock()
+	defer connector.stateLock.Unlock()
+
+	return connector.state
+}
+
+func (connector *ConnectorRuntime) updateState(update func(state *ConnectorState)) {
+	connector.stateLock.Lock()
+	defer connector.stateLock.Unlock()
+
+	update(&connector.state)
+}
+
+// Trigger requests an immediate check of the connector, returning false if one is already pending
+func (connector *ConnectorRuntime) Trigger() bool {
+	select {
+	case connector.trigger <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func NewRunner(config *Config, offsets *Offsets, reporter *ErrorReporter) (*Runner, error) {
+	breaker, err := NewCircuitBreaker(config, offsets)
+	if err != nil {
+		return nil, err
+	}
+
+	runner := &Runner{
+		offsets:  offsets,
+		sent:     NewSentMessages(offsets),
+		pool:     NewWorkerPool(config.Concurrency),
+		breaker:  breaker,
+		digest:   NewDigest(config, offsets),
+		reporter: reporter,
+	}
+	if config.Outbox {
+		runner.outbox = &Outbox{offsets: offsets}
+	}
+	if config.Dedupe.Enabled {
+		runner.dedupe = NewDeduplicator(config.Dedupe, offsets)
+	}
+	if config.History.Enabled {
+		runner.history = NewHistory(config.History, offsets)
+	}
+
+	for _, connector := range config.Connectors {
+		runtime, err := runner.newConnectorRuntime(connector)
+		if err != nil {
+			return nil, err
+		}
+
+		runner.connectors = append(runner.connectors, runtime)
+	}
+
+	return runner, nil
+}
+
+// newConnectorRuntime sets up the HTTP client and delivery stages of a connector
+func (runner *Runner) newConnectorRuntime(connector Connector) (*ConnectorRuntime, error) {
+	connectorInfo, connectorError, connectorLog := connectorLoggers(connector)
+	runtime := &ConnectorRuntime{
+		Connector: connector,
+		info:      connectorInfo,
+		error:     connectorError,
+		log:       connectorLog,
+		trigger:   make(chan struct{}, 1),
+		cache:     NewHTTPCache(runner.offsets, connector.Name),
+	}
+
+	transport, err := connector.HTTP.Transport()
+	if err != nil {
+		return nil, fmt.Errorf("invalid HTTP settings for connector '%s': %w", connector.Name, err)
+	}
+	runtime.http = &http.Client{
+		Transport: &RetryTransport{
+			Base: &CachingTransport{
+				Base: otelhttp.NewTransport(&metrics.Transport{
+					Base:      &LoggingTransport{Base: transport, Log: connectorLog},
+					Connector: connector.Name,
+				}),
+				Store: runtime.cache,
+			},
+			Policy: connector.Retry,
+			Info:   connectorInfo,
+		},
+		CheckRedirect: connector.HTTP.CheckRedirect,
+		Timeout:       connector.HTTP.Timeout,
+	}
+
+	var delivery Sink = &tracingSink{Sink: connector.Sink, connector: connector.Name, targets: connector.Targets}
+	if !connector.Overrides.empty() {
+		delivery = &overrideSink{Sink: delivery, overrides: connector.Overrides}
+	}
+	if fanOut, ok := connector.Sink.(*sinks.FanOut); ok {
+		fanOut.OnFailure = func(sink string, message Message, err error) {
+			runtime.error.Printf("Failed to deliver message to sink '%s': %s", sink, err)
+			runtime.partialFailure.Store(true)
+			runtime.failed.Add(1)
+			runner.reporter.DeliveryFailed(runtime, sink, message, err)
+		}
+	} else if len(connector.Targets) > 0 {
+		delivery = &reportingSink{Sink: delivery, name: connector.Targets[0], connector: runtime, reporter: runner.reporter}
+	}
+
+	runtime.delivery = &countingSink{
+		Sink:      delivery,
+		delivered: &runtime.posted,
+		failed:    &runtime.failed,
+	}
+
+	if runner.history != nil {
+		runtime.delivery = &HistorySink{
+			Sink:      runtime.delivery,
+			connector: connector.Name,
+			targets:   connector.Targets,
+			history:   runner.history,
+			error:     connectorError,
+		}
+	}
+	runtime.sink = runtime.delivery
+
+	if runner.outbox != nil {
+		runtime.sink = &OutboxSink{
+			Sink:      runtime.sink,
+			connector: connector.Name,
+			outbox:    runner.outbox,
+			error:     connectorError,
+			queued:    &runtime.queued,
+		}
+	}
+
+	runtime.hold = &HoldSink{
+		Sink:      runtime.sink,
+		connector: connector.Name,
+		quiet:     connector.QuietHours,
+		offsets:   runner.offsets,
+		info:      connectorInfo,
+	}
+	runtime.sink = runtime.hold
+
+	if runner.dedupe != nil {
+		runtime.sink = &DedupeSink{
+			Sink:      runtime.sink,
+			connector: connector.Name,
+			dedupe:    runner.dedupe,
+			info:      connectorInfo,
+		}
+	}
+
+	runtime.sink = &pausedSink{Sink: runtime.sink, connector: runtime}
+	runtime.sink = &countingSink{Sink: runtime.sink, messages: &runtime.found}
+
+	_, runtime.state.Paused = runner.offsets.Entry(state.PausedBucket, connector.Name)
+	if raw, ok := runner.offsets.Entry(state.RunsBucket, connector.Name); ok {
+		var lastRun RunResult
+		if err = json.Unmarshal(raw, &lastRun); err != nil {
+			return nil, fmt.Errorf("could not parse last run of connector '%s': %w", connector.Name, err)
+		}
+		runtime.state.LastRun = &lastRun
+	}
+
+	return runtime, nil
+}
+
+// Connectors returns the currently configured connectors
+func (runner *Runner) Connectors() []*ConnectorRuntime {
+	runner.lock.RLock()
+	defer runner.lock.RUnlock()
+
+	return slices.Clone(runner.connectors)
+}
+
+// Connector looks up a currently configured connector by its name
+func (runner *Runner) Connector(name string) (*ConnectorRuntime, bool) {
+	runner.lock.RLock()
+	defer runner.lock.RUnlock()
+
+	for _, connector := range runner.connectors {
+		if connector.Name == name {
+			return connector, true
+		}
+	}
+
+	return nil, false
+}
+
+// Reload replaces all connectors with those of the new config. Connectors that are still configured keep their state,
+// e.g. whether they're paused. Apart from the circuit breaker, settings shared by all connectors are not reloaded.
+func (runner *Runner) Reload(config *Config) ([]*ConnectorRuntime, error) {
+	connectors := make([]*ConnectorRuntime, 0, len(config.Connectors))
+	for _, connector := range config.Connectors {
+		runtime, err := runner.newConnectorRuntime(connector)
+		if err != nil {
+			return nil, err
+		}
+
+		if previous, ok := runner.Connector(connector.Name); ok {
+			runtime.state = previous.State()
+			runtime.state.Running = false
+			if len(previous.trigger) > 0 {
+				runtime.Trigger()
+			}
+		}
+		connectors = append(connectors, runtime)
+	}
+
+	runner.breaker.Reconfigure(config)
+
+	runner.lock.Lock()
+	runner.connectors = connectors
+	runner.lock.Unlock()
+
+	return slices.Clone(connectors), nil
+}
+
+// connectorLoggers creates the loggers of a connector, which are tagged with its name and plugin
+func connectorLoggers(connector Connector) (*log.Logger, *log.Logger, *slog.Logger) {
+	name := fmt.Sprintf("connector=%s", connector.Name)
+	attrs := []any{"connector", connector.Name, "plugin", (*connector.Plugin).Name()}
+	info, error := CreateLoggers(name, attrs...)
+
+	return info, error, NewLogger(name, attrs...)
+}
+
+// PostDigest posts the digest of the latest checks if it's due
+func (runner *Runner) PostDigest(ctx context.Context) {
+	runner.digest.PostIfDue(ctx)
+}
+
+// RunAll checks all connectors once, as concurrently as the worker pool allows, returning a report of the checks
+func (runner *Runner) RunAll(ctx context.Context) *RunReport {
+	var wg sync.WaitGroup
+	report := &RunReport{Started: time.Now()}
+
+	connectors := runner.Connectors()
+	ctx, span := tracer.Start(ctx, "run", trace.WithAttributes(attribute.Int("connectors", len(connectors))))
+	defer span.End()
+
+	for _, connector := range connectors {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = runner.Check(ctx, connector)
+		}()
+	}
+
+	wg.Wait()
+
+	report.Finished = time.Now()
+	for _, connector := range connectors {
+		report.Connectors = append(report.Connectors, newConnectorReport(connector, report.Started))
+	}
+	report.ExitCode = exitCode(report.Connectors)
+	span.SetAttributes(attribute.Int("exit_code", report.ExitCode))
+
+	return report
+}
+
+// Check runs a single check of a connector once the worker pool has a free slot and persists its new offset.
+// Connectors whose circuit is open due to previous failures are skipped.
+func (runner *Runner) Check(ctx context.Context, connector *ConnectorRuntime) (err error) {
+	if openUntil, open := runner.breaker.OpenUntil(connector.Name); open {
+		connector.info.Printf("Skipping check for connector '%s' until %s due to previous failures", connector.Name, openUntil.Format(time.RFC3339))
+		return nil
+	}
+
+	var host string
+	if plugin, ok := (*connector.Plugin).(SourcePlugin); ok {
+		host = plugin.SourceHost()
+	}
+
+	ctx, span := tracer.Start(ctx, spanName(connector), trace.WithAttributes(
+		attribute.String("connector", connector.Name),
+		attribute.String("plugin", (*connector.Plugin).Name()),
+	))
+	defer func() {
+		endSpan(span, err)
+	}()
+
+	release, err := runner.pool.Acquire(ctx, host)
+	if err != nil {
+		connector.info.Printf("Check for connector '%s' was cancelled before it started", connector.Name)
+		return err
+	}
+	defer release()
+
+	result := RunResult{Started: time.Now()}
+	connector.updateState(func(state *ConnectorState) {
+		state.Running = true
+	})
+
+	startOffset, _ := runner.offsets.Get(connector.Name)
+	err = runner.check(ctx, connector)
+	if ctx.Err() == nil {
+		runner.breaker.Record(ctx, connector, err)
+		if err != nil {
+			runner.reporter.CheckFailed(connector, startOffset, err)
+		}
+	}
+
+	result.Finished = time.Now()
+	result.Found = connector.found.Load()
+	result.Posted = connector.posted.Load()
+	result.Failed = connector.failed.Load()
+	if err != nil {
+		result.Error = err.Error()
+	}
+	connector.updateState(func(state *ConnectorState) {
+		state.Running = false
+		state.LastRun = &result
+	})
+	recordMetrics(connector.Name, result)
+	runner.digest.Record(connector.Name, result)
+	if storeErr := runner.offsets.SetEntry(state.RunsBucket, connector.Name, result); storeErr != nil {
+		connector.error.Printf("Could not store result of check for connector '%s': %s", connector.Name, storeErr)
+	}
+
+	// Persist the new offset right away, so it isn't lost if the application crashes while other checks are running.
+	// Changes that can't be written now are retried with the next write.
+	if storeErr := runner.offsets.Flush(); storeErr != nil {
+		connector.error.Printf("Could not store offsets after check for connector '%s': %s", connector.Name, storeErr)
+	}
+
+	return err
+}
+
+func (runner *Runner) check(parentCtx context.Context, connector *ConnectorRuntime) error {
+	ctx, cancel := context.WithTimeout(WithSentMessages(parentCtx, runner.sent), connector.Timeout)
+	defer cancel()
+
+	connector.found.Store(0)
+	connector.posted.Store(0)
+	connector.failed.Store(0)
+
+	pluginContext := PluginContext{
+		Info:            connector.info,
+		Error:           connector.error,
+		Log:             connector.log,
+		HTTP:            connector.http,
+		HTTPConfig:      connector.HTTP,
+		Locale:          connector.Locale,
+		OffsetRetention: connector.OffsetRetention,
+	}
+
+	var offset interface{}
+	rawOffset, ok := runner.offsets.Get(connector.Name)
+	if ok {
+		var err error
+		if offset, err = decodeOffset(*connector.Plugin, rawOffset); err != nil {
+			pluginContext.Error.Printf("Could not parse offsets for connector '%s': %s", connector.Name, err)
+			return err
+		}
+	}
+	connector.cache.Begin(offset != nil)
+
+	connector.partialFailure.Store(false)
+	connector.queued.Store(false)
+
+	// Messages that were already queued are kept back as well while the connector is paused
+	var redeliveryErr error
+	paused := connector.State().Paused
+	if runner.outbox != nil && !paused {
+		if redeliveryErr = runner.outbox.Redeliver(ctx, connector.Name, connector.delivery, connector.info); redeliveryErr != nil {
+			pluginContext.Error.Println(redeliveryErr)
+		}
+	}
+	if !paused {
+		if releaseErr := connector.hold.Release(ctx); releaseErr != nil {
+			pluginContext.Error.Println(releaseErr)
+			if redeliveryErr == nil {
+				redeliveryErr = releaseErr
+			}
+		}
+	}
+
+	var newOffset interface{}
+	err := connector.Retry.Do(ctx, func() error {
+		attemptCtx, span := tracer.Start(ctx, "plugin check")
+		attemptCtx, retried := TrackRetries(attemptCtx)
+		pluginContext.Context = &attemptCtx
+		pluginContext.Discord = SinkSender{Connector: connector.Name, Sink: connector.sink, Context: attemptCtx}
+
+		var err error
+		newOffset, err = (*connector.Plugin).Check(offset, pluginContext)
+		if err != nil && newOffset != nil {
+			// Continue from the updates that were already posted, so they aren't posted again
+			offset = newOffset
+		}
+		endSpan(span, err)
+		if retried.Load() {
+			// Requests were retried on their own already, so repeating the whole check would only multiply them
+			return AlreadyRetried(err)
+		}
+		return err
+	}, func(err error, delay time.Duration) {
+		pluginContext.Info.Printf("Check for connector '%s' failed, retrying in %s: %s", connector.Name, delay.Round(time.Millisecond), err)
+	})
+	if err == nil {
+		if storeErr := connector.cache.Commit(); storeErr != nil {
+			pluginContext.Error.Printf("Could not store HTTP cache of connector '%s': %s", connector.Name, storeErr)
+		}
+	}
+	if err != nil && parentCtx.Err() != nil {
+		pluginContext.Info.Printf("Check for connector '%s' was cancelled: %s", connector.Name, err)
+	} else if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("check timed out after %s: %w", connector.Timeout, err)
+		pluginContext.Error.Printf("Check for connector '%s' failed: %s", connector.Name, err)
+	} else if err != nil {
+		pluginContext.Error.Printf("Check for connector '%s' failed: %s", connector.Name, err)
+	}
+
+	if storeErr := runner.offsets.Set(connector.Name, newOffset); storeErr != nil {
+		pluginContext.Error.Println(storeErr)
+		if err == nil {
+			err = storeErr
+		}
+	}
+	if connector.log.Enabled(ctx, slog.LevelDebug) {
+		updated, _ := runner.offsets.Get(connector.Name)
+		logOffsetDiff(connector, rawOffset, updated)
+	}
+
+	if err == nil && connector.partialFailure.Load() {
+		err = fmt.Errorf("delivery to some sinks failed for connector '%s'", connector.Name)
+	}
+	if err == nil && redeliveryErr != nil {
+		err = redeliveryErr
+	}
+	if err == nil && connector.queued.Load() {
+		err = fmt.Errorf("some messages of connector '%s' were queued for redelivery", connector.Name)
+	}
+
+	return err
+}
+
+// logOffsetDiff logs how a check changed the offset of a connector at debug level, e.g. which entries it handled
+func logOffsetDiff(connector *ConnectorRuntime, before, after json.RawMessage) {
+	if bytes.Equal(before, after) {
+		connector.log.Debug("Offset is unchanged")
+		return
+	}
+
+	changes, err := diffOffsets(*connector.Plugin, before, after)
+	if err != nil {
+		connector.log.Debug("Offset changed", "before", string(before), "after", string(after))
+		return
+	}
+	for _, change := range changes {
+		connector.log.Debug("Offset changed", "change", change)
+	}
+}
+
+// decodeOffset parses a stored offset into the type the plugin expects
+func decodeOffset(plugin Plugin, raw json.RawMessage) (interface{}, error) {
+	offsetPrototype := plugin.OffsetPrototype()
+	offsetRef := reflect.New(reflect.TypeOf(offsetPrototype))
+	offsetRef.Elem().Set(reflect.ValueOf(offsetPrototype))
+	if err := json.Unmarshal(raw, offsetRef.Interface()); err != nil {
+		return nil, err
+	}
+
+	return offsetRef.Elem().Interface(), nil
+}
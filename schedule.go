@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"github.com/robfig/cron/v3"
+	"time"
+)
+
+// Schedule determines when a connector is checked in daemon mode
+type Schedule struct {
+	cron.Schedule
+	Description string
+	// Immediate determines whether the first check happens right away instead of at the first scheduled time
+	Immediate bool
+}
+
+// IntervalSchedule checks a connector whenever the given interval has passed since the last check finished
+func IntervalSchedule(interval time.Duration) Schedule {
+	return Schedule{
+		Schedule:    cron.Every(interval),
+		Description: fmt.Sprintf("every %s", interval),
+		Immediate:   true,
+	}
+}
+
+// CronSchedule checks a connector according to a standard cron expression, evaluated in the given timezone
+func CronSchedule(expression, timezone string) (Schedule, error) {
+	schedule, err := cron.ParseStandard(expression)
+	if err != nil {
+		return Schedule{}, fmt.Errorf("invalid cron expression '%s': %w", expression, err)
+	}
+
+	description := fmt.Sprintf("on schedule '%s'", expression)
+	if len(timezone) > 0 {
+		location, err := time.LoadLocation(timezone)
+		if err != nil {
+			return Schedule{}, fmt.Errorf("invalid timezone '%s': %w", timezone, err)
+		}
+
+		if specSchedule, ok := schedule.(*cron.SpecSchedule); ok {
+			specSchedule.Location = location
+		}
+		description = fmt.Sprintf("%s in timezone %s", description, location)
+	}
+
+	return Schedule{
+		Schedule:    schedule,
+		Description: description,
+	}, nil
+}
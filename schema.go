@@ -0,0 +1,511 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Schema is the subset of JSON Schema needed to describe the config
+type Schema struct {
+	Schema               string             `json:"$schema,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Type                 schemaType         `json:"type,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Const                string             `json:"const,omitempty"`
+	AnyOf                []*Schema          `json:"anyOf,omitempty"`
+	AllOf                []*Schema          `json:"allOf,omitempty"`
+	If                   *Schema            `json:"if,omitempty"`
+	Then                 *Schema            `json:"then,omitempty"`
+
+	// closed forbids any properties that aren't listed, which is encoded as `additionalProperties: false`
+	closed bool
+}
+
+func (schema *Schema) MarshalJSON() ([]byte, error) {
+	type plain Schema
+	if !schema.closed {
+		return json.Marshal((*plain)(schema))
+	}
+
+	return json.Marshal(struct {
+		*plain
+		AdditionalProperties bool `json:"additionalProperties"`
+	}{(*plain)(schema), false})
+}
+
+// schemaType is a single type, or a list of allowed types
+type schemaType []string
+
+func (types schemaType) MarshalJSON() ([]byte, error) {
+	if len(types) == 1 {
+		return json.Marshal(types[0])
+	}
+
+	return json.Marshal([]string(types))
+}
+
+// schemaProvider is implemented by config types whose structure can't be derived from their fields
+type schemaProvider interface {
+	jsonSchema() *Schema
+}
+
+// tagMode selects which struct tags name the keys of a type, as the main config is decoded from YAML while the configs
+// of plugins, sinks and state stores are decoded via mapstructure
+type tagMode string
+
+const (
+	yamlTags         tagMode = "yaml"
+	mapstructureTags tagMode = "mapstructure"
+)
+
+var (
+	durationType       = reflect.TypeOf(time.Duration(0))
+	timeType           = reflect.TypeOf(time.Time{})
+	schemaProviderType = reflect.TypeOf((*schemaProvider)(nil)).Elem()
+	unmarshalerType    = reflect.TypeOf((*yaml.Unmarshaler)(nil)).Elem()
+)
+
+// GenerateSchema derives the schema of the config from its types as well as those of the available plugins, sinks
+// and state stores
+func (loader ConfigLoader) GenerateSchema() *Schema {
+	schema := schemaOf(reflect.TypeOf(Config{}), yamlTags)
+	schema.Schema = "https://json-schema.org/draft/2020-12/schema"
+	schema.Title = "sanderson-notifications config"
+	schema.Properties[includeKey] = &Schema{AnyOf: []*Schema{
+		{Type: schemaType{"string"}},
+		{Type: schemaType{"array"}, Items: &Schema{Type: schemaType{"string"}}},
+	}}
+
+	plugins := make(map[string]*Schema)
+	for name, builder := range loader.AvailablePlugins {
+		plugins[name] = schemaOf(reflect.TypeOf(builder()), mapstructureTags)
+	}
+	schema.Properties["shared"] = &Schema{Type: schemaType{"object"}, Properties: plugins, closed: true}
+	withVariants(schema.Properties["connectors"].AdditionalProperties, "plugin", plugins)
+	withVariants(schema.Properties["templates"].AdditionalProperties, "plugin", plugins)
+
+	sinks := make(map[string]*Schema)
+	for name, builder := range loader.AvailableSinks {
+		sinks[name] = schemaOf(reflect.TypeOf(builder()), mapstructureTags)
+	}
+	withVariants(schema.Properties["sinks"].AdditionalProperties, "type", sinks)
+
+	stores := make(map[string]*Schema)
+	for name, builder := range loader.AvailableStores {
+		stores[name] = schemaOf(reflect.TypeOf(builder()), mapstructureTags)
+	}
+	withVariants(schema.Properties["state"], "type", stores)
+
+	return schema
+}
+
+// withVariants restricts the `config` of an object to the schema of the variant selected by the given key
+func withVariants(schema *Schema, key string, variants map[string]*Schema) {
+	names := make([]string, 0, len(variants))
+	for name := range variants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	schema.Properties[key].Enum = names
+	for _, name := range names {
+		schema.AllOf = append(schema.AllOf, &Schema{
+			If:   &Schema{Properties: map[string]*Schema{key: {Const: name}}, Required: []string{key}},
+			Then: &Schema{Properties: map[string]*Schema{"config": variants[name]}},
+		})
+	}
+}
+
+func schemaOf(t reflect.Type, mode tagMode) *Schema {
+	if t.Kind() == reflect.Pointer {
+		return schemaOf(t.Elem(), mode)
+	}
+	if t.Implements(schemaProviderType) {
+		return reflect.Zero(t).Interface().(schemaProvider).jsonSchema()
+	}
+	if t.Implements(unmarshalerType) || reflect.PointerTo(t).Implements(unmarshalerType) {
+		return &Schema{}
+	}
+
+	switch t {
+	case durationType:
+		return &Schema{Type: schemaType{"string", "integer"}}
+	case timeType:
+		return &Schema{Type: schemaType{"string"}}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return &Schema{Type: schemaType{"string"}}
+	case reflect.Bool:
+		return &Schema{Type: schemaType{"boolean"}}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: schemaType{"integer"}}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: schemaType{"number"}}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: schemaType{"array"}, Items: schemaOf(t.Elem(), mode)}
+	case reflect.Map:
+		return &Schema{Type: schemaType{"object"}, AdditionalProperties: schemaOf(t.Elem(), mode)}
+	case reflect.Struct:
+		schema := &Schema{Type: schemaType{"object"}, Properties: make(map[string]*Schema), closed: true}
+		addFields(schema, t, mode)
+		return schema
+	default:
+		return &Schema{}
+	}
+}
+
+// addFields adds the exported fields of a struct to the properties of its schema, named like the decoder does
+func addFields(schema *Schema, t reflect.Type, mode tagMode) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, options, _ := strings.Cut(field.Tag.Get(string(mode)), ",")
+		if name == "-" {
+			continue
+		}
+		if slices.Contains(strings.Split(options, ","), "inline") || slices.Contains(strings.Split(options, ","), "squash") {
+			addFields(schema, field.Type, mode)
+			continue
+		}
+		if len(name) == 0 {
+			if mode == yamlTags {
+				name = strings.ToLower(field.Name)
+			} else {
+				name = lowerCamelCase(field.Name)
+			}
+		}
+
+		schema.Properties[name] = schemaOf(field.Type, mode)
+	}
+}
+
+// lowerCamelCase lowers the leading initialism or letter of a field name, e.g. 'URL' to 'url' and 'HTTPTimeout' to
+// 'httpTimeout', which is how options are written in configs
+func lowerCamelCase(name string) string {
+	upper := 0
+	for upper < len(name) && unicode.IsUpper(rune(name[upper])) {
+		upper++
+	}
+	if upper > 1 && upper < len(name) {
+		upper--
+	}
+	if upper == 0 {
+		upper = 1
+	}
+
+	return strings.ToLower(name[:upper]) + name[upper:]
+}
+
+func (RawConnectorSink) jsonSchema() *Schema {
+	return &Schema{AnyOf: []*Schema{
+		{Type: schemaType{"string"}},
+		{
+			Type: schemaType{"object"},
+			Properties: map[string]*Schema{
+				"sink":   {Type: schemaType{"string"}},
+				"config": {Type: schemaType{"object"}},
+			},
+			closed: true,
+		},
+	}}
+}
+
+// SchemaError locates a violation of the schema in the config
+type SchemaError struct {
+	File    string
+	Line    int
+	Column  int
+	Path    string
+	Message string
+
+	// unknownKey is the path of the key for errors about keys the schema doesn't allow
+	unknownKey string
+}
+
+func (err SchemaError) Error() string {
+	location := configLocation{File: err.File, Line: err.Line, Column: err.Column}
+	if len(err.Path) == 0 {
+		return fmt.Sprintf("%s: %s", location, err.Message)
+	}
+
+	return fmt.Sprintf("%s: %s: %s", location, err.Path, err.Message)
+}
+
+// CheckSchema checks the config file at the given path and all files it includes against the schema, returning all
+// violations in the order they appear. As included files have the same structure, each of them is checked on its own.
+// Values given by environment variables are checked on their own as well.
+func (loader ConfigLoader) CheckSchema(path string) ([]SchemaError, error) {
+	schema := loader.GenerateSchema()
+
+	environment, err := loader.environmentConfig(os.Environ())
+	if err != nil {
+		return nil, err
+	}
+
+	files := []string{path}
+	var errs []SchemaError
+	for i := 0; i < len(files); i++ {
+		content, err := readConfigFile(files[i])
+		if i == 0 && errors.Is(err, fs.ErrNotExist) && environment != nil {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not read config file: %w", err)
+		}
+
+		document, err := parseConfigDocument(files[i], content)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse config file '%s': %w", files[i], err)
+		}
+		root := documentRoot(document)
+		if root == nil {
+			continue
+		}
+
+		if i == 0 {
+			includes, err := takeIncludes(root)
+			if err != nil {
+				return nil, err
+			}
+			included, _, err := includedFiles(includes, filepath.Dir(path))
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, included...)
+		}
+
+		// Missing variables are reported when loading the config, but their values may change the type of values
+		_ = interpolate(document)
+
+		var fileErrs []SchemaError
+		schema.validate(root, "", &fileErrs)
+		sort.SliceStable(fileErrs, func(i, j int) bool {
+			return fileErrs[i].Line < fileErrs[j].Line ||
+				(fileErrs[i].Line == fileErrs[j].Line && fileErrs[i].Column < fileErrs[j].Column)
+		})
+		for _, err := range fileErrs {
+			err.File = files[i]
+			errs = append(errs, err)
+		}
+	}
+
+	if environment != nil {
+		var envErrs []SchemaError
+		schema.validate(documentRoot(environment), "", &envErrs)
+		for _, err := range envErrs {
+			err.File = "environment"
+			errs = append(errs, err)
+		}
+	}
+
+	return errs, nil
+}
+
+// checkUnknownKeys reports all keys of the merged config document that the schema doesn't allow, e.g. misspelled
+// options that would otherwise be ignored silently, along with where they're given. Other problems are left to
+// decoding the config.
+func (loader ConfigLoader) checkUnknownKeys(document *yaml.Node, locations configLocations) error {
+	root := documentRoot(document)
+	if root == nil {
+		return nil
+	}
+
+	var schemaErrs []SchemaError
+	loader.GenerateSchema().validate(root, "", &schemaErrs)
+
+	var errs []error
+	for _, err := range schemaErrs {
+		if len(err.unknownKey) == 0 {
+			continue
+		}
+		message := err.Message
+		if len(err.Path) > 0 {
+			message = fmt.Sprintf("%s: %s", err.Path, message)
+		}
+		if location, ok := locations.find(err.unknownKey); ok {
+			message = fmt.Sprintf("%s: %s", location, message)
+		}
+		errs = append(errs, errors.New(message))
+	}
+
+	return errors.Join(errs...)
+}
+
+func (schema *Schema) validate(node *yaml.Node, path string, errs *[]SchemaError) {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	fail := func(at *yaml.Node, message string, args ...any) {
+		*errs = append(*errs, SchemaError{Line: at.Line, Column: at.Column, Path: path, Message: fmt.Sprintf(message, args...)})
+	}
+
+	if node.Kind == yaml.ScalarNode && node.ShortTag() == "!!null" {
+		return
+	}
+
+	if len(schema.Type) > 0 {
+		actual := nodeType(node)
+		if !slices.Contains(schema.Type, actual) && !(actual == "integer" && slices.Contains(schema.Type, "number")) {
+			fail(node, "expected %s, got %s", strings.Join(schema.Type, " or "), actual)
+			return
+		}
+	}
+
+	if len(schema.Enum) > 0 && !slices.Contains(schema.Enum, node.Value) {
+		fail(node, "unknown value '%s', must be one of %s", node.Value, strings.Join(schema.Enum, ", "))
+	}
+	if len(schema.Const) > 0 && node.Value != schema.Const {
+		fail(node, "must be '%s'", schema.Const)
+	}
+
+	if len(schema.AnyOf) > 0 {
+		// Report the problems with the first option of the right type, as the others hardly help
+		var optionErrs [][]SchemaError
+		reported := -1
+		for i, option := range schema.AnyOf {
+			var errs []SchemaError
+			option.validate(node, path, &errs)
+			if len(errs) == 0 {
+				optionErrs = nil
+				break
+			}
+			optionErrs = append(optionErrs, errs)
+			if reported < 0 && slices.Contains(option.Type, nodeType(node)) {
+				reported = i
+			}
+		}
+		if len(optionErrs) > 0 {
+			*errs = append(*errs, optionErrs[max(reported, 0)]...)
+		}
+	}
+
+	for _, part := range schema.AllOf {
+		if part.If != nil {
+			var conditionErrs []SchemaError
+			part.If.validate(node, path, &conditionErrs)
+			if len(conditionErrs) == 0 && part.Then != nil {
+				part.Then.validate(node, path, errs)
+			}
+			continue
+		}
+		part.validate(node, path, errs)
+	}
+
+	switch node.Kind {
+	case yaml.MappingNode:
+		for _, key := range schema.Required {
+			if value, _ := mappingValue(node, key); value == nil {
+				fail(node, "missing key '%s'", key)
+			}
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if key.Value == "<<" {
+				continue
+			}
+			keyPath := key.Value
+			if len(path) > 0 {
+				keyPath = fmt.Sprintf("%s.%s", path, key.Value)
+			}
+
+			if property, ok := schema.Properties[key.Value]; ok {
+				property.validate(value, keyPath, errs)
+			} else if schema.AdditionalProperties != nil {
+				schema.AdditionalProperties.validate(value, keyPath, errs)
+			} else if schema.closed {
+				message := fmt.Sprintf("unknown key '%s'", key.Value)
+				if suggestion := closestKey(key.Value, schema.Properties); len(suggestion) > 0 {
+					message = fmt.Sprintf("%s, did you mean '%s'?", message, suggestion)
+				}
+				fail(key, "%s", message)
+				(*errs)[len(*errs)-1].unknownKey = keyPath
+			}
+		}
+	case yaml.SequenceNode:
+		if schema.Items != nil {
+			for i, item := range node.Content {
+				schema.Items.validate(item, fmt.Sprintf("%s[%d]", path, i), errs)
+			}
+		}
+	}
+}
+
+// nodeType returns the JSON Schema type of a YAML node
+func nodeType(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.MappingNode:
+		return "object"
+	case yaml.SequenceNode:
+		return "array"
+	}
+
+	switch node.ShortTag() {
+	case "!!int":
+		return "integer"
+	case "!!float":
+		return "number"
+	case "!!bool":
+		return "boolean"
+	case "!!null":
+		return "null"
+	default:
+		return "string"
+	}
+}
+
+// closestKey suggests the known key most similar to an unknown one, if any is similar enough to be a typo
+func closestKey(key string, properties map[string]*Schema) string {
+	best, bestDistance := "", len(key)/3+1
+	for property := range properties {
+		if distance := editDistance(strings.ToLower(key), strings.ToLower(property)); distance < bestDistance ||
+			(distance == bestDistance && len(best) > 0 && property < best) {
+			best, bestDistance = property, distance
+		}
+	}
+
+	return best
+}
+
+// editDistance is the Levenshtein distance between two strings
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+
+	return previous[len(b)]
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	. "17thshard.com/sanderson-notifications/common"
+	"17thshard.com/sanderson-notifications/state"
+	"encoding/json"
+	"time"
+)
+
+// sentMessageTTL is how long messages can be edited after they were posted
+const sentMessageTTL = 30 * 24 * time.Hour
+
+type sentMessageEntry struct {
+	SentMessage
+	Sent time.Time `json:"sent"`
+}
+
+// SentMessages keeps the messages sinks posted in the state store, forgetting them once they're too old to be edited
+type SentMessages struct {
+	offsets *Offsets
+}
+
+func NewSentMessages(offsets *Offsets) *SentMessages {
+	sent := &SentMessages{offsets: offsets}
+	for key := range offsets.Entries(state.MessagesBucket) {
+		if _, ok := sent.entry(key); !ok {
+			offsets.DeleteEntry(state.MessagesBucket, key)
+		}
+	}
+
+	return sent
+}
+
+func (sent *SentMessages) entry(key string) (sentMessageEntry, bool) {
+	var entry sentMessageEntry
+	raw, ok := sent.offsets.Entry(state.MessagesBucket, key)
+	if !ok || json.Unmarshal(raw, &entry) != nil || time.Since(entry.Sent) > sentMessageTTL {
+		return entry, false
+	}
+
+	return entry, true
+}
+
+func (sent *SentMessages) SentMessage(key string) (SentMessage, bool) {
+	entry, ok := sent.entry(key)
+	return entry.SentMessage, ok
+}
+
+func (sent *SentMessages) SetSentMessage(key string, message SentMessage) error {
+	return sent.offsets.SetEntry(state.MessagesBucket, key, sentMessageEntry{SentMessage: message, Sent: time.Now()})
+}
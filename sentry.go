@@ -0,0 +1,121 @@
+package main
+
+import (
+	. "17thshard.com/sanderson-notifications/common"
+	"context"
+	"encoding/json"
+	"github.com/getsentry/sentry-go"
+	"log"
+	"time"
+)
+
+const sentryFlushTimeout = 5 * time.Second
+
+// SentryConfig configures the reporting of errors to Sentry or a compatible service such as GlitchTip
+type SentryConfig struct {
+	DSN         string `yaml:"dsn"`
+	Environment string `yaml:"environment"`
+}
+
+// ErrorReporter sends failed checks and deliveries to Sentry and the admin webhook. A nil reporter discards all errors.
+type ErrorReporter struct {
+	sentry bool
+	admin  *AdminReporter
+}
+
+// NewErrorReporter sets up the Sentry client and the admin webhook, returning nil if neither is configured
+func NewErrorReporter(config *Config, errorLog *log.Logger) *ErrorReporter {
+	reporter := &ErrorReporter{admin: NewAdminReporter(config, errorLog)}
+
+	if len(config.Sentry.DSN) > 0 {
+		err := sentry.Init(sentry.ClientOptions{
+			Dsn:         config.Sentry.DSN,
+			Environment: config.Sentry.Environment,
+			Release:     "sanderson-notifications@" + version,
+		})
+		if err != nil {
+			errorLog.Printf("Failed to set up error reporting, continuing without it: %s", err)
+		}
+		reporter.sentry = err == nil
+	}
+
+	if !reporter.sentry && reporter.admin == nil {
+		return nil
+	}
+
+	return reporter
+}
+
+// CheckFailed reports a failed check of a connector along with the offset it started from
+func (reporter *ErrorReporter) CheckFailed(connector *ConnectorRuntime, offset json.RawMessage, err error) {
+	if reporter == nil {
+		return
+	}
+
+	reporter.admin.CheckFailed(connector, err)
+	if !reporter.sentry {
+		return
+	}
+
+	sentry.WithScope(func(scope *sentry.Scope) {
+		scope.SetTag("connector", connector.Name)
+		scope.SetTag("plugin", (*connector.Plugin).Name())
+		scope.SetTag("stage", "check")
+		if offset != nil {
+			scope.SetContext("offset", sentry.Context{"value": string(offset)})
+		}
+		sentry.CaptureException(err)
+	})
+}
+
+// DeliveryFailed reports a message that couldn't be delivered to a sink
+func (reporter *ErrorReporter) DeliveryFailed(connector *ConnectorRuntime, sink string, message Message, err error) {
+	if reporter == nil {
+		return
+	}
+
+	reporter.admin.DeliveryFailed(connector, sink, err)
+	if !reporter.sentry {
+		return
+	}
+
+	sentry.WithScope(func(scope *sentry.Scope) {
+		scope.SetTag("connector", connector.Name)
+		scope.SetTag("plugin", (*connector.Plugin).Name())
+		scope.SetTag("stage", "delivery")
+		scope.SetTag("sink", sink)
+		scope.SetContext("message", sentry.Context{
+			"text":     message.Text,
+			"username": message.Username,
+			"embed":    message.Embed,
+		})
+		sentry.CaptureException(err)
+	})
+}
+
+// Flush waits for all reported errors to be sent
+func (reporter *ErrorReporter) Flush() {
+	if reporter == nil || !reporter.sentry {
+		return
+	}
+
+	sentry.Flush(sentryFlushTimeout)
+}
+
+// reportingSink reports messages that could not be delivered to a single sink. Failures of fan-out sinks are reported
+// per sink as they happen instead.
+type reportingSink struct {
+	Sink
+	name      string
+	connector *ConnectorRuntime
+	reporter  *ErrorReporter
+}
+
+func (sink *reportingSink) Deliver(ctx context.Context, message Message) error {
+	err := sink.Sink.Deliver(ctx, message)
+	if err != nil && ctx.Err() == nil {
+		sink.reporter.DeliveryFailed(sink.connector, sink.name, message, err)
+	}
+
+	return err
+}
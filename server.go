@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+// ServerConfig configures the HTTP server that is available in daemon mode
+type ServerConfig struct {
+	Listen     string `yaml:"listen"`
+	AdminToken string `yaml:"adminToken"`
+}
+
+// Server serves the HTTP endpoints of the daemon until its context is cancelled
+type Server struct {
+	config ServerConfig
+	mux    *http.ServeMux
+	info   *log.Logger
+	error  *log.Logger
+}
+
+func NewServer(config ServerConfig, info, error *log.Logger) *Server {
+	return &Server{config: config, mux: http.NewServeMux(), info: info, error: error}
+}
+
+func (server *Server) Handle(pattern string, handler http.Handler) {
+	server.mux.Handle(pattern, handler)
+}
+
+func (server *Server) Run(ctx context.Context) {
+	httpServer := &http.Server{Addr: server.config.Listen, Handler: server.mux}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = httpServer.Shutdown(shutdownCtx)
+	}()
+
+	server.info.Printf("Listening for HTTP requests on %s", server.config.Listen)
+	if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		server.error.Printf("HTTP server failed: %s", err)
+	}
+}
@@ -0,0 +1,88 @@
+package sinks
+
+import (
+	"17thshard.com/sanderson-notifications/common"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// AppriseSink delivers notifications through Apprise, either by calling an Apprise API server or by running the CLI
+type AppriseSink struct {
+	URL     string `mapstructure:"url"`
+	Key     string
+	URLs    []string `mapstructure:"urls"`
+	Tag     string
+	Command string
+}
+
+func (sink *AppriseSink) Name() string {
+	return "apprise"
+}
+
+func (sink *AppriseSink) Validate() error {
+	if len(sink.URL) == 0 && len(sink.Command) == 0 {
+		return fmt.Errorf("either API URL or command for Apprise must be specified")
+	}
+
+	if len(sink.URL) > 0 && len(sink.Command) > 0 {
+		return fmt.Errorf("only one of API URL and command for Apprise may be specified")
+	}
+
+	if len(sink.Key) == 0 && len(sink.URLs) == 0 {
+		return fmt.Errorf("either a configuration key or notification URLs for Apprise must be specified")
+	}
+
+	if len(sink.Command) > 0 && len(sink.URLs) == 0 {
+		return fmt.Errorf("notification URLs for Apprise must be specified when using the command")
+	}
+
+	return nil
+}
+
+func (sink *AppriseSink) Deliver(ctx context.Context, message common.Message) error {
+	if len(sink.Command) > 0 {
+		return sink.runCommand(ctx, message)
+	}
+
+	return sink.callAPI(ctx, message)
+}
+
+func (sink *AppriseSink) callAPI(ctx context.Context, message common.Message) error {
+	body := map[string]interface{}{
+		"title":  message.Username,
+		"body":   renderMarkdown(message),
+		"format": "markdown",
+	}
+
+	endpoint := fmt.Sprintf("%s/notify/", strings.TrimSuffix(sink.URL, "/"))
+	if len(sink.Key) > 0 {
+		endpoint = fmt.Sprintf("%s%s", endpoint, sink.Key)
+	} else {
+		body["urls"] = strings.Join(sink.URLs, ",")
+	}
+
+	if len(sink.Tag) > 0 {
+		body["tag"] = sink.Tag
+	}
+
+	_, err := postJSON(ctx, "Apprise", endpoint, body, nil)
+
+	return err
+}
+
+func (sink *AppriseSink) runCommand(ctx context.Context, message common.Message) error {
+	args := []string{"--title", message.Username, "--body", renderMarkdown(message), "--input-format", "markdown"}
+	if len(sink.Tag) > 0 {
+		args = append(args, "--tag", sink.Tag)
+	}
+	args = append(args, sink.URLs...)
+
+	output, err := exec.CommandContext(ctx, sink.Command, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("couldn't send Apprise notification: %w: %s", err, string(output))
+	}
+
+	return nil
+}
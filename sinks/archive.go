@@ -0,0 +1,53 @@
+package sinks
+
+import (
+	"17thshard.com/sanderson-notifications/common"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// ArchiveSink appends every message as a single JSON line to a file, or writes it to stdout if no path is given
+type ArchiveSink struct {
+	Path string
+
+	lock sync.Mutex
+}
+
+func (sink *ArchiveSink) Name() string {
+	return "archive"
+}
+
+func (sink *ArchiveSink) Validate() error {
+	return nil
+}
+
+func (sink *ArchiveSink) Deliver(ctx context.Context, message common.Message) error {
+	serialized, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("could not serialize message for archive: %w", err)
+	}
+	serialized = append(serialized, '\n')
+
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+
+	var out io.Writer = os.Stdout
+	if len(sink.Path) > 0 && sink.Path != "-" {
+		f, err := os.OpenFile(sink.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return fmt.Errorf("could not open archive %s: %w", sink.Path, err)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	if _, err = out.Write(serialized); err != nil {
+		return fmt.Errorf("could not write to archive %s: %w", sink.Path, err)
+	}
+
+	return nil
+}
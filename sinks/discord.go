@@ -0,0 +1,93 @@
+package sinks
+
+import (
+	"17thshard.com/sanderson-notifications/common"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// maxForumTags is the maximum number of tags Discord allows on a forum post
+const maxForumTags = 5
+
+// webhookURLPrefixes are removed from webhooks given as full URL instead of just their ID
+var webhookURLPrefixes = []string{"https://discord.com/api/webhooks/", "https://discordapp.com/api/webhooks/"}
+
+type DiscordSink struct {
+	Webhook string
+	// WebhookEnv and WebhookFile name an environment variable or file to read the webhook from instead
+	WebhookEnv  string `mapstructure:"webhookEnv"`
+	WebhookFile string `mapstructure:"webhookFile"`
+	Mentions    common.DiscordMentions
+	// ThreadID posts all messages into an existing thread
+	ThreadID string `mapstructure:"threadId"`
+	// ThreadName creates a new thread with this name for every message
+	ThreadName string `mapstructure:"threadName"`
+	// Tags are the IDs of forum tags to apply to created threads
+	Tags []string
+	// BotToken authorizes publishing messages in announcement channels
+	BotToken string `mapstructure:"botToken"`
+	// Crosspost publishes every message, so servers following the announcement channel receive it as well
+	Crosspost bool
+
+	client common.DiscordClient
+}
+
+func (sink *DiscordSink) Name() string {
+	return "discord"
+}
+
+func (sink *DiscordSink) Validate() error {
+	webhook, err := common.ResolveSecret("webhook for Discord", sink.Webhook, sink.WebhookEnv, sink.WebhookFile)
+	if err != nil {
+		return err
+	}
+	for _, prefix := range webhookURLPrefixes {
+		webhook = strings.TrimPrefix(webhook, prefix)
+	}
+	if len(webhook) == 0 {
+		return fmt.Errorf("webhook for Discord must not be empty")
+	}
+
+	if len(sink.ThreadID) > 0 && len(sink.ThreadName) > 0 {
+		return fmt.Errorf("Discord messages can either be posted to an existing thread or create new ones, not both")
+	}
+
+	if len(sink.Tags) > 0 && len(sink.ThreadName) == 0 {
+		return fmt.Errorf("tags can only be applied to Discord forum posts, which require a thread name")
+	}
+	if len(sink.Tags) > maxForumTags {
+		return fmt.Errorf("at most %d tags can be applied to Discord forum posts, got %d", maxForumTags, len(sink.Tags))
+	}
+
+	if sink.Crosspost && len(sink.BotToken) == 0 {
+		return fmt.Errorf("publishing Discord messages requires a bot token")
+	}
+	if sink.Crosspost && (len(sink.ThreadID) > 0 || len(sink.ThreadName) > 0) {
+		return fmt.Errorf("Discord messages in threads can't be published")
+	}
+
+	sink.client = common.CreateDiscordClient(
+		webhook,
+		sink.Mentions,
+		common.DiscordThread{ID: sink.ThreadID, Name: sink.ThreadName, Tags: sink.Tags},
+		common.DiscordBot{Token: sink.BotToken, Crosspost: sink.Crosspost},
+	)
+
+	return nil
+}
+
+// Diagnose checks that the webhook exists
+func (sink *DiscordSink) Diagnose(ctx context.Context) error {
+	return sink.client.Verify(ctx)
+}
+
+func (sink *DiscordSink) Deliver(ctx context.Context, message common.Message) error {
+	return sink.client.Deliver(ctx, message)
+}
+
+// Preview renders the payloads posted to the webhook for the message
+func (sink *DiscordSink) Preview(message common.Message) ([]json.RawMessage, error) {
+	return sink.client.Preview(message)
+}
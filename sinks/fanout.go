@@ -0,0 +1,52 @@
+package sinks
+
+import (
+	"17thshard.com/sanderson-notifications/common"
+	"context"
+	"errors"
+	"fmt"
+)
+
+// NamedSink is a sink together with the name it was configured with
+type NamedSink struct {
+	Name string
+	Sink common.Sink
+}
+
+// FanOut delivers every message to all of its sinks. Delivery only fails if no sink received the message, failures of
+// individual sinks are reported through OnFailure instead, so they don't cause duplicate messages in the other sinks.
+type FanOut struct {
+	Sinks     []NamedSink
+	OnFailure func(sink string, message common.Message, err error)
+}
+
+func (sink *FanOut) Name() string {
+	return "fan-out"
+}
+
+func (sink *FanOut) Validate() error {
+	if len(sink.Sinks) == 0 {
+		return fmt.Errorf("fan-out requires at least one sink")
+	}
+
+	return nil
+}
+
+func (sink *FanOut) Deliver(ctx context.Context, message common.Message) error {
+	var errs []error
+	for _, target := range sink.Sinks {
+		if err := target.Sink.Deliver(ctx, message); err != nil {
+			err = fmt.Errorf("delivery to sink '%s' failed: %w", target.Name, err)
+			errs = append(errs, err)
+			if sink.OnFailure != nil {
+				sink.OnFailure(target.Name, message, err)
+			}
+		}
+	}
+
+	if len(errs) == len(sink.Sinks) {
+		return errors.Join(errs...)
+	}
+
+	return nil
+}
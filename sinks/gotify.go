@@ -0,0 +1,62 @@
+package sinks
+
+import (
+	"17thshard.com/sanderson-notifications/common"
+	"context"
+	"fmt"
+	"strings"
+)
+
+type GotifySink struct {
+	URL      string `mapstructure:"url"`
+	Token    string
+	Priority int
+	Markdown *bool
+}
+
+func (sink *GotifySink) Name() string {
+	return "gotify"
+}
+
+func (sink *GotifySink) Validate() error {
+	if len(sink.URL) == 0 {
+		return fmt.Errorf("server URL for Gotify must not be empty")
+	}
+
+	if len(sink.Token) == 0 {
+		return fmt.Errorf("app token for Gotify must not be empty")
+	}
+
+	if sink.Markdown == nil {
+		markdown := true
+		sink.Markdown = &markdown
+	}
+
+	return nil
+}
+
+func (sink *GotifySink) Deliver(ctx context.Context, message common.Message) error {
+	body := map[string]interface{}{
+		"title":    message.Username,
+		"message":  renderMarkdown(message),
+		"priority": sink.Priority,
+	}
+
+	if *sink.Markdown {
+		body["extras"] = map[string]interface{}{
+			"client::display": map[string]interface{}{
+				"contentType": "text/markdown",
+			},
+		}
+	}
+
+	_, err := postJSON(
+		ctx,
+		"Gotify",
+		fmt.Sprintf("%s/message", strings.TrimSuffix(sink.URL, "/")),
+		body,
+		map[string]string{"X-Gotify-Key": sink.Token},
+	)
+
+	return err
+}
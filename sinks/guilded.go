@@ -0,0 +1,43 @@
+package sinks
+
+import (
+	"17thshard.com/sanderson-notifications/common"
+	"context"
+	"fmt"
+)
+
+// GuildedSink posts messages to a Guilded webhook, whose format closely follows Discord's
+type GuildedSink struct {
+	Webhook string
+}
+
+func (sink *GuildedSink) Name() string {
+	return "guilded"
+}
+
+func (sink *GuildedSink) Validate() error {
+	if len(sink.Webhook) == 0 {
+		return fmt.Errorf("webhook URL for Guilded must not be empty")
+	}
+
+	return nil
+}
+
+func (sink *GuildedSink) Deliver(ctx context.Context, message common.Message) error {
+	body := map[string]interface{}{
+		"content":  textWithLinks(message),
+		"username": message.Username,
+	}
+
+	if len(message.AvatarURL) > 0 {
+		body["avatar_url"] = message.AvatarURL
+	}
+
+	if message.Embed != nil {
+		body["embeds"] = []interface{}{message.Embed}
+	}
+
+	_, err := postJSON(ctx, "Guilded", sink.Webhook, body, nil)
+
+	return err
+}
@@ -0,0 +1,44 @@
+package sinks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// postJSON sends body as JSON to url, treating any status code outside the 2xx range as failure
+func postJSON(ctx context.Context, service, url string, body interface{}, headers map[string]string) ([]byte, error) {
+	serialized, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("could not serialize %s request: %w", service, err)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(serialized))
+	if err != nil {
+		return nil, fmt.Errorf("could not create %s request: %w", service, err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		request.Header.Set(key, value)
+	}
+
+	res, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("could not send %s request: %w", service, err)
+	}
+	defer res.Body.Close()
+
+	responseBody, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s response: %w", service, err)
+	}
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return nil, fmt.Errorf("couldn't send %s message: %s", service, string(responseBody))
+	}
+
+	return responseBody, nil
+}
@@ -0,0 +1,138 @@
+package sinks
+
+import (
+	"17thshard.com/sanderson-notifications/common"
+	"context"
+	"encoding/json"
+	"fmt"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMQTTTopic = "sanderson-notifications/{connector}"
+	// mqttTimeout limits connecting to the broker and publishing a message
+	mqttTimeout = 30 * time.Second
+)
+
+type MQTTSink struct {
+	Broker   string
+	ClientID string `mapstructure:"clientId"`
+	Username string
+	Password string
+	Topic    string
+	Topics   map[string]string
+	QoS      byte `mapstructure:"qos"`
+	Retain   bool
+
+	client mqtt.Client
+	lock   sync.Mutex
+}
+
+func (sink *MQTTSink) Name() string {
+	return "mqtt"
+}
+
+func (sink *MQTTSink) Validate() error {
+	if len(sink.Broker) == 0 {
+		return fmt.Errorf("broker URL for MQTT must not be empty")
+	}
+
+	if sink.QoS > 2 {
+		return fmt.Errorf("QoS for MQTT must be 0, 1 or 2, got %d", sink.QoS)
+	}
+
+	if len(sink.Topic) == 0 {
+		sink.Topic = defaultMQTTTopic
+	}
+
+	if len(sink.ClientID) == 0 {
+		sink.ClientID = fmt.Sprintf("sanderson-notifications-%d", time.Now().UnixNano())
+	}
+
+	return nil
+}
+
+// TopicFor returns the topic messages of a connector are published to, preferring explicit per-connector topics
+func (sink *MQTTSink) TopicFor(connector string) string {
+	if topic, ok := sink.Topics[connector]; ok {
+		return topic
+	}
+
+	return strings.ReplaceAll(sink.Topic, "{connector}", connector)
+}
+
+func (sink *MQTTSink) Deliver(ctx context.Context, message common.Message) error {
+	ctx, cancel := context.WithTimeout(ctx, mqttTimeout)
+	defer cancel()
+
+	client, err := sink.connect(ctx)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("could not serialize MQTT message: %w", err)
+	}
+
+	topic := sink.TopicFor(message.Connector)
+	if err = waitForToken(ctx, client.Publish(topic, sink.QoS, sink.Retain, payload)); err != nil {
+		return fmt.Errorf("could not publish to MQTT topic '%s': %w", topic, err)
+	}
+
+	return nil
+}
+
+// connect returns the connected client, replacing the previous one if it lost its connection
+func (sink *MQTTSink) connect(ctx context.Context) (mqtt.Client, error) {
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+
+	if sink.client != nil {
+		if sink.client.IsConnected() {
+			return sink.client, nil
+		}
+		sink.client.Disconnect(0)
+		sink.client = nil
+	}
+
+	options := mqtt.NewClientOptions().
+		AddBroker(sink.Broker).
+		SetClientID(sink.ClientID).
+		SetUsername(sink.Username).
+		SetPassword(sink.Password).
+		SetConnectTimeout(mqttTimeout)
+
+	client := mqtt.NewClient(options)
+	if err := waitForToken(ctx, client.Connect()); err != nil {
+		client.Disconnect(0)
+		return nil, fmt.Errorf("could not connect to MQTT broker '%s': %w", sink.Broker, err)
+	}
+	sink.client = client
+
+	return client, nil
+}
+
+// waitForToken waits until the operation of the token completes or the context is done
+func waitForToken(ctx context.Context, token mqtt.Token) error {
+	select {
+	case <-token.Done():
+		return token.Error()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (sink *MQTTSink) Close() error {
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+
+	if sink.client != nil && sink.client.IsConnected() {
+		sink.client.Disconnect(250)
+	}
+
+	return nil
+}
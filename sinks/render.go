@@ -0,0 +1,62 @@
+package sinks
+
+import (
+	"17thshard.com/sanderson-notifications/common"
+	"fmt"
+	"strings"
+)
+
+// embedOf returns the embed of a message, which is empty if the message has none
+func embedOf(message common.Message) *common.Embed {
+	if message.Embed == nil {
+		return &common.Embed{}
+	}
+
+	return message.Embed
+}
+
+// renderMarkdown flattens a message including its embed into a single Markdown text
+func renderMarkdown(message common.Message) string {
+	var builder strings.Builder
+	builder.WriteString(message.Text)
+
+	embed := embedOf(message)
+	if len(embed.Title) > 0 && len(embed.URL) > 0 {
+		builder.WriteString(fmt.Sprintf("\n\n**[%s](%s)**", embed.Title, embed.URL))
+	} else if len(embed.Title) > 0 {
+		builder.WriteString(fmt.Sprintf("\n\n**%s**", embed.Title))
+	}
+	if len(embed.Description) > 0 {
+		builder.WriteString(fmt.Sprintf("\n\n%s", embed.Description))
+	}
+	for _, field := range embed.Fields {
+		builder.WriteString(fmt.Sprintf("\n\n**%s**: %s", field.Name, field.Value))
+	}
+	if footer := embed.FooterText(); len(footer) > 0 {
+		builder.WriteString(fmt.Sprintf("\n\n_%s_", footer))
+	}
+	if links := buttonLinks(message); len(links) > 0 {
+		builder.WriteString(fmt.Sprintf("\n\n%s", links))
+	}
+
+	return builder.String()
+}
+
+// buttonLinks renders the buttons of a message as Markdown links, which is empty if there are none
+func buttonLinks(message common.Message) string {
+	links := make([]string, len(message.Buttons))
+	for i, button := range message.Buttons {
+		links[i] = fmt.Sprintf("[%s](%s)", button.Label, button.URL)
+	}
+
+	return strings.Join(links, " · ")
+}
+
+// textWithLinks appends the buttons of a message to its text, for targets that don't support buttons
+func textWithLinks(message common.Message) string {
+	if links := buttonLinks(message); len(links) > 0 {
+		return fmt.Sprintf("%s\n%s", message.Text, links)
+	}
+
+	return message.Text
+}
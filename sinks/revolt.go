@@ -0,0 +1,76 @@
+package sinks
+
+import (
+	"17thshard.com/sanderson-notifications/common"
+	"context"
+	"fmt"
+	"strings"
+)
+
+const defaultRevoltAPI = "https://api.revolt.chat"
+
+// RevoltSink posts messages to a Revolt channel as a bot, using masquerades to show the plugin's name and avatar
+type RevoltSink struct {
+	API     string `mapstructure:"api"`
+	Token   string
+	Channel string
+}
+
+func (sink *RevoltSink) Name() string {
+	return "revolt"
+}
+
+func (sink *RevoltSink) Validate() error {
+	if len(sink.Token) == 0 {
+		return fmt.Errorf("bot token for Revolt must not be empty")
+	}
+
+	if len(sink.Channel) == 0 {
+		return fmt.Errorf("channel ID for Revolt must not be empty")
+	}
+
+	if len(sink.API) == 0 {
+		sink.API = defaultRevoltAPI
+	}
+
+	return nil
+}
+
+func (sink *RevoltSink) Deliver(ctx context.Context, message common.Message) error {
+	masquerade := map[string]interface{}{
+		"name": message.Username,
+	}
+	if len(message.AvatarURL) > 0 {
+		masquerade["avatar"] = message.AvatarURL
+	}
+
+	body := map[string]interface{}{
+		"content":    textWithLinks(message),
+		"masquerade": masquerade,
+	}
+
+	if embed := message.Embed; embed != nil {
+		description := embed.Description
+		if footer := embed.FooterText(); len(footer) > 0 {
+			description = fmt.Sprintf("%s\n\n%s", description, footer)
+		}
+
+		body["embeds"] = []interface{}{
+			map[string]interface{}{
+				"title":       embed.Title,
+				"url":         embed.URL,
+				"description": strings.TrimSpace(description),
+			},
+		}
+	}
+
+	_, err := postJSON(
+		ctx,
+		"Revolt",
+		fmt.Sprintf("%s/channels/%s/messages", strings.TrimSuffix(sink.API, "/"), sink.Channel),
+		body,
+		map[string]string{"X-Bot-Token": sink.Token},
+	)
+
+	return err
+}
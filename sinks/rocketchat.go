@@ -0,0 +1,75 @@
+package sinks
+
+import (
+	"17thshard.com/sanderson-notifications/common"
+	"context"
+	"fmt"
+)
+
+// RocketChatSink posts messages to a Rocket.Chat incoming webhook, rendering embeds as attachments
+type RocketChatSink struct {
+	Webhook string
+	Channel string
+}
+
+func (sink *RocketChatSink) Name() string {
+	return "rocketchat"
+}
+
+func (sink *RocketChatSink) Validate() error {
+	if len(sink.Webhook) == 0 {
+		return fmt.Errorf("webhook URL for Rocket.Chat must not be empty")
+	}
+
+	return nil
+}
+
+func (sink *RocketChatSink) Deliver(ctx context.Context, message common.Message) error {
+	body := map[string]interface{}{
+		"alias": message.Username,
+		"text":  textWithLinks(message),
+	}
+
+	if len(message.AvatarURL) > 0 {
+		body["avatar"] = message.AvatarURL
+	}
+
+	if len(sink.Channel) > 0 {
+		body["channel"] = sink.Channel
+	}
+
+	if embed := message.Embed; embed != nil {
+		attachment := map[string]interface{}{
+			"title":      embed.Title,
+			"title_link": embed.URL,
+			"text":       embed.Description,
+		}
+		if embed.Image != nil {
+			attachment["image_url"] = embed.Image.URL
+		}
+
+		var fields []interface{}
+		for _, field := range embed.Fields {
+			fields = append(fields, map[string]interface{}{
+				"short": field.Inline,
+				"title": field.Name,
+				"value": field.Value,
+			})
+		}
+		if footer := embed.FooterText(); len(footer) > 0 {
+			fields = append(fields, map[string]interface{}{
+				"short": false,
+				"value": footer,
+			})
+		}
+		if len(fields) > 0 {
+			attachment["fields"] = fields
+		}
+
+		body["attachments"] = []interface{}{attachment}
+	}
+
+	_, err := postJSON(ctx, "Rocket.Chat", sink.Webhook, body, nil)
+
+	return err
+}
@@ -0,0 +1,150 @@
+package sinks
+
+import (
+	"17thshard.com/sanderson-notifications/common"
+	"context"
+	"fmt"
+)
+
+// TeamsSink posts messages to a Microsoft Teams incoming webhook, rendered as Adaptive Cards
+type TeamsSink struct {
+	Webhook string
+}
+
+func (sink *TeamsSink) Name() string {
+	return "teams"
+}
+
+func (sink *TeamsSink) Validate() error {
+	if len(sink.Webhook) == 0 {
+		return fmt.Errorf("webhook URL for Teams must not be empty")
+	}
+
+	return nil
+}
+
+func (sink *TeamsSink) Deliver(ctx context.Context, message common.Message) error {
+	_, err := postJSON(ctx, "Teams", sink.Webhook, map[string]interface{}{
+		"type": "message",
+		"attachments": []interface{}{
+			map[string]interface{}{
+				"contentType": "application/vnd.microsoft.card.adaptive",
+				"content":     buildAdaptiveCard(message),
+			},
+		},
+	}, nil)
+
+	return err
+}
+
+func buildAdaptiveCard(message common.Message) map[string]interface{} {
+	author := map[string]interface{}{
+		"type":   "TextBlock",
+		"text":   message.Username,
+		"weight": "Bolder",
+		"wrap":   true,
+	}
+
+	var header interface{} = author
+	if len(message.AvatarURL) > 0 {
+		header = map[string]interface{}{
+			"type": "ColumnSet",
+			"columns": []interface{}{
+				map[string]interface{}{
+					"type":  "Column",
+					"width": "auto",
+					"items": []interface{}{
+						map[string]interface{}{
+							"type":  "Image",
+							"url":   message.AvatarURL,
+							"size":  "Small",
+							"style": "Person",
+						},
+					},
+				},
+				map[string]interface{}{
+					"type":                     "Column",
+					"width":                    "stretch",
+					"verticalContentAlignment": "Center",
+					"items":                    []interface{}{author},
+				},
+			},
+		}
+	}
+
+	body := []interface{}{
+		header,
+		map[string]interface{}{
+			"type": "TextBlock",
+			"text": message.Text,
+			"wrap": true,
+		},
+	}
+
+	embed := embedOf(message)
+	if title := embed.Title; len(title) > 0 {
+		body = append(body, map[string]interface{}{
+			"type":   "TextBlock",
+			"text":   title,
+			"weight": "Bolder",
+			"wrap":   true,
+		})
+	}
+	if description := embed.Description; len(description) > 0 {
+		body = append(body, map[string]interface{}{
+			"type":     "TextBlock",
+			"text":     description,
+			"fontType": "Monospace",
+			"wrap":     true,
+		})
+	}
+	if len(embed.Fields) > 0 {
+		facts := make([]interface{}, len(embed.Fields))
+		for i, field := range embed.Fields {
+			facts[i] = map[string]interface{}{
+				"title": field.Name,
+				"value": field.Value,
+			}
+		}
+		body = append(body, map[string]interface{}{
+			"type":  "FactSet",
+			"facts": facts,
+		})
+	}
+	if embed.Image != nil {
+		body = append(body, map[string]interface{}{
+			"type": "Image",
+			"url":  embed.Image.URL,
+			"size": "Stretch",
+		})
+	}
+	if footer := embed.FooterText(); len(footer) > 0 {
+		body = append(body, map[string]interface{}{
+			"type":     "TextBlock",
+			"text":     footer,
+			"isSubtle": true,
+			"size":     "Small",
+			"wrap":     true,
+		})
+	}
+
+	card := map[string]interface{}{
+		"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+		"type":    "AdaptiveCard",
+		"version": "1.4",
+		"body":    body,
+	}
+	if len(message.Buttons) > 0 {
+		actions := make([]interface{}, len(message.Buttons))
+		for i, button := range message.Buttons {
+			actions[i] = map[string]interface{}{
+				"type":  "Action.OpenUrl",
+				"title": button.Label,
+				"url":   button.URL,
+			}
+		}
+		card["actions"] = actions
+	}
+
+	return card
+}
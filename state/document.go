@@ -0,0 +1,121 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const bucketPrefix = "$"
+
+// metaKeys are the entries of the meta bucket, which mustn't collide with the names of other buckets. Each namespace
+// has its own entries, whose keys contain a slash and thus can't collide either.
+var metaKeys = []string{"version", "lease"}
+
+// document is a single JSON object holding all state. Offsets are stored at the top level for compatibility with
+// existing offset files, all other buckets are nested in keys prefixed with '$'. Entries of the meta bucket are stored
+// at the top level as well, prefixed with '$', e.g. '$version'.
+type document map[string]json.RawMessage
+
+func parseDocument(content []byte) (document, error) {
+	var doc document
+	if err := json.Unmarshal(content, &doc); err != nil {
+		return nil, fmt.Errorf("could not parse offsets: %w", err)
+	}
+	if doc == nil {
+		doc = make(document)
+	}
+
+	return doc, nil
+}
+
+func (doc document) load(bucket string) (map[string]json.RawMessage, error) {
+	result := make(map[string]json.RawMessage)
+
+	if bucket == OffsetsBucket {
+		for key, value := range doc {
+			if !strings.HasPrefix(key, bucketPrefix) {
+				result[key] = value
+			}
+		}
+
+		return result, nil
+	}
+
+	if bucket == MetaBucket {
+		for key, value := range doc {
+			if key, ok := strings.CutPrefix(key, bucketPrefix); ok && isMetaKey(key) {
+				result[key] = value
+			}
+		}
+
+		return result, nil
+	}
+
+	if raw, ok := doc[bucketPrefix+bucket]; ok {
+		if err := json.Unmarshal(raw, &result); err != nil {
+			return nil, fmt.Errorf("could not parse bucket '%s': %w", bucket, err)
+		}
+	}
+
+	return result, nil
+}
+
+func (doc document) apply(changes Changes) error {
+	for bucket, entries := range changes {
+		if bucket == OffsetsBucket {
+			applyEntries(doc, entries)
+			continue
+		}
+
+		if bucket == MetaBucket {
+			for key, value := range entries {
+				if !isMetaKey(key) {
+					return fmt.Errorf("unknown meta entry '%s'", key)
+				}
+				applyEntries(doc, map[string]json.RawMessage{bucketPrefix + key: value})
+			}
+			continue
+		}
+
+		existing, err := doc.load(bucket)
+		if err != nil {
+			return err
+		}
+		applyEntries(existing, entries)
+
+		serialized, err := json.Marshal(existing)
+		if err != nil {
+			return fmt.Errorf("could not serialize bucket '%s': %w", bucket, err)
+		}
+		doc[bucketPrefix+bucket] = serialized
+	}
+
+	return nil
+}
+
+func (doc document) serialize() ([]byte, error) {
+	serialized, err := json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("could not serialize offsets: %w", err)
+	}
+
+	return serialized, nil
+}
+
+// isMetaKey checks whether the key is an entry of the meta bucket, in any namespace
+func isMetaKey(key string) bool {
+	_, key = SplitNamespace(key)
+	return slices.Contains(metaKeys, key)
+}
+
+func applyEntries(target map[string]json.RawMessage, entries map[string]json.RawMessage) {
+	for key, value := range entries {
+		if value == nil {
+			delete(target, key)
+		} else {
+			target[key] = value
+		}
+	}
+}
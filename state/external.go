@@ -0,0 +1,185 @@
+package state
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// externalSuffix is the extension of files holding single offsets outside the offsets file
+const externalSuffix = ".json.gz"
+
+// unsafeFileChars are replaced in connector names when naming the files their offsets are stored in
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// externalReference replaces an offset in the offsets file that is stored in a separate gzip-compressed file. The path
+// of the file is relative to the directory of the offsets file.
+type externalReference struct {
+	File string `json:"$file"`
+}
+
+// parseReference checks whether an offset refers to a separate file
+func parseReference(value json.RawMessage) (string, bool) {
+	if !bytes.HasPrefix(bytes.TrimSpace(value), []byte(`{"$file"`)) {
+		return "", false
+	}
+
+	var fields map[string]string
+	if json.Unmarshal(value, &fields) != nil || len(fields) != 1 || len(fields["$file"]) == 0 {
+		return "", false
+	}
+
+	return fields["$file"], true
+}
+
+// externalDir is the directory holding the offsets stored outside the offsets file
+func (store *FileStore) externalDir() string {
+	return store.Path + ".d"
+}
+
+// loadExternal replaces all references to separate files in the document with the offsets stored in them
+func (store *FileStore) loadExternal(doc document) error {
+	for key, value := range doc {
+		if strings.HasPrefix(key, bucketPrefix) {
+			continue
+		}
+
+		file, ok := parseReference(value)
+		if !ok {
+			continue
+		}
+
+		offset, err := readCompressed(filepath.Join(filepath.Dir(store.Path), filepath.FromSlash(file)))
+		if err != nil {
+			return fmt.Errorf("could not read offset of '%s' from '%s': %w", key, file, err)
+		}
+		doc[key] = offset
+	}
+
+	return nil
+}
+
+// externalize returns a copy of the document in which offsets larger than the configured size are replaced by
+// references to separate files, writing all files that don't exist yet
+func (store *FileStore) externalize(doc document) (document, error) {
+	if store.ExternalizeAbove <= 0 {
+		return doc, nil
+	}
+
+	result := make(document, len(doc))
+	for key, value := range doc {
+		result[key] = value
+		if strings.HasPrefix(key, bucketPrefix) || len(value) <= store.ExternalizeAbove {
+			continue
+		}
+
+		hash := sha256.Sum256(value)
+		name := fmt.Sprintf("%s-%s%s", unsafeFileChars.ReplaceAllString(key, "_"), hex.EncodeToString(hash[:6]), externalSuffix)
+		path := filepath.Join(store.externalDir(), name)
+
+		// Files are named after their content, so existing ones never need to be written again
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			if err = os.MkdirAll(store.externalDir(), 0755); err != nil {
+				return nil, fmt.Errorf("could not create directory for offsets: %w", err)
+			}
+			if err = writeCompressed(path, value); err != nil {
+				return nil, fmt.Errorf("could not write offset of '%s': %w", key, err)
+			}
+		}
+
+		reference, err := json.Marshal(externalReference{File: filepath.ToSlash(filepath.Join(filepath.Base(store.externalDir()), name))})
+		if err != nil {
+			return nil, err
+		}
+		result[key] = reference
+	}
+
+	return result, nil
+}
+
+// cleanExternal removes all separate offset files that neither the offsets file nor any of its backups refer to
+func (store *FileStore) cleanExternal() error {
+	files, err := filepath.Glob(filepath.Join(store.externalDir(), "*"+externalSuffix))
+	if err != nil || len(files) == 0 {
+		return err
+	}
+
+	backups, err := filepath.Glob(store.Path + ".*.bak")
+	if err != nil {
+		return fmt.Errorf("could not list backups: %w", err)
+	}
+
+	referenced := make(map[string]bool)
+	for _, path := range append(backups, store.Path) {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("could not read '%s': %w", path, err)
+		}
+		doc, err := parseDocument(content)
+		if err != nil {
+			return err
+		}
+
+		for _, value := range doc {
+			if file, ok := parseReference(value); ok {
+				referenced[filepath.Base(filepath.FromSlash(file))] = true
+			}
+		}
+	}
+
+	for _, file := range files {
+		if referenced[filepath.Base(file)] {
+			continue
+		}
+		if err = os.Remove(file); err != nil {
+			return fmt.Errorf("could not remove unused offset file: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func readCompressed(path string) (json.RawMessage, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	if !json.Valid(content) {
+		return nil, fmt.Errorf("file does not contain valid JSON")
+	}
+
+	return content, nil
+}
+
+func writeCompressed(path string, content []byte) error {
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	if _, err := writer.Write(content); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	return writeAtomically(path, compressed.Bytes())
+}
@@ -0,0 +1,177 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gofrs/flock"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	defaultBackups        = 5
+	backupInterval        = time.Hour
+	backupTimestampFormat = "20060102T150405Z"
+)
+
+// FileStore keeps all state in a single JSON file. The file is replaced atomically on every save, and timestamped
+// backups of previous versions are kept next to it. Offsets larger than ExternalizeAbove bytes are stored in separate
+// gzip-compressed files referenced from it.
+type FileStore struct {
+	Path             string
+	Backups          *int
+	ExternalizeAbove int
+
+	content    document
+	lock       *flock.Flock
+	lastBackup time.Time
+}
+
+func (store *FileStore) Name() string {
+	return "file"
+}
+
+func (store *FileStore) Open(options OpenOptions) error {
+	if len(store.Path) == 0 {
+		return fmt.Errorf("path of state file must not be empty")
+	}
+	if store.Backups == nil {
+		backups := defaultBackups
+		store.Backups = &backups
+	}
+
+	if !options.ReadOnly {
+		lock, err := lockFile(store.Path+".lock", options.LockTimeout)
+		if err != nil {
+			return err
+		}
+		store.lock = lock
+	}
+
+	content, err := os.ReadFile(store.Path)
+	if os.IsNotExist(err) {
+		content = []byte("{}")
+	} else if err != nil {
+		_ = store.Close()
+		return fmt.Errorf("could not read offsets: %w", err)
+	}
+
+	if store.content, err = parseDocument(content); err != nil {
+		_ = store.Close()
+		return err
+	}
+	if err = store.loadExternal(store.content); err != nil {
+		_ = store.Close()
+		return err
+	}
+
+	return nil
+}
+
+func (store *FileStore) Load(bucket string) (map[string]json.RawMessage, error) {
+	return store.content.load(bucket)
+}
+
+func (store *FileStore) Save(changes Changes) error {
+	if err := store.content.apply(changes); err != nil {
+		return err
+	}
+
+	content, err := store.externalize(store.content)
+	if err != nil {
+		return err
+	}
+	serialized, err := content.serialize()
+	if err != nil {
+		return err
+	}
+
+	// Back up the previous version once per run, and at most hourly when running as daemon
+	if time.Since(store.lastBackup) >= backupInterval {
+		if err = store.Backup(); err != nil {
+			return err
+		}
+	}
+
+	if err = writeAtomically(store.Path, serialized); err != nil {
+		return err
+	}
+
+	return store.cleanExternal()
+}
+
+// Backup copies the current state file to a timestamped backup next to it, removing the oldest backups beyond the
+// configured number
+func (store *FileStore) Backup() error {
+	if *store.Backups <= 0 {
+		return nil
+	}
+
+	content, err := os.ReadFile(store.Path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("could not read offsets for backup: %w", err)
+	}
+
+	now := time.Now().UTC()
+	backupPath := fmt.Sprintf("%s.%s.bak", store.Path, now.Format(backupTimestampFormat))
+	if err = writeAtomically(backupPath, content); err != nil {
+		return fmt.Errorf("could not back up offsets: %w", err)
+	}
+	store.lastBackup = now
+
+	// The timestamps sort chronologically, so the oldest backups come first
+	backups, err := filepath.Glob(store.Path + ".*.bak")
+	if err != nil {
+		return fmt.Errorf("could not list backups: %w", err)
+	}
+	sort.Strings(backups)
+
+	for len(backups) > *store.Backups {
+		if err = os.Remove(backups[0]); err != nil {
+			return fmt.Errorf("could not remove old backup: %w", err)
+		}
+		backups = backups[1:]
+	}
+
+	return nil
+}
+
+func (store *FileStore) Close() error {
+	if store.lock == nil {
+		return nil
+	}
+
+	return store.lock.Unlock()
+}
+
+// writeAtomically writes to a temporary file that then replaces the target, so that the target is never left
+// partially written
+func writeAtomically(path string, content []byte) error {
+	temp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("could not write offsets: %w", err)
+	}
+	defer os.Remove(temp.Name())
+
+	if _, err = temp.Write(content); err == nil {
+		err = temp.Sync()
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(temp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), path)
+	}
+	if err != nil {
+		return fmt.Errorf("could not write offsets: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,127 @@
+package state
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	"google.golang.org/api/storage/v1"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// GCSStore keeps all state in a single object in Google Cloud Storage, in the same format as the offsets file.
+// Concurrent modifications are detected using the generation of the object.
+type GCSStore struct {
+	Bucket          string
+	Object          string
+	CredentialsFile string `mapstructure:"credentialsFile"`
+
+	service *storage.Service
+	object  objectDocument
+}
+
+func (store *GCSStore) Name() string {
+	return "gcs"
+}
+
+func (store *GCSStore) Open(_ OpenOptions) error {
+	if len(store.Bucket) == 0 {
+		return fmt.Errorf("GCS bucket must not be empty")
+	}
+	if len(store.Object) == 0 {
+		store.Object = defaultObjectKey
+	}
+
+	// Without a credentials file, the application default credentials are used
+	var options []option.ClientOption
+	if len(store.CredentialsFile) > 0 {
+		options = append(options, option.WithCredentialsFile(store.CredentialsFile))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), objectTimeout)
+	defer cancel()
+
+	service, err := storage.NewService(ctx, options...)
+	if err != nil {
+		return fmt.Errorf("could not create GCS client: %w", err)
+	}
+
+	store.service = service
+	store.object.backend = store
+
+	return store.object.open()
+}
+
+func (store *GCSStore) read(ctx context.Context) ([]byte, string, error) {
+	res, err := store.service.Objects.Get(store.Bucket, store.Object).Context(ctx).Download()
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+		return nil, "", nil
+	} else if err != nil {
+		return nil, "", fmt.Errorf("could not read gs://%s/%s: %w", store.Bucket, store.Object, err)
+	}
+	defer res.Body.Close()
+
+	content, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("could not read gs://%s/%s: %w", store.Bucket, store.Object, err)
+	}
+
+	return content, res.Header.Get("X-Goog-Generation"), nil
+}
+
+func (store *GCSStore) write(ctx context.Context, content []byte, version string) (string, error) {
+	// Generation 0 requires that the object doesn't exist yet
+	var generation int64
+	if len(version) > 0 {
+		var err error
+		if generation, err = strconv.ParseInt(version, 10, 64); err != nil {
+			return "", fmt.Errorf("invalid generation '%s' of gs://%s/%s", version, store.Bucket, store.Object)
+		}
+	}
+
+	object, err := store.service.Objects.
+		Insert(store.Bucket, &storage.Object{Name: store.Object, ContentType: "application/json"}).
+		Media(bytes.NewReader(content)).
+		IfGenerationMatch(generation).
+		Context(ctx).
+		Do()
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusPreconditionFailed {
+		return "", errConflict
+	} else if err != nil {
+		return "", fmt.Errorf("could not write gs://%s/%s: %w", store.Bucket, store.Object, err)
+	}
+
+	return strconv.FormatInt(object.Generation, 10), nil
+}
+
+func (store *GCSStore) Load(bucket string) (map[string]json.RawMessage, error) {
+	return store.object.load(bucket)
+}
+
+func (store *GCSStore) Save(changes Changes) error {
+	return store.object.save(changes)
+}
+
+func (store *GCSStore) AcquireLease(name, holder string, duration time.Duration) (bool, error) {
+	return store.object.acquireLease(name, holder, duration)
+}
+
+func (store *GCSStore) FenceSaves(name, holder string) {
+	store.object.fenceSaves(name, holder)
+}
+
+func (store *GCSStore) ReleaseLease(name, holder string) error {
+	return store.object.releaseLease(name, holder)
+}
+
+func (store *GCSStore) Close() error {
+	return nil
+}
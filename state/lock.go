@@ -0,0 +1,42 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gofrs/flock"
+	"time"
+)
+
+const lockRetryDelay = time.Second
+
+// ErrLocked is returned when opening a store that another running instance holds a lock on
+var ErrLocked = errors.New("state is locked by another running instance")
+
+// lockFile acquires an exclusive lock on the given file, waiting up to the given timeout for other instances to
+// release it
+func lockFile(path string, timeout time.Duration) (*flock.Flock, error) {
+	lock := flock.New(path)
+
+	var acquired bool
+	var err error
+	if timeout > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		acquired, err = lock.TryLockContext(ctx, lockRetryDelay)
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = nil
+		}
+	} else {
+		acquired, err = lock.TryLock()
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not lock '%s': %w", path, err)
+	}
+	if !acquired {
+		return nil, ErrLocked
+	}
+
+	return lock, nil
+}
@@ -0,0 +1,205 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	objectTimeout      = time.Minute
+	maxConflictRetries = 5
+)
+
+// errConflict is returned by object backends when the object was changed since it was last read
+var errConflict = errors.New("object was modified concurrently")
+
+// objectBackend reads and writes a single object in an object storage, using the version of the object to detect
+// concurrent modifications
+type objectBackend interface {
+	// read returns the content and version of the object, or nil content if it doesn't exist yet
+	read(ctx context.Context) ([]byte, string, error)
+
+	// write replaces the object if it still has the given version, returning its new version. An empty version means
+	// that the object must not exist yet.
+	write(ctx context.Context, content []byte, version string) (string, error)
+}
+
+// objectDocument keeps all state in a single object with optimistic concurrency control. If another instance changed
+// the object in the meantime, the changes are applied on top of the other instance's.
+type objectDocument struct {
+	backend objectBackend
+	content document
+	version string
+	fence   *leaseFence
+	// lock guards the content, which leases change concurrently to saves
+	lock sync.Mutex
+}
+
+// objectLease is the meta entry holding a lease of an instance sharing the object
+type objectLease struct {
+	Holder  string    `json:"holder"`
+	Expires time.Time `json:"expires"`
+}
+
+func (object *objectDocument) open() error {
+	ctx, cancel := context.WithTimeout(context.Background(), objectTimeout)
+	defer cancel()
+
+	content, version, err := object.backend.read(ctx)
+	if err != nil {
+		return err
+	}
+	if content == nil {
+		content = []byte("{}")
+	}
+
+	parsed, err := parseDocument(content)
+	if err != nil {
+		return err
+	}
+
+	object.content, object.version = parsed, version
+
+	return nil
+}
+
+func (object *objectDocument) load(bucket string) (map[string]json.RawMessage, error) {
+	object.lock.Lock()
+	defer object.lock.Unlock()
+
+	return object.content.load(bucket)
+}
+
+func (object *objectDocument) save(changes Changes) error {
+	object.lock.Lock()
+	defer object.lock.Unlock()
+
+	for attempt := 1; ; attempt++ {
+		// A successor taking over the lease changes the object, so the write fails if the lease changed hands since
+		// it was checked
+		if object.fence != nil && !object.holds(*object.fence) {
+			return ErrLeaseLost
+		}
+		if err := object.content.apply(changes); err != nil {
+			return err
+		}
+
+		err := object.write()
+		if err == nil || !errors.Is(err, errConflict) {
+			return err
+		}
+		if attempt == maxConflictRetries {
+			return fmt.Errorf("could not write offsets after %d attempts: %w", attempt, err)
+		}
+
+		if err = object.open(); err != nil {
+			return err
+		}
+	}
+}
+
+// holds checks whether the fence's holder holds the lease in the current content
+func (object *objectDocument) holds(fence leaseFence) bool {
+	var current objectLease
+	raw, ok := object.content[bucketPrefix+fence.name]
+	return ok && json.Unmarshal(raw, &current) == nil && current.Holder == fence.holder
+}
+
+// fenceSaves makes saves fail unless the holder holds the lease
+func (object *objectDocument) fenceSaves(name, holder string) {
+	object.lock.Lock()
+	defer object.lock.Unlock()
+
+	object.fence = &leaseFence{name: name, holder: holder}
+}
+
+// acquireLease takes or extends the lease in the latest version of the object. As expiry is determined by the clocks of
+// the instances, they must be roughly in sync.
+func (object *objectDocument) acquireLease(name, holder string, duration time.Duration) (bool, error) {
+	object.lock.Lock()
+	defer object.lock.Unlock()
+
+	key := bucketPrefix + name
+	for attempt := 1; ; attempt++ {
+		if err := object.open(); err != nil {
+			return false, err
+		}
+
+		var current objectLease
+		if raw, ok := object.content[key]; ok {
+			if err := json.Unmarshal(raw, &current); err != nil {
+				return false, fmt.Errorf("could not parse lease: %w", err)
+			}
+		}
+		if current.Holder != holder && time.Now().Before(current.Expires) {
+			return false, nil
+		}
+
+		lease, err := json.Marshal(objectLease{Holder: holder, Expires: time.Now().Add(duration)})
+		if err != nil {
+			return false, err
+		}
+		object.content[key] = lease
+
+		err = object.write()
+		if err == nil {
+			return true, nil
+		}
+		if !errors.Is(err, errConflict) {
+			return false, err
+		}
+		if attempt == maxConflictRetries {
+			return false, fmt.Errorf("could not acquire lease after %d attempts: %w", attempt, err)
+		}
+	}
+}
+
+// releaseLease removes the lease from the latest version of the object if the holder still holds it
+func (object *objectDocument) releaseLease(name, holder string) error {
+	object.lock.Lock()
+	defer object.lock.Unlock()
+
+	key := bucketPrefix + name
+	for attempt := 1; ; attempt++ {
+		if err := object.open(); err != nil {
+			return err
+		}
+
+		var current objectLease
+		if raw, ok := object.content[key]; !ok || json.Unmarshal(raw, &current) != nil || current.Holder != holder {
+			return nil
+		}
+		delete(object.content, key)
+
+		err := object.write()
+		if err == nil || !errors.Is(err, errConflict) {
+			return err
+		}
+		if attempt == maxConflictRetries {
+			return fmt.Errorf("could not release lease after %d attempts: %w", attempt, err)
+		}
+	}
+}
+
+// write stores the current content if the object wasn't changed since it was read
+func (object *objectDocument) write() error {
+	serialized, err := object.content.serialize()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), objectTimeout)
+	defer cancel()
+
+	version, err := object.backend.write(ctx, serialized, object.version)
+	if err != nil {
+		return err
+	}
+	object.version = version
+
+	return nil
+}
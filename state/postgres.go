@@ -0,0 +1,304 @@
+package state
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"time"
+)
+
+const (
+	// postgresLockID identifies the advisory lock held by the running instance
+	postgresLockID = 0x5a4e4445
+	// postgresMigrationLockID identifies the advisory lock held while migrating, as instances sharing the database
+	// may start at the same time
+	postgresMigrationLockID = 0x5a4e4446
+	postgresTimeout         = time.Minute
+	// postgresUndefinedTable is the error code of queries referencing tables that don't exist
+	postgresUndefinedTable = "42P01"
+)
+
+// postgresMigrations are applied in order, each exactly once
+var postgresMigrations = []string{
+	`CREATE TABLE state (
+		bucket TEXT NOT NULL,
+		key TEXT NOT NULL,
+		value JSONB NOT NULL,
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		PRIMARY KEY (bucket, key)
+	)`,
+	`CREATE TABLE run_history (
+		id BIGSERIAL PRIMARY KEY,
+		connector TEXT NOT NULL,
+		result JSONB NOT NULL,
+		recorded_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	);
+	CREATE INDEX run_history_connector ON run_history (connector, recorded_at)`,
+	`CREATE TABLE leases (
+		name TEXT PRIMARY KEY,
+		holder TEXT NOT NULL,
+		expires_at TIMESTAMPTZ NOT NULL
+	)`,
+}
+
+// PostgresStore keeps all state in a PostgreSQL database with one row per entry. While the store is open, it holds
+// an advisory lock, so that no two instances work with the same state at once, unless it's shared by instances holding
+// leases instead.
+type PostgresStore struct {
+	URL string
+
+	db       *sql.DB
+	lock     *sql.Conn
+	fence    *leaseFence
+	readOnly bool
+}
+
+func (store *PostgresStore) Name() string {
+	return "postgres"
+}
+
+func (store *PostgresStore) Open(options OpenOptions) error {
+	if len(store.URL) == 0 {
+		return fmt.Errorf("PostgreSQL connection URL must not be empty")
+	}
+
+	db, err := sql.Open("postgres", store.URL)
+	if err != nil {
+		return fmt.Errorf("could not open PostgreSQL database: %w", err)
+	}
+	store.db = db
+
+	ctx, cancel := context.WithTimeout(context.Background(), postgresTimeout+options.LockTimeout)
+	defer cancel()
+
+	// Read-only stores aren't migrated, so their database may not have any tables yet
+	store.readOnly = options.ReadOnly
+	if options.ReadOnly {
+		return nil
+	}
+
+	if !options.Shared {
+		if err = store.acquireLock(ctx, options.LockTimeout); err != nil {
+			_ = store.Close()
+			return err
+		}
+	}
+
+	if err = store.migrate(ctx); err != nil {
+		_ = store.Close()
+		return err
+	}
+
+	return nil
+}
+
+// acquireLock takes the advisory lock on a dedicated connection, since it's bound to the session holding it
+func (store *PostgresStore) acquireLock(ctx context.Context, timeout time.Duration) error {
+	conn, err := store.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("could not connect to PostgreSQL database: %w", err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		var acquired bool
+		if err = conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", postgresLockID).Scan(&acquired); err != nil {
+			_ = conn.Close()
+			return fmt.Errorf("could not acquire advisory lock: %w", err)
+		}
+		if acquired {
+			store.lock = conn
+			return nil
+		}
+		if time.Now().After(deadline) {
+			_ = conn.Close()
+			return ErrLocked
+		}
+
+		time.Sleep(lockRetryDelay)
+	}
+}
+
+func (store *PostgresStore) migrate(ctx context.Context) error {
+	tx, err := store.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", postgresMigrationLockID); err != nil {
+		return fmt.Errorf("could not lock migrations: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`)
+	if err != nil {
+		return fmt.Errorf("could not set up migrations: %w", err)
+	}
+
+	var applied int
+	if err = tx.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&applied); err != nil {
+		return fmt.Errorf("could not determine schema version: %w", err)
+	}
+	if applied > len(postgresMigrations) {
+		return fmt.Errorf("database schema version %d is newer than supported version %d", applied, len(postgresMigrations))
+	}
+
+	for version := applied + 1; version <= len(postgresMigrations); version++ {
+		if _, err = tx.ExecContext(ctx, postgresMigrations[version-1]); err != nil {
+			return fmt.Errorf("could not apply migration %d: %w", version, err)
+		}
+		if _, err = tx.ExecContext(ctx, "INSERT INTO schema_migrations (version) VALUES ($1)", version); err != nil {
+			return fmt.Errorf("could not record migration %d: %w", version, err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit migrations: %w", err)
+	}
+
+	return nil
+}
+
+func (store *PostgresStore) Load(bucket string) (map[string]json.RawMessage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), postgresTimeout)
+	defer cancel()
+
+	rows, err := store.db.QueryContext(ctx, "SELECT key, value FROM state WHERE bucket = $1", bucket)
+	var pqErr *pq.Error
+	if store.readOnly && errors.As(err, &pqErr) && pqErr.Code == postgresUndefinedTable {
+		return map[string]json.RawMessage{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read bucket '%s': %w", bucket, err)
+	}
+	defer rows.Close()
+
+	result := make(map[string]json.RawMessage)
+	for rows.Next() {
+		var key string
+		var value []byte
+		if err = rows.Scan(&key, &value); err != nil {
+			return nil, fmt.Errorf("could not read bucket '%s': %w", bucket, err)
+		}
+		result[key] = value
+	}
+
+	return result, rows.Err()
+}
+
+func (store *PostgresStore) Save(changes Changes) error {
+	ctx, cancel := context.WithTimeout(context.Background(), postgresTimeout)
+	defer cancel()
+
+	tx, err := store.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Locking the lease keeps it from changing hands until the changes are committed
+	if store.fence != nil {
+		rows, err := tx.QueryContext(
+			ctx,
+			"SELECT holder FROM leases WHERE name = $1 AND holder = $2 AND expires_at > now() FOR SHARE",
+			store.fence.name, store.fence.holder,
+		)
+		if err != nil {
+			return fmt.Errorf("could not check lease: %w", err)
+		}
+		held := rows.Next()
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return fmt.Errorf("could not check lease: %w", err)
+		}
+		if !held {
+			return ErrLeaseLost
+		}
+	}
+
+	for bucket, entries := range changes {
+		for key, value := range entries {
+			if value == nil {
+				_, err = tx.ExecContext(ctx, "DELETE FROM state WHERE bucket = $1 AND key = $2", bucket, key)
+			} else {
+				_, err = tx.ExecContext(
+					ctx,
+					`INSERT INTO state (bucket, key, value, updated_at) VALUES ($1, $2, $3, now())
+					ON CONFLICT (bucket, key) DO UPDATE SET value = excluded.value, updated_at = excluded.updated_at`,
+					bucket, key, string(value),
+				)
+			}
+			if err != nil {
+				return fmt.Errorf("could not store '%s' in bucket '%s': %w", key, bucket, err)
+			}
+
+			if bucket == RunsBucket && value != nil {
+				_, err = tx.ExecContext(ctx, "INSERT INTO run_history (connector, result) VALUES ($1, $2)", key, string(value))
+				if err != nil {
+					return fmt.Errorf("could not record run of connector '%s': %w", key, err)
+				}
+			}
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (store *PostgresStore) AcquireLease(name, holder string, duration time.Duration) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), postgresTimeout)
+	defer cancel()
+
+	// The lease is only taken over if it expired, according to the clock of the database
+	rows, err := store.db.QueryContext(
+		ctx,
+		`INSERT INTO leases (name, holder, expires_at) VALUES ($1, $2, now() + $3 * interval '1 millisecond')
+		ON CONFLICT (name) DO UPDATE SET holder = excluded.holder, expires_at = excluded.expires_at
+		WHERE leases.holder = excluded.holder OR leases.expires_at < now()
+		RETURNING holder`,
+		name, holder, duration.Milliseconds(),
+	)
+	if err != nil {
+		return false, fmt.Errorf("could not acquire lease: %w", err)
+	}
+	defer rows.Close()
+
+	acquired := rows.Next()
+	return acquired, rows.Err()
+}
+
+func (store *PostgresStore) FenceSaves(name, holder string) {
+	store.fence = &leaseFence{name: name, holder: holder}
+}
+
+func (store *PostgresStore) ReleaseLease(name, holder string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), postgresTimeout)
+	defer cancel()
+
+	if _, err := store.db.ExecContext(ctx, "DELETE FROM leases WHERE name = $1 AND holder = $2", name, holder); err != nil {
+		return fmt.Errorf("could not release lease: %w", err)
+	}
+
+	return nil
+}
+
+func (store *PostgresStore) Close() error {
+	if store.lock != nil {
+		_ = store.lock.Close()
+	}
+	if store.db == nil {
+		return nil
+	}
+
+	// Closing the database ends the session holding the advisory lock, releasing it
+	return store.db.Close()
+}
@@ -0,0 +1,202 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRedisPrefix = "sanderson-notifications"
+	redisTimeout       = 30 * time.Second
+	// maxRedisFenceRetries limits how often fenced saves are retried after the lease was extended while saving
+	maxRedisFenceRetries = 5
+)
+
+// RedisStore keeps every entry in a separate Redis key named '<prefix>:<bucket>:<key>', optionally expiring entries
+// that weren't updated for a while
+type RedisStore struct {
+	URL    string
+	Prefix string
+	TTL    time.Duration
+
+	client *redis.Client
+	fence  *leaseFence
+}
+
+func (store *RedisStore) Name() string {
+	return "redis"
+}
+
+func (store *RedisStore) Open(_ OpenOptions) error {
+	if len(store.URL) == 0 {
+		return fmt.Errorf("Redis URL must not be empty")
+	}
+	if len(store.Prefix) == 0 {
+		store.Prefix = defaultRedisPrefix
+	}
+
+	options, err := redis.ParseURL(store.URL)
+	if err != nil {
+		return fmt.Errorf("invalid Redis URL: %w", err)
+	}
+
+	store.client = redis.NewClient(options)
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	if err = store.client.Ping(ctx).Err(); err != nil {
+		_ = store.client.Close()
+		return fmt.Errorf("could not connect to Redis: %w", err)
+	}
+
+	return nil
+}
+
+func (store *RedisStore) key(bucket, key string) string {
+	return fmt.Sprintf("%s:%s:%s", store.Prefix, bucket, key)
+}
+
+func (store *RedisStore) Load(bucket string) (map[string]json.RawMessage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	bucketPrefix := store.key(bucket, "")
+	var keys []string
+	iter := store.client.Scan(ctx, 0, bucketPrefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("could not list bucket '%s': %w", bucket, err)
+	}
+
+	result := make(map[string]json.RawMessage)
+	if len(keys) == 0 {
+		return result, nil
+	}
+
+	values, err := store.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("could not read bucket '%s': %w", bucket, err)
+	}
+
+	for i, value := range values {
+		// Keys may have expired in the meantime
+		if serialized, ok := value.(string); ok {
+			result[strings.TrimPrefix(keys[i], bucketPrefix)] = json.RawMessage(serialized)
+		}
+	}
+
+	return result, nil
+}
+
+func (store *RedisStore) Save(changes Changes) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	write := func(pipe redis.Pipeliner) error {
+		for bucket, entries := range changes {
+			for key, value := range entries {
+				if value == nil {
+					pipe.Del(ctx, store.key(bucket, key))
+				} else {
+					pipe.Set(ctx, store.key(bucket, key), string(value), store.TTL)
+				}
+			}
+		}
+
+		return nil
+	}
+
+	if store.fence == nil {
+		if _, err := store.client.TxPipelined(ctx, write); err != nil {
+			return fmt.Errorf("could not write state to Redis: %w", err)
+		}
+		return nil
+	}
+
+	// The changes are only written if the lease didn't change hands between checking and writing
+	leaseKey := store.key("lease", store.fence.name)
+	for attempt := 1; ; attempt++ {
+		err := store.client.Watch(ctx, func(tx *redis.Tx) error {
+			holder, err := tx.Get(ctx, leaseKey).Result()
+			if err != nil && !errors.Is(err, redis.Nil) {
+				return err
+			}
+			if holder != store.fence.holder {
+				return ErrLeaseLost
+			}
+
+			_, err = tx.TxPipelined(ctx, write)
+			return err
+		}, leaseKey)
+		switch {
+		case err == nil:
+			return nil
+		case errors.Is(err, ErrLeaseLost):
+			return err
+		case !errors.Is(err, redis.TxFailedErr) || attempt == maxRedisFenceRetries:
+			return fmt.Errorf("could not write state to Redis: %w", err)
+		}
+	}
+}
+
+func (store *RedisStore) Close() error {
+	if store.client == nil {
+		return nil
+	}
+
+	return store.client.Close()
+}
+
+// redisAcquireLease sets the lease if it's free or held by the same holder, atomically
+var redisAcquireLease = redis.NewScript(`
+local current = redis.call('GET', KEYS[1])
+if current == false or current == ARGV[1] then
+	redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+	return 1
+end
+return 0
+`)
+
+// redisReleaseLease removes the lease if it's still held by the holder, atomically
+var redisReleaseLease = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// AcquireLease keeps the lease in the key '<prefix>:lease:<name>', which expires along with the lease
+func (store *RedisStore) AcquireLease(name, holder string, duration time.Duration) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	acquired, err := redisAcquireLease.Run(ctx, store.client, []string{store.key("lease", name)}, holder, duration.Milliseconds()).Int()
+	if err != nil {
+		return false, fmt.Errorf("could not acquire lease: %w", err)
+	}
+
+	return acquired == 1, nil
+}
+
+func (store *RedisStore) FenceSaves(name, holder string) {
+	store.fence = &leaseFence{name: name, holder: holder}
+}
+
+func (store *RedisStore) ReleaseLease(name, holder string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	if err := redisReleaseLease.Run(ctx, store.client, []string{store.key("lease", name)}, holder).Err(); err != nil {
+		return fmt.Errorf("could not release lease: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,139 @@
+package state
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultS3Endpoint = "s3.amazonaws.com"
+	defaultObjectKey  = "offsets.json"
+)
+
+// S3Store keeps all state in a single object of an S3-compatible object storage, in the same format as the offsets
+// file. Concurrent modifications are detected using the ETag of the object.
+type S3Store struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	Key       string
+	AccessKey string `mapstructure:"accessKey"`
+	SecretKey string `mapstructure:"secretKey"`
+	Insecure  bool
+
+	client *minio.Client
+	object objectDocument
+}
+
+func (store *S3Store) Name() string {
+	return "s3"
+}
+
+func (store *S3Store) Open(_ OpenOptions) error {
+	if len(store.Bucket) == 0 {
+		return fmt.Errorf("S3 bucket must not be empty")
+	}
+	if len(store.Endpoint) == 0 {
+		store.Endpoint = defaultS3Endpoint
+	}
+	if len(store.Key) == 0 {
+		store.Key = defaultObjectKey
+	}
+
+	// Without explicit keys, credentials are taken from the environment like in other AWS tools
+	creds := credentials.NewChainCredentials([]credentials.Provider{
+		&credentials.EnvAWS{},
+		&credentials.FileAWSCredentials{},
+		&credentials.IAM{},
+	})
+	if len(store.AccessKey) > 0 {
+		creds = credentials.NewStaticV4(store.AccessKey, store.SecretKey, "")
+	}
+
+	client, err := minio.New(store.Endpoint, &minio.Options{
+		Creds:  creds,
+		Region: store.Region,
+		Secure: !store.Insecure,
+	})
+	if err != nil {
+		return fmt.Errorf("could not create S3 client: %w", err)
+	}
+
+	store.client = client
+	store.object.backend = store
+
+	return store.object.open()
+}
+
+func (store *S3Store) read(ctx context.Context) ([]byte, string, error) {
+	object, err := store.client.GetObject(ctx, store.Bucket, store.Key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, "", fmt.Errorf("could not read s3://%s/%s: %w", store.Bucket, store.Key, err)
+	}
+	defer object.Close()
+
+	info, err := object.Stat()
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return nil, "", nil
+	} else if err != nil {
+		return nil, "", fmt.Errorf("could not read s3://%s/%s: %w", store.Bucket, store.Key, err)
+	}
+
+	content, err := io.ReadAll(object)
+	if err != nil {
+		return nil, "", fmt.Errorf("could not read s3://%s/%s: %w", store.Bucket, store.Key, err)
+	}
+
+	return content, info.ETag, nil
+}
+
+func (store *S3Store) write(ctx context.Context, content []byte, version string) (string, error) {
+	options := minio.PutObjectOptions{ContentType: "application/json"}
+	if len(version) > 0 {
+		options.SetMatchETag(version)
+	} else {
+		options.SetMatchETagExcept("*")
+	}
+
+	info, err := store.client.PutObject(ctx, store.Bucket, store.Key, bytes.NewReader(content), int64(len(content)), options)
+	if err != nil {
+		status := minio.ToErrorResponse(err).StatusCode
+		if status == http.StatusPreconditionFailed || status == http.StatusConflict {
+			return "", errConflict
+		}
+		return "", fmt.Errorf("could not write s3://%s/%s: %w", store.Bucket, store.Key, err)
+	}
+
+	return info.ETag, nil
+}
+
+func (store *S3Store) Load(bucket string) (map[string]json.RawMessage, error) {
+	return store.object.load(bucket)
+}
+
+func (store *S3Store) Save(changes Changes) error {
+	return store.object.save(changes)
+}
+
+func (store *S3Store) AcquireLease(name, holder string, duration time.Duration) (bool, error) {
+	return store.object.acquireLease(name, holder, duration)
+}
+
+func (store *S3Store) FenceSaves(name, holder string) {
+	store.object.fenceSaves(name, holder)
+}
+
+func (store *S3Store) ReleaseLease(name, holder string) error {
+	return store.object.releaseLease(name, holder)
+}
+
+func (store *S3Store) Close() error {
+	return nil
+}
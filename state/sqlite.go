@@ -0,0 +1,142 @@
+package state
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/gofrs/flock"
+	_ "modernc.org/sqlite"
+	"time"
+)
+
+// SQLiteStore keeps all state in a SQLite database. In addition to the current state, it keeps a history of all
+// check results.
+type SQLiteStore struct {
+	Path string
+
+	db   *sql.DB
+	lock *flock.Flock
+}
+
+func (store *SQLiteStore) Name() string {
+	return "sqlite"
+}
+
+func (store *SQLiteStore) Open(options OpenOptions) error {
+	if len(store.Path) == 0 {
+		return fmt.Errorf("path of SQLite database must not be empty")
+	}
+
+	if !options.ReadOnly {
+		lock, err := lockFile(store.Path+".lock", options.LockTimeout)
+		if err != nil {
+			return err
+		}
+		store.lock = lock
+	}
+
+	db, err := sql.Open("sqlite", fmt.Sprintf("file:%s?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)", store.Path))
+	if err != nil {
+		_ = store.Close()
+		return fmt.Errorf("could not open SQLite database '%s': %w", store.Path, err)
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS state (
+			bucket TEXT NOT NULL,
+			key TEXT NOT NULL,
+			value TEXT NOT NULL,
+			updated_at TIMESTAMP NOT NULL,
+			PRIMARY KEY (bucket, key)
+		);
+		CREATE TABLE IF NOT EXISTS run_history (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			connector TEXT NOT NULL,
+			result TEXT NOT NULL,
+			recorded_at TIMESTAMP NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS run_history_connector ON run_history (connector, recorded_at);
+	`)
+	if err != nil {
+		_ = db.Close()
+		_ = store.Close()
+		return fmt.Errorf("could not set up SQLite database '%s': %w", store.Path, err)
+	}
+
+	store.db = db
+
+	return nil
+}
+
+func (store *SQLiteStore) Load(bucket string) (map[string]json.RawMessage, error) {
+	rows, err := store.db.Query("SELECT key, value FROM state WHERE bucket = ?", bucket)
+	if err != nil {
+		return nil, fmt.Errorf("could not read bucket '%s': %w", bucket, err)
+	}
+	defer rows.Close()
+
+	result := make(map[string]json.RawMessage)
+	for rows.Next() {
+		var key, value string
+		if err = rows.Scan(&key, &value); err != nil {
+			return nil, fmt.Errorf("could not read bucket '%s': %w", bucket, err)
+		}
+		result[key] = json.RawMessage(value)
+	}
+
+	return result, rows.Err()
+}
+
+func (store *SQLiteStore) Save(changes Changes) error {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return fmt.Errorf("could not start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	for bucket, entries := range changes {
+		for key, value := range entries {
+			if value == nil {
+				_, err = tx.Exec("DELETE FROM state WHERE bucket = ? AND key = ?", bucket, key)
+			} else {
+				_, err = tx.Exec(
+					`INSERT INTO state (bucket, key, value, updated_at) VALUES (?, ?, ?, ?)
+					ON CONFLICT (bucket, key) DO UPDATE SET value = excluded.value, updated_at = excluded.updated_at`,
+					bucket, key, string(value), now,
+				)
+			}
+			if err != nil {
+				return fmt.Errorf("could not store '%s' in bucket '%s': %w", key, bucket, err)
+			}
+
+			if bucket == RunsBucket && value != nil {
+				_, err = tx.Exec(
+					"INSERT INTO run_history (connector, result, recorded_at) VALUES (?, ?, ?)",
+					key, string(value), now,
+				)
+				if err != nil {
+					return fmt.Errorf("could not record run of connector '%s': %w", key, err)
+				}
+			}
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (store *SQLiteStore) Close() error {
+	var err error
+	if store.db != nil {
+		err = store.db.Close()
+	}
+	if store.lock != nil {
+		_ = store.lock.Unlock()
+	}
+
+	return err
+}
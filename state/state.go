@@ -0,0 +1,111 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+const (
+	OffsetsBucket  = "offsets"
+	CircuitsBucket = "circuits"
+	RunsBucket     = "runs"
+	OutboxBucket   = "outbox"
+	DedupeBucket   = "dedupe"
+	HistoryBucket  = "history"
+	// HeldBucket holds the messages of connectors that are held back during quiet hours
+	HeldBucket = "held"
+	// PausedBucket holds the connectors whose notifications are suppressed until they're resumed
+	PausedBucket = "paused"
+	// HTTPCacheBucket holds the validators of conditional requests, keyed by connector and URL
+	HTTPCacheBucket = "httpcache"
+	// MessagesBucket holds the IDs of posted messages, keyed by sink, connector and item, so they can be edited
+	MessagesBucket = "messages"
+	// DigestBucket holds the results of checks collected for the next digest
+	DigestBucket = "digest"
+	// MetaBucket holds information about the state itself, such as the version of its format
+	MetaBucket = "meta"
+)
+
+// Buckets lists all buckets used by the application
+var Buckets = []string{OffsetsBucket, CircuitsBucket, RunsBucket, OutboxBucket, DedupeBucket, HistoryBucket, HeldBucket, PausedBucket, HTTPCacheBucket, MessagesBucket, DigestBucket, MetaBucket}
+
+// NamespacePrefix starts the keys of entries that belong to a namespace, followed by the namespace and a slash, e.g.
+// '@tenant/connector'. Entries of other keys don't belong to any namespace.
+const NamespacePrefix = "@"
+
+// SplitNamespace separates a key into its namespace and the key within it
+func SplitNamespace(key string) (string, string) {
+	if !strings.HasPrefix(key, NamespacePrefix) {
+		return "", key
+	}
+
+	namespace, unqualified, ok := strings.Cut(strings.TrimPrefix(key, NamespacePrefix), "/")
+	if !ok {
+		return "", key
+	}
+
+	return namespace, unqualified
+}
+
+// Changes maps buckets to the entries that changed in them. Entries with a nil value are deleted.
+type Changes map[string]map[string]json.RawMessage
+
+// OpenOptions control how a store is opened
+type OpenOptions struct {
+	// LockTimeout is how long to wait for other running instances to release their lock on the state. Stores fail
+	// to open immediately if it's zero.
+	LockTimeout time.Duration
+	// ReadOnly opens the store without locking it, e.g. for inspecting the state while another instance is running.
+	// Changes must not be saved to read-only stores.
+	ReadOnly bool
+	// Shared opens the store without locking it, as the instances sharing it only change it while holding a lease
+	Shared bool
+}
+
+// Store persists the state of the application, such as connector offsets, as JSON values organized in buckets
+type Store interface {
+	Name() string
+
+	// Open prepares the store for use after its configuration was loaded. Stores that are only meant to be used by a
+	// single instance at once lock the state while they're open.
+	Open(options OpenOptions) error
+
+	// Load reads all entries of a bucket
+	Load(bucket string) (map[string]json.RawMessage, error)
+
+	// Save writes all changes at once, so that either all or none of them are stored
+	Save(changes Changes) error
+
+	Close() error
+}
+
+// BackupStore is implemented by stores that can back up the state before it's migrated
+type BackupStore interface {
+	Backup() error
+}
+
+// LeaseStore is implemented by stores that several instances may share, of which only the one holding a lease works
+// with the state at a time
+type LeaseStore interface {
+	// AcquireLease takes the lease with the given name for the holder if it's free or expired, or extends it if the
+	// holder already holds it. It returns whether the holder now holds the lease for the given duration.
+	AcquireLease(name, holder string, duration time.Duration) (bool, error)
+
+	// ReleaseLease frees the lease if the holder holds it, so another instance can take it over right away
+	ReleaseLease(name, holder string) error
+
+	// FenceSaves makes all further saves fail with ErrLeaseLost unless the holder holds the lease. The lease is checked
+	// atomically with every write, so an instance that lost the lease can't overwrite the changes of its successor.
+	FenceSaves(name, holder string)
+}
+
+// ErrLeaseLost is returned by saves of fenced stores whose lease is held by another instance
+var ErrLeaseLost = errors.New("lease is held by another instance")
+
+// leaseFence is the lease a store's saves are fenced by
+type leaseFence struct {
+	name   string
+	holder string
+}
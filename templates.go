@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"slices"
+)
+
+// maxTemplateDepth limits how many templates may be based on each other, which also catches cycles
+const maxTemplateDepth = 10
+
+// applyTemplates fills in the settings of connectors from the templates they reference and then from the defaults.
+// Settings of a connector take precedence over those of its template, with mappings such as `config` being merged.
+// The plugin config of the defaults is left to be merged after the shared plugin configs.
+func applyTemplates(document *yaml.Node) error {
+	root := documentRoot(document)
+	if root == nil {
+		return nil
+	}
+
+	templates, _ := mappingValue(root, "templates")
+	connectors, _ := mappingValue(root, "connectors")
+	if connectors == nil || connectors.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	defaults, _ := mappingValue(root, "defaults")
+	if defaults != nil && defaults.Kind != yaml.MappingNode {
+		defaults = nil
+	}
+	if defaults != nil {
+		if plugin, _ := mappingValue(defaults, "plugin"); plugin != nil {
+			return fmt.Errorf("defaults must not set a plugin, use a template instead")
+		}
+		defaults = copyNode(defaults)
+		if _, index := mappingValue(defaults, "config"); index >= 0 {
+			defaults.Content = slices.Delete(defaults.Content, index, index+2)
+		}
+	}
+
+	for i := 0; i+1 < len(connectors.Content); i += 2 {
+		name, connector := connectors.Content[i].Value, connectors.Content[i+1]
+		if err := applyTemplate(connector, templates, 0); err != nil {
+			return fmt.Errorf("failed to load connector '%s': %w", name, err)
+		}
+		if defaults != nil && connector.Kind == yaml.MappingNode {
+			fillNodes(connector, copyNode(defaults))
+		}
+	}
+
+	return nil
+}
+
+// applyTemplate fills in the settings of the template referenced by a connector or template, including the templates
+// that one is based on
+func applyTemplate(target *yaml.Node, templates *yaml.Node, depth int) error {
+	if target.Kind != yaml.MappingNode {
+		return nil
+	}
+	reference, _ := mappingValue(target, "template")
+	if reference == nil {
+		return nil
+	}
+	if depth >= maxTemplateDepth {
+		return fmt.Errorf("templates must not be based on each other more than %d times", maxTemplateDepth)
+	}
+
+	var template *yaml.Node
+	if templates != nil && templates.Kind == yaml.MappingNode {
+		template, _ = mappingValue(templates, reference.Value)
+	}
+	if template == nil {
+		return fmt.Errorf("unknown template '%s'", reference.Value)
+	}
+
+	template = copyNode(template)
+	if err := applyTemplate(template, templates, depth+1); err != nil {
+		return fmt.Errorf("invalid template '%s': %w", reference.Value, err)
+	}
+	fillNodes(target, template)
+
+	return nil
+}
+
+// fillNodes adds the keys of the source mapping missing in the target mapping, filling nested mappings as well
+func fillNodes(target, source *yaml.Node) {
+	for i := 0; i+1 < len(source.Content); i += 2 {
+		key, value := source.Content[i], source.Content[i+1]
+		if key.Value == "template" {
+			continue
+		}
+
+		existing, _ := mappingValue(target, key.Value)
+		if existing == nil {
+			target.Content = append(target.Content, key, value)
+		} else if existing.Kind == yaml.MappingNode && value.Kind == yaml.MappingNode {
+			fillNodes(existing, value)
+		}
+	}
+}
+
+// copyNode copies a node deeply, so templates can be applied to several connectors without sharing nodes
+func copyNode(node *yaml.Node) *yaml.Node {
+	copied := *node
+	copied.Content = make([]*yaml.Node, len(node.Content))
+	for i, child := range node.Content {
+		copied.Content[i] = copyNode(child)
+	}
+
+	return &copied
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	. "17thshard.com/sanderson-notifications/common"
+	. "17thshard.com/sanderson-notifications/plugins"
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// testCommand sends a made-up update of a connector to its sinks, so permissions, mentions and formatting can be
+// checked without waiting for a real update
+func testCommand(args []string) {
+	infoLog, errorLog := CreateLoggers("main")
+
+	flags := newFlagSet("test")
+	configPath := flags.String("config", "config.yml", "path or URL of YAML, JSON or TOML config file")
+	environment := flags.String("env", "", "environment to select connectors for via 'onlyIn' and 'exceptIn', replacing the config's")
+	connectorName := flags.String("connector", "", "name of the connector to send a sample message for")
+	messageType := flags.String("type", "", "type of update to send, e.g. 'livestream' or 'retweet'")
+	dryRun := flags.Bool("dry-run", false, "log the message instead of sending it")
+	logLevelFlag(flags)
+	_ = flags.Parse(args)
+
+	if len(*connectorName) == 0 {
+		errorLog.Fatal("The connector to test must be specified with -connector")
+	}
+
+	config := loadConfig(*configPath, *environment, infoLog, errorLog)
+	if *dryRun {
+		infoLog.Println("Performing dry run, messages will only be logged")
+		enableDryRun(config)
+	}
+	defer closeSinks(config, errorLog)
+
+	connector := config.Connector(*connectorName)
+	if connector == nil {
+		errorLog.Fatalf("Unknown connector '%s'", *connectorName)
+	}
+
+	plugin, ok := (*connector.Plugin).(SamplePlugin)
+	if !ok {
+		errorLog.Fatalf("Plugin '%s' of connector '%s' does not support sample messages", (*connector.Plugin).Name(), connector.Name)
+	}
+
+	message, err := plugin.SampleMessage(connector.Locale, SampleItem{Type: *messageType})
+	if err != nil {
+		errorLog.Fatalf("Failed to create sample message for connector '%s': %s", connector.Name, err)
+	}
+	// Sample messages must not be mistaken for real items, e.g. when editing messages about them later
+	message.Item = ""
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var sink Sink = connector.Sink
+	if !connector.Overrides.empty() {
+		sink = &overrideSink{Sink: sink, overrides: connector.Overrides}
+	}
+
+	infoLog.Printf("Sending sample message of connector '%s'...", connector.Name)
+	sender := SinkSender{Connector: connector.Name, Sink: sink, Context: ctx}
+	if err = sender.SendMessage(message); err != nil {
+		errorLog.Fatalf("Failed to send sample message of connector '%s': %s", connector.Name, err)
+	}
+	infoLog.Println("Sent sample message")
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	. "17thshard.com/sanderson-notifications/common"
+	"context"
+	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"log"
+	"time"
+)
+
+const serviceName = "sanderson-notifications"
+
+var tracer = otel.Tracer("17thshard.com/sanderson-notifications")
+
+// TracingConfig configures the export of OpenTelemetry traces via OTLP over HTTP. Without an endpoint, the standard
+// OTEL_EXPORTER_OTLP_* environment variables are used.
+type TracingConfig struct {
+	Enabled  bool              `yaml:"enabled"`
+	Endpoint string            `yaml:"endpoint"`
+	Insecure bool              `yaml:"insecure"`
+	Headers  map[string]string `yaml:"headers"`
+}
+
+// setupTracing installs the exporter for traces, if enabled. The returned function flushes all pending spans and
+// must be called before exiting.
+func setupTracing(config TracingConfig, errorLog *log.Logger) func() {
+	if !config.Enabled {
+		return func() {}
+	}
+
+	var options []otlptracehttp.Option
+	if len(config.Endpoint) > 0 {
+		options = append(options, otlptracehttp.WithEndpointURL(config.Endpoint))
+	}
+	if config.Insecure {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+	if len(config.Headers) > 0 {
+		options = append(options, otlptracehttp.WithHeaders(config.Headers))
+	}
+
+	exporter, err := otlptracehttp.New(context.Background(), options...)
+	if err != nil {
+		errorLog.Printf("Failed to set up tracing, continuing without it: %s", err)
+		return func() {}
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(serviceName))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if err := provider.Shutdown(ctx); err != nil {
+			errorLog.Printf("Failed to export traces: %s", err)
+		}
+	}
+}
+
+// endSpan ends the span, marking it as failed if there was an error
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// tracingSink records a span for every delivery of a message
+type tracingSink struct {
+	Sink
+	connector string
+	targets   []string
+}
+
+func (sink *tracingSink) Deliver(ctx context.Context, message Message) error {
+	ctx, span := tracer.Start(ctx, "deliver", trace.WithAttributes(
+		attribute.String("connector", sink.connector),
+		attribute.StringSlice("sinks", sink.targets),
+	))
+
+	err := sink.Sink.Deliver(ctx, message)
+	endSpan(span, err)
+
+	return err
+}
+
+// spanName names the span of a connector check
+func spanName(connector *ConnectorRuntime) string {
+	return fmt.Sprintf("check %s", connector.Name)
+}
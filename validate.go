@@ -0,0 +1,56 @@
+package main
+
+import (
+	. "17thshard.com/sanderson-notifications/common"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// validateCommand checks a config file against the schema, reporting where unknown keys or values of the wrong type
+// are, and then loads it to check everything else, without running any connectors
+func validateCommand(args []string) {
+	infoLog, errorLog := CreateLoggers("main")
+
+	flags := newFlagSet("validate")
+	configPath := flags.String("config", "config.yml", "path or URL of YAML, JSON or TOML config file")
+	environment := flags.String("env", "", "environment to select connectors for via 'onlyIn' and 'exceptIn', replacing the config's")
+	_ = flags.Parse(args)
+
+	loader := newConfigLoader()
+	loader.Environment = *environment
+	schemaErrs, err := loader.CheckSchema(*configPath)
+	if err != nil {
+		errorLog.Printf("Failed to check config: %s", err)
+		os.Exit(ExitConfigError)
+	}
+	for _, schemaErr := range schemaErrs {
+		errorLog.Println(schemaErr)
+	}
+	if len(schemaErrs) > 0 {
+		errorLog.Printf("Config does not match the schema, found %d problems", len(schemaErrs))
+		os.Exit(ExitConfigError)
+	}
+
+	config, err := loader.Load(*configPath)
+	if err != nil {
+		errorLog.Printf("Invalid config: %s", err)
+		os.Exit(ExitConfigError)
+	}
+	closeSinks(config, errorLog)
+
+	infoLog.Printf("Config is valid, with %d connectors", len(config.Connectors))
+}
+
+// schemaCommand prints the JSON Schema of the config, e.g. for editors to complete and check configs
+func schemaCommand(args []string) {
+	flags := newFlagSet("schema")
+	_ = flags.Parse(args)
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(newConfigLoader().GenerateSchema()); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to print schema: %s\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,433 @@
+package main
+
+import (
+	. "17thshard.com/sanderson-notifications/plugins"
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultLeaseDuration = 5 * 24 * time.Hour
+	webSubRetryDelay     = 15 * time.Minute
+	webSubVerifyTimeout  = 2 * time.Minute
+	webSubRequestTimeout = 30 * time.Second
+	// webSubPollInterval is how often connectors are still polled while subscribed, in case the hub misses updates
+	webSubPollInterval = 6 * time.Hour
+)
+
+// WebSubConfig configures subscriptions to WebSub hubs, which push updates instead of connectors polling for them
+type WebSubConfig struct {
+	CallbackURL   string        `yaml:"callbackUrl"`
+	Secret        string        `yaml:"secret"`
+	LeaseDuration time.Duration `yaml:"leaseDuration"`
+}
+
+// WebSubManager subscribes connectors to their WebSub hubs and triggers checks whenever a hub pushes an update.
+// Connectors keep being polled as long as they don't have an active subscription.
+type WebSubManager struct {
+	config WebSubConfig
+	runner *Runner
+	client *http.Client
+	info   *log.Logger
+	error  *log.Logger
+
+	lock          sync.Mutex
+	ctx           context.Context
+	subscriptions map[string]*webSubSubscription
+	// unsubscribing holds the topics connectors unsubscribed from until the hubs verified it
+	unsubscribing map[webSubTopic]bool
+}
+
+type webSubTopic struct {
+	connector string
+	topic     string
+}
+
+type webSubSubscription struct {
+	connector *ConnectorRuntime
+	plugin    WebSubPlugin
+	// config is the one the subscription was requested with, as reloading the config may replace the manager's
+	config   WebSubConfig
+	verified chan time.Time
+	// stop ends maintaining the subscription, after which done is closed
+	stop context.CancelFunc
+	done chan struct{}
+
+	lock    sync.Mutex
+	topic   string
+	hub     string
+	pending bool
+}
+
+func NewWebSubManager(config WebSubConfig, runner *Runner, info, error *log.Logger) *WebSubManager {
+	return &WebSubManager{
+		config:        config,
+		runner:        runner,
+		client:        &http.Client{Timeout: webSubRequestTimeout},
+		info:          info,
+		error:         error,
+		subscriptions: make(map[string]*webSubSubscription),
+		unsubscribing: make(map[webSubTopic]bool),
+	}
+}
+
+func (manager *WebSubManager) Register(server *Server) {
+	server.Handle("GET /websub/{name}", http.HandlerFunc(manager.verify))
+	server.Handle("POST /websub/{name}", http.HandlerFunc(manager.receive))
+}
+
+// Run keeps the subscriptions of all supporting connectors alive until the context is cancelled
+func (manager *WebSubManager) Run(ctx context.Context) {
+	manager.lock.Lock()
+	manager.ctx = ctx
+	manager.lock.Unlock()
+
+	manager.Reload(manager.config)
+}
+
+// Reload resubscribes the currently configured connectors with the given config. Connectors that were removed or
+// don't support WebSub anymore are unsubscribed, as are all connectors if WebSub was disabled.
+func (manager *WebSubManager) Reload(config WebSubConfig) {
+	if config.LeaseDuration <= 0 {
+		config.LeaseDuration = defaultLeaseDuration
+	}
+
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+
+	manager.config = config
+	previous := manager.subscriptions
+	manager.subscriptions = make(map[string]*webSubSubscription)
+
+	for _, connector := range manager.runner.Connectors() {
+		plugin, ok := (*connector.Plugin).(WebSubPlugin)
+		if !ok || len(config.CallbackURL) == 0 {
+			continue
+		}
+
+		ctx, stop := context.WithCancel(manager.ctx)
+		subscription := &webSubSubscription{
+			connector: connector,
+			plugin:    plugin,
+			config:    config,
+			verified:  make(chan time.Time, 1),
+			stop:      stop,
+			done:      make(chan struct{}),
+		}
+		manager.subscriptions[connector.Name] = subscription
+		go manager.maintain(ctx, subscription, previous[connector.Name])
+	}
+
+	for name, subscription := range previous {
+		subscription.stop()
+		if _, ok := manager.subscriptions[name]; !ok {
+			go func() {
+				<-subscription.done
+				manager.unsubscribe(manager.ctx, subscription)
+			}()
+		}
+	}
+}
+
+// maintain keeps a subscription alive, once the one it replaces, if any, stopped being maintained
+func (manager *WebSubManager) maintain(ctx context.Context, subscription, previous *webSubSubscription) {
+	defer close(subscription.done)
+	if previous != nil {
+		<-previous.done
+	}
+
+	connector := subscription.connector
+	for {
+		expires, err := manager.subscribe(ctx, subscription, previous)
+		previous = nil
+
+		var delay time.Duration
+		switch {
+		case ctx.Err() != nil:
+			return
+		case err != nil:
+			connector.error.Printf("Could not subscribe to WebSub hub, falling back to polling: %s", err)
+			delay = webSubRetryDelay
+		case subscription.hub == "":
+			connector.info.Println("Source does not support WebSub, falling back to polling")
+			return
+		default:
+			connector.info.Printf("Subscribed to WebSub hub '%s' until %s", subscription.hub, expires.Format(time.RFC3339))
+			// Renew once 90% of the lease has passed, so there's no gap in the subscription
+			delay = time.Until(expires) * 9 / 10
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// subscribe requests a subscription from the topic's hub and waits for the hub to verify it. The previous subscription
+// of the connector is unsubscribed first if it was for another topic.
+func (manager *WebSubManager) subscribe(ctx context.Context, subscription, previous *webSubSubscription) (time.Time, error) {
+	connector := subscription.connector
+	topic, hub, err := subscription.plugin.WebSubTopic(PluginContext{
+		Info:       connector.info,
+		Error:      connector.error,
+		Log:        connector.log,
+		Context:    &ctx,
+		HTTP:       connector.http,
+		HTTPConfig: connector.HTTP,
+		Locale:     connector.Locale,
+	})
+	if err != nil {
+		return time.Time{}, fmt.Errorf("could not determine WebSub topic: %w", err)
+	}
+
+	if previous != nil {
+		if previousTopic, previousHub := previous.current(); previousTopic != topic || previousHub != hub {
+			manager.unsubscribe(ctx, previous)
+		}
+	}
+
+	select {
+	case <-subscription.verified:
+	default:
+	}
+
+	subscription.lock.Lock()
+	subscription.topic, subscription.hub = topic, hub
+	subscription.pending = hub != ""
+	subscription.lock.Unlock()
+
+	if hub == "" {
+		return time.Time{}, nil
+	}
+
+	form := url.Values{"hub.lease_seconds": {strconv.Itoa(int(subscription.config.LeaseDuration.Seconds()))}}
+	if len(subscription.config.Secret) > 0 {
+		form.Set("hub.secret", subscription.config.Secret)
+	}
+	if err = manager.request(ctx, subscription, "subscribe", topic, hub, form); err != nil {
+		return time.Time{}, err
+	}
+
+	select {
+	case expires := <-subscription.verified:
+		return expires, nil
+	case <-time.After(webSubVerifyTimeout):
+		return time.Time{}, fmt.Errorf("WebSub hub '%s' did not verify subscription in time", hub)
+	case <-ctx.Done():
+		return time.Time{}, ctx.Err()
+	}
+}
+
+// unsubscribe requests the hub of a subscription to end it. The connector is polled again right away, as the hub
+// doesn't push updates until it's subscribed again.
+func (manager *WebSubManager) unsubscribe(ctx context.Context, subscription *webSubSubscription) {
+	topic, hub := subscription.current()
+	if hub == "" {
+		return
+	}
+
+	manager.current(subscription).updateState(func(state *ConnectorState) {
+		state.PushedUntil = time.Time{}
+	})
+
+	key := webSubTopic{connector: subscription.connector.Name, topic: topic}
+	manager.lock.Lock()
+	manager.unsubscribing[key] = true
+	manager.lock.Unlock()
+
+	if err := manager.request(ctx, subscription, "unsubscribe", topic, hub, url.Values{}); err != nil {
+		subscription.connector.error.Printf("Could not unsubscribe from WebSub hub: %s", err)
+		manager.lock.Lock()
+		delete(manager.unsubscribing, key)
+		manager.lock.Unlock()
+		return
+	}
+
+	subscription.connector.info.Printf("Unsubscribed from WebSub hub '%s'", hub)
+}
+
+// request sends a subscription request with the given mode to a hub
+func (manager *WebSubManager) request(ctx context.Context, subscription *webSubSubscription, mode, topic, hub string, form url.Values) error {
+	form.Set("hub.mode", mode)
+	form.Set("hub.topic", topic)
+	form.Set("hub.callback", fmt.Sprintf("%s/%s", strings.TrimSuffix(subscription.config.CallbackURL, "/"), url.PathEscape(subscription.connector.Name)))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hub, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	res, err := manager.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not reach WebSub hub '%s': %w", hub, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusAccepted && res.StatusCode != http.StatusNoContent {
+		body, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("WebSub hub '%s' rejected %s request: %s", hub, mode, string(body))
+	}
+
+	return nil
+}
+
+// verify answers the hub's verification of intent for subscriptions and unsubscriptions we requested
+func (manager *WebSubManager) verify(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	query := r.URL.Query()
+
+	if query.Get("hub.mode") == "unsubscribe" {
+		key := webSubTopic{connector: name, topic: query.Get("hub.topic")}
+		manager.lock.Lock()
+		requested := manager.unsubscribing[key]
+		delete(manager.unsubscribing, key)
+		manager.lock.Unlock()
+
+		if !requested {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = io.WriteString(w, query.Get("hub.challenge"))
+		return
+	}
+
+	subscription, ok := manager.subscription(name)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	subscription.lock.Lock()
+	defer subscription.lock.Unlock()
+
+	switch query.Get("hub.mode") {
+	case "subscribe":
+		if !subscription.pending || query.Get("hub.topic") != subscription.topic {
+			http.NotFound(w, r)
+			return
+		}
+
+		leaseSeconds, err := strconv.Atoi(query.Get("hub.lease_seconds"))
+		if err != nil {
+			leaseSeconds = int(subscription.config.LeaseDuration.Seconds())
+		}
+		expires := time.Now().Add(time.Duration(leaseSeconds) * time.Second)
+
+		subscription.pending = false
+		manager.current(subscription).updateState(func(state *ConnectorState) {
+			state.PushedUntil = expires
+		})
+		select {
+		case subscription.verified <- expires:
+		default:
+		}
+
+		_, _ = io.WriteString(w, query.Get("hub.challenge"))
+	case "denied":
+		subscription.connector.error.Printf("WebSub hub denied subscription: %s", query.Get("hub.reason"))
+		manager.current(subscription).updateState(func(state *ConnectorState) {
+			state.PushedUntil = time.Time{}
+		})
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// receive handles content distribution by the hub, triggering a check of the connector
+func (manager *WebSubManager) receive(w http.ResponseWriter, r *http.Request) {
+	subscription, ok := manager.subscription(r.PathValue("name"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if len(subscription.config.Secret) > 0 && !validWebSubSignature(subscription.config.Secret, r.Header.Get("X-Hub-Signature"), body) {
+		subscription.connector.error.Println("Ignoring WebSub notification with invalid signature")
+		// Hubs must not be able to tell whether the signature was valid
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	subscription.connector.info.Println("Received update from WebSub hub")
+	manager.current(subscription).Trigger()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// subscription looks up the current subscription of a connector
+func (manager *WebSubManager) subscription(name string) (*webSubSubscription, bool) {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+
+	subscription, ok := manager.subscriptions[name]
+	return subscription, ok
+}
+
+// current returns the topic and hub of the subscription
+func (subscription *webSubSubscription) current() (string, string) {
+	subscription.lock.Lock()
+	defer subscription.lock.Unlock()
+
+	return subscription.topic, subscription.hub
+}
+
+// current returns the connector of a subscription as currently configured, since reloading the config replaces it
+func (manager *WebSubManager) current(subscription *webSubSubscription) *ConnectorRuntime {
+	if connector, ok := manager.runner.Connector(subscription.connector.Name); ok {
+		return connector
+	}
+
+	return subscription.connector
+}
+
+func validWebSubSignature(secret, header string, body []byte) bool {
+	method, signature, found := strings.Cut(header, "=")
+	if !found {
+		return false
+	}
+
+	var hashFunc func() hash.Hash
+	switch method {
+	case "sha1":
+		hashFunc = sha1.New
+	case "sha256":
+		hashFunc = sha256.New
+	case "sha512":
+		hashFunc = sha512.New
+	default:
+		return false
+	}
+
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(hashFunc, []byte(secret))
+	mac.Write(body)
+
+	return hmac.Equal(mac.Sum(nil), expected)
+}